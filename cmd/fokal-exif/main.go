@@ -1,14 +1,15 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 
+	"github.com/fokal/fokal-core/pkg/metadata"
+	"github.com/fokal/fokal-core/pkg/model"
 	"github.com/rwcarlsen/goexif/exif"
 	"github.com/rwcarlsen/goexif/tiff"
 )
@@ -23,7 +24,7 @@ func main() {
 		log.Fatal(err)
 	}
 
-	meta, err := GetExif(f)
+	meta, err := metadata.GetExif(f)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -40,7 +41,39 @@ func main() {
 	if err != nil {
 		fmt.Println("Unable to parse lat / lon")
 	} else {
-		fmt.Printf("lat=%f, long=%f", lat, lon)
+		fmt.Printf("lat=%f, long=%f\n", lat, lon)
+	}
+
+	printExtended(metadata.Extract(meta))
+}
+
+// printExtended lists which of the fields beyond the basic camera settings
+// were extracted, and what they were read as.
+func printExtended(m model.ImageMetadata) {
+	fields := []struct {
+		name  string
+		value interface{}
+	}{
+		{"exposure_compensation", m.ExposureCompensation},
+		{"exposure_program", m.ExposureProgram},
+		{"exposure_mode", m.ExposureMode},
+		{"metering_mode", m.MeteringMode},
+		{"flash", m.Flash},
+		{"white_balance", m.WhiteBalance},
+		{"focal_length_35mm", m.FocalLength35mm},
+		{"altitude", m.Altitude},
+		{"timezone_offset", m.TimezoneOffset},
+		{"software", m.Software},
+	}
+
+	fmt.Println("\nExtracted:")
+	for _, f := range fields {
+		v := reflect.ValueOf(f.value)
+		if v.IsNil() {
+			fmt.Printf("%-30s   not found\n", f.name)
+		} else {
+			fmt.Printf("%-30s = %v\n", f.name, v.Elem().Interface())
+		}
 	}
 }
 
@@ -74,12 +107,3 @@ func (dw detailedWalker) Swap(i, j int) {
 func (dw detailedWalker) Less(i, j int) bool {
 	return strings.Compare(string(dw.tags[i].name), string(dw.tags[j].name)) < 0
 }
-
-func GetExif(image io.Reader) (*exif.Exif, error) {
-	exifDat, err := exif.Decode(image)
-	if err != nil {
-		return &exif.Exif{}, errors.New("Unable to parse exif")
-	}
-
-	return exifDat, nil
-}
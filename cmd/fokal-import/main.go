@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fokal/fokal-core/pkg/conn"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/importer"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+)
+
+func main() {
+	var username string
+	var resume int64
+
+	postgresURL := os.Getenv("DATABASE_URL")
+	if postgresURL == "" {
+		fmt.Fprintf(os.Stderr, "Postgres URL not set at DATABASE_URL\n")
+		os.Exit(1)
+	}
+	redisURL := os.Getenv("REDIS_URL")
+	if redisURL == "" {
+		fmt.Fprintf(os.Stderr, "Redis URL not set at REDIS_URL\n")
+		os.Exit(1)
+	}
+	googleToken := os.Getenv("GOOGLE_API_TOKEN")
+	if googleToken == "" {
+		fmt.Fprintf(os.Stderr, "Google API Token not set at GOOGLE_API_TOKEN\n")
+		os.Exit(1)
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s -user <username> [-resume <import-id>] <archive.zip|directory>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&username, "user", "", "Username to import the images under")
+	flag.Int64Var(&resume, "resume", 0, "Id of an earlier import of the same archive to pick up where it stopped")
+
+	flag.Parse()
+
+	if username == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	state := &handler.State{
+		DB: conn.DialPostgres(postgresURL),
+		RD: conn.DialRedis(redisURL),
+	}
+	state.Vision, state.Maps, _ = conn.DialGoogleServices(googleToken)
+
+	if err := run(state, username, resume, flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func run(state *handler.State, username string, resume int64, path string) error {
+	user, err := retrieval.GetUserRef(state.DB, username)
+	if err != nil {
+		return err
+	}
+
+	src, closer, err := open(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	var imp importer.Import
+	if resume != 0 {
+		imp, err = importer.Resume(state.DB, user.Id, resume)
+	} else {
+		imp, err = importer.Start(state.DB, user.Id)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Running import %d for %s.\n", imp.Id, username)
+
+	err = importer.Run(state, imp, src)
+	importer.Finish(state, imp, err)
+	if err != nil {
+		return fmt.Errorf("import %d stopped, run again with -resume %d: %s", imp.Id, imp.Id, err)
+	}
+
+	imp, err = importer.Get(state.DB, user.Id, imp.Id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d images: %d imported, %d skipped, %d failed.\n", imp.Total, imp.Imported, imp.Skipped, imp.Failed)
+	for _, f := range imp.Failures {
+		fmt.Printf("  %s: %s\n", f.Path, *f.Error)
+	}
+	return nil
+}
+
+// open lists the files in a zip archive or a directory.
+func open(path string) (importer.Source, *os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return importer.Source{}, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return importer.Source{}, nil, err
+	}
+
+	var src importer.Source
+	if info.IsDir() {
+		src, err = importer.OpenDir(path)
+	} else if strings.EqualFold(filepath.Ext(path), ".zip") {
+		src, err = importer.OpenZip(f, info.Size())
+	} else {
+		err = fmt.Errorf("%s is not a zip archive or directory", path)
+	}
+	if err != nil {
+		f.Close()
+		return importer.Source{}, nil, err
+	}
+	return src, f, nil
+}
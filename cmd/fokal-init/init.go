@@ -5,7 +5,17 @@ create EXTENSION postgis;
 
 CREATE TYPE STAT_TYPE AS ENUM ('view', 'download');
 CREATE TYPE COLOR_TYPE AS ENUM ('shade', 'specific');
-CREATE TYPE CONTENT_TYPE AS ENUM ('user', 'image');
+CREATE TYPE CONTENT_TYPE AS ENUM ('user', 'image', 'collection');
+CREATE TYPE GROUP_ROLE AS ENUM ('owner', 'admin', 'member');
+CREATE TYPE USER_ROLE AS ENUM ('user', 'curator', 'moderator', 'admin');
+CREATE TYPE NOTIFICATION_TYPE AS ENUM ('favorite', 'follow', 'feature', 'report', 'takedown');
+CREATE TYPE DELIVERY_STATUS AS ENUM ('pending', 'succeeded', 'failed');
+CREATE TYPE REPORT_REASON AS ENUM ('spam', 'nudity', 'violence', 'harassment', 'copyright', 'impersonation', 'other');
+CREATE TYPE REPORT_STATUS AS ENUM ('open', 'reviewing', 'actioned', 'dismissed');
+CREATE TYPE REPORT_ACTION AS ENUM ('hide', 'delete', 'suspend');
+CREATE TYPE EXPORT_STATUS AS ENUM ('pending', 'building', 'ready', 'failed', 'expired');
+CREATE TYPE IMPORT_STATUS AS ENUM ('pending', 'running', 'done', 'failed');
+CREATE TYPE IMPORT_ITEM_STATUS AS ENUM ('imported', 'skipped', 'failed');
 
 --- colors
 create SCHEMA colors;
@@ -127,7 +137,19 @@ create table content.image_metadata
   lens_model text,
   pixel_xd integer not null,
   pixel_yd integer not null,
-  capture_time timestamp
+  capture_time timestamp,
+  creator text,
+  copyright text,
+  exposure_compensation double precision,
+  exposure_program text,
+  exposure_mode text,
+  metering_mode text,
+  flash boolean,
+  white_balance text,
+  focal_length_35mm integer,
+  altitude double precision,
+  timezone_offset text,
+  software text
 )
 ;
 
@@ -195,10 +217,24 @@ create table content.images
   views integer default 0,
   favorites integer default 0,
   title text,
-  description text
+  description text,
+  comments_disabled boolean default false not null,
+  hidden_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  content_hash text
 )
 ;
 
+-- the hash of the uploaded file, so imports skip images the user already has
+create index images_user_id_content_hash_index
+  on content.images (user_id, content_hash)
+;
+
+create index images_deleted_at_index
+  on content.images (deleted_at)
+  where deleted_at is not null
+;
+
 create index index_images_on_ranking
   on content.images (ranking(id, views + favorites, featured::integer + 3))
 ;
@@ -243,18 +279,62 @@ create table content.user_follows
 (
   user_id integer not null
     constraint user_follow_users_id_fk
-    references users (id),
+    references users (id)
+    on delete cascade,
   followed_id integer not null
-    constraint user_follows_pkey
-    primary key
     constraint user_followed_users_id_fk
-    references users (id),
-  created_at timestamp with time zone default timezone('UTC'::text, now())
+    references users (id)
+    on delete cascade,
+  created_at timestamp with time zone default timezone('UTC'::text, now()),
+  constraint user_follows_pkey
+  primary key (user_id, followed_id),
+  constraint user_follows_self_check
+  check (user_id <> followed_id)
 )
 ;
 
-create unique index user_follows_user_id_uindex
-  on content.user_follows (user_id, followed_id)
+create index user_follows_followed_id_index
+  on content.user_follows (followed_id)
+;
+
+create table content.user_blocks
+(
+  user_id integer not null
+    constraint user_blocks_users_id_fk
+    references users (id)
+    on delete cascade,
+  blocked_id integer not null
+    constraint user_blocked_users_id_fk
+    references users (id)
+    on delete cascade,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  constraint user_blocks_pkey
+  primary key (user_id, blocked_id),
+  constraint user_blocks_self_check
+  check (user_id <> blocked_id)
+)
+;
+
+create index user_blocks_blocked_id_index
+  on content.user_blocks (blocked_id)
+;
+
+create table content.user_mutes
+(
+  user_id integer not null
+    constraint user_mutes_users_id_fk
+    references users (id)
+    on delete cascade,
+  muted_id integer not null
+    constraint user_muted_users_id_fk
+    references users (id)
+    on delete cascade,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  constraint user_mutes_pkey
+  primary key (user_id, muted_id),
+  constraint user_mutes_self_check
+  check (user_id <> muted_id)
+)
 ;
 
 
@@ -266,22 +346,341 @@ create table content.users
     primary key,
   name text,
   email varchar(100) not null,
+  password text,
+  salt text,
   bio text,
   url varchar(50),
   twitter text,
   instagram text,
   featured boolean default false not null,
-  admin boolean default false not null,
+  role user_role default 'user' not null,
+  suspended_at timestamp with time zone,
+  suspended_reason text,
+  hidden_at timestamp with time zone,
+  deleted_at timestamp with time zone,
   created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
   last_modified timestamp with time zone default timezone('UTC'::text, now()) not null,
   location text
 )
 ;
 
+create index users_deleted_at_index
+  on content.users (deleted_at)
+  where deleted_at is not null
+;
+
 create unique index users_id_uindex
   on content.users (id)
 ;
 
+create table content.user_identities
+(
+  id serial not null
+    constraint user_identities_pkey
+    primary key,
+  user_id integer not null
+    constraint user_identities_users_id_fk
+    references users (id)
+    on delete cascade,
+  provider varchar(64) not null,
+  subject text not null,
+  email varchar(100),
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create unique index user_identities_provider_subject_uindex
+  on content.user_identities (provider, subject)
+;
+
+create unique index user_identities_user_id_provider_uindex
+  on content.user_identities (user_id, provider)
+;
+
+create table content.groups
+(
+  id serial not null
+    constraint groups_pkey
+    primary key,
+  shortcode varchar(12) not null,
+  name text not null,
+  description text,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  last_modified timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create unique index groups_shortcode_uindex
+  on content.groups (shortcode)
+;
+
+create table content.group_members
+(
+  group_id integer not null
+    constraint group_members_groups_id_fk
+    references content.groups (id)
+    on delete cascade,
+  user_id integer not null
+    constraint group_members_users_id_fk
+    references users (id)
+    on delete cascade,
+  role group_role default 'member' not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  constraint group_members_pkey
+  primary key (group_id, user_id)
+)
+;
+
+create index group_members_user_id_index
+  on content.group_members (user_id)
+;
+
+create table content.collections
+(
+  id serial not null
+    constraint collections_pkey
+    primary key,
+  shortcode varchar(12) not null,
+  user_id integer not null
+    constraint collections_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  title text not null,
+  description text,
+  cover_image_id integer
+    constraint collections_images_id_fk
+    references content.images (id)
+    on delete set null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  last_modified timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create unique index collections_shortcode_uindex
+  on content.collections (shortcode)
+;
+
+create index collections_user_id_index
+  on content.collections (user_id)
+;
+
+create table content.collection_images
+(
+  collection_id integer not null
+    constraint collection_images_collections_id_fk
+    references content.collections (id)
+    on delete cascade,
+  image_id integer not null
+    constraint collection_images_images_id_fk
+    references content.images (id)
+    on delete cascade,
+  position integer not null,
+  added_by integer,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  constraint collection_images_pkey
+  primary key (collection_id, image_id)
+)
+;
+
+create index collection_images_collection_id_position_index
+  on content.collection_images (collection_id, position)
+;
+
+create table content.comments
+(
+  id serial not null
+    constraint comments_pkey
+    primary key,
+  image_id integer not null
+    constraint comments_images_id_fk
+    references content.images (id)
+    on delete cascade,
+  user_id integer not null
+    constraint comments_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  parent_id integer
+    constraint comments_comments_id_fk
+    references content.comments (id)
+    on delete cascade,
+  body text not null,
+  edited_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create index comments_image_id_index
+  on content.comments (image_id, created_at)
+;
+
+create index comments_parent_id_index
+  on content.comments (parent_id)
+;
+
+-- reports outlive what they are about, so the target isn't a foreign key
+create table content.reports
+(
+  id serial not null
+    constraint reports_pkey
+    primary key,
+  reporter_id integer
+    constraint reports_reporter_id_fk
+    references content.users (id)
+    on delete set null,
+  target_type content_type not null,
+  target_id integer not null,
+  owner_id integer
+    constraint reports_owner_id_fk
+    references content.users (id)
+    on delete set null,
+  reason report_reason not null,
+  details text,
+  status report_status default 'open' not null,
+  action report_action,
+  note text,
+  moderator_id integer
+    constraint reports_moderator_id_fk
+    references content.users (id)
+    on delete set null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  updated_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  resolved_at timestamp with time zone
+)
+;
+
+-- one pending report per reporter and target
+create unique index reports_pending_uindex
+  on content.reports (reporter_id, target_type, target_id)
+  where status in ('open', 'reviewing')
+;
+
+create index reports_status_created_at_index
+  on content.reports (status, created_at)
+;
+
+create index reports_target_type_target_id_index
+  on content.reports (target_type, target_id)
+;
+
+create table content.notifications
+(
+  id serial not null
+    constraint notifications_pkey
+    primary key,
+  user_id integer not null
+    constraint notifications_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  type notification_type not null,
+  image_id integer
+    constraint notifications_images_id_fk
+    references content.images (id)
+    on delete cascade,
+  actor_ids integer[] default '{}' not null,
+  report_id integer
+    constraint notifications_reports_id_fk
+    references content.reports (id)
+    on delete cascade,
+  read_at timestamp with time zone,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  updated_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+-- one unread notification per recipient, type and image, so bursts collapse
+-- into a single row
+create unique index notifications_unread_uindex
+  on content.notifications (user_id, type, coalesce(image_id, 0), coalesce(report_id, 0))
+  where read_at is null
+;
+
+create index notifications_user_id_updated_at_index
+  on content.notifications (user_id, updated_at desc)
+;
+
+-- exports are built in the background and downloaded from storage until they
+-- expire
+create table content.exports
+(
+  id serial not null
+    constraint exports_pkey
+    primary key,
+  user_id integer not null
+    constraint exports_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  status export_status default 'pending' not null,
+  attempts integer default 0 not null,
+  key text,
+  size bigint,
+  error text,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  started_at timestamp with time zone,
+  completed_at timestamp with time zone,
+  expires_at timestamp with time zone
+)
+;
+
+-- a user can only have one export waiting to be built at a time
+create unique index exports_user_id_pending_uindex
+  on content.exports (user_id)
+  where status in ('pending', 'building')
+;
+
+create index exports_user_id_index
+  on content.exports (user_id, created_at desc)
+;
+
+-- imports publish the images in an uploaded archive, or a local one when run
+-- from fokal-import, which leaves key null
+create table content.imports
+(
+  id serial not null
+    constraint imports_pkey
+    primary key,
+  user_id integer not null
+    constraint imports_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  status import_status default 'pending' not null,
+  attempts integer default 0 not null,
+  key text,
+  total integer default 0 not null,
+  error text,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  started_at timestamp with time zone,
+  completed_at timestamp with time zone
+)
+;
+
+create unique index imports_user_id_pending_uindex
+  on content.imports (user_id)
+  where status in ('pending', 'running') and key is not null
+;
+
+create index imports_user_id_index
+  on content.imports (user_id, created_at desc)
+;
+
+-- import_items record how each file in an import went, so an interrupted
+-- import picks up where it stopped
+create table content.import_items
+(
+  import_id integer not null
+    constraint import_items_imports_id_fk
+    references content.imports (id)
+    on delete cascade,
+  path text not null,
+  status import_item_status not null,
+  image varchar(12),
+  error text,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  constraint import_items_pkey
+    primary key (import_id, path)
+)
+;
+
 --- permissions
 create table permissions.can_delete
 (
@@ -291,8 +690,8 @@ create table permissions.can_delete
 )
 ;
 
-create unique index can_delete_user_id_o_id_uindex
-  on permissions.can_delete (user_id, o_id)
+create unique index can_delete_user_id_o_id_type_uindex
+  on permissions.can_delete (user_id, o_id, type)
 ;
 
 create table permissions.can_edit
@@ -303,8 +702,8 @@ create table permissions.can_edit
 )
 ;
 
-create unique index can_edit_user_id_o_id_uindex
-  on permissions.can_edit (user_id, o_id)
+create unique index can_edit_user_id_o_id_type_uindex
+  on permissions.can_edit (user_id, o_id, type)
 ;
 
 create table permissions.can_view
@@ -319,6 +718,263 @@ create unique index can_view_user_id_o_id_type_uindex
   on permissions.can_view (user_id, o_id, type)
 ;
 
+create table permissions.group_can_view
+(
+  group_id integer not null
+    constraint group_can_view_groups_id_fk
+    references content.groups (id)
+    on delete cascade,
+  o_id integer not null,
+  type content_type not null
+)
+;
+
+create unique index group_can_view_group_id_o_id_type_uindex
+  on permissions.group_can_view (group_id, o_id, type)
+;
+
+create table permissions.group_can_edit
+(
+  group_id integer not null
+    constraint group_can_edit_groups_id_fk
+    references content.groups (id)
+    on delete cascade,
+  o_id integer not null,
+  type content_type not null
+)
+;
+
+create unique index group_can_edit_group_id_o_id_type_uindex
+  on permissions.group_can_edit (group_id, o_id, type)
+;
+
+create table permissions.group_can_delete
+(
+  group_id integer not null
+    constraint group_can_delete_groups_id_fk
+    references content.groups (id)
+    on delete cascade,
+  o_id integer not null,
+  type content_type not null
+)
+;
+
+create unique index group_can_delete_group_id_o_id_type_uindex
+  on permissions.group_can_delete (group_id, o_id, type)
+;
+
+create table permissions.api_keys
+(
+  id serial not null
+    constraint api_keys_pkey
+    primary key,
+  user_id integer not null,
+  name text not null,
+  prefix varchar(16) not null,
+  hash text not null,
+  scopes text[] not null,
+  expires_at timestamp with time zone,
+  last_used timestamp with time zone,
+  revoked boolean default false not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create unique index api_keys_prefix_uindex
+  on permissions.api_keys (prefix)
+;
+
+create index api_keys_user_id_index
+  on permissions.api_keys (user_id)
+;
+
+create table permissions.signing_keys
+(
+  kid varchar(64) not null
+    constraint signing_keys_pkey
+    primary key,
+  private_key text not null,
+  public_key text not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  activates_at timestamp with time zone not null,
+  retired_at timestamp with time zone
+)
+;
+
+create table permissions.share_links
+(
+  id serial not null
+    constraint share_links_pkey
+    primary key,
+  image_id integer not null
+    constraint share_links_images_id_fk
+    references content.images (id)
+    on delete cascade,
+  user_id integer not null
+    constraint share_links_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  allow_download boolean default false not null,
+  max_views integer,
+  views integer default 0 not null,
+  expires_at timestamp with time zone not null,
+  revoked boolean default false not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create index share_links_image_id_index
+  on permissions.share_links (image_id)
+;
+
+create table permissions.totp
+(
+  user_id integer not null
+    constraint totp_pkey
+    primary key
+    constraint totp_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  secret text not null,
+  enabled boolean default false not null,
+  last_step bigint default 0 not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  enabled_at timestamp with time zone
+)
+;
+
+create table permissions.totp_recovery_codes
+(
+  id serial not null
+    constraint totp_recovery_codes_pkey
+    primary key,
+  user_id integer not null
+    constraint totp_recovery_codes_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  hash varchar(64) not null,
+  used_at timestamp with time zone
+)
+;
+
+create unique index totp_recovery_codes_user_id_hash_uindex
+  on permissions.totp_recovery_codes (user_id, hash)
+;
+
+-- Audit
+
+create schema audit;
+
+create table audit.entries
+(
+  id bigserial not null
+    constraint entries_pkey
+    primary key,
+  actor_id integer,
+  actor varchar(100),
+  action varchar(64) not null,
+  target_type varchar(32) not null,
+  target_id integer not null,
+  target varchar(100) not null,
+  ip varchar(45),
+  request_id uuid,
+  before jsonb default '{}'::jsonb not null,
+  after jsonb default '{}'::jsonb not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create index entries_target_index
+  on audit.entries (target_type, target)
+;
+
+create index entries_actor_index
+  on audit.entries (actor)
+;
+
+create index entries_action_index
+  on audit.entries (action)
+;
+
+-- Entries outlive the users and content they refer to, so actor_id and
+-- target_id are not foreign keys and the table can only be appended to.
+CREATE OR REPLACE FUNCTION audit.append_only()
+  RETURNS TRIGGER
+LANGUAGE plpgsql AS
+$BODY$
+BEGIN
+  RAISE EXCEPTION 'audit entries cannot be changed or removed';
+END;
+$BODY$;
+
+create trigger entries_append_only
+  before update or delete on audit.entries
+  for each row execute procedure audit.append_only()
+;
+
+create trigger entries_no_truncate
+  before truncate on audit.entries
+  for each statement execute procedure audit.append_only()
+;
+
+-- Webhooks
+
+create schema webhooks;
+
+-- hooks without a user_id are registered by admins and receive every event
+create table webhooks.hooks
+(
+  id serial not null
+    constraint hooks_pkey
+    primary key,
+  user_id integer
+    constraint hooks_users_id_fk
+    references content.users (id)
+    on delete cascade,
+  url text not null,
+  secret text not null,
+  events text[] not null,
+  active boolean default true not null,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null
+)
+;
+
+create index hooks_user_id_index
+  on webhooks.hooks (user_id)
+;
+
+-- deliveries are both the queue and the delivery log
+create table webhooks.deliveries
+(
+  id serial not null
+    constraint deliveries_pkey
+    primary key,
+  hook_id integer not null
+    constraint deliveries_hooks_id_fk
+    references webhooks.hooks (id)
+    on delete cascade,
+  event varchar(64) not null,
+  payload jsonb not null,
+  status delivery_status default 'pending' not null,
+  attempts integer default 0 not null,
+  next_attempt_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  response_code integer,
+  error text,
+  redelivery_of integer,
+  created_at timestamp with time zone default timezone('UTC'::text, now()) not null,
+  delivered_at timestamp with time zone
+)
+;
+
+create index deliveries_pending_index
+  on webhooks.deliveries (next_attempt_at)
+  where status = 'pending'
+;
+
+create index deliveries_hook_id_index
+  on webhooks.deliveries (hook_id, created_at desc)
+;
+
 
 
 
@@ -387,86 +1043,242 @@ END;
 $BODY$;
 
 
+--- Visibility
+
+-- editable and deletable report whether the user holds the permission on the
+-- item, directly or through a group. Admins can edit and delete anything and
+-- moderators can delete anything.
+CREATE OR REPLACE FUNCTION permissions.editable(viewer INTEGER, item INTEGER, item_type CONTENT_TYPE)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT EXISTS(SELECT 1
+              FROM content.users
+              WHERE id = viewer AND role = 'admin')
+       OR EXISTS(SELECT 1
+                 FROM permissions.can_edit
+                 WHERE user_id = viewer AND o_id = item AND type = item_type)
+       OR EXISTS(SELECT 1
+                 FROM permissions.group_can_edit AS grants
+                   INNER JOIN content.group_members AS members ON members.group_id = grants.group_id
+                 WHERE members.user_id = viewer AND grants.o_id = item AND grants.type = item_type);
+$BODY$;
+
+CREATE OR REPLACE FUNCTION permissions.deletable(viewer INTEGER, item INTEGER, item_type CONTENT_TYPE)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT EXISTS(SELECT 1
+              FROM content.users
+              WHERE id = viewer AND role IN ('admin', 'moderator'))
+       OR EXISTS(SELECT 1
+                 FROM permissions.can_delete
+                 WHERE user_id = viewer AND o_id = item AND type = item_type)
+       OR EXISTS(SELECT 1
+                 FROM permissions.group_can_delete AS grants
+                   INNER JOIN content.group_members AS members ON members.group_id = grants.group_id
+                 WHERE members.user_id = viewer AND grants.o_id = item AND grants.type = item_type);
+$BODY$;
+
+-- taken_down reports whether moderators hid the item, or the user an image
+-- belongs to.
+CREATE OR REPLACE FUNCTION permissions.taken_down(item INTEGER, item_type CONTENT_TYPE)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT CASE item_type
+       WHEN 'image' THEN EXISTS(SELECT 1
+                                FROM content.images AS images
+                                  INNER JOIN content.users AS users ON users.id = images.user_id
+                                WHERE images.id = item AND (images.hidden_at IS NOT NULL OR users.hidden_at IS NOT NULL))
+       WHEN 'user' THEN EXISTS(SELECT 1
+                               FROM content.users
+                               WHERE id = item AND hidden_at IS NOT NULL)
+       ELSE FALSE END;
+$BODY$;
+
+-- deleted reports whether the item is in the trash waiting to be purged, or
+-- the user an image or collection belongs to is.
+CREATE OR REPLACE FUNCTION permissions.deleted(item INTEGER, item_type CONTENT_TYPE)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT CASE item_type
+       WHEN 'image' THEN EXISTS(SELECT 1
+                                FROM content.images AS images
+                                  INNER JOIN content.users AS users ON users.id = images.user_id
+                                WHERE images.id = item AND (images.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL))
+       WHEN 'user' THEN EXISTS(SELECT 1
+                               FROM content.users
+                               WHERE id = item AND deleted_at IS NOT NULL)
+       WHEN 'collection' THEN EXISTS(SELECT 1
+                                     FROM content.collections AS collections
+                                       INNER JOIN content.users AS users ON users.id = collections.user_id
+                                     WHERE collections.id = item AND users.deleted_at IS NOT NULL)
+       ELSE FALSE END;
+$BODY$;
+
+-- viewable reports whether the viewer can see the item. Items are visible to
+-- anyone who can edit them, to moderators and to users granted can_view,
+-- directly or through a group. A can_view row for user -1 makes them public,
+-- though public images and collections of private users are only shown to
+-- those who can see the user. Items taken down are only visible to those who can edit them and
+-- moderators, and deleted items aren't visible to anyone.
+CREATE OR REPLACE FUNCTION permissions.viewable(viewer INTEGER, item INTEGER, item_type CONTENT_TYPE)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT NOT permissions.deleted(item, item_type)
+       AND (permissions.editable(viewer, item, item_type)
+            OR EXISTS(SELECT 1
+                      FROM content.users
+                      WHERE id = viewer AND role = 'moderator')
+            OR (NOT permissions.taken_down(item, item_type)
+                AND (EXISTS(SELECT 1
+                            FROM permissions.can_view
+                            WHERE user_id = viewer AND o_id = item AND type = item_type)
+                     OR EXISTS(SELECT 1
+                               FROM permissions.group_can_view AS grants
+                                 INNER JOIN content.group_members AS members ON members.group_id = grants.group_id
+                               WHERE members.user_id = viewer AND grants.o_id = item AND grants.type = item_type)
+                     OR (EXISTS(SELECT 1
+                                FROM permissions.can_view
+                                WHERE user_id = -1 AND o_id = item AND type = item_type)
+                         AND CASE item_type
+                             WHEN 'image' THEN EXISTS(SELECT 1
+                                                      FROM content.images AS images
+                                                        INNER JOIN permissions.can_view AS owner
+                                                          ON owner.o_id = images.user_id AND owner.type = 'user'
+                                                      WHERE images.id = item AND owner.user_id IN (-1, viewer))
+                             WHEN 'collection' THEN EXISTS(SELECT 1
+                                                           FROM content.collections AS collections
+                                                             INNER JOIN permissions.can_view AS owner
+                                                               ON owner.o_id = collections.user_id AND owner.type = 'user'
+                                                           WHERE collections.id = item AND owner.user_id IN (-1, viewer))
+                             ELSE TRUE END))));
+$BODY$;
+
+-- Blocks work in both directions: neither user can follow, favorite or comment
+-- on the other.
+CREATE OR REPLACE FUNCTION permissions.blocked(a INTEGER, b INTEGER)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT EXISTS(SELECT 1
+              FROM content.user_blocks AS blocks
+              WHERE (blocks.user_id = a AND blocks.blocked_id = b)
+                    OR (blocks.user_id = b AND blocks.blocked_id = a));
+$BODY$;
+
+-- Users are hidden from the viewer's feed and notifications when the viewer
+-- muted them or either blocked the other.
+CREATE OR REPLACE FUNCTION permissions.hidden(viewer INTEGER, u INTEGER)
+  RETURNS BOOLEAN
+LANGUAGE SQL STABLE AS
+$BODY$
+SELECT permissions.blocked(viewer, u)
+       OR EXISTS(SELECT 1
+                 FROM content.user_mutes AS mutes
+                 WHERE mutes.user_id = viewer AND mutes.muted_id = u);
+$BODY$;
+
+
+
 --- Random
 
-CREATE OR REPLACE FUNCTION random_image(u INTEGER DEFAULT -1)
+CREATE OR REPLACE FUNCTION random_image(viewer INTEGER DEFAULT 0, u INTEGER DEFAULT -1)
   RETURNS INT
 LANGUAGE plpgsql AS
 $BODY$
 BEGIN
-  IF u = -1
-  THEN
-    RETURN (SELECT id
-            FROM content.images
-              INNER JOIN permissions.can_view ON o_id = id AND type = 'image'
-            WHERE permissions.can_view.user_id = -1
-            OFFSET random() * (SELECT count(id)
-                               FROM content.images
-                                 INNER JOIN permissions.can_view ON o_id = id AND type = 'image')
-            LIMIT 1);
-  ELSE
-    RETURN (SELECT id
-            FROM content.images
-              INNER JOIN permissions.can_view ON o_id = id AND type = 'image'
-            WHERE images.user_id = u
-            OFFSET random() * (SELECT count(id)
-                               FROM content.images
-                                 INNER JOIN permissions.can_view ON o_id = id AND type = 'image'
-                               WHERE images.user_id = u)
-            LIMIT 1);
-  END IF;
+  RETURN (SELECT id
+          FROM content.images
+          WHERE (u = -1 OR images.user_id = u) AND permissions.viewable(viewer, id, 'image')
+          ORDER BY random()
+          LIMIT 1);
 END;
 $BODY$;
 
 
 -- Stream
 
-CREATE OR REPLACE FUNCTION stream(u INTEGER)
-  RETURNS TABLE(user_id INT, o_id INT, t CONTENT_TYPE, created_at TIMESTAMP WITH TIME ZONE)
-LANGUAGE plpgsql AS
+-- stream returns up to n things the people u follows have done, newest first:
+-- the images they publish and favorite and the users they follow. Each image
+-- or user only appears once, for the latest thing done to it, and only when u
+-- can see both it and whoever did it. Pages after the first start after the
+-- (before, before_t, before_id) cursor. Candidates are ordered and cut off at
+-- the cursor before permissions are checked, so a page only checks as many
+-- rows as it needs rather than the whole stream.
+CREATE OR REPLACE FUNCTION stream(u INTEGER, before TIMESTAMP WITH TIME ZONE, before_t TEXT, before_id INTEGER, n INTEGER)
+  RETURNS TABLE(user_id INT, o_id INT, t CONTENT_TYPE, relation TEXT, created_at TIMESTAMP WITH TIME ZONE)
+LANGUAGE SQL STABLE AS
 $BODY$
-BEGIN
-  SELECT
-    a.user_id,
-    a.o_id,
-    a.t,
-    a.relation,
-    a.created_at
-  FROM (SELECT
-          follows.user_id,
-          follows.followed_id    AS o_id,
-          'user' :: CONTENT_TYPE AS t,
-          'follows'              AS relation,
-          follows.created_at
-        FROM CONTENT.user_follows AS follows
-        WHERE user_id IN (SELECT followed_id
-                          FROM CONTENT.user_follows AS F
-                          WHERE F.user_id = 10)
-        UNION
-        SELECT
-          favs.user_id,
-          favs.image_id           AS o_id,
-          'image' :: CONTENT_TYPE AS t,
-          'favorites'             AS relation,
-
-          favs.created_at
-        FROM CONTENT.user_favorites AS favs
-        WHERE favs.user_id IN (SELECT followed_id
-                               FROM CONTENT.user_follows AS F
-                               WHERE F.user_id = 10)
-        UNION
-        SELECT
-          I.user_id,
-          I.id                    AS o_id,
-          'image' :: CONTENT_TYPE AS t,
-          'published'             AS relation,
-
-          I.publish_time          AS created_at
-        FROM CONTENT.images AS I
-        WHERE I.user_id IN (SELECT followed_id
-                            FROM CONTENT.user_follows AS F
-                            WHERE F.user_id = 10)) AS a;
-END;
+SELECT
+  c.user_id,
+  c.o_id,
+  c.t,
+  c.relation,
+  c.created_at
+FROM (SELECT
+        a.user_id,
+        a.o_id,
+        a.t,
+        a.relation,
+        a.created_at
+      FROM (SELECT
+              a.*,
+              row_number()
+              OVER (PARTITION BY a.t, a.o_id
+                ORDER BY a.created_at DESC) AS latest
+            FROM (SELECT
+                    follows.user_id,
+                    follows.followed_id    AS o_id,
+                    'user' :: CONTENT_TYPE AS t,
+                    'follows'              AS relation,
+                    follows.created_at
+                  FROM content.user_follows AS follows
+                  WHERE follows.user_id IN (SELECT followed_id
+                                            FROM content.user_follows AS f
+                                            WHERE f.user_id = u)
+                        AND follows.followed_id <> u
+                  UNION ALL
+                  SELECT
+                    favs.user_id,
+                    favs.image_id           AS o_id,
+                    'image' :: CONTENT_TYPE AS t,
+                    'favorites'             AS relation,
+                    favs.created_at
+                  FROM content.user_favorites AS favs
+                  WHERE favs.user_id IN (SELECT followed_id
+                                         FROM content.user_follows AS f
+                                         WHERE f.user_id = u)
+                  UNION ALL
+                  SELECT
+                    i.user_id,
+                    i.id                    AS o_id,
+                    'image' :: CONTENT_TYPE AS t,
+                    'published'             AS relation,
+                    i.publish_time          AS created_at
+                  FROM content.images AS i
+                  WHERE i.user_id IN (SELECT followed_id
+                                      FROM content.user_follows AS f
+                                      WHERE f.user_id = u)) AS a) AS a
+      WHERE a.latest = 1
+            AND (before IS NULL OR (a.created_at, a.t :: TEXT, a.o_id) < (before, before_t, before_id))
+      ORDER BY a.created_at DESC, a.t :: TEXT DESC, a.o_id DESC
+      -- OFFSET 0 keeps the permission checks below from being pushed down
+      -- into this query, where they would run on every candidate.
+      OFFSET 0) AS c
+WHERE permissions.viewable(u, c.o_id, c.t)
+      AND permissions.viewable(u, c.user_id, 'user')
+      AND NOT permissions.hidden(u, c.user_id)
+      AND NOT permissions.hidden(u, CASE c.t
+                                    WHEN 'image' THEN (SELECT i.user_id
+                                                       FROM content.images AS i
+                                                       WHERE i.id = c.o_id)
+                                    ELSE c.o_id END)
+ORDER BY c.created_at DESC, c.t :: TEXT DESC, c.o_id DESC
+LIMIT n;
 $BODY$;
 
 
@@ -492,6 +1304,15 @@ CREATE MATERIALIZED VIEW searches AS
 
   UNION
 
+  SELECT
+    c.id                                                     AS searchable_id,
+    'collection'                                             AS searchable_type,
+    setweight(to_tsvector(coalesce(c.title, '')), 'A') ||
+    setweight(to_tsvector(coalesce(c.description, '')), 'B') AS term
+  FROM content.collections AS c
+
+  UNION
+
   SELECT
     i.id    AS searchable_id,
     'image' AS searchable_type,
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/conn"
+	"github.com/fokal/fokal-core/pkg/keys"
+	"github.com/jmoiron/sqlx"
+)
+
+const usage = `Usage: %s <command> [flags]
+
+Commands:
+  list      List stored signing keys
+  generate  Print a new private key without storing it
+  rotate    Store a new signing key and retire the current one once it activates
+  import    Store an existing PEM private key, by default the one in PRIVATE_KEY
+  prune     Remove retired keys whose tokens have all expired
+  seal      Encrypt private keys stored before sealing with SIGNING_KEY_SECRET
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, filepath.Base(os.Args[0]))
+	}
+	if len(os.Args) < 2 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var overlap, grace time.Duration
+	var kid, path string
+
+	fs := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+	fs.DurationVar(&overlap, "overlap", time.Hour, "Time a new key is published before it starts signing")
+	fs.DurationVar(&grace, "grace", time.Hour*16, "Time retired keys keep verifying tokens, at least the session lifetime")
+	fs.StringVar(&kid, "kid", "", "Key id to import the key under, by default the legacy id for PRIVATE_KEY and derived from the key for -path")
+	fs.StringVar(&path, "path", "", "Path to the PEM private key to import")
+	fs.Parse(os.Args[2:])
+
+	if os.Args[1] == "generate" {
+		k, err := keys.Generate(time.Now())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("kid: %s\n%s", k.ID, k.PrivatePEM)
+		return
+	}
+
+	postgresURL := os.Getenv("DATABASE_URL")
+	if postgresURL == "" {
+		fmt.Fprintf(os.Stderr, "Postgres URL not set at DATABASE_URL\n")
+		os.Exit(1)
+	}
+	sealer, err := keys.NewSealer(os.Getenv("SIGNING_KEY_SECRET"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Signing key secret not set at SIGNING_KEY_SECRET\n")
+		os.Exit(1)
+	}
+	db := conn.DialPostgres(postgresURL)
+
+	switch os.Args[1] {
+	case "list":
+		err = list(db, sealer)
+	case "rotate":
+		var k *keys.Key
+		k, err = keys.Rotate(db, sealer, overlap)
+		if err == nil {
+			fmt.Printf("Stored key %s, signing from %s\n", k.ID, k.ActivatesAt.Format(time.RFC3339))
+		}
+	case "import":
+		err = importKey(db, sealer, kid, path)
+	case "seal":
+		var n int64
+		n, err = keys.SealStored(db, sealer)
+		if err == nil {
+			fmt.Printf("Sealed %d keys\n", n)
+		}
+	case "prune":
+		var n int64
+		n, err = keys.Prune(db, grace)
+		if err == nil {
+			fmt.Printf("Removed %d keys\n", n)
+		}
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
+
+func list(db *sqlx.DB, sealer *keys.Sealer) error {
+	stored, err := keys.Load(db, sealer)
+	if err != nil {
+		return err
+	}
+
+	ring := keys.NewRing(0)
+	ring.Set(stored)
+	current, _ := ring.Current()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tCREATED\tACTIVATES\tRETIRED\tCURRENT")
+	for _, k := range stored {
+		retired := "-"
+		if k.RetiredAt != nil {
+			retired = k.RetiredAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\n", k.ID, k.CreatedAt.Format(time.RFC3339),
+			k.ActivatesAt.Format(time.RFC3339), retired, current != nil && current.ID == k.ID)
+	}
+	return w.Flush()
+}
+
+func importKey(db *sqlx.DB, sealer *keys.Sealer, kid, path string) error {
+	var privatePEM []byte
+	var err error
+	if path != "" {
+		privatePEM, err = ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+	} else {
+		privatePEM = []byte(os.Getenv("PRIVATE_KEY"))
+		// Tokens signed before keys were stored carry the legacy kid, and
+		// only verify while the key is stored under it.
+		if kid == "" {
+			kid = keys.LegacyID
+		}
+	}
+
+	k, err := keys.FromPEM(kid, string(privatePEM))
+	if err != nil {
+		return err
+	}
+	k.CreatedAt = time.Now()
+	k.ActivatesAt = k.CreatedAt
+
+	err = keys.Save(db, sealer, k)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported key %s\n", k.ID)
+	return nil
+}
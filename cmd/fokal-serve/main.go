@@ -1,11 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/fokal/fokal-core/pkg/daemon"
+	"github.com/fokal/fokal-core/pkg/logging"
+	"github.com/fokal/fokal-core/pkg/oidc"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
 
 	"strconv"
 )
@@ -66,6 +71,42 @@ func main() {
 		log.Fatal("NewRelicID not set at NEW_RELIC_LICENSE_KEY")
 	}
 
+	signingKeySecret := os.Getenv("SIGNING_KEY_SECRET")
+	if signingKeySecret == "" {
+		log.Fatal("Signing key secret not set at SIGNING_KEY_SECRET")
+	}
+
+	shareLinkSecret := os.Getenv("SHARE_LINK_SECRET")
+	if shareLinkSecret == "" {
+		log.Fatal("Share link secret not set at SHARE_LINK_SECRET")
+	}
+
+	imageToken := os.Getenv("IMAGE_TOKEN")
+	if imageToken == "" {
+		log.Println("No image token set at IMAGE_TOKEN, image urls will be unsigned")
+	}
+
+	providers, err := loginProviders()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(providers) == 0 {
+		log.Println("No login providers set at OIDC_PROVIDERS or GOOGLE_CLIENT_ID, users will be unable to sign in")
+	}
+
+	limits, err := ratelimit.ParseLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	proxies, err := logging.ParseProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(proxies) == 0 {
+		log.Println("No proxies set at TRUSTED_PROXIES, forwarding headers will be ignored")
+	}
+
 	cfg.GoogleToken = googleToken
 	cfg.PostgresURL = postgresURL
 	cfg.RedisURL = redisURL
@@ -73,6 +114,27 @@ func main() {
 	cfg.AWSSecretAccessKey = AWSSecret
 	cfg.SentryURL = SentryURL
 	cfg.NewRelicID = NewRelicID
+	cfg.SigningKeySecret = signingKeySecret
+	cfg.ShareLinkSecret = shareLinkSecret
+	cfg.ImageToken = imageToken
+	cfg.Providers = providers
+	cfg.RateLimits = limits
+	cfg.TrustedProxies = proxies
 
 	daemon.Run(cfg)
 }
+
+// loginProviders reads the OIDC providers from OIDC_PROVIDERS as a JSON list,
+// falling back to Google for the client ids in GOOGLE_CLIENT_ID.
+func loginProviders() ([]oidc.Config, error) {
+	providers := []oidc.Config{}
+	if raw := os.Getenv("OIDC_PROVIDERS"); raw != "" {
+		err := json.Unmarshal([]byte(raw), &providers)
+		return providers, err
+	}
+
+	if clientIDs := os.Getenv("GOOGLE_CLIENT_ID"); clientIDs != "" {
+		providers = append(providers, oidc.Google(strings.Split(clientIDs, ",")...))
+	}
+	return providers, nil
+}
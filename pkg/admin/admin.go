@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Suspend blocks the user from authenticating until they are unsuspended.
+func Suspend(db *sqlx.DB, id int64, reason string) error {
+	res, err := db.Exec(`
+	UPDATE content.users
+		SET suspended_at = CURRENT_TIMESTAMP, suspended_reason = $2
+	WHERE id = $1`, id, reason)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return affected(res)
+}
+
+// Unsuspend lets a suspended user authenticate again.
+func Unsuspend(db *sqlx.DB, id int64) error {
+	res, err := db.Exec(`
+	UPDATE content.users
+		SET suspended_at = NULL, suspended_reason = NULL
+	WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return affected(res)
+}
+
+// Suspended reports whether the user is suspended.
+func Suspended(db *sqlx.DB, id int64) (bool, error) {
+	var suspended bool
+	err := db.Get(&suspended, "SELECT suspended_at IS NOT NULL FROM content.users WHERE id = $1", id)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return suspended, nil
+}
+
+func affected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+type Stats struct {
+	Users          int            `db:"users" json:"users"`
+	NewUsers       int            `db:"new_users" json:"new_users"`
+	FeaturedUsers  int            `db:"featured_users" json:"featured_users"`
+	SuspendedUsers int            `db:"suspended_users" json:"suspended_users"`
+	Roles          map[string]int `db:"-" json:"roles"`
+	Images         int            `db:"images" json:"images"`
+	NewImages      int            `db:"new_images" json:"new_images"`
+	FeaturedImages int            `db:"featured_images" json:"featured_images"`
+	DeletedImages  int            `db:"deleted_images" json:"deleted_images"`
+	Views          int            `db:"views" json:"views"`
+	Downloads      int            `db:"downloads" json:"downloads"`
+	Favorites      int            `db:"favorites" json:"favorites"`
+	Groups         int            `db:"groups" json:"groups"`
+	ShareLinks     int            `db:"share_links" json:"active_share_links"`
+}
+
+// GetStats counts the content on the site. New users and images are those
+// created in the given window. Deleted users and images waiting in the trash
+// are left out of everything but DeletedImages.
+func GetStats(db *sqlx.DB, window time.Duration) (Stats, error) {
+	since := time.Now().Add(-window)
+	stats := Stats{Roles: map[string]int{}}
+	err := db.Get(&stats, `
+	SELECT
+		(SELECT count(*) FROM content.users WHERE deleted_at IS NULL) AS users,
+		(SELECT count(*) FROM content.users WHERE deleted_at IS NULL AND created_at > $1) AS new_users,
+		(SELECT count(*) FROM content.users WHERE deleted_at IS NULL AND featured) AS featured_users,
+		(SELECT count(*) FROM content.users WHERE deleted_at IS NULL AND suspended_at IS NOT NULL) AS suspended_users,
+		(SELECT count(*) FROM content.images WHERE deleted_at IS NULL) AS images,
+		(SELECT count(*) FROM content.images WHERE deleted_at IS NULL AND publish_time > $1) AS new_images,
+		(SELECT count(*) FROM content.images WHERE deleted_at IS NULL AND featured) AS featured_images,
+		(SELECT coalesce(sum(total), 0) FROM content.image_stats WHERE stat_type = 'view') AS views,
+		(SELECT coalesce(sum(total), 0) FROM content.image_stats WHERE stat_type = 'download') AS downloads,
+		(SELECT count(*) FROM content.user_favorites WHERE NOT permissions.deleted(image_id, 'image')) AS favorites,
+		(SELECT count(*) FROM content.images WHERE deleted_at IS NOT NULL) AS deleted_images,
+		(SELECT count(*) FROM content.groups) AS groups,
+		(SELECT count(*) FROM permissions.share_links
+		 WHERE NOT revoked AND expires_at > CURRENT_TIMESTAMP) AS share_links`, since)
+	if err != nil {
+		log.Println(err)
+		return Stats{}, err
+	}
+
+	rows, err := db.Query("SELECT role, count(*) FROM content.users WHERE deleted_at IS NULL GROUP BY role")
+	if err != nil {
+		log.Println(err)
+		return Stats{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var role string
+		var count int
+		if err := rows.Scan(&role, &count); err != nil {
+			log.Println(err)
+			return Stats{}, err
+		}
+		stats.Roles[role] = count
+	}
+	if err := rows.Err(); err != nil {
+		log.Println(err)
+		return Stats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,187 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/cache"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/modification"
+	"github.com/fokal/fokal-core/pkg/notifications"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/tokens"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+// DefaultStatsWindow is how far back new users and images are counted.
+const DefaultStatsWindow = time.Hour * 24
+
+type adminHandler func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error)
+
+// subject returns the user in the url.
+func subject(state *handler.State, r *http.Request) (model.Ref, error) {
+	return retrieval.GetUserRef(state.DB, mux.Vars(r)["ID"])
+}
+
+func flush(state *handler.State) {
+	if err := cache.Flush(state.RD); err != nil {
+		log.Println(err)
+	}
+}
+
+// RoleHandler promotes or demotes the user in the url.
+func RoleHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	target, err := subject(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.RoleRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	role, err := roles.Parse(req.Role)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	previous, err := roles.Get(state.DB, target.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	err = roles.Set(state.DB, target.Id, role)
+	if err == roles.ErrLastAdmin {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to change role")}
+	}
+
+	audit.Record(state.DB, r, "user.role", target, map[string]roles.Role{"role": previous}, map[string]roles.Role{"role": role})
+	flush(state)
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+// FeatureHandler features or unfeatures the user in the url.
+func FeatureHandler(featured bool) adminHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		target, err := subject(state, r)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		action := "user.feature"
+		if featured {
+			err = modification.FeatureUser(state.DB, target.Id)
+		} else {
+			action = "user.unfeature"
+			err = modification.UnFeatureUser(state.DB, target.Id)
+		}
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+
+		if featured {
+			notifications.Featured(state, retrieval.Viewer(r), target)
+		} else {
+			notifications.Retract(state.DB, notifications.Feature, retrieval.Viewer(r), target)
+		}
+
+		audit.Record(state.DB, r, action, target, map[string]bool{"featured": !featured}, map[string]bool{"featured": featured})
+		flush(state)
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
+
+// SuspendHandler suspends the user in the url and revokes their tokens. Users
+// can only suspend those ranked below them.
+func SuspendHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	target, err := subject(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.SuspendRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	role, err := roles.Get(state.DB, target.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !roles.Current(r).Outranks(role) {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Cannot suspend users of an equal or higher role")}
+	}
+
+	err = Suspend(state.DB, target.Id, req.Reason)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to suspend user")}
+	}
+
+	err = tokens.RevokeAll(state, target.Id)
+	if err != nil {
+		log.Println(err)
+	}
+
+	audit.Record(state.DB, r, "user.suspend", target,
+		map[string]interface{}{"suspended": false},
+		map[string]interface{}{"suspended": true, "reason": req.Reason})
+	flush(state)
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+// UnsuspendHandler lifts the suspension of the user in the url. As with
+// suspending, users can only unsuspend those ranked below them.
+func UnsuspendHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	target, err := subject(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	role, err := roles.Get(state.DB, target.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !roles.Current(r).Outranks(role) {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Cannot unsuspend users of an equal or higher role")}
+	}
+
+	err = Unsuspend(state.DB, target.Id)
+	if err == sql.ErrNoRows {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No user found")}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to unsuspend user")}
+	}
+
+	audit.Record(state.DB, r, "user.unsuspend", target, map[string]bool{"suspended": true}, map[string]bool{"suspended": false})
+	flush(state)
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+// StatsHandler reports the system stats. The window param sets how far back
+// new users and images are counted, as a duration such as 168h.
+func StatsHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	window := DefaultStatsWindow
+	if raw := r.URL.Query().Get("window"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid window")}
+		}
+		window = d
+	}
+
+	stats, err := GetStats(state.DB, window)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve stats")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: stats}, nil
+}
@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Entry is a single action in the audit trail. Before and After only hold the
+// fields of the target that the action changed.
+type Entry struct {
+	ID         int64          `json:"id"`
+	Actor      *string        `json:"actor"`
+	Action     string         `json:"action"`
+	TargetType string         `db:"target_type" json:"target_type"`
+	Target     string         `json:"target"`
+	IP         *string        `json:"ip,omitempty"`
+	RequestID  *string        `db:"request_id" json:"request_id,omitempty"`
+	Before     types.JSONText `json:"before"`
+	After      types.JSONText `json:"after"`
+	CreatedAt  time.Time      `db:"created_at" json:"created_at"`
+}
+
+// Record appends an action taken by the logged in user to the audit trail.
+// before and after describe the target on either side of the action and are
+// reduced to what changed, either may be nil.
+func Record(db sqlx.Execer, r *http.Request, action string, target model.Ref, before, after interface{}) error {
+	actor, _ := context.Get(r, "auth").(model.Ref)
+	return RecordAs(db, r, actor, action, target, before, after)
+}
+
+// RecordAs appends an action to the audit trail for requests that are not
+// authenticated yet, such as logging in.
+func RecordAs(db sqlx.Execer, r *http.Request, actor model.Ref, action string, target model.Ref, before, after interface{}) error {
+	b, a, err := Diff(before, after)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	rawBefore, err := json.Marshal(b)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	rawAfter, err := json.Marshal(a)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	var actorID *int64
+	var actorName *string
+	if actor.Id != 0 {
+		actorID, actorName = &actor.Id, &actor.Shortcode
+	}
+
+	var ip, requestID *string
+	if v, ok := context.Get(r, "ip").(string); ok {
+		ip = &v
+	}
+	if v, ok := context.GetOk(r, "uuid"); ok {
+		id := fmt.Sprint(v)
+		requestID = &id
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO audit.entries(actor_id, actor, action, target_type, target_id, target, ip, request_id, before, after)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);`,
+		actorID, actorName, action, target.Collection.String(), target.Id, target.Shortcode,
+		ip, requestID, string(rawBefore), string(rawAfter))
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Diff reduces before and after to the fields whose values differ. Both have
+// to encode to JSON objects, nil is treated as an empty object.
+func Diff(before, after interface{}) (map[string]interface{}, map[string]interface{}, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for k, v := range b {
+		if w, ok := a[k]; ok && reflect.DeepEqual(v, w) {
+			delete(b, k)
+			delete(a, k)
+		}
+	}
+	return b, a, nil
+}
+
+func fields(v interface{}) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if v == nil {
+		return m, nil
+	}
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	return m, nil
+}
+
+// Filter narrows an audit query. Empty fields match everything and an action
+// ending in .* matches every action with that prefix.
+type Filter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	Target     string
+	Since      *time.Time
+	Until      *time.Time
+	Limit      int
+	Offset     int
+}
+
+// Query returns the entries matching the filter, newest first.
+func Query(db *sqlx.DB, f Filter) ([]Entry, error) {
+	clauses := []string{}
+	args := []interface{}{}
+	where := func(clause string, arg interface{}) {
+		args = append(args, arg)
+		clauses = append(clauses, fmt.Sprintf(clause, len(args)))
+	}
+
+	if f.Actor != "" {
+		where("actor = $%d", f.Actor)
+	}
+	if strings.HasSuffix(f.Action, ".*") {
+		where("action LIKE $%d", strings.TrimSuffix(f.Action, "*")+"%")
+	} else if f.Action != "" {
+		where("action = $%d", f.Action)
+	}
+	if f.TargetType != "" {
+		where("target_type = $%d", f.TargetType)
+	}
+	if f.Target != "" {
+		where("target = $%d", f.Target)
+	}
+	if f.Since != nil {
+		where("created_at >= $%d", *f.Since)
+	}
+	if f.Until != nil {
+		where("created_at < $%d", *f.Until)
+	}
+
+	q := `SELECT id, actor, action, target_type, target, ip, request_id, before, after, created_at FROM audit.entries`
+	if len(clauses) > 0 {
+		q += " WHERE " + strings.Join(clauses, " AND ")
+	}
+	args = append(args, f.Limit, f.Offset)
+	q += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	entries := []Entry{}
+	err := db.Select(&entries, q, args...)
+	if err != nil {
+		log.Println(err)
+		return []Entry{}, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	type image struct {
+		Title    string   `json:"title"`
+		Featured bool     `json:"featured"`
+		Tags     []string `json:"tags"`
+		Secret   string   `json:"-"`
+	}
+
+	tests := []struct {
+		name          string
+		before, after interface{}
+		wantB, wantA  map[string]interface{}
+	}{
+		{
+			name:   "changed fields only",
+			before: image{Title: "lake", Tags: []string{"water"}, Secret: "a"},
+			after:  image{Title: "lake", Featured: true, Tags: []string{"water", "blue"}, Secret: "b"},
+			wantB:  map[string]interface{}{"featured": false, "tags": []interface{}{"water"}},
+			wantA:  map[string]interface{}{"featured": true, "tags": []interface{}{"water", "blue"}},
+		},
+		{
+			name:   "created",
+			before: nil,
+			after:  map[string]string{"provider": "google"},
+			wantB:  map[string]interface{}{},
+			wantA:  map[string]interface{}{"provider": "google"},
+		},
+		{
+			name:   "removed keys",
+			before: map[string]bool{"jane": true},
+			after:  nil,
+			wantB:  map[string]interface{}{"jane": true},
+			wantA:  map[string]interface{}{},
+		},
+		{
+			name:   "unchanged",
+			before: image{Title: "lake"},
+			after:  image{Title: "lake"},
+			wantB:  map[string]interface{}{},
+			wantA:  map[string]interface{}{},
+		},
+	}
+
+	for _, test := range tests {
+		b, a, err := Diff(test.before, test.after)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !reflect.DeepEqual(b, test.wantB) || !reflect.DeepEqual(a, test.wantA) {
+			t.Errorf("%s: got %v -> %v, expected %v -> %v", test.name, b, a, test.wantB, test.wantA)
+		}
+	}
+
+	if _, _, err := Diff("not an object", nil); err == nil {
+		t.Error("expected an error for values that are not objects")
+	}
+}
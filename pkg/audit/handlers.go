@@ -0,0 +1,48 @@
+package audit
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 500
+)
+
+// QueryHandler lists audit entries matching the actor, action, target_type,
+// target, since and until params. Times are RFC 3339.
+func QueryHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	params := r.URL.Query()
+	f := Filter{
+		Actor:      params.Get("actor"),
+		Action:     params.Get("action"),
+		TargetType: params.Get("target_type"),
+		Target:     params.Get("target"),
+	}
+
+	for name, dest := range map[string]**time.Time{"since": &f.Since, "until": &f.Until} {
+		if raw := params.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid " + name)}
+			}
+			*dest = &t
+		}
+	}
+
+	var err error
+	f.Limit, f.Offset, err = handler.Page(r, defaultLimit, maxLimit)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	entries, err := Query(state.DB, f)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve audit entries")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: entries}, nil
+}
@@ -65,3 +65,34 @@ func Setex(pool *redis.Pool, key string, content []byte, t time.Duration) error
 	}
 	return nil
 }
+
+// Flush removes every cached value.
+func Flush(pool *redis.Pool) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", 100))
+		if err != nil {
+			return errors.Wrap(err, "unable to scan redis cached values")
+		}
+
+		cursor, _ = redis.Int(values[0], nil)
+		keys, err := redis.Strings(values[1], nil)
+		if err != nil {
+			return errors.Wrap(err, "unable to scan redis cached values")
+		}
+
+		if len(keys) > 0 {
+			_, err = conn.Do("DEL", redis.Args{}.AddFlat(keys)...)
+			if err != nil {
+				return errors.Wrap(err, "unable to delete redis cached values")
+			}
+		}
+
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
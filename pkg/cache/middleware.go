@@ -10,9 +10,11 @@ import (
 	"github.com/fokal/fokal-core/pkg/handler"
 )
 
+// Handler caches successful responses by url. Authenticated requests can see
+// private content and are never cached.
 func Handler(state *handler.State, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if state.Local {
+		if state.Local || r.Header.Get("Authorization") != "" {
 			next.ServeHTTP(w, r)
 		} else {
 
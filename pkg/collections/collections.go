@@ -0,0 +1,342 @@
+package collections
+
+import (
+	"errors"
+	"log"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrNotInCollection is returned when an image has to be in the collection
+	// for a change, such as making it the cover.
+	ErrNotInCollection = errors.New("image is not in the collection")
+	// ErrOrder is returned when a new order does not list every image in the
+	// collection exactly once.
+	ErrOrder = errors.New("order must list every image in the collection once")
+)
+
+// Create stores a new collection owned by the user. Public collections can be
+// seen by anyone, others only by those they are shared with.
+func Create(db *sqlx.DB, userID int64, title string, description *string, public bool) (model.Ref, error) {
+	sc, err := retrieval.GenerateSC(db, model.Collections)
+	if err != nil {
+		return model.Ref{}, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return model.Ref{}, err
+	}
+
+	ref := model.Ref{Collection: model.Collections, Shortcode: sc}
+	err = tx.Get(&ref.Id, `
+	INSERT INTO content.collections(shortcode, user_id, title, description)
+	VALUES ($1, $2, $3, $4) RETURNING id;`, sc, userID, title, description)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return model.Ref{}, err
+	}
+
+	grants := []string{
+		"INSERT INTO permissions.can_edit(user_id, o_id, type) VALUES ($1, $2, 'collection');",
+		"INSERT INTO permissions.can_delete(user_id, o_id, type) VALUES ($1, $2, 'collection');",
+	}
+	if public {
+		grants = append(grants, "INSERT INTO permissions.can_view(user_id, o_id, type) VALUES (-1, $2, 'collection');")
+	}
+	for _, q := range grants {
+		if _, err = tx.Exec(q, userID, ref.Id); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return model.Ref{}, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return model.Ref{}, err
+	}
+	return ref, nil
+}
+
+// Get returns the collection as seen by the viewer. Only images the viewer can
+// see are counted, and the cover falls back to the first of them.
+func Get(state *handler.State, viewer, id int64) (model.Collection, error) {
+	c := model.Collection{}
+	err := state.DB.Get(&c, `
+	SELECT collections.id, collections.shortcode, collections.title, collections.description,
+		collections.cover_image_id, collections.created_at, collections.last_modified,
+		users.username AS owner,
+		EXISTS(SELECT 1 FROM permissions.can_view
+			WHERE user_id = -1 AND o_id = collections.id AND type = 'collection') AS public,
+		(SELECT count(*) FROM content.collection_images AS items
+			WHERE items.collection_id = collections.id
+				AND permissions.viewable($2, items.image_id, 'image')) AS image_count
+	FROM content.collections AS collections
+		INNER JOIN content.users AS users ON users.id = collections.user_id
+	WHERE collections.id = $1`, id, viewer)
+	if err != nil {
+		log.Println(err)
+		return model.Collection{}, err
+	}
+
+	c.Collaborators = []string{}
+	err = state.DB.Select(&c.Collaborators, `
+	SELECT users.username
+	FROM permissions.can_edit AS grants
+		INNER JOIN content.users AS users ON users.id = grants.user_id
+		INNER JOIN content.collections AS collections ON collections.id = grants.o_id
+	WHERE grants.o_id = $1 AND grants.type = 'collection' AND grants.user_id <> collections.user_id
+	ORDER BY users.username`, id)
+	if err != nil {
+		log.Println(err)
+		return model.Collection{}, err
+	}
+
+	var cover []int64
+	err = state.DB.Select(&cover, `
+	SELECT items.image_id
+	FROM content.collection_images AS items
+	WHERE items.collection_id = $1 AND permissions.viewable($2, items.image_id, 'image')
+	ORDER BY items.image_id = $3 DESC, items.position
+	LIMIT 1`, id, viewer, c.CoverId)
+	if err != nil {
+		log.Println(err)
+		return model.Collection{}, err
+	}
+	if len(cover) == 1 {
+		img, err := retrieval.GetImage(state, cover[0])
+		if err != nil {
+			return model.Collection{}, err
+		}
+		c.Cover = &img
+	}
+
+	ref := model.Ref{Id: c.Id, Collection: model.Collections, Shortcode: c.Shortcode}
+	c.Permalink = ref.ToURL(state.Port, state.Local)
+	return c, nil
+}
+
+// ForUser returns the user's collections that the viewer can see, most
+// recently changed first.
+func ForUser(state *handler.State, viewer, userID int64) ([]model.Collection, error) {
+	ids := []int64{}
+	err := state.DB.Select(&ids, `
+	SELECT id FROM content.collections
+	WHERE user_id = $1 AND permissions.viewable($2, id, 'collection')
+	ORDER BY last_modified DESC`, userID, viewer)
+	if err != nil {
+		log.Println(err)
+		return []model.Collection{}, err
+	}
+
+	collections := make([]model.Collection, len(ids))
+	for i, id := range ids {
+		collections[i], err = Get(state, viewer, id)
+		if err != nil {
+			return []model.Collection{}, err
+		}
+	}
+	return collections, nil
+}
+
+// Update changes the title, description and cover of the collection. Nil
+// values are left as they are, and the cover has to be in the collection.
+func Update(db *sqlx.DB, id int64, title, description *string, cover *model.Ref) error {
+	if cover != nil {
+		ok, err := contains(db, id, cover.Id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNotInCollection
+		}
+	}
+
+	var coverID *int64
+	if cover != nil {
+		coverID = &cover.Id
+	}
+
+	_, err := db.Exec(`
+	UPDATE content.collections
+	SET title = coalesce($2, title),
+		description = coalesce($3, description),
+		cover_image_id = coalesce($4, cover_image_id),
+		last_modified = CURRENT_TIMESTAMP
+	WHERE id = $1`, id, title, description, coverID)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Delete removes the collection and everything shared with it. The images
+// themselves are kept.
+func Delete(db *sqlx.DB, id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, q := range []string{
+		"DELETE FROM permissions.can_view WHERE o_id = $1 AND type = 'collection'",
+		"DELETE FROM permissions.can_edit WHERE o_id = $1 AND type = 'collection'",
+		"DELETE FROM permissions.can_delete WHERE o_id = $1 AND type = 'collection'",
+		"DELETE FROM permissions.group_can_view WHERE o_id = $1 AND type = 'collection'",
+		"DELETE FROM permissions.group_can_edit WHERE o_id = $1 AND type = 'collection'",
+		"DELETE FROM permissions.group_can_delete WHERE o_id = $1 AND type = 'collection'",
+		"DELETE FROM content.collections WHERE id = $1",
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Images returns the images in the collection that the viewer can see, in
+// order.
+func Images(state *handler.State, viewer, id int64) ([]model.Image, error) {
+	ids := []int64{}
+	err := state.DB.Select(&ids, `
+	SELECT image_id
+	FROM content.collection_images
+	WHERE collection_id = $1 AND permissions.viewable($2, image_id, 'image')
+	ORDER BY position`, id, viewer)
+	if err != nil {
+		log.Println(err)
+		return []model.Image{}, err
+	}
+	return retrieval.GetImages(state, ids)
+}
+
+// AddImage appends the image to the collection, reporting whether it was not
+// already there.
+func AddImage(db *sqlx.DB, id, imageID, userID int64) (bool, error) {
+	res, err := db.Exec(`
+	INSERT INTO content.collection_images(collection_id, image_id, added_by, position)
+	SELECT $1, $2, $3, coalesce(max(position) + 1, 0)
+	FROM content.collection_images WHERE collection_id = $1
+	ON CONFLICT (collection_id, image_id) DO NOTHING`, id, imageID, userID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	return true, touch(db, id)
+}
+
+// RemoveImage takes the image out of the collection, clearing it as the cover
+// if it was one.
+func RemoveImage(db *sqlx.DB, id, imageID int64) (bool, error) {
+	res, err := db.Exec("DELETE FROM content.collection_images WHERE collection_id = $1 AND image_id = $2", id, imageID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	_, err = db.Exec(`
+	UPDATE content.collections SET cover_image_id = NULL
+	WHERE id = $1 AND cover_image_id = $2`, id, imageID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return true, touch(db, id)
+}
+
+// Order rearranges the collection to follow imageIDs, which has to hold every
+// image in it.
+func Order(db *sqlx.DB, id int64, imageIDs []int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	current := []int64{}
+	err = tx.Select(&current, "SELECT image_id FROM content.collection_images WHERE collection_id = $1 FOR UPDATE", id)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+	if !samePositions(current, imageIDs) {
+		tx.Rollback()
+		return ErrOrder
+	}
+
+	for position, imageID := range imageIDs {
+		_, err = tx.Exec(`
+		UPDATE content.collection_images SET position = $3
+		WHERE collection_id = $1 AND image_id = $2`, id, imageID, position)
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("UPDATE content.collections SET last_modified = CURRENT_TIMESTAMP WHERE id = $1", id)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// samePositions reports whether order lists every id in current exactly once.
+func samePositions(current, order []int64) bool {
+	if len(current) != len(order) {
+		return false
+	}
+	seen := make(map[int64]bool, len(current))
+	for _, id := range current {
+		seen[id] = false
+	}
+	for _, id := range order {
+		listed, ok := seen[id]
+		if !ok || listed {
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+func contains(db *sqlx.DB, id, imageID int64) (bool, error) {
+	var ok bool
+	err := db.Get(&ok, `
+	SELECT EXISTS(SELECT 1 FROM content.collection_images WHERE collection_id = $1 AND image_id = $2)`, id, imageID)
+	if err != nil {
+		log.Println(err)
+	}
+	return ok, err
+}
+
+func touch(db *sqlx.DB, id int64) error {
+	_, err := db.Exec("UPDATE content.collections SET last_modified = CURRENT_TIMESTAMP WHERE id = $1", id)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package collections
+
+import "testing"
+
+func TestSamePositions(t *testing.T) {
+	current := []int64{1, 2, 3}
+	tests := []struct {
+		order []int64
+		valid bool
+	}{
+		{[]int64{3, 1, 2}, true},
+		{[]int64{1, 2, 3}, true},
+		{[]int64{1, 2}, false},
+		{[]int64{1, 2, 2}, false},
+		{[]int64{1, 2, 4}, false},
+		{[]int64{1, 2, 3, 4}, false},
+	}
+
+	for _, test := range tests {
+		if valid := samePositions(current, test.order); valid != test.valid {
+			t.Errorf("order %v expected %t, got %t", test.order, test.valid, valid)
+		}
+	}
+	if !samePositions([]int64{}, []int64{}) {
+		t.Error("expected an empty order to match an empty collection")
+	}
+}
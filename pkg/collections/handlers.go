@@ -0,0 +1,278 @@
+package collections
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+func authUser(r *http.Request) (model.Ref, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return model.Ref{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+	return user, nil
+}
+
+// image returns an image named in the url that the viewer can see. Images the
+// viewer cannot see are reported as missing.
+func image(state *handler.State, viewer int64, shortcode string) (model.Ref, error) {
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+	ref, err := retrieval.GetImageRef(state.DB, shortcode)
+	if err != nil {
+		return model.Ref{}, notFound
+	}
+
+	var viewable bool
+	err = state.DB.Get(&viewable, "SELECT permissions.viewable($1, $2, 'image')", viewer, ref.Id)
+	if err != nil {
+		return model.Ref{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !viewable {
+		return model.Ref{}, notFound
+	}
+	return ref, nil
+}
+
+func CreateHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.CreateCollectionRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	public := req.Public == nil || *req.Public
+
+	ref, err := Create(state.DB, user.Id, req.Title, req.Description, public)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create collection")}
+	}
+
+	collection, err := Get(state, user.Id, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	audit.Record(state.DB, r, "collection.create", ref, nil, collection)
+	return handler.Response{Code: http.StatusCreated, Data: collection}, nil
+}
+
+func CollectionHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	ref, err := retrieval.GetCollectionRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	collection, err := Get(state, retrieval.Viewer(r), ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return handler.Response{Code: http.StatusOK, Data: collection}, nil
+}
+
+// UserCollectionsHandler lists the collections of the user in the url.
+func UserCollectionsHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := retrieval.GetUserRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	collections, err := ForUser(state, retrieval.Viewer(r), user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve collections")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: collections}, nil
+}
+
+// LoggedInCollectionsHandler lists the logged in user's own collections.
+func LoggedInCollectionsHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	collections, err := ForUser(state, user.Id, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve collections")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: collections}, nil
+}
+
+// PatchHandler changes the title, description or cover of the collection.
+// The cover is named by its image id and has to be in the collection.
+func PatchHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	ref, err := retrieval.GetCollectionRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.PatchCollectionRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	if req.Title != nil && *req.Title == "" {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Title cannot be empty")}
+	}
+
+	var cover *model.Ref
+	if req.Cover != nil {
+		img, err := image(state, user.Id, *req.Cover)
+		if err != nil {
+			return handler.Response{}, err
+		}
+		cover = &img
+	}
+
+	before, _ := Get(state, user.Id, ref.Id)
+	err = Update(state.DB, ref.Id, req.Title, req.Description, cover)
+	if err == ErrNotInCollection {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to update collection")}
+	}
+
+	after, err := Get(state, user.Id, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	audit.Record(state.DB, r, "collection.patch", ref, before, after)
+	return handler.Response{Code: http.StatusOK, Data: after}, nil
+}
+
+func DeleteHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	ref, err := retrieval.GetCollectionRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	before, _ := Get(state, user.Id, ref.Id)
+	err = Delete(state.DB, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete collection")}
+	}
+
+	audit.Record(state.DB, r, "collection.delete", ref, before, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
+
+func ImagesHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	ref, err := retrieval.GetCollectionRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	images, err := Images(state, retrieval.Viewer(r), ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve images")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: images}, nil
+}
+
+// AddImageHandler appends an image the user can see to the collection.
+func AddImageHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	vars := mux.Vars(r)
+	ref, err := retrieval.GetCollectionRef(state.DB, vars["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+	img, err := image(state, user.Id, vars["image"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	added, err := AddImage(state.DB, ref.Id, img.Id, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to add image")}
+	}
+	if !added {
+		return handler.Response{Code: http.StatusNoContent}, nil
+	}
+
+	audit.Record(state.DB, r, "collection.add_image", ref, nil, map[string]string{"image": img.Shortcode})
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+func RemoveImageHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	vars := mux.Vars(r)
+	ref, err := retrieval.GetCollectionRef(state.DB, vars["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image is not in the collection")}
+	img, err := retrieval.GetImageRef(state.DB, vars["image"])
+	if err != nil {
+		return handler.Response{}, notFound
+	}
+
+	removed, err := RemoveImage(state.DB, ref.Id, img.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to remove image")}
+	}
+	if !removed {
+		return handler.Response{}, notFound
+	}
+
+	audit.Record(state.DB, r, "collection.remove_image", ref, map[string]string{"image": img.Shortcode}, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
+
+// OrderHandler rearranges the images in the collection. Every image has to be
+// listed, including those the user cannot see.
+func OrderHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	ref, err := retrieval.GetCollectionRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.OrderCollectionRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	ids := make([]int64, len(req.Images))
+	for i, shortcode := range req.Images {
+		img, err := retrieval.GetImageRef(state.DB, shortcode)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: ErrOrder}
+		}
+		ids[i] = img.Id
+	}
+
+	err = Order(state.DB, ref.Id, ids)
+	if err == ErrOrder {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to order collection")}
+	}
+
+	audit.Record(state.DB, r, "collection.order", ref, nil, map[string][]string{"images": req.Images})
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
@@ -0,0 +1,227 @@
+package comments
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// MaxLength is the longest comment body allowed, in characters.
+const MaxLength = 2000
+
+var (
+	// ErrDisabled is returned when commenting on an image whose owner turned
+	// comments off.
+	ErrDisabled = errors.New("comments are disabled on this image")
+	// ErrParent is returned when replying to a comment that is not on the same
+	// image or has been deleted.
+	ErrParent = errors.New("parent comment not found")
+	// ErrBody is returned for empty or overlong comments.
+	ErrBody = errors.New("comments must be between 1 and 2000 characters")
+)
+
+// selectComments reads comments, showing those of users in the trash as
+// deleted so their threads stay in place until the user is restored or purged.
+const selectComments = `
+	SELECT comments.id, comments.parent_id, comments.image_id, comments.user_id,
+		CASE WHEN comments.deleted_at IS NULL AND users.deleted_at IS NULL THEN users.username ELSE '' END AS author,
+		CASE WHEN users.deleted_at IS NULL THEN comments.body ELSE '' END AS body,
+		comments.deleted_at IS NOT NULL OR users.deleted_at IS NOT NULL AS deleted,
+		comments.edited_at, comments.created_at
+	FROM content.comments AS comments
+		INNER JOIN content.users AS users ON users.id = comments.user_id`
+
+// Body trims a comment body and checks its length.
+func Body(raw string) (string, error) {
+	body := strings.TrimSpace(raw)
+	if body == "" || utf8.RuneCountInString(body) > MaxLength {
+		return "", ErrBody
+	}
+	return body, nil
+}
+
+// Create adds the user's comment to the image, as a reply when parent is set.
+func Create(db *sqlx.DB, image, user int64, parent *int64, body string) (int64, error) {
+	var disabled bool
+	err := db.Get(&disabled, "SELECT comments_disabled FROM content.images WHERE id = $1", image)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	if disabled {
+		return 0, ErrDisabled
+	}
+
+	if parent != nil {
+		var ok bool
+		err = db.Get(&ok, `
+		SELECT EXISTS(SELECT 1 FROM content.comments
+			WHERE id = $1 AND image_id = $2 AND deleted_at IS NULL)`, *parent, image)
+		if err != nil {
+			log.Println(err)
+			return 0, err
+		}
+		if !ok {
+			return 0, ErrParent
+		}
+	}
+
+	var id int64
+	err = db.Get(&id, `
+	INSERT INTO content.comments (image_id, user_id, parent_id, body)
+	VALUES ($1, $2, $3, $4) RETURNING id`, image, user, parent, body)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return id, nil
+}
+
+// Get returns a single comment without its replies.
+func Get(db *sqlx.DB, id int64) (model.Comment, error) {
+	c := model.Comment{}
+	err := db.Get(&c, selectComments+" WHERE comments.id = $1", id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return model.Comment{}, err
+	}
+	c.Replies = []model.Comment{}
+	return c, nil
+}
+
+// ForImage returns a page of the image's threads, oldest first, each with all
+// of its replies.
+func ForImage(db *sqlx.DB, image int64, limit, offset int) ([]model.Comment, error) {
+	flat := []model.Comment{}
+	err := db.Select(&flat, `
+	WITH RECURSIVE threads AS (
+		SELECT id FROM content.comments
+		WHERE image_id = $1 AND parent_id IS NULL
+		ORDER BY created_at, id
+		LIMIT $2 OFFSET $3
+	), tree AS (
+		SELECT id FROM threads
+		UNION ALL
+		SELECT replies.id FROM content.comments AS replies
+			INNER JOIN tree ON replies.parent_id = tree.id
+	)`+selectComments+`
+	WHERE comments.id IN (SELECT id FROM tree)
+	ORDER BY comments.created_at, comments.id`, image, limit, offset)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return thread(flat), nil
+}
+
+// thread nests comments under their parents, keeping their order.
+func thread(flat []model.Comment) []model.Comment {
+	children := map[int64][]model.Comment{}
+	for _, c := range flat {
+		var parent int64
+		if c.ParentId != nil {
+			parent = *c.ParentId
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	var build func(parent int64) []model.Comment
+	build = func(parent int64) []model.Comment {
+		replies := make([]model.Comment, len(children[parent]))
+		for i, c := range children[parent] {
+			c.Replies = build(c.Id)
+			replies[i] = c
+		}
+		return replies
+	}
+	return build(0)
+}
+
+// Edit replaces the body of a comment that has not been deleted.
+func Edit(db *sqlx.DB, id int64, body string) error {
+	_, err := db.Exec(`
+	UPDATE content.comments
+		SET body = $2, edited_at = timezone('UTC'::text, now())
+	WHERE id = $1 AND deleted_at IS NULL`, id, body)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Delete removes a comment. Comments with replies are blanked instead so the
+// thread stays intact, and deleted parents go once their last reply does.
+func Delete(db *sqlx.DB, id int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	var replies bool
+	err = tx.Get(&replies, "SELECT EXISTS(SELECT 1 FROM content.comments WHERE parent_id = $1)", id)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+
+	if replies {
+		_, err = tx.Exec(`
+		UPDATE content.comments
+			SET body = '', deleted_at = timezone('UTC'::text, now())
+		WHERE id = $1`, id)
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+
+	for {
+		var parent *int64
+		err = tx.Get(&parent, "DELETE FROM content.comments WHERE id = $1 RETURNING parent_id", id)
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+		if parent == nil {
+			break
+		}
+
+		var orphaned bool
+		err = tx.Get(&orphaned, `
+		SELECT deleted_at IS NOT NULL
+			AND NOT EXISTS(SELECT 1 FROM content.comments WHERE parent_id = $1)
+		FROM content.comments WHERE id = $1`, *parent)
+		if err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+		if !orphaned {
+			break
+		}
+		id = *parent
+	}
+	return tx.Commit()
+}
+
+// SetDisabled turns comments on the image off or back on. Existing comments
+// are kept.
+func SetDisabled(db *sqlx.DB, image int64, disabled bool) error {
+	_, err := db.Exec("UPDATE content.images SET comments_disabled = $2 WHERE id = $1", image, disabled)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+package comments
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fokal/fokal-core/pkg/model"
+)
+
+func TestThread(t *testing.T) {
+	parent := func(id int64) *int64 { return &id }
+	flat := []model.Comment{
+		{Id: 1},
+		{Id: 2, ParentId: parent(1)},
+		{Id: 3},
+		{Id: 4, ParentId: parent(2)},
+		{Id: 5, ParentId: parent(1)},
+	}
+
+	threads := thread(flat)
+	if len(threads) != 2 || threads[0].Id != 1 || threads[1].Id != 3 {
+		t.Fatalf("expected threads 1 and 3, got %+v", threads)
+	}
+
+	replies := threads[0].Replies
+	if len(replies) != 2 || replies[0].Id != 2 || replies[1].Id != 5 {
+		t.Fatalf("expected replies 2 and 5, got %+v", replies)
+	}
+	if len(replies[0].Replies) != 1 || replies[0].Replies[0].Id != 4 {
+		t.Errorf("expected 4 to reply to 2, got %+v", replies[0].Replies)
+	}
+	if threads[1].Replies == nil || len(threads[1].Replies) != 0 {
+		t.Errorf("expected an empty list of replies, got %#v", threads[1].Replies)
+	}
+}
+
+func TestBody(t *testing.T) {
+	if body, err := Body("  nice light \n"); err != nil || body != "nice light" {
+		t.Errorf("expected the body to be trimmed, got %q, %v", body, err)
+	}
+	for _, invalid := range []string{"", "   ", strings.Repeat("é", MaxLength+1)} {
+		if _, err := Body(invalid); err != ErrBody {
+			t.Errorf("expected a %d character body to be refused", len(invalid))
+		}
+	}
+	if _, err := Body(strings.Repeat("é", MaxLength)); err != nil {
+		t.Errorf("expected %d characters to be allowed", MaxLength)
+	}
+}
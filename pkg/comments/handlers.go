@@ -0,0 +1,223 @@
+package comments
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/social"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// comment returns the comment in the url along with its image, as long as the
+// comment belongs to that image.
+func comment(state *handler.State, r *http.Request) (model.Ref, model.Comment, error) {
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Comment not found")}
+	vars := mux.Vars(r)
+
+	image, err := retrieval.GetImageRef(state.DB, vars["ID"])
+	if err != nil {
+		return model.Ref{}, model.Comment{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+	}
+
+	id, err := strconv.ParseInt(vars["comment"], 10, 64)
+	if err != nil {
+		return model.Ref{}, model.Comment{}, notFound
+	}
+	c, err := Get(state.DB, id)
+	if err == sql.ErrNoRows || (err == nil && c.ImageId != image.Id) {
+		return model.Ref{}, model.Comment{}, notFound
+	} else if err != nil {
+		return model.Ref{}, model.Comment{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return image, c, nil
+}
+
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	image, err := retrieval.GetImageRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+	}
+
+	limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	comments, err := ForImage(state.DB, image.Id, limit, offset)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve comments")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: comments}, nil
+}
+
+func CreateHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	image, err := retrieval.GetImageRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+	}
+
+	req := new(request.CreateCommentRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	body, err := Body(req.Body)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	// Users who blocked each other can't comment on each other's images or
+	// reply to each other.
+	targets := []model.Ref{image}
+	if req.Parent != nil {
+		if parent, err := Get(state.DB, *req.Parent); err == nil {
+			targets = append(targets, model.Ref{Collection: model.Users, Id: parent.UserId})
+		}
+	}
+	for _, target := range targets {
+		blocked, err := social.Blocked(state.DB, user.Id, target)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+		if blocked {
+			return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Cannot interact with a blocked user")}
+		}
+	}
+
+	id, err := Create(state.DB, image.Id, user.Id, req.Parent, body)
+	switch err {
+	case nil:
+	case ErrDisabled:
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: err}
+	case ErrParent:
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	default:
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create comment")}
+	}
+
+	c, err := Get(state.DB, id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	audit.Record(state.DB, r, "comment.create", image, nil, c)
+	return handler.Response{Code: http.StatusCreated, Data: c}, nil
+}
+
+// EditHandler replaces the body of the logged in user's own comment.
+func EditHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	image, c, err := comment(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if c.Deleted {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Comment not found")}
+	}
+	if c.UserId != user.Id {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Can only edit your own comments")}
+	}
+
+	req := new(request.EditCommentRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	body, err := Body(req.Body)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	if err = Edit(state.DB, c.Id, body); err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to edit comment")}
+	}
+
+	edited, err := Get(state.DB, c.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	audit.Record(state.DB, r, "comment.edit", image, c, edited)
+	return handler.Response{Code: http.StatusOK, Data: edited}, nil
+}
+
+// DeleteHandler deletes a comment. Authors can delete their own comments and
+// image owners and moderators can delete anyone's.
+func DeleteHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	image, c, err := comment(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if c.Deleted {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Comment not found")}
+	}
+
+	if c.UserId != user.Id {
+		owner, err := permissions.Owner(state.DB, image.Id, model.Images)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+		if owner != user.Id {
+			role, err := roles.Get(state.DB, user.Id)
+			if err != nil {
+				return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+			}
+			if !role.Can(roles.ModerateComments) {
+				return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Cannot delete this comment")}
+			}
+		}
+	}
+
+	if err = Delete(state.DB, c.Id); err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete comment")}
+	}
+
+	audit.Record(state.DB, r, "comment.delete", image, c, nil)
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+// DisableHandler turns comments on the image in the url off, or back on.
+func DisableHandler(disabled bool) func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		image, err := retrieval.GetImageRef(state.DB, mux.Vars(r)["ID"])
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+		}
+
+		if err = SetDisabled(state.DB, image.Id, disabled); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+
+		audit.Record(state.DB, r, "image.comments", image,
+			map[string]bool{"comments_disabled": !disabled}, map[string]bool{"comments_disabled": disabled})
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
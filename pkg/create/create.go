@@ -6,22 +6,23 @@ import (
 	"fmt"
 
 	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/oidc"
 	"github.com/jmoiron/sqlx"
 )
 
-// CreateImage stores the image data in the database under the given user.
-// Currently does not set the metadata or db interal state.
-func commitImage(db *sqlx.DB, image model.Image) error {
+// CreateImage stores the image data in the database under the given user,
+// along with the hash of the file it was uploaded from.
+func commitImage(db *sqlx.DB, image model.Image, hash string) error {
 	tx, err := db.Beginx()
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 	var id int64
-	rows, err := tx.NamedQuery(`
-	INSERT INTO content.images(user_id, shortcode)
-	VALUES(:user_id, :shortcode) RETURNING id;`,
-		image)
+	rows, err := tx.Query(`
+	INSERT INTO content.images(user_id, shortcode, title, description, content_hash)
+	VALUES($1, $2, $3, $4, $5) RETURNING id;`,
+		image.UserId, image.Shortcode, image.Title, image.Description, hash)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -38,10 +39,16 @@ func commitImage(db *sqlx.DB, image model.Image) error {
 	_, err = tx.NamedExec(`
 	INSERT INTO content.image_metadata(image_id, aperture, exposure_time,
 	focal_length, iso, make, model, lens_make, lens_model, pixel_xd,
-	pixel_yd, capture_time) VALUES (:id, :metadata.aperture, :metadata.exposure_time,
+	pixel_yd, capture_time, creator, copyright, exposure_compensation,
+	exposure_program, exposure_mode, metering_mode, flash, white_balance,
+	focal_length_35mm, altitude, timezone_offset, software) VALUES (:id, :metadata.aperture, :metadata.exposure_time,
 	:metadata.focal_length, :metadata.iso, :metadata.make, :metadata.model,
 	:metadata.lens_make, :metadata.lens_model, :metadata.pixel_xd,
-	:metadata.pixel_yd, :metadata.capture_time);
+	:metadata.pixel_yd, :metadata.capture_time, :metadata.creator, :metadata.copyright,
+	:metadata.exposure_compensation, :metadata.exposure_program, :metadata.exposure_mode,
+	:metadata.metering_mode, :metadata.flash, :metadata.white_balance,
+	:metadata.focal_length_35mm, :metadata.altitude, :metadata.timezone_offset,
+	:metadata.software);
 	`, image)
 	if err != nil {
 		log.Println(err)
@@ -133,6 +140,26 @@ func commitImage(db *sqlx.DB, image model.Image) error {
 		}
 	}
 
+	// Adding tags
+	var tagID int64
+	for _, tag := range image.Tags {
+		err := tx.Get(&tagID, `
+			INSERT INTO content.image_tags (description) VALUES (LOWER($1))
+				ON CONFLICT (description) DO UPDATE SET description = excluded.description
+			RETURNING id;`, tag)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO content.image_tag_bridge(image_id, tag_id)
+			VALUES ($1, $2) ON CONFLICT DO NOTHING`, image.Id, tagID)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+
 	// Permissions
 	_, err = tx.NamedExec(`
 	INSERT INTO permissions.can_edit(user_id, o_id, type) VALUES (:user_id, :id, 'image');
@@ -170,7 +197,8 @@ func commitImage(db *sqlx.DB, image model.Image) error {
 	return nil
 }
 
-func CommitUser(db *sqlx.DB, username, email, name string) error {
+// CommitUser creates the user along with the identity they signed up with.
+func CommitUser(db *sqlx.DB, username, email, name string, id oidc.Identity) error {
 	var uID int64
 	tx, err := db.Beginx()
 	if err != nil {
@@ -217,6 +245,11 @@ func CommitUser(db *sqlx.DB, username, email, name string) error {
 		return err
 	}
 
+	err = oidc.Add(tx, uID, id)
+	if err != nil {
+		return err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		log.Println(err)
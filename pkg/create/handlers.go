@@ -1,6 +1,8 @@
 package create
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"image"
 	"net/http"
 
@@ -10,59 +12,113 @@ import (
 
 	"bytes"
 
+	"database/sql"
+	"fmt"
 	"log"
-	"strings"
+	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
+	postgis "github.com/cridenour/go-postgis"
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/feed"
 	"github.com/fokal/fokal-core/pkg/geo"
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/metadata"
 	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/oidc"
 	"github.com/fokal/fokal-core/pkg/retrieval"
 	"github.com/fokal/fokal-core/pkg/tokens"
 	"github.com/fokal/fokal-core/pkg/upload"
 	"github.com/fokal/fokal-core/pkg/vision"
+	"github.com/fokal/fokal-core/pkg/webhooks"
 	"github.com/gorilla/context"
+	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
 )
 
 func UserHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
-	token, err := tokens.Parse(store, r)
-	log.Println(token)
-	if err == nil && token.Valid {
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New(http.StatusText(http.StatusBadRequest))}
-		}
+	raw, err := tokens.Bearer(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
 
-		email := claims["email"].(string)
-		name := claims["name"].(string)
-		username := strings.Split(email, "@")[0]
-		if domain, ok := claims["hd"]; ok {
-			username = username + "." + domain.(string)
-		}
+	id, err := store.Providers.Verify(raw)
+	if err != nil {
+		log.Println(err)
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token is invalid.")}
+	}
 
-		log.Printf("Creating new user: {Username: %s, Email: %s, Name: %s}", username, email, name)
-		err = CommitUser(store.DB, username, email, name)
-		if err != nil {
-			return handler.Response{}, handler.StatusError{
-				Code: http.StatusInternalServerError,
-				Err:  errors.New("error while adding user to db")}
-		}
+	_, _, err = oidc.Lookup(store.DB, id)
+	if err == nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("User already exists.")}
+	} else if err != sql.ErrNoRows {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	if id.Email == "" {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token does not include an email.")}
+	}
+	if !id.EmailVerified {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token email is not verified.")}
+	}
+
+	// Tokens are tied to users by email, so an address can't be taken by a
+	// second account. Its owner links the provider from their own session.
+	_, err = retrieval.GetUserRefByEmail(store.DB, id.Email)
+	if err == nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("An account already uses this email, sign in and link this provider instead.")}
+	} else if err != sql.ErrNoRows {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	username, err := availableUsername(store.DB, id.Username)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	log.Printf("Creating new user: {Username: %s, Email: %s, Name: %s, Provider: %s}", username, id.Email, id.Name, id.Provider)
+	err = CommitUser(store.DB, username, id.Email, id.Name, id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{
+			Code: http.StatusInternalServerError,
+			Err:  errors.New("error while adding user to db")}
+	}
+
+	ref, err := retrieval.GetUserRef(store.DB, username)
+	if err != nil {
+		log.Println(err)
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	created, _ := retrieval.GetUser(store, ref.Id, ref.Id)
+	audit.RecordAs(store.DB, r, ref, "user.create", ref, nil, created)
 
-		ref, err := retrieval.GetUserRef(store.DB, username)
+	token, _ := tokens.Create(store, r, ref, id.Email)
+	return handler.Response{Code: http.StatusAccepted, Data: map[string]string{"token": token}}, nil
+}
+
+// availableUsername returns the username, or the username with the first
+// free numeric suffix if it is already taken.
+func availableUsername(db *sqlx.DB, username string) (string, error) {
+	if username == "" {
+		username = "user"
+	}
+
+	candidate := username
+	for i := 2; ; i++ {
+		exists, err := retrieval.ExistsUser(db, candidate)
 		if err != nil {
-			log.Println(err)
-			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+			return "", err
 		}
-		token, _ := tokens.Create(store, ref, email)
-		return handler.Response{Code: http.StatusAccepted, Data: map[string]string{"token": token}}, nil
-	} else {
-		log.Println(token, err)
-		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token is invalid.")}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", username, i)
 	}
 }
 
+// MaxImageSize is the largest image that can be uploaded, in bytes.
+const MaxImageSize = 50 << 20
+
 func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
 	var user model.Ref
 	val, ok := context.GetOk(r, "auth")
@@ -72,23 +128,68 @@ func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized}
 	}
 
-	file, err := ioutil.ReadAll(r.Body)
-	if err != nil {
+	file, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxImageSize))
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return handler.Response{}, handler.StatusError{
+			Err:  errors.New("Image is too large."),
+			Code: http.StatusRequestEntityTooLarge}
+	} else if err != nil {
 		return handler.Response{}, handler.StatusError{
 			Err:  errors.New("Unable to read image body."),
 			Code: http.StatusBadRequest}
 	}
 
+	ref, err := Publish(store, r, user, file, Details{})
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	return handler.Response{
+		Code: http.StatusAccepted,
+		Data: map[string]string{"link": ref.ToURL(store.Port, store.Local), "id": ref.Shortcode},
+	}, nil
+
+}
+
+// Details are what is known about an image besides its file, such as from
+// the sidecar an archive kept next to it. Set fields take precedence over the
+// image's own metadata.
+type Details struct {
+	Title       *string
+	Description *string
+	Tags        []string
+	Creator     *string
+	Copyright   *string
+	CaptureTime *time.Time
+	Point       *postgis.PointS
+
+	// Imported images aren't announced to followers' feeds, so moving an
+	// archive over doesn't flood them.
+	Imported bool
+}
+
+// Hash identifies the contents of an image file, so the same file isn't
+// imported twice.
+func Hash(file []byte) string {
+	sum := sha256.Sum256(file)
+	return hex.EncodeToString(sum[:])
+}
+
+// Publish runs the image file through the upload pipeline under the user:
+// its metadata is read, it is annotated and stored, and it is published once
+// it passes the safe search check. r is only used for the audit trail and may
+// be nil outside of a request.
+func Publish(store *handler.State, r *http.Request, user model.Ref, file []byte, details Details) (ref model.Ref, err error) {
 	uploadedImage, format, err := image.Decode(bytes.NewBuffer(file))
 	if err != nil {
-		return handler.Response{}, handler.StatusError{
+		return model.Ref{}, handler.StatusError{
 			Err:  errors.New("Unable to read image body."),
 			Code: http.StatusBadRequest}
 	}
 
 	sc, err := retrieval.GenerateSC(store.DB, model.Images)
 	if err != nil {
-		return handler.Response{}, handler.StatusError{
+		return model.Ref{}, handler.StatusError{
 			Err:  errors.New("Unable to generate new shortcode"),
 			Code: http.StatusInternalServerError}
 	}
@@ -99,11 +200,19 @@ func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 	}
 
 	if uploadedImage.Bounds().Dx() <= 1500 || uploadedImage.Bounds().Dy() <= 1500 {
-		return handler.Response{}, handler.StatusError{
+		return model.Ref{}, handler.StatusError{
 			Err:  errors.New("Cannot upload image smaller than 1500x1500"),
 			Code: http.StatusBadRequest}
 	}
 
+	// The uploader's streams follow the upload as it is processed.
+	progress(store, user, img.Shortcode, "processing", nil)
+	defer func() {
+		if err != nil {
+			progress(store, user, img.Shortcode, "failed", map[string]string{"error": failure(err)})
+		}
+	}()
+
 	errChan := make(chan error, 3)
 	metadataChan := make(chan model.ImageMetadata, 1)
 	annotationsChan := make(chan vision.ImageResponse, 1)
@@ -115,25 +224,37 @@ func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 	for i := 0; i < 2; i++ {
 		err = <-errChan
 		if err != nil {
-			return handler.Response{}, err
+			return model.Ref{}, err
 		}
 
 	}
 
 	img.Metadata = <-metadataChan
+	describe(&img, details, metadata.GetDescriptive(file))
 	annotations := <-annotationsChan
 	rotatedImage := metadata.NormalizeOrientatation(uploadedImage, img.Metadata.Orientation)
 	img.Metadata.PixelXDimension = int64(rotatedImage.Bounds().Dx())
 	img.Metadata.PixelYDimension = int64(rotatedImage.Bounds().Dy())
 
+	if details.CaptureTime != nil {
+		img.Metadata.CaptureTime = details.CaptureTime
+	}
+	if details.Point != nil {
+		if img.Metadata.Location == nil {
+			img.Metadata.Location = &model.Location{}
+		}
+		img.Metadata.Location.Point = details.Point
+	}
+
+	progress(store, user, img.Shortcode, "storing", nil)
 	go upload.ProccessImage(errChan, rotatedImage, format, img.Shortcode, "content")
 	err = <-errChan
 	if err != nil {
-		return handler.Response{}, err
+		return model.Ref{}, err
 	}
 
 	if !annotations.Safe {
-		return handler.Response{}, handler.StatusError{
+		return model.Ref{}, handler.StatusError{
 			Err:  errors.New("Image contains violent, medical or adult imagery."),
 			Code: http.StatusBadRequest}
 	}
@@ -150,19 +271,67 @@ func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 	img.Landmarks = annotations.Landmark
 	img.Colors = annotations.ColorProperties
 
-	err = commitImage(store.DB, img)
+	err = commitImage(store.DB, img, Hash(file))
 	if err != nil {
-		return handler.Response{}, handler.StatusError{
+		return model.Ref{}, handler.StatusError{
 			Err:  errors.New("Error while adding image to DB"),
 			Code: http.StatusInternalServerError}
 	}
 
-	ref := model.Ref{Collection: model.Images, Shortcode: img.Shortcode}
-	return handler.Response{
-		Code: http.StatusAccepted,
-		Data: map[string]string{"link": ref.ToURL(store.Port, store.Local), "id": ref.Shortcode},
-	}, nil
+	ref, err = retrieval.GetImageRef(store.DB, img.Shortcode)
+	if err != nil {
+		return model.Ref{}, err
+	}
+
+	created, _ := retrieval.GetImage(store, ref.Id)
+	audit.RecordAs(store.DB, r, user, "image.create", ref, nil, created)
+	webhooks.Emit(store, webhooks.ImagePublished, user.Id, map[string]model.Ref{"image": ref, "user": user})
+	if !details.Imported {
+		feed.Announce(store, user, feed.Published, ref)
+	}
+	progress(store, user, ref.Shortcode, "published", map[string]string{"permalink": ref.ToURL(store.Port, store.Local)})
+	return ref, nil
+}
+
+// describe sets what the image is called and who it belongs to, from the
+// details given with it or else from the XMP and IPTC embedded in it. These
+// are only read when the image is created, so what its owner patches them to
+// later is kept.
+func describe(img *model.Image, details Details, embedded metadata.Descriptive) {
+	img.Title = firstSet(details.Title, embedded.Title)
+	img.Description = firstSet(details.Description, embedded.Description)
+	img.Metadata.Creator = firstSet(details.Creator, embedded.Creator)
+	img.Metadata.Copyright = firstSet(details.Copyright, embedded.Copyright)
+	img.Tags = details.Tags
+	if img.Tags == nil {
+		img.Tags = embedded.Keywords
+	}
+}
 
+func firstSet(values ...*string) *string {
+	for _, v := range values {
+		if v != nil && *v != "" {
+			return v
+		}
+	}
+	return nil
+}
+
+// progress pushes the stage the user's upload of the image has reached.
+func progress(store *handler.State, user model.Ref, id, stage string, detail map[string]string) {
+	data := map[string]string{"id": id, "stage": stage}
+	for k, v := range detail {
+		data[k] = v
+	}
+	events.Publish(store.RD, user.Id, events.Upload, data)
+}
+
+// failure describes why an upload failed.
+func failure(err error) string {
+	if e, ok := err.(handler.StatusError); ok && e.Err == nil {
+		return http.StatusText(e.Code)
+	}
+	return err.Error()
 }
 
 func AvatarHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
@@ -204,8 +373,10 @@ func AvatarHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to update avatar id")}
 	}
 
+	audit.Record(store.DB, r, "user.avatar", user, nil, map[string]string{"avatar_id": uid.String()})
+
 	return handler.Response{
 		Code: http.StatusAccepted,
-		Data: map[string]interface{}{"links": retrieval.ImageSources(uid.String(), "avatar")},
+		Data: map[string]interface{}{"links": retrieval.ImageSources(store, uid.String(), "avatar")},
 	}, nil
 }
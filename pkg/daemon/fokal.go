@@ -6,20 +6,24 @@ import (
 	"os"
 	"strconv"
 
-	"crypto/rsa"
-	"encoding/json"
-	"io/ioutil"
-
 	"time"
 
 	_ "github.com/heroku/x/hmetrics/onload"
 	newrelic "github.com/newrelic/go-agent"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/fokal/fokal-core/pkg/conn"
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/export"
 	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/importer"
+	"github.com/fokal/fokal-core/pkg/keys"
 	"github.com/fokal/fokal-core/pkg/logging"
+	"github.com/fokal/fokal-core/pkg/oidc"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
 	"github.com/fokal/fokal-core/pkg/routes"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/trash"
+	"github.com/fokal/fokal-core/pkg/webhooks"
 	raven "github.com/getsentry/raven-go"
 	"github.com/gorilla/context"
 	"github.com/gorilla/handlers"
@@ -43,19 +47,21 @@ type Config struct {
 
 	SentryURL  string
 	NewRelicID string
+
+	SigningKeySecret string
+	ShareLinkSecret  string
+	ImageToken       string
+
+	Providers      []oidc.Config
+	RateLimits     ratelimit.Limits
+	TrustedProxies logging.Proxies
 }
 
 var AppState handler.State
 
-const PublicKey = `-----BEGIN PUBLIC KEY-----
-MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAsW3uHvJvqaaMIW8wKP2E
-NI3oVRghsNwUV4VN+5UH2oMAEaYaHiUfOvhXXRjPZo3q8f+v3rS4R7gfJXe8efP0
-3x87DRB1uJlNNS777xDISnTLzVAOFFkLOTL9bOTJBlb69yCRhHV1NdUIPCGWntWC
-WdKZBJ2zHOQUQgPpAn31imsYlvmlrLEoGNqKOPUQjwdtxEqEYpZyN84Hj5/NIhTC
-F6rU8FhReQzEL27BHPfbUwTWUApmtfvCtrSc9pVM3MtlsMOf4OfoGg65kF5HJ/S8
-tKRtL24z48ya+ntjbwbE3A5pEswm/Vm19wd77qbY5UILLmNf0xMQfwrkT/IcnBoD
-pQIDAQAB
------END PUBLIC KEY-----`
+// LegacyKeyID is the kid of the key in PRIVATE_KEY, used until signing keys
+// have been imported with fokal-keys.
+const LegacyKeyID = keys.LegacyID
 
 func Run(cfg *Config) {
 	flag := log.LstdFlags | log.Lmicroseconds | log.Lshortfile
@@ -87,18 +93,49 @@ func Run(cfg *Config) {
 	AppState.Port = cfg.Port
 	AppState.DB.SetMaxOpenConns(20)
 	AppState.DB.SetMaxIdleConns(50)
-	AppState.KeyHash = "554b5db484856bfa16e7da70a427dc4d9989678a"
-
-	// RSA Keys
 	AppState.SessionLifetime = time.Hour * 16
+	AppState.MaxSessionAge = time.Hour * 24 * 30
 
 	AppState.RefreshAt = time.Minute * 15
 
+	AppState.RateLimits = cfg.RateLimits
+	if AppState.RateLimits == nil {
+		AppState.RateLimits = ratelimit.DefaultLimits
+	}
+
 	// Refreshing Materialized View
 	refreshMaterializedView()
 
-	AppState.PrivateKey, AppState.PublicKeys = ParseKeys()
-	refreshGoogleOauthKeys()
+	// Webhooks
+	deliverWebhooks(cfg.Local)
+
+	// Trash
+	purgeTrash()
+
+	// Exports
+	buildExports()
+
+	// Imports
+	processImports()
+
+	// Event Streams
+	AppState.Events = events.NewHub(AppState.RD)
+	AppState.Events.Recheck = security.Recheck(&AppState)
+	go AppState.Events.Run()
+
+	// RSA Keys
+	sealer, err := keys.NewSealer(cfg.SigningKeySecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	AppState.Keys = keys.NewRing(AppState.SessionLifetime)
+	loadSigningKeys(sealer)
+	refreshSigningKeys(sealer)
+	AppState.ShareSecret = []byte(cfg.ShareLinkSecret)
+	AppState.ImageToken = []byte(cfg.ImageToken)
+
+	// Login Providers
+	AppState.Providers = loadProviders(cfg.Providers)
 
 	var secureMiddleware = secure.New(secure.Options{
 		AllowedHosts:          []string{"api.fok.al", "alpha.fok.al", "beta.fok.al", "fok.al"},
@@ -120,19 +157,39 @@ func Run(cfg *Config) {
 		AllowedOrigins:     []string{"https://fok.al", "https://beta.fok.al", "https://alpha.fok.al", "http://localhost:3000"},
 		AllowCredentials:   true,
 		OptionsPassthrough: true,
-		AllowedHeaders:     []string{"Authorization", "Content-Type"},
+		AllowedHeaders:     []string{"Authorization", "Content-Type", "Last-Event-ID"},
+		ExposedHeaders:     []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowedMethods:     []string{"GET", "PUT", "OPTIONS", "PATCH", "POST", "DELETE"},
 	})
 
 	var base = alice.New(
 		handler.NewRelic(app),
 		handler.SentryRecovery,
-		//ratelimit.RateLimit,
 		crs.Handler,
 		handler.Timeout,
-		logging.IP, logging.UUID, secureMiddleware.Handler,
+		cfg.TrustedProxies.IP, logging.UUID,
+		secureMiddleware.Handler,
 		context.ClearHandler, handlers.CompressHandler, logging.ContentTypeJSON)
 
+	// Archives can take longer than the timeout to upload.
+	var uploads = alice.New(
+		handler.NewRelic(app),
+		handler.SentryRecovery,
+		crs.Handler,
+		cfg.TrustedProxies.IP, logging.UUID,
+		secureMiddleware.Handler,
+		context.ClearHandler, handlers.CompressHandler, logging.ContentTypeJSON)
+
+	// Streams stay open well past the timeout and can't be buffered by
+	// compression.
+	var streaming = alice.New(
+		handler.NewRelic(app),
+		handler.SentryRecovery,
+		crs.Handler,
+		cfg.TrustedProxies.IP, logging.UUID,
+		secureMiddleware.Handler,
+		context.ClearHandler)
+
 	//  ROUTES
 	routes.RegisterCreateRoutes(&AppState, api, base)
 	routes.RegisterModificationRoutes(&AppState, api, base)
@@ -141,54 +198,70 @@ func Run(cfg *Config) {
 	routes.RegisterSearchRoutes(&AppState, api, base)
 	routes.RegisterRandomRoutes(&AppState, api, base)
 	routes.RegisterAuthRoutes(&AppState, api, base)
+	routes.RegisterPermissionRoutes(&AppState, api, base)
+	routes.RegisterGroupRoutes(&AppState, api, base)
+	routes.RegisterCollectionRoutes(&AppState, api, base)
+	routes.RegisterCommentRoutes(&AppState, api, base)
+	routes.RegisterWebhookRoutes(&AppState, api, base)
+	routes.RegisterEventRoutes(&AppState, api, streaming)
+	routes.RegisterReportRoutes(&AppState, api, base)
+	routes.RegisterTrashRoutes(&AppState, api, base)
+	routes.RegisterExportRoutes(&AppState, api, base)
+	routes.RegisterImportRoutes(&AppState, api, base, uploads)
+	routes.RegisterAdminRoutes(&AppState, api, base)
 	routes.RegisterStatusRoutes(&AppState, api, base)
+	routes.RegisterWellKnownRoutes(&AppState, router, base)
 	api.NotFoundHandler = base.Then(http.HandlerFunc(handler.NotFound))
 
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(cfg.Port),
 		handlers.LoggingHandler(os.Stdout, router)))
 }
 
-func ParseKeys() (*rsa.PrivateKey, map[string]*rsa.PublicKey) {
-	resp, err := http.Get("https://www.googleapis.com/oauth2/v1/certs")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Fatal(err)
+func loadProviders(configs []oidc.Config) *oidc.Registry {
+	providers := []*oidc.Provider{}
+	for _, c := range configs {
+		p, err := oidc.NewProvider(c, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Accepting logins from %s (%s)", c.Name, c.Issuer)
+		providers = append(providers, p)
 	}
+	return oidc.NewRegistry(providers...)
+}
 
-	keys := make(map[string]string)
-	err = json.Unmarshal(body, &keys)
+func loadSigningKeys(sealer *keys.Sealer) {
+	stored, err := keys.Load(AppState.DB, sealer)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	parsedKeys := make(map[string]*rsa.PublicKey)
-
-	for kid, pem := range keys {
-		publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
+	if len(stored) == 0 {
+		log.Println("No signing keys stored, falling back to PRIVATE_KEY")
+		legacy, err := keys.FromPEM(LegacyKeyID, os.Getenv("PRIVATE_KEY"))
 		if err != nil {
 			log.Fatal(err)
 		}
-		parsedKeys[kid] = publicKey
+		stored = append(stored, legacy)
 	}
 
-	publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(PublicKey))
-	if err != nil {
-		log.Fatal(err)
-	}
-	parsedKeys[AppState.KeyHash] = publicKey
-
-	privateStr := os.Getenv("PRIVATE_KEY")
-	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateStr))
-	if err != nil {
-		log.Fatal(err)
-	}
-	return privateKey, parsedKeys
+	AppState.Keys.Set(stored)
+}
 
+func refreshSigningKeys(sealer *keys.Sealer) {
+	tick := time.NewTicker(time.Minute)
+	go func() {
+		for range tick.C {
+			stored, err := keys.Load(AppState.DB, sealer)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			if len(stored) > 0 {
+				AppState.Keys.Set(stored)
+			}
+		}
+	}()
 }
 
 func refreshMaterializedView() {
@@ -204,34 +277,39 @@ func refreshMaterializedView() {
 	}()
 }
 
-func refreshGoogleOauthKeys() {
-	tick := time.NewTicker(time.Minute * 10)
+func purgeTrash() {
+	tick := time.NewTicker(time.Hour)
 	go func() {
 		for range tick.C {
-			log.Println("Refreshing Google Auth Keys")
-			resp, err := http.Get("https://www.googleapis.com/oauth2/v1/certs")
-			if err != nil {
-				log.Fatal(err)
-			}
+			trash.Purge(&AppState)
+		}
+	}()
+}
 
-			body, err := ioutil.ReadAll(resp.Body)
-			if err != nil {
-				log.Fatal(err)
-			}
-			resp.Body.Close()
+func buildExports() {
+	tick := time.NewTicker(time.Second * 30)
+	go func() {
+		for range tick.C {
+			export.Build(&AppState)
+		}
+	}()
+}
 
-			keys := make(map[string]string)
-			err = json.Unmarshal(body, &keys)
-			if err != nil {
-				log.Fatal(err)
-			}
-			for kid, pem := range keys {
-				publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pem))
-				if err != nil {
-					log.Fatal(err)
-				}
-				AppState.PublicKeys[kid] = publicKey
-			}
+func processImports() {
+	tick := time.NewTicker(time.Second * 30)
+	go func() {
+		for range tick.C {
+			importer.Process(&AppState)
+		}
+	}()
+}
+
+func deliverWebhooks(local bool) {
+	client := webhooks.Client(local)
+	tick := time.NewTicker(time.Second * 15)
+	go func() {
+		for range tick.C {
+			webhooks.Deliver(AppState.DB, client)
 		}
 	}()
 }
@@ -0,0 +1,148 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+const (
+	Notification = "notification"
+	Upload       = "upload"
+	Feed         = "feed"
+	Export       = "export"
+	Import       = "import"
+)
+
+const (
+	// Backlog is how many of a user's latest events are kept for clients
+	// resuming with Last-Event-ID.
+	Backlog = 100
+	// backlogTTL is how long a user's backlog is kept after their last
+	// event.
+	backlogTTL = time.Hour
+
+	prefix = "events:"
+)
+
+// Event is something pushed to a user's open streams. IDs increase across
+// every user, so a stream can resume from the last one it saw.
+type Event struct {
+	ID   int64
+	User int64
+	Type string
+	Data json.RawMessage
+}
+
+var errMessage = errors.New("malformed event")
+
+// publish numbers the event, keeps it in the user's backlog and sends it to
+// every server listening for events.
+var publish = redis.NewScript(2, `
+local id = redis.call('INCR', KEYS[1])
+local message = id .. '|' .. ARGV[2] .. '|' .. ARGV[3]
+redis.call('LPUSH', KEYS[2], message)
+redis.call('LTRIM', KEYS[2], 0, ARGV[4] - 1)
+redis.call('EXPIRE', KEYS[2], ARGV[5])
+redis.call('PUBLISH', ARGV[1], message)
+return id
+`)
+
+func channel(user int64) string {
+	return fmt.Sprintf("%suser:%d", prefix, user)
+}
+
+func backlog(user int64) string {
+	return fmt.Sprintf("%sbacklog:%d", prefix, user)
+}
+
+// Publish pushes an event to the user's streams. Users without an open stream
+// get it when they next resume.
+func Publish(pool *redis.Pool, user int64, t string, data interface{}) error {
+	return PublishAll(pool, []int64{user}, t, data)
+}
+
+// PublishAll pushes the same event to each of the users' streams.
+func PublishAll(pool *redis.Pool, users []int64, t string, data interface{}) error {
+	if len(users) == 0 {
+		return nil
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+
+	for _, user := range users {
+		_, err = publish.Do(conn, prefix+"seq", backlog(user),
+			channel(user), t, raw, Backlog, int(backlogTTL.Seconds()))
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Since returns the user's kept events after last, oldest first.
+func Since(pool *redis.Pool, user, last int64) ([]Event, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	messages, err := redis.ByteSlices(conn.Do("LRANGE", backlog(user), 0, -1))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	events := []Event{}
+	for i := len(messages) - 1; i >= 0; i-- {
+		e, err := parse(user, messages[i])
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if e.ID > last {
+			events = append(events, e)
+		}
+	}
+	return events, nil
+}
+
+// parse reads a message published for the user, formatted as id|type|data.
+func parse(user int64, message []byte) (Event, error) {
+	parts := bytes.SplitN(message, []byte("|"), 3)
+	if len(parts) != 3 {
+		return Event{}, errMessage
+	}
+	id, err := strconv.ParseInt(string(parts[0]), 10, 64)
+	if err != nil {
+		return Event{}, errMessage
+	}
+	return Event{ID: id, User: user, Type: string(parts[1]), Data: parts[2]}, nil
+}
+
+// user returns whose channel a message was published on.
+func user(channel string) (int64, error) {
+	if !strings.HasPrefix(channel, prefix+"user:") {
+		return 0, errMessage
+	}
+	return strconv.ParseInt(strings.TrimPrefix(channel, prefix+"user:"), 10, 64)
+}
+
+// write sends the event to a stream.
+func (e Event) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+	return err
+}
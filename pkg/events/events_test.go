@@ -0,0 +1,121 @@
+package events
+
+import (
+	"bytes"
+	stdcontext "context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+)
+
+func TestParse(t *testing.T) {
+	e, err := parse(7, []byte(`42|notification|{"id":3,"note":"a|b"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != 42 || e.User != 7 || e.Type != Notification || string(e.Data) != `{"id":3,"note":"a|b"}` {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	for _, raw := range []string{"", "42|notification", "x|notification|{}"} {
+		if _, err := parse(7, []byte(raw)); err == nil {
+			t.Errorf("expected %q to be refused", raw)
+		}
+	}
+
+	if u, err := user(channel(12)); err != nil || u != 12 {
+		t.Errorf("expected user 12, got %d, %v", u, err)
+	}
+	if _, err := user("cache:user:12"); err == nil {
+		t.Error("expected a foreign channel to be refused")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	Event{ID: 5, Type: Upload, Data: []byte(`{"stage":"processing"}`)}.write(&b)
+	want := "id: 5\nevent: upload\ndata: {\"stage\":\"processing\"}\n\n"
+	if b.String() != want {
+		t.Errorf("expected %q, got %q", want, b.String())
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	h := NewHub(nil)
+	a, b := h.subscribe(1), h.subscribe(2)
+
+	h.dispatch(Event{ID: 1, User: 1, Type: Feed})
+	if e := <-a; e.ID != 1 {
+		t.Errorf("expected event 1, got %d", e.ID)
+	}
+	if len(b) != 0 {
+		t.Error("expected the event to only reach its user")
+	}
+
+	// A stream that falls behind is closed so its client resumes.
+	for i := 0; i <= buffered; i++ {
+		h.dispatch(Event{ID: int64(i + 2), User: 2, Type: Feed})
+	}
+	for range b {
+	}
+	if _, ok := h.subscribers[2]; ok {
+		t.Error("expected the slow stream to be removed")
+	}
+
+	h.unsubscribe(1, a)
+	if _, ok := <-a; ok {
+		t.Error("expected the stream to be closed")
+	}
+	h.unsubscribe(1, a)
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := NewHub(nil)
+	ctx, cancel := stdcontext.WithCancel(stdcontext.Background())
+	r := httptest.NewRequest("GET", "/v0/events", nil).WithContext(ctx)
+	context.Set(r, "auth", model.Ref{Id: 3, Collection: model.Users, Shortcode: "ana"})
+	defer context.Clear(r)
+	w := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(w, r)
+		close(done)
+	}()
+
+	for subscribed := false; !subscribed; time.Sleep(time.Millisecond) {
+		h.mu.Lock()
+		subscribed = len(h.subscribers[3]) > 0
+		h.mu.Unlock()
+	}
+	h.dispatch(Event{ID: 9, User: 3, Type: Notification, Data: []byte(`{}`)})
+	h.dispatch(Event{ID: 8, User: 3, Type: Notification, Data: []byte(`{}`)})
+	h.dispatch(Event{ID: 10, User: 3, Type: Feed, Data: []byte(`{}`)})
+	for empty := false; !empty; time.Sleep(time.Millisecond) {
+		h.mu.Lock()
+		for ch := range h.subscribers[3] {
+			empty = len(ch) == 0
+		}
+		h.mu.Unlock()
+	}
+	cancel()
+	<-done
+
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected an event stream, got %s", ct)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "id: 9\nevent: notification") || !strings.Contains(body, "id: 10\nevent: feed") {
+		t.Errorf("expected events 9 and 10, got %q", body)
+	}
+	if strings.Contains(body, "id: 8\n") {
+		t.Error("expected events older than the last one sent to be skipped")
+	}
+	if len(h.subscribers) != 0 {
+		t.Error("expected the stream to unsubscribe when the client goes away")
+	}
+}
@@ -0,0 +1,249 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/context"
+)
+
+const (
+	// Heartbeat is how often an idle stream is sent a comment, so proxies
+	// don't close it.
+	Heartbeat = 25 * time.Second
+	// retry is how long clients wait before reconnecting, in milliseconds.
+	retry = 3000
+	// buffered is how many events a stream can fall behind before it is
+	// closed. Clients resume from where they were when they reconnect.
+	buffered = 32
+)
+
+// Hub fans the events published on any server out to the streams open on this
+// one. Every server runs one.
+type Hub struct {
+	pool *redis.Pool
+
+	// Recheck verifies a stream's credentials again on every heartbeat, so
+	// streams are closed once their token is revoked or their user is
+	// suspended or deleted.
+	Recheck func(r *http.Request) error
+
+	mu          sync.Mutex
+	subscribers map[int64]map[chan Event]bool
+}
+
+func NewHub(pool *redis.Pool) *Hub {
+	return &Hub{pool: pool, subscribers: make(map[int64]map[chan Event]bool)}
+}
+
+// Run listens for events until the process exits, reconnecting whenever the
+// connection to Redis is lost.
+func (h *Hub) Run() {
+	for {
+		err := h.listen()
+		log.Println(err)
+		time.Sleep(time.Second)
+	}
+}
+
+// listen subscribes to every user's channel on its own connection, outside the
+// pool, and dispatches what arrives.
+func (h *Hub) listen() error {
+	c, err := h.pool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: c}
+	defer psc.Close()
+
+	if err = psc.PSubscribe(prefix + "user:*"); err != nil {
+		return err
+	}
+	// Events published while the hub was disconnected never arrived, so open
+	// streams are closed to make their clients resume from their backlog.
+	h.closeAll()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		tick := time.NewTicker(Heartbeat)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				psc.Ping("")
+			}
+		}
+	}()
+
+	for {
+		switch v := psc.ReceiveWithTimeout(2 * Heartbeat).(type) {
+		case redis.PMessage:
+			u, err := user(v.Channel)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			e, err := parse(u, v.Data)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			h.dispatch(e)
+		case error:
+			return v
+		}
+	}
+}
+
+func (h *Hub) subscribe(user int64) chan Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan Event, buffered)
+	if h.subscribers[user] == nil {
+		h.subscribers[user] = make(map[chan Event]bool)
+	}
+	h.subscribers[user][ch] = true
+	return ch
+}
+
+func (h *Hub) unsubscribe(user int64, ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(user, ch)
+}
+
+// remove closes the stream's channel. h.mu must be held.
+func (h *Hub) remove(user int64, ch chan Event) {
+	if !h.subscribers[user][ch] {
+		return
+	}
+	delete(h.subscribers[user], ch)
+	if len(h.subscribers[user]) == 0 {
+		delete(h.subscribers, user)
+	}
+	close(ch)
+}
+
+// dispatch hands the event to each of the user's streams, closing those that
+// have fallen too far behind.
+func (h *Hub) dispatch(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[e.User] {
+		select {
+		case ch <- e:
+		default:
+			h.remove(e.User, ch)
+		}
+	}
+}
+
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for user, subs := range h.subscribers {
+		for ch := range subs {
+			h.remove(user, ch)
+		}
+	}
+}
+
+// ServeHTTP streams the logged in user's events. Clients sending
+// Last-Event-ID are first sent the kept events they missed.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "auth").(model.Ref)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		fail(w, http.StatusInternalServerError, "Streaming is unsupported")
+		return
+	}
+
+	var last int64
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			fail(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		last = n
+	}
+
+	// Subscribing before reading the backlog means nothing published in
+	// between is lost. Anything in both is only sent once.
+	sub := h.subscribe(user.Id)
+	defer h.unsubscribe(user.Id, sub)
+
+	missed := []Event{}
+	if last > 0 {
+		var err error
+		missed, err = Since(h.pool, user.Id, last)
+		if err != nil {
+			fail(w, http.StatusInternalServerError, "Unable to retrieve events")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", retry)
+	for _, e := range missed {
+		e.write(w)
+		last = e.ID
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub:
+			if !ok {
+				return
+			}
+			if e.ID <= last {
+				continue
+			}
+			if err := e.write(w); err != nil {
+				return
+			}
+			last = e.ID
+		case <-heartbeat.C:
+			if h.Recheck != nil && h.Recheck(r) != nil {
+				return
+			}
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func fail(w http.ResponseWriter, code int, msg string) {
+	log.Printf("HTTP %d - %s", code, msg)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	j, _ := json.Marshal(map[string]interface{}{
+		"code": code,
+		"err":  msg,
+	})
+	w.Write(j)
+}
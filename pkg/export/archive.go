@@ -0,0 +1,218 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+)
+
+// archive writes an export one file at a time, so only the file being copied
+// is held in memory however many images the user has.
+type archive struct {
+	zw    *zip.Writer
+	open  func(name, kind string) (io.ReadCloser, error)
+	fetch func(url string) (io.ReadCloser, error)
+}
+
+type favorite struct {
+	Image     string    `db:"image" json:"image"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type follow struct {
+	User      string    `db:"username" json:"user"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type comment struct {
+	Id        int64      `db:"id" json:"id"`
+	Image     string     `db:"image" json:"image"`
+	ParentId  *int64     `db:"parent_id" json:"parent_id,omitempty"`
+	Body      string     `db:"body" json:"body"`
+	EditedAt  *time.Time `db:"edited_at" json:"edited_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+}
+
+type stat struct {
+	Image string    `db:"image" json:"image"`
+	Type  string    `db:"stat_type" json:"type"`
+	Date  time.Time `db:"date" json:"date"`
+	Total int       `db:"total" json:"total"`
+}
+
+// write adds everything kept about the user: their profile and avatar, each of
+// their images with its original, derivatives and metadata, and their
+// favorites, follows, comments and stats.
+func (a *archive) write(state *handler.State, user int64) error {
+	profile, err := retrieval.GetUser(state, user, user)
+	if err != nil {
+		return err
+	}
+	// The email address is never shown publicly, but is theirs to export.
+	err = a.json("profile.json", struct {
+		model.User
+		Email string `json:"email"`
+	}{profile, profile.Email})
+	if err != nil {
+		return err
+	}
+	if profile.AvatarID != nil {
+		if err = a.original("avatar.jpg", *profile.AvatarID, "avatar"); err != nil {
+			return err
+		}
+	}
+
+	ids := []int64{}
+	err = state.DB.Select(&ids, `
+	SELECT id FROM content.images
+	WHERE user_id = $1 AND deleted_at IS NULL
+	ORDER BY publish_time`, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for _, id := range ids {
+		img, err := retrieval.GetImage(state, id)
+		if err != nil {
+			return err
+		}
+		if err = a.image(img); err != nil {
+			return err
+		}
+	}
+
+	favorites := []favorite{}
+	err = state.DB.Select(&favorites, `
+	SELECT images.shortcode AS image, favs.created_at
+	FROM content.user_favorites AS favs
+		INNER JOIN content.images AS images ON images.id = favs.image_id
+	WHERE favs.user_id = $1 AND images.deleted_at IS NULL
+	ORDER BY favs.created_at`, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if err = a.json("favorites.json", favorites); err != nil {
+		return err
+	}
+
+	following, followers := []follow{}, []follow{}
+	err = state.DB.Select(&following, `
+	SELECT users.username, follows.created_at
+	FROM content.user_follows AS follows
+		INNER JOIN content.users AS users ON users.id = follows.followed_id
+	WHERE follows.user_id = $1 AND users.deleted_at IS NULL
+	ORDER BY follows.created_at`, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	err = state.DB.Select(&followers, `
+	SELECT users.username, follows.created_at
+	FROM content.user_follows AS follows
+		INNER JOIN content.users AS users ON users.id = follows.user_id
+	WHERE follows.followed_id = $1 AND users.deleted_at IS NULL
+	ORDER BY follows.created_at`, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	err = a.json("follows.json", map[string][]follow{"following": following, "followers": followers})
+	if err != nil {
+		return err
+	}
+
+	comments := []comment{}
+	err = state.DB.Select(&comments, `
+	SELECT comments.id, images.shortcode AS image, comments.parent_id, comments.body,
+		comments.edited_at, comments.created_at
+	FROM content.comments AS comments
+		INNER JOIN content.images AS images ON images.id = comments.image_id
+	WHERE comments.user_id = $1 AND comments.deleted_at IS NULL
+	ORDER BY comments.created_at`, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if err = a.json("comments.json", comments); err != nil {
+		return err
+	}
+
+	stats := []stat{}
+	err = state.DB.Select(&stats, `
+	SELECT images.shortcode AS image, stats.stat_type, stats.date, stats.total
+	FROM content.image_stats AS stats
+		INNER JOIN content.images AS images ON images.id = stats.image_id
+	WHERE images.user_id = $1 AND images.deleted_at IS NULL
+	ORDER BY stats.date, images.shortcode, stats.stat_type`, user)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return a.json("stats.json", stats)
+}
+
+// image adds the image's metadata, its original and each size it is served in
+// under images/{id}/.
+func (a *archive) image(img model.Image) error {
+	dir := "images/" + img.Shortcode + "/"
+	if err := a.json(dir+"image.json", img); err != nil {
+		return err
+	}
+	if err := a.original(dir+"original.jpg", img.Shortcode, "content"); err != nil {
+		return err
+	}
+
+	for _, d := range []struct{ name, url string }{
+		{"large", img.Source.Large},
+		{"medium", img.Source.Medium},
+		{"small", img.Source.Small},
+		{"thumb", img.Source.Thumb},
+	} {
+		body, err := a.fetch(d.url)
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if err = a.copy(dir+d.name+".jpg", body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *archive) original(path, name, kind string) error {
+	body, err := a.open(name, kind)
+	if err != nil {
+		return err
+	}
+	return a.copy(path, body)
+}
+
+func (a *archive) json(path string, v interface{}) error {
+	w, err := a.zw.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// copy streams body into the archive and closes it. Images are already
+// compressed, so they are stored as they are.
+func (a *archive) copy(path string, body io.ReadCloser) error {
+	defer body.Close()
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: path, Method: zip.Store, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, body)
+	return err
+}
@@ -0,0 +1,274 @@
+package export
+
+import (
+	"archive/zip"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/generator"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/upload"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	Pending  = "pending"
+	Building = "building"
+	Ready    = "ready"
+	Failed   = "failed"
+	Expired  = "expired"
+)
+
+const (
+	// Lifetime is how long a finished export can be downloaded.
+	Lifetime = 48 * time.Hour
+	// maxAttempts is how many times an export is built before giving up.
+	maxAttempts = 3
+	// stale is how long an export can be building before it is assumed the
+	// server building it went away, and another takes over.
+	stale = time.Hour
+
+	bucket = "exports-fokal"
+	region = "us-west-1"
+)
+
+// ErrInProgress is returned when the user already has an export waiting to be
+// built.
+var ErrInProgress = errors.New("An export is already being built")
+
+// client fetches derivatives. Large originals can take a while to render.
+var client = &http.Client{Timeout: 2 * time.Minute}
+
+// Export is a zip of everything kept about a user. URL is only set on ready
+// exports, and stops working when they expire.
+type Export struct {
+	Id          int64      `db:"id" json:"id"`
+	UserId      int64      `db:"user_id" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	Attempts    int        `db:"attempts" json:"-"`
+	Key         *string    `db:"key" json:"-"`
+	Size        *int64     `db:"size" json:"size,omitempty"`
+	Error       *string    `db:"error" json:"-"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	StartedAt   *time.Time `db:"started_at" json:"-"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `db:"expires_at" json:"expires_at,omitempty"`
+	URL         string     `db:"-" json:"url,omitempty"`
+}
+
+// link sets the export's download url, valid until the export expires.
+func (e *Export) link() error {
+	if e.Status != Ready || e.Key == nil || e.ExpiresAt == nil {
+		return nil
+	}
+	ttl := time.Until(*e.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	url, err := upload.PresignAWS(*e.Key, bucket, region, ttl)
+	if err != nil {
+		return err
+	}
+	e.URL = url
+	return nil
+}
+
+// Create queues an export of the user's account.
+func Create(db *sqlx.DB, user int64) (Export, error) {
+	e := Export{}
+	err := db.Get(&e, "INSERT INTO content.exports (user_id) VALUES ($1) RETURNING *", user)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return Export{}, ErrInProgress
+	}
+	if err != nil {
+		log.Println(err)
+		return Export{}, err
+	}
+	return e, nil
+}
+
+// Get returns one of the user's exports, with its download url once it is
+// ready.
+func Get(db *sqlx.DB, user, id int64) (Export, error) {
+	e := Export{}
+	err := db.Get(&e, "SELECT * FROM content.exports WHERE id = $1 AND user_id = $2", id, user)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return Export{}, err
+	}
+	return e, e.link()
+}
+
+// List returns the user's latest exports, newest first.
+func List(db *sqlx.DB, user int64) ([]Export, error) {
+	exports := []Export{}
+	err := db.Select(&exports, `
+	SELECT * FROM content.exports
+	WHERE user_id = $1
+	ORDER BY created_at DESC, id DESC
+	LIMIT 25`, user)
+	if err != nil {
+		log.Println(err)
+	}
+	return exports, err
+}
+
+// Build builds queued exports one at a time until none are left, then expires
+// old ones. Servers building at the same time each take different exports.
+func Build(state *handler.State) error {
+	for {
+		e := Export{}
+		err := state.DB.Get(&e, `
+		UPDATE content.exports
+			SET status = 'building', attempts = attempts + 1, started_at = timezone('UTC'::text, now())
+		WHERE id = (
+			SELECT id FROM content.exports
+			WHERE attempts < $2
+				AND (status = 'pending' OR (status = 'building' AND started_at < $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`, time.Now().Add(-stale), maxAttempts)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		key, size, err := build(state, e)
+		finish(state, e, key, size, err)
+	}
+
+	_, err := state.DB.Exec(`
+	UPDATE content.exports
+		SET status = 'failed', completed_at = timezone('UTC'::text, now())
+	WHERE status = 'building' AND started_at < $1 AND attempts >= $2`, time.Now().Add(-stale), maxAttempts)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return expire(state.DB)
+}
+
+// build streams the export's zip straight into storage as it is written.
+func build(state *handler.State, e Export) (string, int64, error) {
+	key := fmt.Sprintf("exports/%d/%s.zip", e.UserId, generator.RandString(32))
+	log.Printf("Building export_id = %d for user_id = %d\n", e.Id, e.UserId)
+
+	pr, pw := io.Pipe()
+	written := &counter{w: pw}
+	go func() {
+		zw := zip.NewWriter(written)
+		a := &archive{zw: zw, open: upload.Original, fetch: fetch}
+		err := a.write(state, e.UserId)
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	err := upload.StreamAWS(pr, "application/zip", key, bucket, region)
+	if err != nil {
+		// Unblocks the writer if the upload gave up partway through.
+		pr.CloseWithError(err)
+		return "", 0, err
+	}
+	return key, written.n, nil
+}
+
+// finish records how building the export went and tells its user once it is
+// ready or has failed for good.
+func finish(state *handler.State, e Export, key string, size int64, buildErr error) {
+	status := Ready
+	var err error
+	if buildErr == nil {
+		_, err = state.DB.Exec(`
+		UPDATE content.exports
+			SET status = 'ready', key = $2, size = $3, error = NULL,
+				completed_at = timezone('UTC'::text, now()),
+				expires_at = timezone('UTC'::text, now()) + $4 * INTERVAL '1 second'
+		WHERE id = $1`, e.Id, key, size, Lifetime.Seconds())
+	} else {
+		log.Printf("Unable to build export_id = %d %s", e.Id, buildErr)
+		status = Pending
+		if e.Attempts >= maxAttempts {
+			status = Failed
+		}
+		_, err = state.DB.Exec(`
+		UPDATE content.exports
+			SET status = $2, error = $3,
+				completed_at = CASE WHEN $2 = 'failed' THEN timezone('UTC'::text, now()) END
+		WHERE id = $1`, e.Id, status, buildErr.Error())
+	}
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if status != Pending {
+		events.Publish(state.RD, e.UserId, events.Export, map[string]interface{}{"id": e.Id, "status": status})
+	}
+}
+
+// expire removes finished exports from storage once they can no longer be
+// downloaded.
+func expire(db *sqlx.DB) error {
+	exports := []Export{}
+	err := db.Select(&exports, `
+	SELECT * FROM content.exports
+	WHERE status = 'ready' AND expires_at < timezone('UTC'::text, now())`)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, e := range exports {
+		if e.Key != nil {
+			if err := upload.RemoveAWS(*e.Key, bucket, region); err != nil {
+				continue
+			}
+		}
+		_, err := db.Exec("UPDATE content.exports SET status = 'expired', key = NULL WHERE id = $1", e.Id)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// fetch downloads a derivative.
+func fetch(url string) (io.ReadCloser, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+	}
+	return resp.Body, nil
+}
+
+// counter counts what is written through it.
+type counter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,106 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/model"
+)
+
+func TestArchiveImage(t *testing.T) {
+	buf := new(bytes.Buffer)
+	a := &archive{
+		zw: zip.NewWriter(buf),
+		open: func(name, kind string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(kind + "/" + name)), nil
+		},
+		fetch: func(url string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(url)), nil
+		},
+	}
+	img := model.Image{Shortcode: "abcdefghijkl", Source: model.ImageSource{
+		Large: "l", Medium: "m", Small: "s", Thumb: "t",
+	}}
+	if err := a.image(img); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"images/abcdefghijkl/original.jpg": "content/abcdefghijkl",
+		"images/abcdefghijkl/large.jpg":    "l",
+		"images/abcdefghijkl/medium.jpg":   "m",
+		"images/abcdefghijkl/small.jpg":    "s",
+		"images/abcdefghijkl/thumb.jpg":    "t",
+	}
+	found := map[string]bool{}
+	for _, f := range zr.File {
+		found[f.Name] = true
+		want, ok := expected[f.Name]
+		if !ok {
+			continue
+		}
+		if f.Method != zip.Store {
+			t.Errorf("expected %s to be stored uncompressed", f.Name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(got) != want {
+			t.Errorf("expected %s to hold %q, got %q", f.Name, want, got)
+		}
+	}
+	for name := range expected {
+		if !found[name] {
+			t.Errorf("expected %s in the archive", name)
+		}
+	}
+	if !found["images/abcdefghijkl/image.json"] {
+		t.Error("expected the image's metadata in the archive")
+	}
+}
+
+func TestArchiveFetchError(t *testing.T) {
+	a := &archive{
+		zw: zip.NewWriter(ioutil.Discard),
+		open: func(name, kind string) (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		},
+		fetch: func(url string) (io.ReadCloser, error) {
+			return nil, errors.New("unavailable")
+		},
+	}
+	if err := a.image(model.Image{Shortcode: "abcdefghijkl"}); err == nil {
+		t.Error("expected a missing derivative to fail the export")
+	}
+}
+
+func TestLink(t *testing.T) {
+	key := "exports/1/abc.zip"
+	past := time.Now().Add(-time.Minute)
+	for _, e := range []Export{
+		{Status: Pending},
+		{Status: Ready},
+		{Status: Ready, Key: &key, ExpiresAt: &past},
+		{Status: Expired, Key: &key},
+	} {
+		if err := e.link(); err != nil || e.URL != "" {
+			t.Errorf("expected no link for %+v, got %q, %v", e, e.URL, err)
+		}
+	}
+}
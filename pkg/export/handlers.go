@@ -0,0 +1,60 @@
+package export
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// CreateHandler queues an export of the logged in user's account. It is built
+// in the background and its user is sent an export event once it is ready.
+func CreateHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+
+	e, err := Create(state.DB, user.Id)
+	if err == ErrInProgress {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create export")}
+	}
+
+	audit.Record(state.DB, r, "user.export", user, nil, e)
+	return handler.Response{Code: http.StatusAccepted, Data: e}, nil
+}
+
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+
+	exports, err := List(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve exports")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: exports}, nil
+}
+
+// GetHandler returns one of the logged in user's exports, with a link to
+// download it from while it is ready.
+func GetHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Export not found")}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["export"], 10, 64)
+	if err != nil {
+		return handler.Response{}, notFound
+	}
+
+	e, err := Get(state.DB, user.Id, id)
+	if err == sql.ErrNoRows {
+		return handler.Response{}, notFound
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve export")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: e}, nil
+}
@@ -0,0 +1,179 @@
+package feed
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+)
+
+// Relations returned by stream.
+const (
+	Published = "published"
+	Favorites = "favorites"
+	Follows   = "follows"
+)
+
+// actions names what happened for each relation returned by stream.
+var actions = map[string]string{
+	Published: "published",
+	Favorites: "favorited",
+	Follows:   "followed",
+}
+
+// Item is something done by someone the viewer follows. Image is set for
+// publishes and favorites, User for follows.
+type Item struct {
+	Action    string       `json:"action"`
+	Actor     string       `json:"actor"`
+	ActorLink string       `json:"actor_permalink"`
+	Image     *model.Image `json:"image,omitempty"`
+	User      *model.User  `json:"user,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// Page is one page of the feed. Next is empty on the last page.
+type Page struct {
+	Items []Item `json:"items"`
+	Next  string `json:"next_cursor,omitempty"`
+}
+
+// Cursor marks the last item of a page. Items are ordered by time, then type
+// and id, so the next page starts right after it.
+type Cursor struct {
+	Time time.Time
+	Type string
+	ID   int64
+}
+
+var errCursor = errors.New("invalid cursor")
+
+func (c Cursor) String() string {
+	raw := fmt.Sprintf("%d:%s:%d", c.Time.UnixNano(), c.Type, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor reads a cursor returned as next_cursor.
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, errCursor
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return Cursor{}, errCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, errCursor
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || (parts[1] != "image" && parts[1] != "user") {
+		return Cursor{}, errCursor
+	}
+	return Cursor{Time: time.Unix(0, nanos).UTC(), Type: parts[1], ID: id}, nil
+}
+
+type entry struct {
+	UserID    int64     `db:"user_id"`
+	Username  string    `db:"username"`
+	ID        int64     `db:"o_id"`
+	Type      string    `db:"t"`
+	Relation  string    `db:"relation"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Get returns up to limit items from the viewer's feed, newest first, starting
+// after the cursor if there is one.
+func Get(state *handler.State, viewer int64, after *Cursor, limit int) (Page, error) {
+	var (
+		since *time.Time
+		t     string
+		id    int64
+	)
+	if after != nil {
+		since, t, id = &after.Time, after.Type, after.ID
+	}
+
+	entries := []entry{}
+	err := state.DB.Select(&entries, `
+	SELECT s.user_id, users.username, s.o_id, s.t, s.relation, s.created_at
+	FROM stream($1, $2, $3, $4, $5) AS s
+		INNER JOIN content.users AS users ON users.id = s.user_id
+	ORDER BY s.created_at DESC, s.t :: TEXT DESC, s.o_id DESC`, viewer, since, t, id, limit+1)
+	if err != nil {
+		log.Println(err)
+		return Page{}, err
+	}
+
+	page := Page{Items: []Item{}}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		last := entries[limit-1]
+		page.Next = Cursor{Time: last.CreatedAt, Type: last.Type, ID: last.ID}.String()
+	}
+
+	for _, e := range entries {
+		item := Item{
+			Action:    actions[e.Relation],
+			Actor:     e.Username,
+			ActorLink: model.Ref{Collection: model.Users, Shortcode: e.Username}.ToURL(state.Port, state.Local),
+			CreatedAt: e.CreatedAt,
+		}
+
+		switch e.Type {
+		case "image":
+			img, err := retrieval.GetImage(state, e.ID)
+			if err != nil {
+				return Page{}, err
+			}
+			item.Image = &img
+		case "user":
+			user, err := retrieval.GetUser(state, viewer, e.ID)
+			if err != nil {
+				return Page{}, err
+			}
+			item.User = &user
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
+
+// Announce pushes what the actor just did to the streams of their followers
+// whose feed it would show up in, so they know to fetch it. action is one of
+// Published, Favorites or Follows.
+func Announce(state *handler.State, actor model.Ref, action string, subject model.Ref) error {
+	followers := []int64{}
+	err := state.DB.Select(&followers, `
+	SELECT follows.user_id
+	FROM content.user_follows AS follows
+	WHERE follows.followed_id = $1 AND follows.user_id <> $2
+		AND permissions.viewable(follows.user_id, $2, $3 :: CONTENT_TYPE)
+		AND permissions.viewable(follows.user_id, $1, 'user')
+		AND NOT permissions.hidden(follows.user_id, $1)
+		AND NOT permissions.hidden(follows.user_id, CASE $3
+			WHEN 'image' THEN (SELECT images.user_id FROM content.images AS images WHERE images.id = $2)
+			ELSE $2 END)`, actor.Id, subject.Id, subject.Collection.String())
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	return events.PublishAll(state.RD, followers, events.Feed, map[string]string{
+		"action":          actions[action],
+		"actor":           actor.Shortcode,
+		"actor_permalink": actor.ToURL(state.Port, state.Local),
+		"id":              subject.Shortcode,
+		"permalink":       subject.ToURL(state.Port, state.Local),
+	})
+}
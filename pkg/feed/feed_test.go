@@ -0,0 +1,24 @@
+package feed
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	c := Cursor{Time: time.Date(2018, 3, 4, 5, 6, 7, 891011, time.UTC), Type: "image", ID: 42}
+
+	parsed, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.Time.Equal(c.Time) || parsed.Type != c.Type || parsed.ID != c.ID {
+		t.Errorf("expected %+v, got %+v", c, parsed)
+	}
+
+	for _, invalid := range []string{"", "not a cursor", Cursor{Type: "tag", ID: 1}.String()} {
+		if _, err := ParseCursor(invalid); err == nil {
+			t.Errorf("expected %q to be refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package feed
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// FeedHandler returns a page of the logged in user's feed. Pages after the
+// first are requested with the previous page's next_cursor.
+func FeedHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	limit, _, err := handler.Page(r, defaultLimit, maxLimit)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	var after *Cursor
+	if c := r.URL.Query().Get("cursor"); c != "" {
+		cursor, err := ParseCursor(c)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+		after = &cursor
+	}
+
+	page, err := Get(state, user.Id, after, limit)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve feed")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: page}, nil
+}
@@ -0,0 +1,252 @@
+package groups
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/jmoiron/sqlx"
+)
+
+type Role string
+
+const (
+	Owner  = Role("owner")
+	Admin  = Role("admin")
+	Member = Role("member")
+)
+
+var rank = map[Role]int{Member: 1, Admin: 2, Owner: 3}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, error) {
+	r := Role(name)
+	if _, ok := rank[r]; !ok {
+		return "", fmt.Errorf("unknown role %s", name)
+	}
+	return r, nil
+}
+
+// AtLeast reports whether r carries every power of o.
+func (r Role) AtLeast(o Role) bool {
+	return rank[r] >= rank[o]
+}
+
+// ErrLastOwner is returned when a change would leave a group without an owner.
+var ErrLastOwner = errors.New("groups must keep at least one owner")
+
+// Create stores a new group owned by the user.
+func Create(db *sqlx.DB, userID int64, name string, description *string) (model.Ref, error) {
+	sc, err := retrieval.GenerateSC(db, model.Groups)
+	if err != nil {
+		return model.Ref{}, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return model.Ref{}, err
+	}
+
+	ref := model.Ref{Collection: model.Groups, Shortcode: sc}
+	err = tx.Get(&ref.Id, `
+	INSERT INTO content.groups(shortcode, name, description)
+	VALUES ($1, $2, $3) RETURNING id;`, sc, name, description)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return model.Ref{}, err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO content.group_members(group_id, user_id, role) VALUES ($1, $2, 'owner');`, ref.Id, userID)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return model.Ref{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return model.Ref{}, err
+	}
+	return ref, nil
+}
+
+// GetRef returns the reference for the group shortcode.
+func GetRef(db *sqlx.DB, shortcode string) (model.Ref, error) {
+	ref := model.Ref{Collection: model.Groups, Shortcode: shortcode}
+	err := db.Get(&ref.Id, "SELECT id FROM content.groups WHERE shortcode = $1", shortcode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return model.Ref{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No group found")}
+		}
+		log.Println(err)
+		return model.Ref{}, err
+	}
+	return ref, nil
+}
+
+// Get returns the group along with its members.
+func Get(state *handler.State, id int64) (model.Group, error) {
+	group := model.Group{}
+	err := state.DB.Get(&group, `
+	SELECT id, shortcode, name, description, created_at, last_modified
+	FROM content.groups WHERE id = $1`, id)
+	if err != nil {
+		log.Println(err)
+		return model.Group{}, err
+	}
+
+	group.Members = []model.GroupMember{}
+	err = state.DB.Select(&group.Members, `
+	SELECT users.username, members.role, members.created_at
+	FROM content.group_members AS members
+		INNER JOIN content.users AS users ON users.id = members.user_id
+	WHERE members.group_id = $1
+	ORDER BY members.created_at`, id)
+	if err != nil {
+		log.Println(err)
+		return model.Group{}, err
+	}
+
+	ref := model.Ref{Id: group.Id, Collection: model.Groups, Shortcode: group.Shortcode}
+	group.Permalink = ref.ToURL(state.Port, state.Local)
+	return group, nil
+}
+
+// ForUser returns the groups the user belongs to, without their members.
+func ForUser(state *handler.State, userID int64) ([]model.Group, error) {
+	groups := []model.Group{}
+	err := state.DB.Select(&groups, `
+	SELECT groups.id, groups.shortcode, groups.name, groups.description, groups.created_at, groups.last_modified
+	FROM content.groups AS groups
+		INNER JOIN content.group_members AS members ON members.group_id = groups.id
+	WHERE members.user_id = $1
+	ORDER BY groups.name`, userID)
+	if err != nil {
+		log.Println(err)
+		return []model.Group{}, err
+	}
+
+	for i, g := range groups {
+		ref := model.Ref{Id: g.Id, Collection: model.Groups, Shortcode: g.Shortcode}
+		groups[i].Permalink = ref.ToURL(state.Port, state.Local)
+	}
+	return groups, nil
+}
+
+// MemberRole returns the user's role in the group, or false if they are not a
+// member.
+func MemberRole(db *sqlx.DB, groupID, userID int64) (Role, bool, error) {
+	var role Role
+	err := db.Get(&role, "SELECT role FROM content.group_members WHERE group_id = $1 AND user_id = $2", groupID, userID)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		log.Println(err)
+		return "", false, err
+	}
+	return role, true, nil
+}
+
+// SetMember adds the user to the group or changes their role.
+func SetMember(db *sqlx.DB, groupID, userID int64, role Role) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO content.group_members(group_id, user_id, role) VALUES ($1, $2, $3)
+	ON CONFLICT (group_id, user_id) DO UPDATE SET role = excluded.role;`, groupID, userID, role)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+
+	err = keepOwner(tx, groupID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveMember takes the user out of the group.
+func RemoveMember(db *sqlx.DB, groupID, userID int64) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	res, err := tx.Exec("DELETE FROM content.group_members WHERE group_id = $1 AND user_id = $2", groupID, userID)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		tx.Rollback()
+		return false, err
+	}
+
+	err = keepOwner(tx, groupID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func keepOwner(tx *sqlx.Tx, groupID int64) error {
+	var owners int
+	err := tx.Get(&owners, "SELECT count(*) FROM content.group_members WHERE group_id = $1 AND role = 'owner'", groupID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// Delete removes the group and everything shared with it.
+func Delete(db *sqlx.DB, groupID int64) error {
+	_, err := db.Exec("DELETE FROM content.groups WHERE id = $1", groupID)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Images returns the images shared with the group that the member can see,
+// leaving out trashed and taken down images.
+func Images(state *handler.State, member, groupID int64) ([]model.Image, error) {
+	ids := []int64{}
+	err := state.DB.Select(&ids, `
+	SELECT images.id
+	FROM content.images AS images
+	WHERE images.id IN (
+		SELECT o_id FROM permissions.group_can_view WHERE group_id = $1 AND type = 'image'
+		UNION
+		SELECT o_id FROM permissions.group_can_edit WHERE group_id = $1 AND type = 'image')
+		AND permissions.viewable($2, images.id, 'image')
+	ORDER BY images.publish_time DESC`, groupID, member)
+	if err != nil {
+		log.Println(err)
+		return []model.Image{}, err
+	}
+	return retrieval.GetImages(state, ids)
+}
@@ -0,0 +1,207 @@
+package groups
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+func authUser(r *http.Request) (model.Ref, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return model.Ref{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+	return user, nil
+}
+
+// membership returns the logged in user, the group in the url and the user's
+// role in it. Groups are hidden from those who are not members.
+func membership(state *handler.State, r *http.Request) (model.Ref, model.Ref, Role, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return model.Ref{}, model.Ref{}, "", err
+	}
+
+	group, err := GetRef(state.DB, mux.Vars(r)["ID"])
+	if err != nil {
+		return model.Ref{}, model.Ref{}, "", err
+	}
+
+	role, ok, err := MemberRole(state.DB, group.Id, user.Id)
+	if err != nil {
+		return model.Ref{}, model.Ref{}, "", handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !ok {
+		return model.Ref{}, model.Ref{}, "", handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No group found")}
+	}
+	return user, group, role, nil
+}
+
+func CreateHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.CreateGroupRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	ref, err := Create(state.DB, user.Id, req.Name, req.Description)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create group")}
+	}
+
+	audit.Record(state.DB, r, "group.create", ref, nil, req)
+
+	group, err := Get(state, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return handler.Response{Code: http.StatusCreated, Data: group}, nil
+}
+
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := authUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	groups, err := ForUser(state, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve groups")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: groups}, nil
+}
+
+func GroupHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	_, ref, _, err := membership(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	group, err := Get(state, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return handler.Response{Code: http.StatusOK, Data: group}, nil
+}
+
+func ImagesHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ref, _, err := membership(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	images, err := Images(state, user.Id, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return handler.Response{Code: http.StatusOK, Data: images}, nil
+}
+
+func DeleteHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	_, ref, role, err := membership(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if !role.AtLeast(Owner) {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Only owners can delete a group")}
+	}
+
+	before, _ := Get(state, ref.Id)
+	err = Delete(state.DB, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete group")}
+	}
+
+	audit.Record(state.DB, r, "group.delete", ref, before, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
+
+// SetMemberHandler adds a user to the group or changes their role. Admins
+// manage members, only owners can appoint or change owners.
+func SetMemberHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	_, group, role, err := membership(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.GroupMemberRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	newRole, err := ParseRole(req.Role)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	member, err := retrieval.GetUserRef(state.DB, mux.Vars(r)["user"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	current, _, err := MemberRole(state.DB, group.Id, member.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !role.AtLeast(Admin) || ((newRole == Owner || current == Owner) && role != Owner) {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Not allowed to change this member")}
+	}
+
+	err = SetMember(state.DB, group.Id, member.Id, newRole)
+	if err == ErrLastOwner {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to change member")}
+	}
+
+	audit.Record(state.DB, r, "group.member", group,
+		map[string]Role{member.Shortcode: current}, map[string]Role{member.Shortcode: newRole})
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+// RemoveMemberHandler takes a user out of the group. Members can always leave.
+func RemoveMemberHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, group, role, err := membership(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	member, err := retrieval.GetUserRef(state.DB, mux.Vars(r)["user"])
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	if member.Id != user.Id {
+		current, _, err := MemberRole(state.DB, group.Id, member.Id)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+		if !role.AtLeast(Admin) || (current == Owner && role != Owner) {
+			return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Not allowed to remove this member")}
+		}
+	}
+
+	removed, err := RemoveMember(state.DB, group.Id, member.Id)
+	if err == ErrLastOwner {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to remove member")}
+	}
+	if !removed {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("User is not a member")}
+	}
+
+	audit.Record(state.DB, r, "group.remove_member", group, map[string]bool{member.Shortcode: true}, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
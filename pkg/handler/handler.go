@@ -8,11 +8,14 @@ import (
 	"bytes"
 	"encoding/json"
 
-	"crypto/rsa"
 	"time"
 
 	"strings"
 
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/keys"
+	"github.com/fokal/fokal-core/pkg/oidc"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
 	"github.com/garyburd/redigo/redis"
 	raven "github.com/getsentry/raven-go"
 	"github.com/gorilla/context"
@@ -75,10 +78,14 @@ type State struct {
 	NewRelic newrelic.Application
 
 	SessionLifetime time.Duration
+	MaxSessionAge   time.Duration
 	RefreshAt       time.Duration
-	PrivateKey      *rsa.PrivateKey
-	PublicKeys      map[string]*rsa.PublicKey
-	KeyHash         string
+	Keys            *keys.Ring
+	ShareSecret     []byte
+	ImageToken      []byte
+	Providers       *oidc.Registry
+	RateLimits      ratelimit.Limits
+	Events          *events.Hub
 }
 
 // Handler struct that takes a configured Env and a function matching
@@ -88,8 +95,17 @@ type Handler struct {
 	H func(e *State, w http.ResponseWriter, r *http.Request) (Response, error)
 }
 
-// ServeHTTP allows our Handler type to satisfy http.Handler.
+// ServeHTTP allows our Handler type to satisfy http.Handler. Every request is
+// counted towards the default rate limit here, once any authentication
+// middleware has run, so it is counted against the user rather than their IP.
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.State != nil && h.RD != nil {
+		limit := ratelimit.Middleware{Pool: h.RD, Limits: h.RateLimits, C: ratelimit.Default}
+		if !limit.Allow(w, r) {
+			return
+		}
+	}
+
 	res, err := h.H(h.State, w, r)
 	if err != nil {
 		switch e := err.(type) {
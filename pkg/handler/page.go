@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// Page reads the limit and offset params of a listing. The limit is def when
+// it is left out and capped at max.
+func Page(r *http.Request, def, max int) (int, int, error) {
+	params := r.URL.Query()
+	limit, offset := def, 0
+	if raw := params.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return 0, 0, StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid limit")}
+		}
+		limit = n
+	}
+	if limit > max {
+		limit = max
+	}
+	if raw := params.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return 0, 0, StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid offset")}
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		query  string
+		limit  int
+		offset int
+		code   int
+	}{
+		{"", 25, 0, 0},
+		{"?limit=10&offset=20", 10, 20, 0},
+		{"?limit=1000", 100, 0, 0},
+		{"?limit=0", 0, 0, http.StatusBadRequest},
+		{"?limit=ten", 0, 0, http.StatusBadRequest},
+		{"?offset=-1", 0, 0, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/v0/images"+test.query, nil)
+		limit, offset, err := Page(r, 25, 100)
+
+		if test.code != 0 {
+			e, ok := err.(StatusError)
+			if !ok || e.Code != test.code {
+				t.Errorf("%q: expected status %d, got %v", test.query, test.code, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", test.query, err)
+			continue
+		}
+		if limit != test.limit || offset != test.offset {
+			t.Errorf("%q: expected %d, %d, got %d, %d", test.query, test.limit, test.offset, limit, offset)
+		}
+	}
+}
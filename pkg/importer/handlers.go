@@ -0,0 +1,76 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// CreateHandler stores the zip archive in the body and queues it to be
+// imported under the logged in user. It is imported in the background and
+// its user is sent an import event once it is done.
+func CreateHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/zip" && mediaType != "application/x-zip-compressed" {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnsupportedMediaType, Err: errors.New("Archive must be a zip")}
+	}
+
+	if r.ContentLength > MaxArchiveSize {
+		return handler.Response{}, handler.StatusError{Code: http.StatusRequestEntityTooLarge, Err: ErrArchiveTooLarge}
+	}
+
+	// One byte more than allowed is read so Upload can tell the archive is
+	// too large.
+	imp, err := Upload(state.DB, user.Id, http.MaxBytesReader(w, r.Body, MaxArchiveSize+1))
+	switch {
+	case err == ErrInProgress:
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+	case err == ErrArchiveTooLarge:
+		return handler.Response{}, handler.StatusError{Code: http.StatusRequestEntityTooLarge, Err: err}
+	case err != nil:
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to store archive")}
+	}
+
+	audit.Record(state.DB, r, "user.import", user, nil, imp)
+	return handler.Response{Code: http.StatusAccepted, Data: imp}, nil
+}
+
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+
+	imports, err := List(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve imports")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: imports}, nil
+}
+
+// GetHandler returns one of the logged in user's imports, with the files in
+// it that couldn't be imported and why.
+func GetHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Import not found")}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["import"], 10, 64)
+	if err != nil {
+		return handler.Response{}, notFound
+	}
+
+	imp, err := Get(state.DB, user.Id, id)
+	if err == sql.ErrNoRows {
+		return handler.Response{}, notFound
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve import")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: imp}, nil
+}
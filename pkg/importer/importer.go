@@ -0,0 +1,420 @@
+package importer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/create"
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/generator"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/upload"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	Pending = "pending"
+	Running = "running"
+	Done    = "done"
+	Failed  = "failed"
+)
+
+// Statuses of the files in an import.
+const (
+	Imported = "imported"
+	Skipped  = "skipped"
+)
+
+const (
+	// maxAttempts is how many times an import is run before giving up.
+	maxAttempts = 3
+	// stale is how long an import can go without finishing a file before it
+	// is assumed the server running it went away, and another takes over.
+	stale = 15 * time.Minute
+
+	bucket = "imports-fokal"
+	region = "us-west-1"
+)
+
+// MaxArchiveSize is the largest archive that can be uploaded, in bytes.
+// Larger ones can be imported with fokal-import.
+const MaxArchiveSize = 4 << 30
+
+// ErrInProgress is returned when the user already has an uploaded archive
+// waiting to be imported.
+var ErrInProgress = errors.New("An import is already in progress")
+
+// ErrArchiveTooLarge is returned when an uploaded archive is larger than
+// MaxArchiveSize.
+var ErrArchiveTooLarge = errors.New("Archive is too large to upload")
+
+// Import publishes the images in an archive under its user. Imports of
+// uploaded archives have a Key, those run from fokal-import don't.
+type Import struct {
+	Id          int64      `db:"id" json:"id"`
+	UserId      int64      `db:"user_id" json:"-"`
+	Status      string     `db:"status" json:"status"`
+	Attempts    int        `db:"attempts" json:"-"`
+	Key         *string    `db:"key" json:"-"`
+	Total       int        `db:"total" json:"total"`
+	Imported    int        `db:"imported" json:"imported"`
+	Skipped     int        `db:"skipped" json:"skipped"`
+	Failed      int        `db:"failed" json:"failed"`
+	Error       *string    `db:"error" json:"-"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	StartedAt   *time.Time `db:"started_at" json:"-"`
+	CompletedAt *time.Time `db:"completed_at" json:"completed_at,omitempty"`
+	Failures    []Item     `db:"-" json:"failures,omitempty"`
+}
+
+// Item is how importing a file in an archive went.
+type Item struct {
+	Path   string  `db:"path" json:"path"`
+	Status string  `db:"status" json:"status"`
+	Image  *string `db:"image" json:"image,omitempty"`
+	Error  *string `db:"error" json:"error,omitempty"`
+}
+
+// selectImports counts how each import's files went alongside it.
+const selectImports = `
+	SELECT imports.*,
+		count(items.path) FILTER (WHERE items.status = 'imported') AS imported,
+		count(items.path) FILTER (WHERE items.status = 'skipped') AS skipped,
+		count(items.path) FILTER (WHERE items.status = 'failed') AS failed
+	FROM content.imports AS imports
+		LEFT JOIN content.import_items AS items ON items.import_id = imports.id
+	`
+
+// Upload stores the zip archive in body and queues it to be imported. Archives
+// larger than MaxArchiveSize are removed again once that is found out.
+func Upload(db *sqlx.DB, user int64, body io.Reader) (Import, error) {
+	var pending bool
+	err := db.Get(&pending, `
+	SELECT exists(SELECT 1 FROM content.imports
+		WHERE user_id = $1 AND status IN ('pending', 'running') AND key IS NOT NULL)`, user)
+	if err != nil {
+		log.Println(err)
+		return Import{}, err
+	}
+	if pending {
+		return Import{}, ErrInProgress
+	}
+
+	key := fmt.Sprintf("imports/%d/%s.zip", user, generator.RandString(32))
+	limited := &io.LimitedReader{R: body, N: MaxArchiveSize + 1}
+	if err = upload.StreamAWS(limited, "application/zip", key, bucket, region); err != nil {
+		return Import{}, err
+	}
+	if limited.N == 0 {
+		upload.RemoveAWS(key, bucket, region)
+		return Import{}, ErrArchiveTooLarge
+	}
+
+	imp := Import{}
+	err = db.Get(&imp, "INSERT INTO content.imports (user_id, key) VALUES ($1, $2) RETURNING *", user, key)
+	if err != nil {
+		upload.RemoveAWS(key, bucket, region)
+		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+			return Import{}, ErrInProgress
+		}
+		log.Println(err)
+		return Import{}, err
+	}
+	return imp, nil
+}
+
+// Start records an import of a local archive that is about to be run.
+func Start(db *sqlx.DB, user int64) (Import, error) {
+	imp := Import{}
+	err := db.Get(&imp, `
+	INSERT INTO content.imports (user_id, status, attempts, started_at)
+	VALUES ($1, 'running', 1, timezone('UTC'::text, now())) RETURNING *`, user)
+	if err != nil {
+		log.Println(err)
+	}
+	return imp, err
+}
+
+// Resume picks a local import back up where it stopped.
+func Resume(db *sqlx.DB, user, id int64) (Import, error) {
+	imp := Import{}
+	err := db.Get(&imp, `
+	UPDATE content.imports
+		SET status = 'running', attempts = attempts + 1, error = NULL, completed_at = NULL,
+			started_at = timezone('UTC'::text, now())
+	WHERE id = $1 AND user_id = $2 AND key IS NULL
+	RETURNING *`, id, user)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return imp, err
+}
+
+// Get returns one of the user's imports, with the files that failed.
+func Get(db *sqlx.DB, user, id int64) (Import, error) {
+	imp := Import{}
+	err := db.Get(&imp, selectImports+`
+	WHERE imports.id = $1 AND imports.user_id = $2
+	GROUP BY imports.id`, id, user)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return Import{}, err
+	}
+
+	err = db.Select(&imp.Failures, `
+	SELECT path, status, image, error FROM content.import_items
+	WHERE import_id = $1 AND status = 'failed'
+	ORDER BY path`, id)
+	if err != nil {
+		log.Println(err)
+	}
+	return imp, err
+}
+
+// List returns the user's latest imports, newest first.
+func List(db *sqlx.DB, user int64) ([]Import, error) {
+	imports := []Import{}
+	err := db.Select(&imports, selectImports+`
+	WHERE imports.user_id = $1
+	GROUP BY imports.id
+	ORDER BY imports.created_at DESC, imports.id DESC
+	LIMIT 25`, user)
+	if err != nil {
+		log.Println(err)
+	}
+	return imports, err
+}
+
+// Process runs uploaded archives waiting to be imported one at a time until
+// none are left. Servers importing at the same time each take different
+// archives.
+func Process(state *handler.State) error {
+	for {
+		imp := Import{}
+		err := state.DB.Get(&imp, `
+		UPDATE content.imports
+			SET status = 'running', attempts = attempts + 1, started_at = timezone('UTC'::text, now())
+		WHERE id = (
+			SELECT id FROM content.imports
+			WHERE key IS NOT NULL AND attempts < $2
+				AND (status = 'pending' OR (status = 'running' AND started_at < $1))
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *`, time.Now().Add(-stale), maxAttempts)
+		if err == sql.ErrNoRows {
+			break
+		} else if err != nil {
+			log.Println(err)
+			return err
+		}
+
+		log.Printf("Importing import_id = %d for user_id = %d\n", imp.Id, imp.UserId)
+		Finish(state, imp, fromStorage(state, imp))
+	}
+
+	// Uploaded imports that keep stalling, and local ones whose command
+	// stopped, are given up on.
+	stalled := []Import{}
+	err := state.DB.Select(&stalled, `
+	UPDATE content.imports
+		SET status = 'failed', error = 'Import stopped responding',
+			completed_at = timezone('UTC'::text, now())
+	WHERE status = 'running' AND started_at < $1 AND (attempts >= $2 OR key IS NULL)
+	RETURNING *`, time.Now().Add(-stale), maxAttempts)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	for _, imp := range stalled {
+		cleanup(imp)
+	}
+	return nil
+}
+
+// fromStorage downloads the import's archive and runs it. Zip archives have
+// to be read from their end, so the archive is kept in a temporary file.
+func fromStorage(state *handler.State, imp Import) error {
+	body, err := upload.OpenAWS(*imp.Key, bucket, region)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := ioutil.TempFile("", "fokal-import")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	size, err := io.Copy(f, body)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	src, err := OpenZip(f, size)
+	if err != nil {
+		return err
+	}
+	return Run(state, imp, src)
+}
+
+// Run imports each image in the archive that the import hasn't already been
+// through, recording how each went. Images the user already has are skipped,
+// however they were uploaded.
+func Run(state *handler.State, imp Import, src Source) error {
+	user := model.Ref{Id: imp.UserId, Collection: model.Users}
+	err := state.DB.Get(&user.Shortcode, "SELECT username FROM content.users WHERE id = $1", imp.UserId)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	items := pair(src.entries)
+	_, err = state.DB.Exec("UPDATE content.imports SET total = $2 WHERE id = $1", imp.Id, len(items))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	finished := []string{}
+	err = state.DB.Select(&finished, `
+	SELECT path FROM content.import_items
+	WHERE import_id = $1 AND status IN ('imported', 'skipped')`, imp.Id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	done := map[string]bool{}
+	for _, p := range finished {
+		done[p] = true
+	}
+
+	for _, it := range items {
+		if done[it.image.name] {
+			continue
+		}
+		result := importItem(state, user, it)
+		if err := record(state.DB, imp, result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importItem publishes the item's image, unless the user already has it.
+func importItem(state *handler.State, user model.Ref, it item) Item {
+	result := Item{Path: it.image.name}
+	fail := func(err error) Item {
+		msg := err.Error()
+		if e, ok := err.(handler.StatusError); ok && e.Err == nil {
+			msg = "Unable to publish image"
+		}
+		result.Status, result.Error = Failed, &msg
+		return result
+	}
+
+	file, err := it.image.read(create.MaxImageSize)
+	if err != nil {
+		return fail(err)
+	}
+
+	var existing string
+	err = state.DB.Get(&existing, `
+	SELECT shortcode FROM content.images
+	WHERE user_id = $1 AND content_hash = $2
+	LIMIT 1`, user.Id, create.Hash(file))
+	if err == nil {
+		result.Status, result.Image = Skipped, &existing
+		return result
+	} else if err != sql.ErrNoRows {
+		log.Println(err)
+		return fail(err)
+	}
+
+	ref, err := create.Publish(state, nil, user, file, it.details())
+	if err != nil {
+		return fail(err)
+	}
+	result.Status, result.Image = Imported, &ref.Shortcode
+	return result
+}
+
+// record saves how importing a file went, and marks the import as still
+// making progress.
+func record(db *sqlx.DB, imp Import, it Item) error {
+	_, err := db.Exec(`
+	INSERT INTO content.import_items (import_id, path, status, image, error)
+	VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (import_id, path) DO UPDATE
+			SET status = excluded.status, image = excluded.image, error = excluded.error,
+				created_at = excluded.created_at`, imp.Id, it.Path, it.Status, it.Image, it.Error)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = db.Exec("UPDATE content.imports SET started_at = timezone('UTC'::text, now()) WHERE id = $1", imp.Id)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Finish records how running the import went and tells its user once it is
+// done or has failed for good.
+func Finish(state *handler.State, imp Import, runErr error) {
+	status := Done
+	var errMsg *string
+	if runErr != nil {
+		log.Printf("Unable to run import_id = %d %s", imp.Id, runErr)
+		msg := runErr.Error()
+		errMsg = &msg
+		status = Pending
+		if imp.Key == nil || imp.Attempts >= maxAttempts {
+			status = Failed
+		}
+	}
+
+	_, err := state.DB.Exec(`
+	UPDATE content.imports
+		SET status = $2, error = $3,
+			completed_at = CASE WHEN $2 IN ('done', 'failed') THEN timezone('UTC'::text, now()) END
+	WHERE id = $1`, imp.Id, status, errMsg)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if status != Pending {
+		cleanup(imp)
+		finished, err := Get(state.DB, imp.UserId, imp.Id)
+		if err != nil {
+			return
+		}
+		events.Publish(state.RD, imp.UserId, events.Import, map[string]interface{}{
+			"id": imp.Id, "status": status,
+			"imported": finished.Imported, "skipped": finished.Skipped, "failed": finished.Failed,
+		})
+	}
+}
+
+// cleanup removes a finished import's archive from storage.
+func cleanup(imp Import) {
+	if imp.Key != nil {
+		upload.RemoveAWS(*imp.Key, bucket, region)
+	}
+}
@@ -0,0 +1,193 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func source(files map[string]string) []entry {
+	entries := []entry{}
+	for name, body := range files {
+		body := body
+		entries = append(entries, entry{name: name, open: func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(body)), nil
+		}})
+	}
+	return entries
+}
+
+func TestPair(t *testing.T) {
+	items := pair(source(map[string]string{
+		"Takeout/IMG_1.jpg":                "",
+		"Takeout/IMG_1.jpg.json":           "",
+		"Takeout/IMG_1-edited.jpg":         "",
+		"Takeout/IMG_2(1).jpg":             "",
+		"Takeout/IMG_2.jpg(1).json":        "",
+		"Lightroom/DSC_0001.JPG":           "",
+		"Lightroom/DSC_0001.xmp":           "",
+		"Lightroom/DSC_0001.json":          "",
+		"Flickr/sunset_4912345678_o.jpg":   "",
+		"Flickr/4912345679_0a1b2c3d_o.jpg": "",
+		"Account/photo_4912345678.json":    "",
+		"Account/photo_4912345679.json":    "",
+		"notes.txt":                        "",
+	}))
+
+	expected := map[string][]string{
+		"Flickr/4912345679_0a1b2c3d_o.jpg": {"Account/photo_4912345679.json"},
+		"Flickr/sunset_4912345678_o.jpg":   {"Account/photo_4912345678.json"},
+		"Lightroom/DSC_0001.JPG":           {"Lightroom/DSC_0001.xmp", "Lightroom/DSC_0001.json"},
+		"Takeout/IMG_1-edited.jpg":         {"Takeout/IMG_1.jpg.json"},
+		"Takeout/IMG_1.jpg":                {"Takeout/IMG_1.jpg.json"},
+		"Takeout/IMG_2(1).jpg":             {"Takeout/IMG_2.jpg(1).json"},
+	}
+	if len(items) != len(expected) {
+		t.Fatalf("Expected %d images, got %d", len(expected), len(items))
+	}
+	for i, it := range items {
+		if i > 0 && items[i-1].image.name > it.image.name {
+			t.Errorf("Expected images in order, got %s before %s", items[i-1].image.name, it.image.name)
+		}
+		sidecars := []string{}
+		for _, s := range it.sidecars {
+			sidecars = append(sidecars, s.name)
+		}
+		if strings.Join(sidecars, ",") != strings.Join(expected[it.image.name], ",") {
+			t.Errorf("Expected sidecars %v for %s, got %v", expected[it.image.name], it.image.name, sidecars)
+		}
+	}
+}
+
+func TestDetailsTakeout(t *testing.T) {
+	items := pair(source(map[string]string{
+		"IMG_1.jpg": "",
+		"IMG_1.jpg.json": `{
+			"title": "IMG_1.jpg",
+			"description": "Golden hour",
+			"photoTakenTime": {"timestamp": "1500000000", "formatted": "Jul 14, 2017"},
+			"geoData": {"latitude": 37.7749, "longitude": -122.4194, "altitude": 0.0}
+		}`,
+	}))
+
+	d := items[0].details()
+	if !d.Imported {
+		t.Error("Expected details to be marked as imported")
+	}
+	if d.Title != nil {
+		t.Errorf("Expected file name title to be dropped, got %s", *d.Title)
+	}
+	if d.Description == nil || *d.Description != "Golden hour" {
+		t.Errorf("Expected description Golden hour, got %v", d.Description)
+	}
+	if d.CaptureTime == nil || !d.CaptureTime.Equal(time.Unix(1500000000, 0)) {
+		t.Errorf("Expected capture time from photoTakenTime, got %v", d.CaptureTime)
+	}
+	if d.Point == nil || d.Point.X != -122.4194 || d.Point.Y != 37.7749 {
+		t.Errorf("Expected point from geoData, got %v", d.Point)
+	}
+}
+
+func TestDetailsFlickr(t *testing.T) {
+	items := pair(source(map[string]string{
+		"sunset_4912345678_o.jpg": "",
+		"photo_4912345678.json": `{
+			"id": "4912345678",
+			"name": "Sunset over the bay",
+			"description": "",
+			"date_taken": "2010-08-21 19:42:10",
+			"tags": [{"tag": "sunset", "user": "1234@N00"}, {"tag": "bay", "user": "1234@N00"}],
+			"geo": [{"latitude": "37774900", "longitude": "-122419400", "accuracy": "16"}]
+		}`,
+	}))
+
+	d := items[0].details()
+	if d.Title == nil || *d.Title != "Sunset over the bay" {
+		t.Errorf("Expected title from name, got %v", d.Title)
+	}
+	if d.Description != nil {
+		t.Errorf("Expected empty description to be left unset, got %s", *d.Description)
+	}
+	if strings.Join(d.Tags, ",") != "sunset,bay" {
+		t.Errorf("Expected tags sunset,bay, got %v", d.Tags)
+	}
+	if d.CaptureTime == nil || !d.CaptureTime.Equal(time.Date(2010, 8, 21, 19, 42, 10, 0, time.UTC)) {
+		t.Errorf("Expected capture time from date_taken, got %v", d.CaptureTime)
+	}
+	if d.Point == nil || d.Point.X != -122.4194 || d.Point.Y != 37.7749 {
+		t.Errorf("Expected scaled point from geo, got %v", d.Point)
+	}
+}
+
+func TestDetailsPrecedence(t *testing.T) {
+	items := pair(source(map[string]string{
+		"DSC_0001.jpg": "",
+		"DSC_0001.xmp": `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+			<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+				<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+					<dc:title><rdf:Alt><rdf:li xml:lang="x-default">From Lightroom</rdf:li></rdf:Alt></dc:title>
+				</rdf:Description>
+			</rdf:RDF>
+		</x:xmpmeta>`,
+		"DSC_0001.json": `{"title": "From JSON", "description": "Only in JSON"}`,
+	}))
+
+	d := items[0].details()
+	if d.Title == nil || *d.Title != "From Lightroom" {
+		t.Errorf("Expected the XMP title to win, got %v", d.Title)
+	}
+	if d.Description == nil || *d.Description != "Only in JSON" {
+		t.Errorf("Expected the description to fall back to JSON, got %v", d.Description)
+	}
+}
+
+func TestOpenZip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, name := range []string{"photos/", "photos/a.jpg", "__MACOSX/photos/._a.jpg"} {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := OpenZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.entries) != 1 || src.entries[0].name != "photos/a.jpg" {
+		t.Errorf("Expected only photos/a.jpg, got %v", src.entries)
+	}
+}
+
+func TestEntryRead(t *testing.T) {
+	open := func(body string) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(strings.NewReader(body)), nil
+		}
+	}
+
+	tests := []struct {
+		e   entry
+		err error
+	}{
+		{entry{name: "fits.jpg", size: 4, open: open("1234")}, nil},
+		{entry{name: "declared.jpg", size: 5, open: open("1234")}, ErrTooLarge},
+		{entry{name: "lies.jpg", size: 1, open: open("12345")}, ErrTooLarge},
+	}
+	for _, test := range tests {
+		raw, err := test.e.read(4)
+		if err != test.err {
+			t.Errorf("%s: expected %v, got %v", test.e.name, test.err, err)
+		}
+		if test.err == nil && string(raw) != "1234" {
+			t.Errorf("%s: expected the whole body, got %q", test.e.name, raw)
+		}
+	}
+}
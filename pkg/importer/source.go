@@ -0,0 +1,330 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	postgis "github.com/cridenour/go-postgis"
+	"github.com/fokal/fokal-core/pkg/create"
+	"github.com/fokal/fokal-core/pkg/metadata"
+)
+
+// maxSidecarSize is the largest sidecar that is read, in bytes.
+const maxSidecarSize = 1 << 20
+
+// ErrTooLarge is recorded for images in an archive larger than can be
+// uploaded.
+var ErrTooLarge = errors.New("File is too large")
+
+// entry is a file in an archive, named by its slash separated path from the
+// archive's root. size is the size the archive gives for it.
+type entry struct {
+	name string
+	size int64
+	open func() (io.ReadCloser, error)
+}
+
+// read returns the entry's contents as long as they are no larger than max.
+// The size a zip gives for an entry can't be trusted, so what is read is
+// limited as well.
+func (e entry) read(max int64) ([]byte, error) {
+	if e.size > max {
+		return nil, ErrTooLarge
+	}
+
+	body, err := e.open()
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(raw)) > max {
+		return nil, ErrTooLarge
+	}
+	return raw, nil
+}
+
+// item is an image in an archive and the sidecars found for it, in the order
+// they take precedence.
+type item struct {
+	image    entry
+	sidecars []entry
+}
+
+// flickrImage matches the id in the names Flickr gives originals in its
+// exports, either title_id_o.jpg or id_secret_o.jpg.
+var flickrImage = regexp.MustCompile(`(?:^|_)(\d+)_(?:[0-9a-f]+_)?o\.jpe?g$`)
+
+// flickrSidecar matches the photo_id.json files Flickr keeps the details of
+// each image in.
+var flickrSidecar = regexp.MustCompile(`(?:^|/)photo_(\d+)\.json$`)
+
+// duplicate matches the (n) Takeout appends to the names of images that would
+// otherwise clash, which it puts after the extension in their sidecars.
+var duplicate = regexp.MustCompile(`^(.*)(\(\d+\))$`)
+
+// Source is the files in an archive being imported, either a zip or a
+// directory it was extracted to.
+type Source struct {
+	entries []entry
+}
+
+// OpenZip lists the files in a zip archive.
+func OpenZip(r io.ReaderAt, size int64) (Source, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return Source{}, err
+	}
+
+	entries := []entry{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		entries = append(entries, entry{name: f.Name, size: int64(f.UncompressedSize64), open: f.Open})
+	}
+	return Source{entries}, nil
+}
+
+// OpenDir lists the files under a directory.
+func OpenDir(root string) (Source, error) {
+	entries := []entry{}
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{
+			name: filepath.ToSlash(rel),
+			size: info.Size(),
+			open: func() (io.ReadCloser, error) { return os.Open(p) },
+		})
+		return nil
+	})
+	return Source{entries}, err
+}
+
+// pair finds the images in an archive and the sidecars that describe them.
+// An XMP sidecar is used before a JSON one, and Flickr's photo_id.json files
+// are matched to images by the id in their names wherever they are kept.
+func pair(entries []entry) []item {
+	byName := map[string]entry{}
+	flickr := map[string]entry{}
+	for _, e := range entries {
+		byName[strings.ToLower(e.name)] = e
+		if m := flickrSidecar.FindStringSubmatch(e.name); m != nil {
+			flickr[m[1]] = e
+		}
+	}
+
+	items := []item{}
+	for _, e := range entries {
+		ext := strings.ToLower(path.Ext(e.name))
+		if ext != ".jpg" && ext != ".jpeg" {
+			continue
+		}
+
+		name := strings.ToLower(e.name)
+		stem := strings.TrimSuffix(name, ext)
+		candidates := []string{stem + ".xmp", name + ".xmp", name + ".json", stem + ".json"}
+		// Takeout keeps one sidecar for an image and its edited copy, and
+		// names the sidecars of duplicates IMG_1.jpg(1).json.
+		if strings.HasSuffix(stem, "-edited") {
+			candidates = append(candidates, strings.TrimSuffix(stem, "-edited")+ext+".json")
+		}
+		if m := duplicate.FindStringSubmatch(stem); m != nil {
+			candidates = append(candidates, m[1]+ext+m[2]+".json")
+		}
+
+		it := item{image: e}
+		seen := map[string]bool{}
+		for _, c := range candidates {
+			if s, ok := byName[c]; ok && !seen[c] {
+				it.sidecars = append(it.sidecars, s)
+				seen[c] = true
+			}
+		}
+		if m := flickrImage.FindStringSubmatch(path.Base(name)); m != nil {
+			if s, ok := flickr[m[1]]; ok {
+				it.sidecars = append(it.sidecars, s)
+			}
+		}
+		items = append(items, it)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].image.name < items[j].image.name })
+	return items
+}
+
+// details reads the item's sidecars, taking each field from the first sidecar
+// that sets it. Sidecars that can't be read are skipped.
+func (it item) details() create.Details {
+	d := create.Details{Imported: true}
+	base := path.Base(it.image.name)
+
+	for _, s := range it.sidecars {
+		raw, err := s.read(maxSidecarSize)
+		if err != nil {
+			continue
+		}
+
+		var found create.Details
+		if strings.HasSuffix(strings.ToLower(s.name), ".xmp") {
+			x, err := metadata.ParseXMP(raw)
+			if err != nil {
+				continue
+			}
+			found = create.Details{Title: x.Title, Description: x.Description, Tags: x.Subject,
+				Copyright: x.Rights, CaptureTime: x.CaptureTime, Point: x.Point}
+			if len(x.Creator) > 0 {
+				creator := strings.Join(x.Creator, ", ")
+				found.Creator = &creator
+			}
+		} else {
+			sc := sidecar{}
+			if err := json.Unmarshal(raw, &sc); err != nil {
+				continue
+			}
+			found = sc.details()
+		}
+
+		// Archives name untitled images after their files.
+		if found.Title != nil && (*found.Title == base || *found.Title == strings.TrimSuffix(base, path.Ext(base))) {
+			found.Title = nil
+		}
+		merge(&d, found)
+	}
+	return d
+}
+
+// merge fills the fields of d that aren't set yet from found.
+func merge(d *create.Details, found create.Details) {
+	if d.Title == nil {
+		d.Title = found.Title
+	}
+	if d.Description == nil {
+		d.Description = found.Description
+	}
+	if d.Tags == nil {
+		d.Tags = found.Tags
+	}
+	if d.Creator == nil {
+		d.Creator = found.Creator
+	}
+	if d.Copyright == nil {
+		d.Copyright = found.Copyright
+	}
+	if d.CaptureTime == nil {
+		d.CaptureTime = found.CaptureTime
+	}
+	if d.Point == nil {
+		d.Point = found.Point
+	}
+}
+
+// sidecar reads both the Google Takeout and Flickr JSON sidecars, which don't
+// share any fields.
+type sidecar struct {
+	// Google Takeout
+	Title          string `json:"title"`
+	Description    string `json:"description"`
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+	GeoData *struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	} `json:"geoData"`
+
+	// Flickr
+	Name      string                 `json:"name"`
+	DateTaken string                 `json:"date_taken"`
+	Tags      []struct{ Tag string } `json:"tags"`
+	Geo       json.RawMessage        `json:"geo"`
+}
+
+// flickrGeo is a location in a Flickr sidecar. Flickr writes coordinates as
+// strings, scaled by a million in older exports.
+type flickrGeo struct {
+	Latitude  json.Number `json:"latitude"`
+	Longitude json.Number `json:"longitude"`
+}
+
+func (sc sidecar) details() create.Details {
+	d := create.Details{}
+	if title := firstNonEmpty(sc.Title, sc.Name); title != "" {
+		d.Title = &title
+	}
+	if sc.Description != "" {
+		desc := sc.Description
+		d.Description = &desc
+	}
+	for _, t := range sc.Tags {
+		if t.Tag != "" {
+			d.Tags = append(d.Tags, t.Tag)
+		}
+	}
+
+	if sec, err := strconv.ParseInt(sc.PhotoTakenTime.Timestamp, 10, 64); err == nil && sec > 0 {
+		t := time.Unix(sec, 0).UTC()
+		d.CaptureTime = &t
+	} else if t, err := time.Parse("2006-01-02 15:04:05", sc.DateTaken); err == nil {
+		d.CaptureTime = &t
+	}
+
+	// Takeout writes 0, 0 for images without a location.
+	if sc.GeoData != nil && (sc.GeoData.Latitude != 0 || sc.GeoData.Longitude != 0) {
+		d.Point = &postgis.PointS{SRID: 4326, X: sc.GeoData.Longitude, Y: sc.GeoData.Latitude}
+	} else if p := sc.flickrPoint(); p != nil {
+		d.Point = p
+	}
+	return d
+}
+
+// flickrPoint reads the Flickr location, which is a list holding one location
+// or empty when the image has none.
+func (sc sidecar) flickrPoint() *postgis.PointS {
+	geos := []flickrGeo{}
+	if err := json.Unmarshal(sc.Geo, &geos); err != nil || len(geos) == 0 {
+		return nil
+	}
+	lat, err := geos[0].Latitude.Float64()
+	if err != nil {
+		return nil
+	}
+	lng, err := geos[0].Longitude.Float64()
+	if err != nil || (lat == 0 && lng == 0) {
+		return nil
+	}
+	if lat > 90 || lat < -90 || lng > 180 || lng < -180 {
+		lat, lng = lat/1e6, lng/1e6
+	}
+	return &postgis.PointS{SRID: 4326, X: lng, Y: lat}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
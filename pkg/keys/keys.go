@@ -0,0 +1,344 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"encoding/base64"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/jmoiron/sqlx"
+)
+
+// LegacyID is the kid of tokens signed with PRIVATE_KEY, from before signing
+// keys were stored.
+const LegacyID = "554b5db484856bfa16e7da70a427dc4d9989678a"
+
+// Key is an RSA key used to sign tokens. A key is published as soon as it is
+// created, signs tokens from ActivatesAt until it is retired and keeps
+// verifying tokens for the ring's grace period after retirement.
+type Key struct {
+	ID          string     `db:"kid"`
+	PrivatePEM  string     `db:"private_key"`
+	PublicPEM   string     `db:"public_key"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ActivatesAt time.Time  `db:"activates_at"`
+	RetiredAt   *time.Time `db:"retired_at"`
+
+	Private *rsa.PrivateKey `db:"-"`
+	Public  *rsa.PublicKey  `db:"-"`
+}
+
+// Generate creates a new 2048 bit key that starts signing at activatesAt.
+func Generate(activatesAt time.Time) (*Key, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(private),
+	})
+
+	key, err := FromPEM("", string(privatePEM))
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = time.Now()
+	key.ActivatesAt = activatesAt
+	return key, nil
+}
+
+// FromPEM parses a PEM encoded RSA private key. If kid is empty the key id is
+// derived from the public key.
+func FromPEM(kid, privatePEM string) (*Key, error) {
+	private, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privatePEM))
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if kid == "" {
+		sum := sha1.Sum(der)
+		kid = hex.EncodeToString(sum[:])
+	}
+
+	return &Key{
+		ID:         kid,
+		PrivatePEM: privatePEM,
+		PublicPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		Private:    private,
+		Public:     &private.PublicKey,
+	}, nil
+}
+
+// Load returns all signing keys stored in the database, opening their private
+// keys with the sealer.
+func Load(db *sqlx.DB, s *Sealer) ([]*Key, error) {
+	stored := []*Key{}
+	err := db.Select(&stored, "SELECT * FROM permissions.signing_keys ORDER BY activates_at")
+	if err != nil {
+		log.Println(err)
+		return []*Key{}, err
+	}
+
+	keys := []*Key{}
+	for _, k := range stored {
+		privatePEM, err := s.Open(k.PrivatePEM)
+		if err != nil {
+			log.Printf("Unable to open signing key %s: %s", k.ID, err)
+			continue
+		}
+		parsed, err := FromPEM(k.ID, privatePEM)
+		if err != nil {
+			log.Printf("Unable to parse signing key %s: %s", k.ID, err)
+			continue
+		}
+		parsed.CreatedAt = k.CreatedAt
+		parsed.ActivatesAt = k.ActivatesAt
+		parsed.RetiredAt = k.RetiredAt
+		keys = append(keys, parsed)
+	}
+	return keys, nil
+}
+
+// Save stores a new key in the database, sealing its private key.
+func Save(db *sqlx.DB, s *Sealer, k *Key) error {
+	sealed, err := s.Seal(k.PrivatePEM)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO permissions.signing_keys(kid, private_key, public_key, created_at, activates_at, retired_at)
+	VALUES ($1, $2, $3, $4, $5, $6);`, k.ID, sealed, k.PublicPEM, k.CreatedAt, k.ActivatesAt, k.RetiredAt)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Rotate generates a key that takes over signing after the overlap window and
+// retires every other key at that point. With no key signing yet there is
+// nothing to overlap, and the new key signs straight away.
+func Rotate(db *sqlx.DB, s *Sealer, overlap time.Duration) (*Key, error) {
+	k, err := Generate(time.Now().Add(overlap))
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := s.Seal(k.PrivatePEM)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var signing bool
+	err = tx.Get(&signing, `
+	SELECT EXISTS(SELECT 1 FROM permissions.signing_keys
+		WHERE activates_at <= $1 AND (retired_at IS NULL OR retired_at > $1))`, k.CreatedAt)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return nil, err
+	}
+	if !signing {
+		k.ActivatesAt = k.CreatedAt
+	}
+
+	_, err = tx.Exec(`
+	UPDATE permissions.signing_keys
+		SET retired_at = $1
+	WHERE retired_at IS NULL OR retired_at > $1`, k.ActivatesAt)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+	INSERT INTO permissions.signing_keys(kid, private_key, public_key, created_at, activates_at)
+	VALUES ($1, $2, $3, $4, $5);`, k.ID, sealed, k.PublicPEM, k.CreatedAt, k.ActivatesAt)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return k, nil
+}
+
+// SealStored seals every private key that is still stored in plain text,
+// returning how many were sealed.
+func SealStored(db *sqlx.DB, s *Sealer) (int64, error) {
+	stored := []*Key{}
+	err := db.Select(&stored, "SELECT kid, private_key FROM permissions.signing_keys")
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+
+	var n int64
+	for _, k := range stored {
+		if IsSealed(k.PrivatePEM) {
+			continue
+		}
+		sealed, err := s.Seal(k.PrivatePEM)
+		if err != nil {
+			return n, err
+		}
+		_, err = db.Exec("UPDATE permissions.signing_keys SET private_key = $1 WHERE kid = $2", sealed, k.ID)
+		if err != nil {
+			log.Println(err)
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Prune removes keys whose tokens have all expired.
+func Prune(db *sqlx.DB, grace time.Duration) (int64, error) {
+	res, err := db.Exec(`
+	DELETE FROM permissions.signing_keys
+	WHERE retired_at IS NOT NULL AND retired_at < $1`, time.Now().Add(-grace))
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Ring holds the keys used to sign and verify tokens. It is safe for
+// concurrent use and can be reloaded while serving.
+type Ring struct {
+	mu    sync.RWMutex
+	keys  []*Key
+	grace time.Duration
+}
+
+// NewRing returns a ring that keeps accepting retired keys for grace, which
+// should be at least the lifetime of a token.
+func NewRing(grace time.Duration) *Ring {
+	return &Ring{grace: grace}
+}
+
+// Set replaces the keys in the ring.
+func (r *Ring) Set(keys []*Key) {
+	sorted := make([]*Key, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ActivatesAt.Before(sorted[j].ActivatesAt)
+	})
+
+	r.mu.Lock()
+	r.keys = sorted
+	r.mu.Unlock()
+}
+
+// Current returns the key new tokens should be signed with, the most recently
+// activated key that has not been retired.
+func (r *Ring) Current() (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		k := r.keys[i]
+		if k.ActivatesAt.After(now) {
+			continue
+		}
+		if k.RetiredAt != nil && !k.RetiredAt.After(now) {
+			continue
+		}
+		return k, nil
+	}
+	return nil, errors.New("No active signing key")
+}
+
+// Published returns the keys that tokens may currently be verified with.
+func (r *Ring) Published() []*Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	published := []*Key{}
+	for _, k := range r.keys {
+		if k.RetiredAt != nil && k.RetiredAt.Add(r.grace).Before(now) {
+			continue
+		}
+		published = append(published, k)
+	}
+	return published
+}
+
+// Public returns the public key for kid if tokens signed by it are still
+// accepted.
+func (r *Ring) Public(kid string) (*rsa.PublicKey, bool) {
+	for _, k := range r.Published() {
+		if k.ID == kid {
+			return k.Public, true
+		}
+	}
+	return nil, false
+}
+
+// JWK is a public key as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// ToJWK encodes the public half of the key.
+func (k *Key) ToJWK() JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: k.ID,
+		N:   base64.RawURLEncoding.EncodeToString(k.Public.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.Public.E)).Bytes()),
+	}
+}
+
+// JWKS returns every published key as a JSON Web Key Set.
+func (r *Ring) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for _, k := range r.Published() {
+		set.Keys = append(set.Keys, k.ToJWK())
+	}
+	return set
+}
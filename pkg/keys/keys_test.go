@@ -0,0 +1,115 @@
+package keys
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRing(t *testing.T) {
+	now := time.Now()
+	retired := now.Add(-time.Hour)
+	expired := now.Add(-time.Hour * 48)
+
+	var ring []*Key
+	for _, k := range []struct {
+		activates time.Time
+		retired   *time.Time
+	}{
+		{now.Add(-time.Hour * 72), &expired},
+		{now.Add(-time.Hour * 24), &retired},
+		{now.Add(-time.Hour), nil},
+		{now.Add(time.Hour), nil},
+	} {
+		key, err := Generate(k.activates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.RetiredAt = k.retired
+		ring = append(ring, key)
+	}
+
+	r := NewRing(time.Hour * 16)
+	r.Set(ring)
+
+	current, err := r.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ID != ring[2].ID {
+		t.Errorf("Expected current key %s, got %s", ring[2].ID, current.ID)
+	}
+
+	for i, accepted := range []bool{false, true, true, true} {
+		if _, ok := r.Public(ring[i].ID); ok != accepted {
+			t.Errorf("Key %d: expected accepted = %t", i, accepted)
+		}
+	}
+
+	if n := len(r.JWKS().Keys); n != 3 {
+		t.Errorf("Expected 3 published keys, got %d", n)
+	}
+}
+
+func TestJWK(t *testing.T) {
+	k, err := Generate(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwk := k.ToJWK()
+	if jwk.E != "AQAB" {
+		t.Errorf("Expected exponent AQAB, got %s", jwk.E)
+	}
+	if jwk.Kid != k.ID || jwk.Kty != "RSA" || jwk.Alg != "RS256" {
+		t.Errorf("Unexpected JWK header fields: %+v", jwk)
+	}
+
+	parsed, err := FromPEM("", k.PrivatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != k.ID {
+		t.Errorf("Expected derived kid %s, got %s", k.ID, parsed.ID)
+	}
+}
+
+func TestSealer(t *testing.T) {
+	k, err := Generate(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSealer("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := s.Seal(k.PrivatePEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "PRIVATE KEY") {
+		t.Errorf("Expected the key to be sealed, got %s", sealed)
+	}
+
+	opened, err := s.Open(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened != k.PrivatePEM {
+		t.Error("Expected the opened key to match")
+	}
+
+	other, _ := NewSealer("other")
+	if _, err := other.Open(sealed); err == nil {
+		t.Error("Expected another secret to be unable to open the key")
+	}
+
+	if plain, err := s.Open(k.PrivatePEM); err != nil || plain != k.PrivatePEM {
+		t.Error("Expected unsealed keys to be returned as they are")
+	}
+
+	if _, err := NewSealer(""); err == nil {
+		t.Error("Expected an empty secret to be refused")
+	}
+}
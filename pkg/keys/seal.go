@@ -0,0 +1,76 @@
+package keys
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+// sealedPrefix marks private keys encrypted by a Sealer.
+const sealedPrefix = "sealed:v1:"
+
+// Sealer encrypts private keys before they are stored, so reading
+// permissions.signing_keys isn't enough to sign tokens.
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer returns a sealer using AES-256-GCM with a key derived from secret,
+// which is kept in SIGNING_KEY_SECRET.
+func NewSealer(secret string) (*Sealer, error) {
+	if secret == "" {
+		return nil, errors.New("Signing key secret is empty")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// IsSealed reports whether a stored private key was sealed.
+func IsSealed(stored string) bool {
+	return strings.HasPrefix(stored, sealedPrefix)
+}
+
+// Seal encrypts a PEM encoded private key.
+func (s *Sealer) Seal(privatePEM string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(privatePEM), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a stored private key. Keys stored before sealing was added
+// are returned as they are until fokal-keys seal is run.
+func (s *Sealer) Open(stored string) (string, error) {
+	if !IsSealed(stored) {
+		return stored, nil
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(raw) < s.aead.NonceSize() {
+		return "", errors.New("Sealed key is too short")
+	}
+	nonce, sealed := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errors.New("Unable to open sealed key, check SIGNING_KEY_SECRET")
+	}
+	return string(plain), nil
+}
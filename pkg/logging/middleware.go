@@ -1,6 +1,7 @@
 package logging
 
 import (
+	"fmt"
 	"net/http"
 
 	"net"
@@ -17,27 +18,88 @@ func UUID(h http.Handler) http.Handler {
 	})
 }
 
-func IP(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for _, h := range []string{"X-Forwarded-For", "X-Real-Ip"} {
-			addresses := strings.Split(r.Header.Get(h), ",")
-			// march from right to left until we get a public address
-			// that will be the address right before our proxy.
-			for i := len(addresses) - 1; i >= 0; i-- {
-				ip := strings.TrimSpace(addresses[i])
-				// header can contain spaces too, strip those out.
-				realIP := net.ParseIP(ip)
-				if !realIP.IsGlobalUnicast() {
-					// bad address, go to next
-					continue
-				}
-				context.Set(r, "ip", realIP.String())
+// Proxies are the networks of the load balancers in front of the server.
+// Forwarding headers are only believed when they were set by one of them.
+type Proxies []*net.IPNet
+
+// ParseProxies reads a comma separated list of networks, such as
+// 10.0.0.0/8,192.168.1.1. Single addresses are taken as their own network.
+func ParseProxies(s string) (Proxies, error) {
+	proxies := Proxies{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return Proxies{}, fmt.Errorf("invalid proxy address %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return Proxies{}, fmt.Errorf("invalid proxy network %q", p)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p Proxies) trusted(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address the request came from. Requests from a trusted
+// proxy are traced back through X-Forwarded-For, from right to left, to the
+// first address that isn't a proxy, as anything further left could have been
+// sent by the client.
+func (p Proxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !p.trusted(ip) {
+		return host
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		addresses := strings.Split(forwarded, ",")
+		for i := len(addresses) - 1; i >= 0; i-- {
+			hop := net.ParseIP(strings.TrimSpace(addresses[i]))
+			if hop == nil {
+				break
+			}
+			ip = hop
+			if !p.trusted(hop) {
 				break
 			}
 		}
+		return ip.String()
+	}
+	if real := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); real != nil {
+		return real.String()
+	}
+	return host
+}
 
+// IP sets the client's address on the request.
+func (p Proxies) IP(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		context.Set(r, "ip", p.ClientIP(r))
 		h.ServeHTTP(w, r)
-
 	})
 }
 
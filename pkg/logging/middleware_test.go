@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseProxies("10.0.0.0/8, 192.168.1.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		real      string
+		ip        string
+	}{
+		// Headers from clients that didn't come through a proxy are ignored.
+		{"203.0.113.9:4000", "198.51.100.1", "198.51.100.2", "203.0.113.9"},
+		{"10.1.2.3:4000", "198.51.100.1", "", "198.51.100.1"},
+		// Addresses a client prepends are skipped past.
+		{"10.1.2.3:4000", "198.51.100.7, 203.0.113.9", "", "203.0.113.9"},
+		{"10.1.2.3:4000", "203.0.113.9, 192.168.1.1, 10.4.4.4", "", "203.0.113.9"},
+		{"192.168.1.1:4000", "", "198.51.100.2", "198.51.100.2"},
+		{"10.1.2.3:4000", "", "", "10.1.2.3"},
+		{"10.1.2.3:4000", "garbage", "", "10.1.2.3"},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.real != "" {
+			r.Header.Set("X-Real-Ip", test.real)
+		}
+		if ip := proxies.ClientIP(r); ip != test.ip {
+			t.Errorf("%s forwarding %q: expected %s, got %s", test.remote, test.forwarded, test.ip, ip)
+		}
+	}
+
+	if _, err := ParseProxies("10.0.0.0/33"); err == nil {
+		t.Error("Expected an invalid network to be refused")
+	}
+}
@@ -0,0 +1,102 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+)
+
+var (
+	xmpSignature       = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	photoshopSignature = []byte("Photoshop 3.0\x00")
+)
+
+// Descriptive is what the photographer wrote about an image in the XMP and
+// IPTC embedded in it.
+type Descriptive struct {
+	Title       *string
+	Description *string
+	Keywords    []string
+	Creator     *string
+	Copyright   *string
+}
+
+// GetDescriptive reads the XMP packet and IPTC block embedded in a JPEG. Where
+// both set a field the XMP is used, as editors keep it the more up to date of
+// the two.
+func GetDescriptive(file []byte) Descriptive {
+	d := Descriptive{}
+	xmpData, iptcData := segments(file)
+
+	if xmpData != nil {
+		if x, err := ParseXMP(xmpData); err == nil {
+			d.Title, d.Description, d.Keywords, d.Copyright = x.Title, x.Description, x.Subject, x.Rights
+			if len(x.Creator) > 0 {
+				creator := strings.Join(x.Creator, ", ")
+				d.Creator = &creator
+			}
+		}
+	}
+
+	if iptcData != nil {
+		if i, err := ParseIPTC(iptcData); err == nil {
+			if d.Title == nil {
+				d.Title = i.ObjectName
+			}
+			if d.Description == nil {
+				d.Description = i.Caption
+			}
+			if d.Keywords == nil {
+				d.Keywords = i.Keywords
+			}
+			if d.Creator == nil && len(i.Byline) > 0 {
+				creator := strings.Join(i.Byline, ", ")
+				d.Creator = &creator
+			}
+			if d.Copyright == nil {
+				d.Copyright = i.Copyright
+			}
+		}
+	}
+	return d
+}
+
+// segments finds the XMP packet in APP1 and the IPTC block in APP13 of a
+// JPEG, stopping where the image data starts.
+func segments(file []byte) (xmpData, iptcData []byte) {
+	if len(file) < 4 || file[0] != 0xff || file[1] != 0xd8 {
+		return nil, nil
+	}
+
+	data := file[2:]
+	for len(data) >= 4 && data[0] == 0xff {
+		marker := data[1]
+		// Start of scan and end of image end the metadata.
+		if marker == 0xda || marker == 0xd9 {
+			break
+		}
+		// Fill bytes and markers without a payload.
+		if marker == 0xff || marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7) {
+			data = data[1:]
+			if marker != 0xff {
+				data = data[1:]
+			}
+			continue
+		}
+
+		size := int(binary.BigEndian.Uint16(data[2:4]))
+		if size < 2 || len(data) < 2+size {
+			break
+		}
+		payload := data[4 : 2+size]
+		data = data[2+size:]
+
+		switch {
+		case marker == 0xe1 && xmpData == nil && bytes.HasPrefix(payload, xmpSignature):
+			xmpData = payload[len(xmpSignature):]
+		case marker == 0xed && iptcData == nil && bytes.HasPrefix(payload, photoshopSignature):
+			iptcData = photoshopIPTC(payload[len(photoshopSignature):])
+		}
+	}
+	return xmpData, iptcData
+}
@@ -0,0 +1,99 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func segment(marker byte, payload []byte) []byte {
+	b := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func dataset(num byte, value string) []byte {
+	b := []byte{0x1c, 2, num, 0, 0}
+	binary.BigEndian.PutUint16(b[3:], uint16(len(value)))
+	return append(b, value...)
+}
+
+func jpeg(xmp string, iim []byte) []byte {
+	buf := bytes.NewBuffer([]byte{0xff, 0xd8})
+	buf.Write(segment(0xe0, []byte("JFIF\x00\x01\x01")))
+	if xmp != "" {
+		buf.Write(segment(0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)))
+	}
+	if iim != nil {
+		res := []byte("8BIM\x04\x04\x00\x00\x00\x00\x00\x00")
+		binary.BigEndian.PutUint32(res[8:], uint32(len(iim)))
+		res = append(res, iim...)
+		if len(iim)%2 == 1 {
+			res = append(res, 0)
+		}
+		// A resource before the IPTC one, with a name, has to be skipped.
+		other := []byte("8BIM\x03\xed\x03abc\x00\x00\x00\x02\x00\x00")
+		buf.Write(segment(0xed, append(append([]byte("Photoshop 3.0\x00"), other...), res...)))
+	}
+	buf.Write([]byte{0xff, 0xda, 0x00, 0x02, 0xff, 0xd9})
+	return buf.Bytes()
+}
+
+func TestParseIPTC(t *testing.T) {
+	iim := append(dataset(iptcObjectName, "Bridge"), dataset(iptcKeywords, "fog")...)
+	iim = append(iim, dataset(iptcKeywords, "bay")...)
+	iim = append(iim, dataset(iptcCaption, "Caf\xe9 at dawn")...)
+
+	i, err := ParseIPTC(iim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i.ObjectName == nil || *i.ObjectName != "Bridge" {
+		t.Errorf("Expected object name Bridge, got %v", i.ObjectName)
+	}
+	if len(i.Keywords) != 2 || i.Keywords[0] != "fog" || i.Keywords[1] != "bay" {
+		t.Errorf("Expected keywords fog, bay, got %v", i.Keywords)
+	}
+	if i.Caption == nil || *i.Caption != "Café at dawn" {
+		t.Errorf("Expected Latin-1 caption to be decoded, got %v", i.Caption)
+	}
+
+	if _, err := ParseIPTC(append(dataset(iptcCaption, "x"), 0x1c, 2, 5, 0, 9)); err == nil {
+		t.Error("Expected an error for a truncated dataset")
+	}
+}
+
+func TestGetDescriptive(t *testing.T) {
+	xmp := `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+		<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+			<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">
+				<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Golden Gate</rdf:li></rdf:Alt></dc:title>
+				<dc:creator><rdf:Seq><rdf:li>Ansel</rdf:li><rdf:li>Dorothea</rdf:li></rdf:Seq></dc:creator>
+			</rdf:Description>
+		</rdf:RDF>
+	</x:xmpmeta>`
+	iim := append(dataset(iptcObjectName, "Bridge"), dataset(iptcCaption, "Fog rolling in")...)
+	iim = append(iim, dataset(iptcCopyright, "(c) 2017 Ansel")...)
+	iim = append(iim, dataset(iptcByline, "Someone else")...)
+
+	d := GetDescriptive(jpeg(xmp, iim))
+	if d.Title == nil || *d.Title != "Golden Gate" {
+		t.Errorf("Expected the XMP title to win, got %v", d.Title)
+	}
+	if d.Creator == nil || *d.Creator != "Ansel, Dorothea" {
+		t.Errorf("Expected the XMP creators, got %v", d.Creator)
+	}
+	if d.Description == nil || *d.Description != "Fog rolling in" {
+		t.Errorf("Expected the IPTC caption to fill in the description, got %v", d.Description)
+	}
+	if d.Copyright == nil || *d.Copyright != "(c) 2017 Ansel" {
+		t.Errorf("Expected the IPTC copyright, got %v", d.Copyright)
+	}
+
+	if d := GetDescriptive(jpeg("", nil)); d.Title != nil || d.Keywords != nil {
+		t.Errorf("Expected nothing from a plain JPEG, got %+v", d)
+	}
+	if d := GetDescriptive([]byte("not a jpeg")); d.Title != nil {
+		t.Errorf("Expected nothing from other files, got %+v", d)
+	}
+}
@@ -23,13 +23,21 @@ func GetExif(img io.Reader) (*exif.Exif, error) {
 }
 
 func GetMetadata(errChan chan error, metaChan chan model.ImageMetadata, img io.Reader) {
-	meta := model.ImageMetadata{Location: &model.Location{}}
 	x, err := GetExif(img)
 	if err != nil {
 		errChan <- err
 		return
 	}
 
+	metaChan <- Extract(x)
+	errChan <- nil
+}
+
+// Extract reads the image metadata kept from the decoded EXIF. Fields the
+// EXIF doesn't have are left nil.
+func Extract(x *exif.Exif) model.ImageMetadata {
+	meta := model.ImageMetadata{Location: &model.Location{}}
+
 	lat, lng, err := x.LatLong()
 	if err != nil {
 		meta.Location = nil
@@ -141,8 +149,8 @@ func GetMetadata(errChan chan error, metaChan chan model.ImageMetadata, img io.R
 		}
 	}
 
-	metaChan <- meta
-	errChan <- nil
+	extended(x, &meta)
+	return meta
 }
 
 func Round(x, unit float64) float64 {
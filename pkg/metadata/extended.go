@@ -0,0 +1,197 @@
+package metadata
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// Fields from EXIF 2.31 that goexif doesn't know about.
+const (
+	OffsetTime         exif.FieldName = "OffsetTime"
+	OffsetTimeOriginal exif.FieldName = "OffsetTimeOriginal"
+)
+
+var offsetFields = map[uint16]exif.FieldName{
+	0x9010: OffsetTime,
+	0x9011: OffsetTimeOriginal,
+}
+
+func init() {
+	exif.RegisterParsers(offsetParser{})
+}
+
+// offsetParser loads the timezone offsets from the EXIF sub-IFD.
+type offsetParser struct{}
+
+func (offsetParser) Parse(x *exif.Exif) error {
+	ptr, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		return nil
+	}
+	offset, err := ptr.Int64(0)
+	if err != nil {
+		return nil
+	}
+
+	r := bytes.NewReader(x.Raw)
+	if _, err = r.Seek(offset, 0); err != nil {
+		return nil
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return nil
+	}
+	x.LoadTags(dir, offsetFields, false)
+	return nil
+}
+
+// EXIF stores these settings as numbers, they are kept by name.
+var (
+	exposurePrograms = map[int]string{
+		1: "manual",
+		2: "normal",
+		3: "aperture priority",
+		4: "shutter priority",
+		5: "creative",
+		6: "action",
+		7: "portrait",
+		8: "landscape",
+	}
+	exposureModes = map[int]string{
+		0: "auto",
+		1: "manual",
+		2: "auto bracket",
+	}
+	meteringModes = map[int]string{
+		1: "average",
+		2: "center weighted average",
+		3: "spot",
+		4: "multi spot",
+		5: "pattern",
+		6: "partial",
+	}
+	whiteBalances = map[int]string{
+		0: "auto",
+		1: "manual",
+	}
+
+	settings = map[string]map[int]string{
+		"exposure_program": exposurePrograms,
+		"exposure_mode":    exposureModes,
+		"metering_mode":    meteringModes,
+		"white_balance":    whiteBalances,
+	}
+)
+
+// ValidSetting reports whether name is one of the names the setting stored
+// under field is kept by, e.g. "spot" for "metering_mode".
+func ValidSetting(field, name string) bool {
+	for _, n := range settings[field] {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// offset matches a timezone offset as EXIF writes it, e.g. +02:00.
+var offset = regexp.MustCompile(`^[+-](0[0-9]|1[0-4]):[0-5][0-9]$`)
+
+// ValidOffset reports whether s is a timezone offset in the ±HH:MM form EXIF
+// uses, between -14:00 and +14:00.
+func ValidOffset(s string) bool {
+	return offset.MatchString(s)
+}
+
+// extended reads the exposure settings, location and processing details
+// beyond the basic camera settings.
+func extended(x *exif.Exif, meta *model.ImageMetadata) {
+	if tag, err := x.Get(exif.ExposureBiasValue); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			// Kept to hundredths, so -2/3 EV reads as -0.67.
+			ev := math.Round(float64(num)/float64(den)*100) / 100
+			meta.ExposureCompensation = &ev
+		}
+	}
+
+	meta.ExposureProgram = named(x, exif.ExposureProgram, exposurePrograms)
+	meta.ExposureMode = named(x, exif.ExposureMode, exposureModes)
+	meta.MeteringMode = named(x, exif.MeteringMode, meteringModes)
+	meta.WhiteBalance = named(x, exif.WhiteBalance, whiteBalances)
+
+	// The lowest bit of Flash is whether it fired, the rest describe its mode
+	// and whether it returned light.
+	if tag, err := x.Get(exif.Flash); err == nil {
+		if n, err := tag.Int(0); err == nil {
+			fired := n&1 == 1
+			meta.Flash = &fired
+		}
+	}
+
+	if tag, err := x.Get(exif.FocalLengthIn35mmFilm); err == nil {
+		if n, err := tag.Int(0); err == nil && n > 0 {
+			meta.FocalLength35mm = &n
+		}
+	}
+
+	if tag, err := x.Get(exif.GPSAltitude); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			alt := math.Round(float64(num)/float64(den)*10) / 10
+			// A reference of 1 means below sea level.
+			if ref, err := x.Get(exif.GPSAltitudeRef); err == nil {
+				if n, err := ref.Int(0); err == nil && n == 1 {
+					alt = -alt
+				}
+			}
+			meta.Altitude = &alt
+		}
+	}
+
+	for _, name := range []exif.FieldName{OffsetTimeOriginal, OffsetTime} {
+		if s := stringTag(x, name); s != nil {
+			meta.TimezoneOffset = s
+			break
+		}
+	}
+	meta.Software = stringTag(x, exif.Software)
+}
+
+// named returns the name of the setting in the tag, or nil if it isn't set or
+// is unknown.
+func named(x *exif.Exif, field exif.FieldName, names map[int]string) *string {
+	tag, err := x.Get(field)
+	if err != nil {
+		return nil
+	}
+	n, err := tag.Int(0)
+	if err != nil {
+		return nil
+	}
+	name, ok := names[n]
+	if !ok {
+		return nil
+	}
+	return &name
+}
+
+func stringTag(x *exif.Exif, field exif.FieldName) *string {
+	tag, err := x.Get(field)
+	if err != nil {
+		return nil
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return nil
+	}
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	if s == "" {
+		return nil
+	}
+	return &s
+}
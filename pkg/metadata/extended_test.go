@@ -0,0 +1,188 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type ifdEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+func short(tag, v uint16) ifdEntry {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return ifdEntry{tag, 3, 1, b}
+}
+
+func long(tag uint16, v uint32) ifdEntry {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return ifdEntry{tag, 4, 1, b}
+}
+
+func ascii(tag uint16, s string) ifdEntry {
+	return ifdEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rational(tag, typ uint16, num, den int32) ifdEntry {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b, uint32(num))
+	binary.LittleEndian.PutUint32(b[4:], uint32(den))
+	return ifdEntry{tag, typ, 1, b}
+}
+
+// ifd encodes a directory written at offset, followed by the values too
+// large to fit in its entries.
+func ifd(offset uint32, entries []ifdEntry) []byte {
+	head := new(bytes.Buffer)
+	extra := new(bytes.Buffer)
+	binary.Write(head, binary.LittleEndian, uint16(len(entries)))
+	dataAt := offset + 2 + 12*uint32(len(entries)) + 4
+	for _, e := range entries {
+		binary.Write(head, binary.LittleEndian, e.tag)
+		binary.Write(head, binary.LittleEndian, e.typ)
+		binary.Write(head, binary.LittleEndian, e.count)
+		if len(e.data) <= 4 {
+			head.Write(append(e.data, make([]byte, 4-len(e.data))...))
+			continue
+		}
+		binary.Write(head, binary.LittleEndian, dataAt+uint32(extra.Len()))
+		extra.Write(e.data)
+		if extra.Len()%2 == 1 {
+			extra.WriteByte(0)
+		}
+	}
+	binary.Write(head, binary.LittleEndian, uint32(0))
+	return append(head.Bytes(), extra.Bytes()...)
+}
+
+func tiffWith(main, sub, gps []ifdEntry) []byte {
+	// The main directory's size doesn't depend on where the others are.
+	size := uint32(len(ifd(8, append(main, long(0x8769, 0), long(0x8825, 0)))))
+	subAt := 8 + size
+	gpsAt := subAt + uint32(len(ifd(subAt, sub)))
+
+	buf := bytes.NewBuffer([]byte{'I', 'I', 42, 0, 8, 0, 0, 0})
+	buf.Write(ifd(8, append(main, long(0x8769, subAt), long(0x8825, gpsAt))))
+	buf.Write(ifd(subAt, sub))
+	buf.Write(ifd(gpsAt, gps))
+	return buf.Bytes()
+}
+
+func TestExtractExtended(t *testing.T) {
+	raw := tiffWith(
+		[]ifdEntry{ascii(0x0131, "Adobe Photoshop Lightroom 6.0")},
+		[]ifdEntry{
+			short(0x8822, 3),
+			ascii(0x9011, "+02:00"),
+			rational(0x9204, 10, -2, 3),
+			short(0x9207, 5),
+			short(0x9209, 0x19),
+			short(0xa402, 1),
+			short(0xa403, 0),
+			short(0xa405, 35),
+		},
+		[]ifdEntry{
+			{0x5, 1, 1, []byte{1}},
+			rational(0x6, 5, 125, 10),
+		},
+	)
+
+	x, err := GetExif(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Extract(x)
+
+	if m.ExposureCompensation == nil || *m.ExposureCompensation != -0.67 {
+		t.Errorf("Expected exposure compensation -0.67, got %v", m.ExposureCompensation)
+	}
+	for name, expected := range map[string]struct {
+		got  *string
+		want string
+	}{
+		"exposure program": {m.ExposureProgram, "aperture priority"},
+		"exposure mode":    {m.ExposureMode, "manual"},
+		"metering mode":    {m.MeteringMode, "pattern"},
+		"white balance":    {m.WhiteBalance, "auto"},
+		"timezone offset":  {m.TimezoneOffset, "+02:00"},
+		"software":         {m.Software, "Adobe Photoshop Lightroom 6.0"},
+	} {
+		if expected.got == nil || *expected.got != expected.want {
+			t.Errorf("Expected %s %s, got %v", name, expected.want, expected.got)
+		}
+	}
+	if m.Flash == nil || !*m.Flash {
+		t.Errorf("Expected the flash to have fired, got %v", m.Flash)
+	}
+	if m.FocalLength35mm == nil || *m.FocalLength35mm != 35 {
+		t.Errorf("Expected 35mm equivalent focal length 35, got %v", m.FocalLength35mm)
+	}
+	if m.Altitude == nil || *m.Altitude != -12.5 {
+		t.Errorf("Expected altitude -12.5, got %v", m.Altitude)
+	}
+}
+
+func TestExtractExtendedMissing(t *testing.T) {
+	raw := tiffWith(nil, []ifdEntry{short(0x8822, 0), short(0x9209, 0x10)}, nil)
+
+	x, err := GetExif(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := Extract(x)
+
+	if m.ExposureProgram != nil {
+		t.Errorf("Expected an undefined exposure program to be left out, got %s", *m.ExposureProgram)
+	}
+	if m.Flash == nil || *m.Flash {
+		t.Errorf("Expected the flash not to have fired, got %v", m.Flash)
+	}
+	if m.ExposureCompensation != nil || m.Altitude != nil || m.TimezoneOffset != nil || m.Software != nil {
+		t.Errorf("Expected missing fields to be nil, got %+v", m)
+	}
+}
+
+func TestValidSetting(t *testing.T) {
+	cases := []struct {
+		field, name string
+		valid       bool
+	}{
+		{"metering_mode", "spot", true},
+		{"exposure_program", "aperture priority", true},
+		{"exposure_mode", "auto bracket", true},
+		{"white_balance", "manual", true},
+		{"white_balance", "spot", false},
+		{"metering_mode", "Spot", false},
+		{"software", "manual", false},
+	}
+	for _, c := range cases {
+		if got := ValidSetting(c.field, c.name); got != c.valid {
+			t.Errorf("ValidSetting(%q, %q) = %v, want %v", c.field, c.name, got, c.valid)
+		}
+	}
+}
+
+func TestValidOffset(t *testing.T) {
+	cases := map[string]bool{
+		"+02:00": true,
+		"-05:30": true,
+		"+14:00": true,
+		"+00:00": true,
+		"02:00":  false,
+		"+2:00":  false,
+		"+15:00": false,
+		"+02:60": false,
+		"+0200":  false,
+		"UTC":    false,
+	}
+	for s, valid := range cases {
+		if got := ValidOffset(s); got != valid {
+			t.Errorf("ValidOffset(%q) = %v, want %v", s, got, valid)
+		}
+	}
+}
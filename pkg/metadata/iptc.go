@@ -0,0 +1,121 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// IIM datasets in the application record that are read.
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcByline     = 80
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// IPTC holds the descriptive fields of an IPTC-IIM block, as written by older
+// editors and still alongside XMP by most current ones.
+type IPTC struct {
+	ObjectName *string
+	Caption    *string
+	Keywords   []string
+	Byline     []string
+	Copyright  *string
+}
+
+// ParseIPTC reads an IPTC-IIM block. Values are read as UTF-8 when they are
+// valid UTF-8 and as Latin-1 otherwise, whichever character set the block
+// declares.
+func ParseIPTC(data []byte) (IPTC, error) {
+	iptc := IPTC{}
+	found := false
+	for len(data) >= 5 && data[0] == 0x1c {
+		record, dataset := data[1], data[2]
+		size := int(binary.BigEndian.Uint16(data[3:5]))
+		data = data[5:]
+
+		// Extended datasets give the number of bytes holding their size.
+		if size&0x8000 != 0 {
+			n := size & 0x7fff
+			if n > 4 || len(data) < n {
+				return IPTC{}, errors.New("Unable to parse iptc")
+			}
+			size = 0
+			for _, b := range data[:n] {
+				size = size<<8 | int(b)
+			}
+			data = data[n:]
+		}
+		if size > len(data) {
+			return IPTC{}, errors.New("Unable to parse iptc")
+		}
+		value := iptcString(data[:size])
+		data = data[size:]
+		found = true
+
+		if record != 2 || value == "" {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			iptc.ObjectName = &value
+		case iptcCaption:
+			iptc.Caption = &value
+		case iptcKeywords:
+			iptc.Keywords = append(iptc.Keywords, value)
+		case iptcByline:
+			iptc.Byline = append(iptc.Byline, value)
+		case iptcCopyright:
+			iptc.Copyright = &value
+		}
+	}
+	if !found {
+		return IPTC{}, errors.New("Unable to parse iptc")
+	}
+	return iptc, nil
+}
+
+func iptcString(b []byte) string {
+	b = bytes.TrimRight(b, "\x00")
+	if utf8.Valid(b) {
+		return strings.TrimSpace(string(b))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return strings.TrimSpace(string(runes))
+}
+
+// photoshopIPTC finds the IPTC-IIM block among the image resources Photoshop
+// keeps in APP13.
+func photoshopIPTC(data []byte) []byte {
+	for len(data) >= 12 && bytes.HasPrefix(data, []byte("8BIM")) {
+		id := binary.BigEndian.Uint16(data[4:6])
+		// The resource's name is a Pascal string padded to an even length.
+		nameLen := int(data[6]) + 1
+		nameLen += nameLen % 2
+		if len(data) < 6+nameLen+4 {
+			return nil
+		}
+		data = data[6+nameLen:]
+		size := int(binary.BigEndian.Uint32(data[:4]))
+		data = data[4:]
+		if size > len(data) {
+			return nil
+		}
+		if id == 0x0404 {
+			return data[:size]
+		}
+		size += size % 2
+		if size > len(data) {
+			return nil
+		}
+		data = data[size:]
+	}
+	return nil
+}
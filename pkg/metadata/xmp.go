@@ -0,0 +1,191 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cridenour/go-postgis"
+)
+
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsEXIF      = "http://ns.adobe.com/exif/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+// XMP holds the descriptive fields of an XMP packet, as written by Lightroom
+// and most other editors into sidecars and the images themselves.
+type XMP struct {
+	Title       *string
+	Description *string
+	Subject     []string
+	Creator     []string
+	Rights      *string
+	CaptureTime *time.Time
+	Point       *postgis.PointS
+}
+
+// ParseXMP reads an XMP packet. Properties are read whether they are written
+// as attributes of rdf:Description or as elements inside it, and only the
+// first entry of language alternatives is kept.
+func ParseXMP(data []byte) (XMP, error) {
+	props := map[string][]string{}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+
+	var stack []xml.Name
+	var prop string
+	var values []string
+	var text strings.Builder
+	found := false
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			parent := xml.Name{}
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, t.Name)
+
+			if t.Name.Space == nsRDF && t.Name.Local == "Description" {
+				found = true
+				for _, attr := range t.Attr {
+					key := attr.Name.Space + " " + attr.Name.Local
+					if _, ok := props[key]; !ok {
+						props[key] = []string{attr.Value}
+					}
+				}
+			} else if parent.Space == nsRDF && parent.Local == "Description" {
+				prop, values = t.Name.Space+" "+t.Name.Local, nil
+				text.Reset()
+			} else if t.Name.Space == nsRDF && t.Name.Local == "li" {
+				text.Reset()
+			}
+		case xml.CharData:
+			if prop != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if prop == "" {
+				continue
+			}
+			if t.Name.Space == nsRDF && t.Name.Local == "li" {
+				if v := strings.TrimSpace(text.String()); v != "" {
+					values = append(values, v)
+				}
+				text.Reset()
+			} else if t.Name.Space+" "+t.Name.Local == prop {
+				if v := strings.TrimSpace(text.String()); len(values) == 0 && v != "" {
+					values = append(values, v)
+				}
+				if _, ok := props[prop]; !ok && len(values) > 0 {
+					props[prop] = values
+				}
+				prop = ""
+			}
+		}
+	}
+	if !found {
+		return XMP{}, errors.New("Unable to parse xmp")
+	}
+
+	x := XMP{}
+	if v, ok := props[nsDC+" title"]; ok {
+		x.Title = &v[0]
+	}
+	if v, ok := props[nsDC+" description"]; ok {
+		x.Description = &v[0]
+	}
+	x.Subject = props[nsDC+" subject"]
+	x.Creator = props[nsDC+" creator"]
+	if v, ok := props[nsDC+" rights"]; ok {
+		x.Rights = &v[0]
+	}
+
+	for _, key := range []string{nsEXIF + " DateTimeOriginal", nsPhotoshop + " DateCreated", nsXMP + " CreateDate"} {
+		if v, ok := props[key]; ok {
+			if t, err := parseXMPDate(v[0]); err == nil {
+				x.CaptureTime = &t
+				break
+			}
+		}
+	}
+
+	lat, latOK := props[nsEXIF+" GPSLatitude"]
+	lng, lngOK := props[nsEXIF+" GPSLongitude"]
+	if latOK && lngOK {
+		y, latErr := parseXMPCoordinate(lat[0])
+		long, lngErr := parseXMPCoordinate(lng[0])
+		if latErr == nil && lngErr == nil {
+			x.Point = &postgis.PointS{SRID: 4326, X: long, Y: y}
+		}
+	}
+	return x, nil
+}
+
+// parseXMPDate reads the date formats XMP allows, from a bare year and month
+// up to a full time with fractional seconds and an offset.
+func parseXMPDate(s string) (time.Time, error) {
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04",
+		"2006-01-02",
+		"2006-01",
+	} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Unable to parse xmp date")
+}
+
+// parseXMPCoordinate reads an XMP GPS coordinate, written as degrees and
+// decimal minutes or degrees, minutes and seconds, followed by its reference,
+// e.g. 37,46.494N or 122,25,9.8W.
+func parseXMPCoordinate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 {
+		return 0, errors.New("Unable to parse xmp coordinate")
+	}
+
+	sign := 1.0
+	switch s[len(s)-1] {
+	case 'N', 'E':
+	case 'S', 'W':
+		sign = -1
+	default:
+		// Some writers leave out the reference and sign the value instead.
+		return strconv.ParseFloat(s, 64)
+	}
+
+	parts := strings.Split(s[:len(s)-1], ",")
+	if len(parts) > 3 {
+		return 0, errors.New("Unable to parse xmp coordinate")
+	}
+	coord, scale := 0.0, 1.0
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, err
+		}
+		coord += v / scale
+		scale *= 60
+	}
+	return sign * coord, nil
+}
@@ -0,0 +1,74 @@
+package metadata
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const packet = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    exif:DateTimeOriginal="2017-07-14T19:42:10.00-07:00"
+    exif:GPSLatitude="37,46.494N"
+    exif:GPSLongitude="122,25,9.84W"
+    xmp:CreateDate="2018-01-01T00:00:00">
+   <dc:title>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Golden Gate</rdf:li>
+     <rdf:li xml:lang="de">Goldenes Tor</rdf:li>
+    </rdf:Alt>
+   </dc:title>
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Fog rolling in</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>bridge</rdf:li>
+     <rdf:li>fog</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+func TestParseXMP(t *testing.T) {
+	x, err := ParseXMP([]byte(packet))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Title == nil || *x.Title != "Golden Gate" {
+		t.Errorf("Expected title Golden Gate, got %v", x.Title)
+	}
+	if x.Description == nil || *x.Description != "Fog rolling in" {
+		t.Errorf("Expected description Fog rolling in, got %v", x.Description)
+	}
+	if strings.Join(x.Subject, ",") != "bridge,fog" {
+		t.Errorf("Expected subject bridge,fog, got %v", x.Subject)
+	}
+
+	taken := time.Date(2017, 7, 15, 2, 42, 10, 0, time.UTC)
+	if x.CaptureTime == nil || !x.CaptureTime.Equal(taken) {
+		t.Errorf("Expected capture time %s from DateTimeOriginal, got %v", taken, x.CaptureTime)
+	}
+
+	if x.Point == nil {
+		t.Fatal("Expected a point")
+	}
+	if x.Point.Y < 37.7748 || x.Point.Y > 37.7750 || x.Point.X < -122.4195 || x.Point.X > -122.4193 {
+		t.Errorf("Expected point near 37.7749, -122.4194, got %v, %v", x.Point.Y, x.Point.X)
+	}
+}
+
+func TestParseXMPInvalid(t *testing.T) {
+	if _, err := ParseXMP([]byte("not xmp")); err == nil {
+		t.Error("Expected an error for data without an rdf:Description")
+	}
+}
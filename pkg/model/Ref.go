@@ -14,8 +14,23 @@ const (
 	Labels
 	Landmarks
 	Collections
+	Groups
 )
 
+var names = map[ReferenceType]string{
+	Images:      "image",
+	Users:       "user",
+	Tags:        "tag",
+	Labels:      "label",
+	Landmarks:   "landmark",
+	Collections: "collection",
+	Groups:      "group",
+}
+
+func (t ReferenceType) String() string {
+	return names[t]
+}
+
 type Ref struct {
 	Id         int64
 	Collection ReferenceType
@@ -36,6 +51,10 @@ func (r Ref) ToURL(port int, local bool) string {
 		return fmt.Sprintf("%s/images/%s", host, r.Shortcode)
 	case Tags:
 		return fmt.Sprintf("%s/tags/%s", host, r.Shortcode)
+	case Groups:
+		return fmt.Sprintf("%s/groups/%s", host, r.Shortcode)
+	case Collections:
+		return fmt.Sprintf("%s/collections/%s", host, r.Shortcode)
 	default:
 		log.Panic("Invalid Collection Type")
 	}
@@ -25,12 +25,57 @@ type User struct {
 	ImageLinks    *[]string `json:"images_links,omitempty"`
 	FavoriteLinks *[]string `json:"favorite_links,omitempty"`
 
-	Featured     bool      `json:"featured"`
-	Admin        bool      `json:"admin"`
+	Followers   int   `db:"-" json:"followers"`
+	Following   int   `db:"-" json:"following"`
+	IsFollowing *bool `db:"-" json:"is_following,omitempty"`
+
+	Featured        bool       `json:"featured"`
+	Role            string     `json:"role"`
+	SuspendedAt     *time.Time `db:"suspended_at" json:"suspended_at,omitempty"`
+	SuspendedReason *string    `db:"suspended_reason" json:"-"`
+	HiddenAt        *time.Time `db:"hidden_at" json:"-"`
+	DeletedAt       *time.Time `db:"deleted_at" json:"-"`
+	CreatedAt       time.Time  `db:"created_at" json:"created_at"`
+	LastModified    time.Time  `db:"last_modified" json:"last_modified"`
+}
+
+type Group struct {
+	Id          int64   `json:"-"`
+	Shortcode   string  `json:"id"`
+	Permalink   string  `json:"permalink"`
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+
+	Members []GroupMember `json:"members,omitempty"`
+
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	LastModified time.Time `db:"last_modified" json:"last_modified"`
+}
+
+type Collection struct {
+	Id          int64   `json:"-"`
+	Shortcode   string  `json:"id"`
+	Permalink   string  `json:"permalink"`
+	Title       string  `json:"title"`
+	Description *string `json:"description,omitempty"`
+	Public      bool    `json:"public"`
+
+	Owner         string   `db:"owner" json:"owner"`
+	Collaborators []string `json:"collaborators"`
+	Cover         *Image   `json:"cover,omitempty"`
+	ImageCount    int      `db:"image_count" json:"image_count"`
+
+	CoverId      *int64    `db:"cover_image_id" json:"-"`
 	CreatedAt    time.Time `db:"created_at" json:"created_at"`
 	LastModified time.Time `db:"last_modified" json:"last_modified"`
 }
 
+type GroupMember struct {
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	Joined   time.Time `db:"created_at" json:"joined"`
+}
+
 type Image struct {
 	Id        int64  `json:"-"`
 	Shortcode string `json:"id"`
@@ -50,7 +95,8 @@ type Image struct {
 	User     *User `json:"user,omitempty"`
 	Featured bool  `json:"featured"`
 
-	FavoritedBy []string `json:"favorited_by"`
+	FavoritedBy      []string `json:"favorited_by"`
+	CommentsDisabled bool     `db:"comments_disabled" json:"comments_disabled"`
 
 	Stats    ImageStats    `json:"stats"`
 	Source   ImageSource   `json:"src_links"`
@@ -61,6 +107,24 @@ type ImageStats struct {
 	Downloads int `json:"downloads"`
 	Views     int `json:"views"`
 	Favorites int `json:"favorites"`
+	Comments  int `json:"comments"`
+}
+
+// Comment is a comment on an image. Deleted comments keep their place in the
+// thread while they have replies, without their author or body.
+type Comment struct {
+	Id       int64  `db:"id" json:"id"`
+	ParentId *int64 `db:"parent_id" json:"parent_id,omitempty"`
+	ImageId  int64  `db:"image_id" json:"-"`
+	UserId   int64  `db:"user_id" json:"-"`
+	Author   string `db:"author" json:"author,omitempty"`
+	Body     string `db:"body" json:"body"`
+	Deleted  bool   `db:"deleted" json:"deleted"`
+
+	Replies []Comment `json:"replies"`
+
+	EditedAt  *time.Time `db:"edited_at" json:"edited_at,omitempty"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
 }
 
 type ImageSource struct {
@@ -83,8 +147,22 @@ type ImageMetadata struct {
 	PixelXDimension int64      `db:"pixel_xd" json:"pixel_xd"`
 	PixelYDimension int64      `db:"pixel_yd" json:"pixel_yd"`
 	CaptureTime     *time.Time `db:"capture_time" json:"capture_time,omitempty"`
-	Location        *Location  `db:"location" json:"location,omitempty"`
-	Orientation     uint16     `db:"-" json:"-"`
+	Creator         *string    `db:"creator" json:"creator,omitempty"`
+	Copyright       *string    `db:"copyright" json:"copyright,omitempty"`
+
+	ExposureCompensation *float64 `db:"exposure_compensation" json:"exposure_compensation,omitempty"`
+	ExposureProgram      *string  `db:"exposure_program" json:"exposure_program,omitempty"`
+	ExposureMode         *string  `db:"exposure_mode" json:"exposure_mode,omitempty"`
+	MeteringMode         *string  `db:"metering_mode" json:"metering_mode,omitempty"`
+	Flash                *bool    `db:"flash" json:"flash,omitempty"`
+	WhiteBalance         *string  `db:"white_balance" json:"white_balance,omitempty"`
+	FocalLength35mm      *int     `db:"focal_length_35mm" json:"focal_length_35mm,omitempty"`
+	Altitude             *float64 `db:"altitude" json:"altitude,omitempty"`
+	TimezoneOffset       *string  `db:"timezone_offset" json:"timezone_offset,omitempty"`
+	Software             *string  `db:"software" json:"software,omitempty"`
+
+	Location    *Location `db:"location" json:"location,omitempty"`
+	Orientation uint16    `db:"-" json:"-"`
 }
 
 type Location struct {
@@ -29,3 +29,27 @@ func UnFeature(db *sqlx.DB, iID int64) error {
 	}
 	return nil
 }
+
+func FeatureUser(db *sqlx.DB, uID int64) error {
+	_, err := db.Exec(`
+	UPDATE content.users
+		SET featured = TRUE
+	WHERE id = $1`, uID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func UnFeatureUser(db *sqlx.DB, uID int64) error {
+	_, err := db.Exec(`
+	UPDATE content.users
+		SET featured = FALSE
+	WHERE id = $1`, uID)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -2,15 +2,24 @@ package modification
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/fatih/structs"
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/cache"
 	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/metadata"
 	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/notifications"
 	"github.com/fokal/fokal-core/pkg/request"
 	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/sharing"
 	"github.com/fokal/fokal-core/pkg/stats"
+	"github.com/fokal/fokal-core/pkg/tokens"
+	"github.com/fokal/fokal-core/pkg/trash"
+	"github.com/fokal/fokal-core/pkg/webhooks"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 	"github.com/mholt/binding"
@@ -31,6 +40,12 @@ func FeatureImage(store *handler.State, w http.ResponseWriter, r *http.Request)
 		return handler.Response{}, err
 	}
 
+	notifications.Featured(store, retrieval.Viewer(r), imageRef)
+	audit.Record(store.DB, r, "image.feature", imageRef, map[string]bool{"featured": false}, map[string]bool{"featured": true})
+	if owner, err := webhooks.ImageOwner(store.DB, imageRef.Id); err == nil {
+		webhooks.Emit(store, webhooks.ImageFeatured, owner.Id, map[string]model.Ref{"image": imageRef, "user": owner})
+	}
+
 	return handler.Response{
 		Code: http.StatusAccepted,
 	}, nil
@@ -51,6 +66,9 @@ func UnFeatureImage(store *handler.State, w http.ResponseWriter, r *http.Request
 		return handler.Response{}, err
 	}
 
+	notifications.Retract(store.DB, notifications.Feature, retrieval.Viewer(r), imageRef)
+	audit.Record(store.DB, r, "image.unfeature", imageRef, map[string]bool{"featured": true}, map[string]bool{"featured": false})
+
 	return handler.Response{
 		Code: http.StatusAccepted,
 	}, nil
@@ -71,13 +89,31 @@ func PatchImage(store *handler.State, w http.ResponseWriter, r *http.Request) (h
 		return handler.Response{}, err
 	}
 
+	for field, name := range map[string]string{
+		"exposure_program": req.ExposureProgram,
+		"exposure_mode":    req.ExposureMode,
+		"metering_mode":    req.MeteringMode,
+		"white_balance":    req.WhiteBalance,
+	} {
+		if name != "" && !metadata.ValidSetting(field, name) {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("Invalid %s %q", field, name)}
+		}
+	}
+	if req.TimezoneOffset != "" && !metadata.ValidOffset(req.TimezoneOffset) {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: fmt.Errorf("Invalid timezone_offset %q", req.TimezoneOffset)}
+	}
+
 	log.Printf("%+v\n", req)
 
+	before, _ := retrieval.GetImage(store, ref.Id)
 	err = commitImagePatch(store.DB, ref, structs.Map(req))
 	if err != nil {
 		return handler.Response{}, err
 	}
 
+	after, _ := retrieval.GetImage(store, ref.Id)
+	audit.Record(store.DB, r, "image.patch", ref, before, after)
+
 	return handler.Response{
 		Code: http.StatusAccepted,
 	}, nil
@@ -102,16 +138,21 @@ func PatchUser(store *handler.State, w http.ResponseWriter, r *http.Request) (ha
 		return handler.Response{}, err
 	}
 
+	before, _ := retrieval.GetUser(store, ref.Id, ref.Id)
 	err = commitUserPatch(store.DB, ref, structs.Map(req))
 	if err != nil {
 		return handler.Response{}, err
 	}
+
+	after, _ := retrieval.GetUser(store, ref.Id, ref.Id)
+	audit.Record(store.DB, r, "user.patch", ref, before, after)
 	return handler.Response{
 		Code: http.StatusAccepted,
 	}, nil
 
 }
 
+// DeleteImage moves the image to its owner's trash.
 func DeleteImage(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
 	id := mux.Vars(r)["ID"]
 
@@ -120,9 +161,20 @@ func DeleteImage(store *handler.State, w http.ResponseWriter, r *http.Request) (
 		return handler.Response{}, err
 	}
 
-	err = deleteImage(store.DB, ref.Id)
+	before, _ := retrieval.GetImage(store, ref.Id)
+	owner, ownerErr := webhooks.ImageOwner(store.DB, ref.Id)
+	err = trash.Delete(store.DB, ref)
 	if err != nil {
-		return handler.Response{}, err
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete image")}
+	}
+
+	audit.Record(store.DB, r, "image.delete", ref, before, nil)
+	if ownerErr == nil {
+		webhooks.Emit(store, webhooks.ImageDeleted, owner.Id, map[string]model.Ref{"image": ref, "user": owner})
+	}
+
+	if err := cache.Flush(store.RD); err != nil {
+		log.Println(err)
 	}
 
 	return handler.Response{
@@ -130,17 +182,31 @@ func DeleteImage(store *handler.State, w http.ResponseWriter, r *http.Request) (
 	}, nil
 }
 
+// DeleteUser moves the logged in user to the trash and logs them out
+// everywhere. Their account and images are purged after trash.Retention.
 func DeleteUser(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
-	id := mux.Vars(r)["ID"]
+	user, ok := context.GetOk(r, "auth")
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("User is not logged in")}
+	}
 
-	ref, err := retrieval.GetUserRef(store.DB, id)
+	ref := user.(model.Ref)
+
+	before, _ := retrieval.GetUser(store, ref.Id, ref.Id)
+	err := trash.Delete(store.DB, ref)
 	if err != nil {
-		return handler.Response{}, err
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete user")}
 	}
 
-	err = deleteUser(store.DB, ref.Id)
+	audit.Record(store.DB, r, "user.delete", ref, before, nil)
+
+	err = tokens.RevokeAll(store, ref.Id)
 	if err != nil {
-		return handler.Response{}, err
+		log.Println(err)
+	}
+
+	if err := cache.Flush(store.RD); err != nil {
+		log.Println(err)
 	}
 
 	return handler.Response{
@@ -149,6 +215,10 @@ func DeleteUser(store *handler.State, w http.ResponseWriter, r *http.Request) (h
 }
 
 func DownloadHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	if link, ok := sharing.Shared(r); ok && !link.AllowDownload {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Share link does not allow downloads")}
+	}
+
 	id := mux.Vars(r)["ID"]
 	ref, err := retrieval.GetImageRef(store.DB, id)
 	if err != nil {
@@ -17,6 +17,11 @@ func commitImagePatch(db *sqlx.DB, image model.Ref, req map[string]interface{})
 	}
 
 	for key, val := range req {
+		// An empty title, description, creator or copyright clears it.
+		if s, ok := val.(*string); ok && s != nil && *s == "" {
+			val = nil
+		}
+
 		if key == "tags" {
 			_, err = tx.Exec("DELETE FROM content.image_tag_bridge WHERE image_id = $1;", image.Id)
 			if err != nil {
@@ -50,6 +55,12 @@ func commitImagePatch(db *sqlx.DB, image model.Ref, req map[string]interface{})
 					return err
 				}
 			}
+		} else if key == "title" || key == "description" {
+			_, err = tx.Exec(fmt.Sprintf(`UPDATE content.images SET %s = $1 WHERE id = $2;`, key), val, image.Id)
+			if err != nil {
+				log.Println(err)
+				return err
+			}
 		} else if key == "geo" {
 			loc := val.(map[string]interface{})
 			p := postgis.PointS{
@@ -0,0 +1,77 @@
+package notifications
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// ListHandler returns the logged in user's notifications. unread=true leaves
+// out those already read.
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	params := r.URL.Query()
+	limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	notifications, err := List(state, user.Id, params.Get("unread") == "true", limit, offset)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve notifications")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: notifications}, nil
+}
+
+// UnreadCountHandler returns how many unread notifications the logged in user
+// has.
+func UnreadCountHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	n, err := UnreadCount(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to count notifications")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: map[string]int{"unread": n}}, nil
+}
+
+// MarkReadHandler marks the notification in the url as read, or all of the
+// logged in user's notifications when there is none.
+func MarkReadHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	ids := []int64{}
+	if raw, ok := mux.Vars(r)["ID"]; ok {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid notification id")}
+		}
+		ids = append(ids, id)
+	}
+
+	n, err := MarkRead(state.DB, user.Id, ids)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to mark notifications read")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: map[string]int64{"marked": n}}, nil
+}
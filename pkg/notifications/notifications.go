@@ -0,0 +1,382 @@
+package notifications
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/events"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	Favorite = "favorite"
+	Follow   = "follow"
+	Feature  = "feature"
+	// Report tells a reporter their report was resolved.
+	Report = "report"
+	// Takedown tells an owner moderators acted on their content.
+	Takedown = "takedown"
+)
+
+// shownActors is how many actors are named on an aggregated notification.
+const shownActors = 3
+
+// Actor is someone who caused a notification.
+type Actor struct {
+	Username  string `json:"username"`
+	Permalink string `json:"permalink"`
+}
+
+// Notification tells a user something happened to them or their image. Bursts
+// of the same thing collapse into one notification until it is read, so
+// ActorCount can be larger than len(Actors).
+type Notification struct {
+	ID         int64        `json:"id"`
+	Type       string       `json:"type"`
+	Message    string       `json:"message"`
+	Actors     []Actor      `json:"actors"`
+	ActorCount int          `json:"actor_count"`
+	Image      *model.Image `json:"image,omitempty"`
+	Report     *int64       `json:"report,omitempty"`
+	Read       bool         `json:"read"`
+	CreatedAt  time.Time    `json:"created_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+}
+
+// upsert adds the actor to the recipient's unread notification of the same
+// type and image, creating it if there is none. source must select the
+// recipient and image id.
+const upsert = `
+	INSERT INTO content.notifications (user_id, type, image_id, actor_ids)
+	%s
+	ON CONFLICT (user_id, type, coalesce(image_id, 0), coalesce(report_id, 0)) WHERE read_at IS NULL
+	DO UPDATE SET actor_ids = array_prepend($1 :: INTEGER, array_remove(notifications.actor_ids, $1 :: INTEGER)),
+		updated_at = timezone('UTC'::text, now())
+	RETURNING id, user_id`
+
+// Favorited notifies the owner of the image that the actor favorited it,
+// unless the owner muted or blocked them.
+func Favorited(state *handler.State, actor, image int64) error {
+	return notify(state, Favorite, fmt.Sprintf(upsert, `
+	SELECT images.user_id, 'favorite', images.id, ARRAY[$1 :: INTEGER]
+	FROM content.images AS images
+	WHERE images.id = $2 AND images.user_id <> $1 AND NOT permissions.hidden(images.user_id, $1)`), actor, image)
+}
+
+// Followed notifies the user that the actor followed them, unless the user
+// muted or blocked them.
+func Followed(state *handler.State, actor, user int64) error {
+	return notify(state, Follow, fmt.Sprintf(upsert, `
+	SELECT $2 :: INTEGER, 'follow', NULL :: INTEGER, ARRAY[$1 :: INTEGER]
+	WHERE $1 <> $2 AND NOT permissions.hidden($2, $1)`), actor, user)
+}
+
+// Featured notifies the owner that their image was featured, or the user that
+// they were when target is a user.
+func Featured(state *handler.State, actor int64, target model.Ref) error {
+	switch target.Collection {
+	case model.Images:
+		return notify(state, Feature, fmt.Sprintf(upsert, `
+		SELECT images.user_id, 'feature', images.id, ARRAY[$1 :: INTEGER]
+		FROM content.images AS images
+		WHERE images.id = $2 AND images.user_id <> $1`), actor, target.Id)
+	case model.Users:
+		return notify(state, Feature, fmt.Sprintf(upsert, `
+		SELECT $2 :: INTEGER, 'feature', NULL :: INTEGER, ARRAY[$1 :: INTEGER]
+		WHERE $1 <> $2`), actor, target.Id)
+	}
+	return nil
+}
+
+// notify runs an upsert and pushes the notification to its recipient's
+// streams along with their unread count.
+func notify(state *handler.State, t, query string, args ...interface{}) error {
+	var id, user int64
+	err := state.DB.QueryRowx(query, args...).Scan(&id, &user)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	unread, err := UnreadCount(state.DB, user)
+	if err != nil {
+		return err
+	}
+	return events.Publish(state.RD, user, events.Notification, map[string]interface{}{
+		"id":     id,
+		"type":   t,
+		"unread": unread,
+	})
+}
+
+// Resolved tells the user the report was resolved: its reporter with Report,
+// the owner of what was reported with Takedown.
+func Resolved(state *handler.State, t string, user, report int64) error {
+	return notify(state, t, `
+	INSERT INTO content.notifications (user_id, type, report_id)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (user_id, type, coalesce(image_id, 0), coalesce(report_id, 0)) WHERE read_at IS NULL
+	DO UPDATE SET updated_at = timezone('UTC'::text, now())
+	RETURNING id, user_id`, user, t, report)
+}
+
+// Retract takes the actor back off an unread notification, such as when an
+// image is unfavorited, and removes the notification once nobody is left.
+// Features are taken back whoever unfeatures. Read notifications are left
+// alone.
+func Retract(db *sqlx.DB, t string, actor int64, target model.Ref) error {
+	image, user := int64(0), int64(0)
+	switch target.Collection {
+	case model.Images:
+		image = target.Id
+	case model.Users:
+		user = target.Id
+	}
+
+	_, err := db.Exec(`
+	WITH retracted AS (
+		UPDATE content.notifications
+			SET actor_ids = CASE WHEN type = 'feature' THEN '{}'
+				ELSE array_remove(actor_ids, $2 :: INTEGER) END
+		WHERE read_at IS NULL AND type = $1 AND coalesce(image_id, 0) = $3
+			AND ($4 :: INTEGER = 0 OR user_id = $4)
+		RETURNING id, actor_ids
+	)
+	DELETE FROM content.notifications
+	WHERE id IN (SELECT id FROM retracted WHERE cardinality(actor_ids) = 0)`, t, actor, image, user)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+type row struct {
+	ID        int64         `db:"id"`
+	Type      string        `db:"type"`
+	ImageID   *int64        `db:"image_id"`
+	ActorIDs  pq.Int64Array `db:"actor_ids"`
+	ReportID  *int64        `db:"report_id"`
+	Target    *string       `db:"target_type"`
+	Status    *string       `db:"status"`
+	Action    *string       `db:"action"`
+	Reason    *string       `db:"reason"`
+	ReadAt    *time.Time    `db:"read_at"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+}
+
+// visible leaves out notifications whose actors are all hidden from the
+// recipient, such as those they muted after being notified, and those about
+// images in the trash.
+const visible = `(type IN ('feature', 'report', 'takedown') OR EXISTS(SELECT 1 FROM unnest(actor_ids) AS a(id)
+		WHERE NOT permissions.hidden(user_id, a.id)))
+	AND (image_id IS NULL OR NOT permissions.deleted(image_id, 'image'))`
+
+// List returns the user's notifications, most recently active first. Actors
+// the user has since muted or blocked are left out.
+func List(state *handler.State, user int64, unread bool, limit, offset int) ([]Notification, error) {
+	rows := []row{}
+	err := state.DB.Select(&rows, `
+	SELECT notifications.id, notifications.type, notifications.image_id, notifications.report_id,
+		notifications.read_at, notifications.created_at, notifications.updated_at,
+		reports.target_type, reports.status, reports.action, reports.reason,
+		ARRAY(SELECT a.id FROM unnest(actor_ids) WITH ORDINALITY AS a(id, n)
+			WHERE NOT permissions.hidden(notifications.user_id, a.id) ORDER BY a.n) AS actor_ids
+	FROM content.notifications AS notifications
+		LEFT JOIN content.reports AS reports ON reports.id = notifications.report_id
+	WHERE notifications.user_id = $1 AND (NOT $2 OR notifications.read_at IS NULL) AND `+visible+`
+	ORDER BY notifications.updated_at DESC, notifications.id DESC
+	LIMIT $3 OFFSET $4`, user, unread, limit, offset)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	notifications := make([]Notification, len(rows))
+	for i, row := range rows {
+		n := Notification{
+			ID:         row.ID,
+			Type:       row.Type,
+			Actors:     []Actor{},
+			ActorCount: len(row.ActorIDs),
+			Read:       row.ReadAt != nil,
+			CreatedAt:  row.CreatedAt,
+			UpdatedAt:  row.UpdatedAt,
+		}
+
+		if row.ReportID != nil {
+			n.Report = row.ReportID
+			n.Message = outcome(row.Type, deref(row.Target), deref(row.Status), deref(row.Action), deref(row.Reason))
+			notifications[i] = n
+			continue
+		}
+
+		// Curators aren't named on features.
+		if row.Type != Feature {
+			n.Actors, err = actors(state, user, row.ActorIDs)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		title := ""
+		if row.ImageID != nil {
+			img, err := retrieval.GetImage(state, *row.ImageID)
+			if err != nil {
+				return nil, err
+			}
+			n.Image = &img
+			title = "your image"
+			if img.Title != nil && *img.Title != "" {
+				title = *img.Title
+			}
+		}
+
+		n.Message = message(row.Type, n.Actors, n.ActorCount, title)
+		notifications[i] = n
+	}
+	return notifications, nil
+}
+
+// actors returns the first few actors the user is allowed to see, in order.
+func actors(state *handler.State, user int64, ids pq.Int64Array) ([]Actor, error) {
+	names := []string{}
+	err := state.DB.Select(&names, `
+	SELECT users.username
+	FROM unnest($1 :: INTEGER[]) WITH ORDINALITY AS a(id, n)
+		INNER JOIN content.users AS users ON users.id = a.id
+	WHERE permissions.viewable($2, a.id, 'user')
+	ORDER BY a.n
+	LIMIT $3`, ids, user, shownActors)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	actors := make([]Actor, len(names))
+	for i, name := range names {
+		actors[i] = Actor{
+			Username:  name,
+			Permalink: model.Ref{Collection: model.Users, Shortcode: name}.ToURL(state.Port, state.Local),
+		}
+	}
+	return actors, nil
+}
+
+// message describes a notification, naming the first actor and counting the
+// rest, e.g. "ana and 11 others favorited Sunrise". title is empty when the
+// notification isn't about an image.
+func message(t string, actors []Actor, count int, title string) string {
+	var who string
+	switch {
+	case len(actors) == 0 && count == 1:
+		who = "Someone"
+	case len(actors) == 0:
+		who = fmt.Sprintf("%d people", count)
+	case count == 1:
+		who = actors[0].Username
+	case count == 2 && len(actors) == 2:
+		who = actors[0].Username + " and " + actors[1].Username
+	case count == 2:
+		who = actors[0].Username + " and 1 other"
+	default:
+		who = fmt.Sprintf("%s and %d others", actors[0].Username, count-1)
+	}
+
+	switch t {
+	case Favorite:
+		return who + " favorited " + title
+	case Follow:
+		return who + " followed you"
+	case Feature:
+		switch title {
+		case "":
+			return "You were featured"
+		case "your image":
+			return "Your image was featured"
+		}
+		return title + " was featured"
+	}
+	return ""
+}
+
+// reasons describes what content was taken down for.
+var reasons = map[string]string{
+	"spam":          "spam",
+	"nudity":        "nudity",
+	"violence":      "violence",
+	"harassment":    "harassment",
+	"copyright":     "copyright infringement",
+	"impersonation": "impersonation",
+}
+
+// outcome describes a resolved report, to its reporter for Report and to the
+// owner of what was reported for Takedown.
+func outcome(t, target, status, action, reason string) string {
+	if t == Report {
+		if status == "dismissed" {
+			return fmt.Sprintf("We reviewed the %s you reported and found it doesn't break our guidelines", target)
+		}
+		return fmt.Sprintf("Thanks for your report, we took action on the %s you reported", target)
+	}
+
+	what := "Your " + target
+	if target == "user" {
+		what = "Your profile"
+	}
+	why := "breaking our guidelines"
+	if r, ok := reasons[reason]; ok {
+		why = r
+	}
+	switch action {
+	case "hide":
+		return fmt.Sprintf("%s was hidden for %s", what, why)
+	case "delete":
+		return fmt.Sprintf("%s was removed for %s", what, why)
+	case "suspend":
+		return "Your account was suspended for " + why
+	}
+	return ""
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// UnreadCount returns how many unread notifications the user has.
+func UnreadCount(db *sqlx.DB, user int64) (int, error) {
+	var n int
+	err := db.Get(&n, `
+	SELECT count(*) FROM content.notifications
+	WHERE user_id = $1 AND read_at IS NULL AND `+visible, user)
+	if err != nil {
+		log.Println(err)
+	}
+	return n, err
+}
+
+// MarkRead marks the given notifications as read, or all of them when ids is
+// empty. It returns how many were marked.
+func MarkRead(db *sqlx.DB, user int64, ids []int64) (int64, error) {
+	res, err := db.Exec(`
+	UPDATE content.notifications
+		SET read_at = timezone('UTC'::text, now())
+	WHERE user_id = $1 AND read_at IS NULL
+		AND (cardinality($2 :: INTEGER[]) = 0 OR id = ANY($2))`, user, pq.Array(ids))
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,48 @@
+package notifications
+
+import "testing"
+
+func TestMessage(t *testing.T) {
+	ana, ben := Actor{Username: "ana"}, Actor{Username: "ben"}
+
+	for _, c := range []struct {
+		t      string
+		actors []Actor
+		count  int
+		title  string
+		want   string
+	}{
+		{Favorite, []Actor{ana}, 1, "Sunrise", "ana favorited Sunrise"},
+		{Favorite, []Actor{ana, ben}, 2, "Sunrise", "ana and ben favorited Sunrise"},
+		{Favorite, []Actor{ana}, 2, "Sunrise", "ana and 1 other favorited Sunrise"},
+		{Favorite, []Actor{ana, ben}, 12, "your image", "ana and 11 others favorited your image"},
+		{Favorite, []Actor{}, 12, "Sunrise", "12 people favorited Sunrise"},
+		{Follow, []Actor{}, 1, "", "Someone followed you"},
+		{Follow, []Actor{ana, ben}, 3, "", "ana and 2 others followed you"},
+		{Feature, []Actor{}, 1, "Sunrise", "Sunrise was featured"},
+		{Feature, []Actor{}, 1, "your image", "Your image was featured"},
+		{Feature, []Actor{}, 1, "", "You were featured"},
+	} {
+		if got := message(c.t, c.actors, c.count, c.title); got != c.want {
+			t.Errorf("expected %q, got %q", c.want, got)
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	for _, c := range []struct {
+		t, target, status, action, reason string
+		want                              string
+	}{
+		{Report, "image", "actioned", "hide", "spam", "Thanks for your report, we took action on the image you reported"},
+		{Report, "user", "dismissed", "", "harassment", "We reviewed the user you reported and found it doesn't break our guidelines"},
+		{Takedown, "image", "actioned", "hide", "nudity", "Your image was hidden for nudity"},
+		{Takedown, "image", "actioned", "delete", "copyright", "Your image was removed for copyright infringement"},
+		{Takedown, "user", "actioned", "hide", "other", "Your profile was hidden for breaking our guidelines"},
+		{Takedown, "user", "actioned", "suspend", "impersonation", "Your account was suspended for impersonation"},
+	} {
+		if got := outcome(c.t, c.target, c.status, c.action, c.reason); got != c.want {
+			t.Errorf("expected %q, got %q", c.want, got)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package oidc
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// Link is a provider identity a user can sign in with.
+type Link struct {
+	Provider  string    `db:"provider" json:"provider"`
+	Email     *string   `db:"email" json:"email,omitempty"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Lookup returns the user the identity is linked to and their email.
+// Identities are not matched to users by email, as anyone who controls the
+// address at a provider could then sign in as the user. They are linked
+// explicitly, by the user from an existing session, or claimed once by an
+// account from before identities were stored.
+func Lookup(db *sqlx.DB, id Identity) (model.Ref, string, error) {
+	user := struct {
+		Id       int64  `db:"id"`
+		Username string `db:"username"`
+		Email    string `db:"email"`
+	}{}
+
+	err := db.Get(&user, `
+	SELECT users.id, users.username, users.email
+	FROM content.user_identities AS ids
+		JOIN content.users AS users ON users.id = ids.user_id
+	WHERE ids.provider = $1 AND ids.subject = $2`, id.Provider, id.Subject)
+	if err != nil {
+		return model.Ref{}, "", err
+	}
+
+	return model.Ref{Id: user.Id, Collection: model.Users, Shortcode: user.Username}, user.Email, nil
+}
+
+// Claim links a Google identity to the only account with its email, if that
+// account has no identities yet. Accounts made before identities were stored
+// signed in by their Google email alone, and have no other way to link one.
+// The email has to be verified by Google, and once an account has any
+// identity it is never matched by email again.
+func Claim(db *sqlx.DB, id Identity) (model.Ref, string, error) {
+	if id.Provider != "google" || !id.EmailVerified || id.Email == "" {
+		return model.Ref{}, "", sql.ErrNoRows
+	}
+
+	user := struct {
+		Id       int64  `db:"id"`
+		Username string `db:"username"`
+		Email    string `db:"email"`
+	}{}
+
+	err := db.Get(&user, `
+	WITH claimed AS (
+		INSERT INTO content.user_identities(user_id, provider, subject, email)
+		SELECT users.id, $1, $2, users.email
+		FROM content.users AS users
+		WHERE users.email = $3
+			AND (SELECT count(*) FROM content.users WHERE email = $3) = 1
+			AND NOT EXISTS (SELECT 1 FROM content.user_identities AS ids WHERE ids.user_id = users.id)
+		RETURNING user_id
+	)
+	SELECT users.id, users.username, users.email
+	FROM claimed
+		JOIN content.users AS users ON users.id = claimed.user_id`, id.Provider, id.Subject, id.Email)
+	if err != nil {
+		return model.Ref{}, "", err
+	}
+
+	return model.Ref{Id: user.Id, Collection: model.Users, Shortcode: user.Username}, user.Email, nil
+}
+
+// Add links the identity to the user.
+func Add(db sqlx.Execer, userID int64, id Identity) error {
+	_, err := db.Exec(`
+	INSERT INTO content.user_identities(user_id, provider, subject, email)
+	VALUES ($1, $2, $3, $4)`, userID, id.Provider, id.Subject, id.Email)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Remove unlinks the user's identity for the provider.
+func Remove(db *sqlx.DB, userID int64, provider string) (bool, error) {
+	res, err := db.Exec(`
+	DELETE FROM content.user_identities
+	WHERE user_id = $1 AND provider = $2`, userID, provider)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Links lists the identities the user can sign in with.
+func Links(db *sqlx.DB, userID int64) ([]Link, error) {
+	links := []Link{}
+	err := db.Select(&links, `
+	SELECT provider, email, created_at FROM content.user_identities
+	WHERE user_id = $1
+	ORDER BY created_at`, userID)
+	if err != nil {
+		log.Println(err)
+		return []Link{}, err
+	}
+	return links, nil
+}
@@ -0,0 +1,131 @@
+package oidc
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fokal/fokal-core/pkg/oidc/oidctest"
+)
+
+func TestVerify(t *testing.T) {
+	iss, err := oidctest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iss.Close()
+
+	p, err := NewProvider(Config{
+		Name:      "test",
+		Issuer:    iss.URL,
+		Audiences: []string{"fokal"},
+		Claims:    ClaimMap{Username: "email", Domain: "hd"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := NewRegistry(p)
+
+	raw, err := iss.Token("fokal", jwt.MapClaims{
+		"sub":            "1234",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"name":           "Jane",
+		"hd":             "example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := registry.Verify(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Provider != "test" || id.Subject != "1234" || !id.EmailVerified {
+		t.Errorf("unexpected identity %+v", id)
+	}
+	if id.Username != "jane.example.com" {
+		t.Errorf("expected username jane.example.com, got %s", id.Username)
+	}
+
+	tests := []struct {
+		name   string
+		aud    string
+		claims jwt.MapClaims
+	}{
+		{"wrong audience", "other", jwt.MapClaims{"sub": "1234"}},
+		{"expired", "fokal", jwt.MapClaims{"sub": "1234", "exp": time.Now().Add(-time.Hour).Unix()}},
+		{"no subject", "fokal", jwt.MapClaims{}},
+	}
+	for _, test := range tests {
+		raw, err := iss.Token(test.aud, test.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := registry.Verify(raw); err == nil {
+			t.Errorf("%s: expected token to be rejected", test.name)
+		}
+	}
+
+	raw, err = iss.Token("fokal", jwt.MapClaims{"sub": "1234", "aud": []string{"other", "fokal"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Verify(raw); err != nil {
+		t.Errorf("expected audience list to be accepted: %s", err)
+	}
+
+	raw, err = iss.Token("fokal", jwt.MapClaims{"sub": "1234", "iss": "https://elsewhere.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := registry.Verify(raw); err != ErrUnknownIssuer {
+		t.Errorf("expected unknown issuer, got %v", err)
+	}
+}
+
+func TestUnknownKid(t *testing.T) {
+	iss, err := oidctest.NewIssuer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iss.Close()
+
+	p, err := NewProvider(Config{Name: "test", Issuer: iss.URL, Audiences: []string{"fokal"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iss.KeyID = "rotated"
+	raw, err := iss.Token("fokal", jwt.MapClaims{"sub": "1234"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys were fetched moments ago, so an unknown kid does not refetch yet.
+	if _, err := p.Verify(raw); err == nil {
+		t.Error("expected unknown kid to be rejected")
+	}
+	if iss.JWKSRequests != 1 {
+		t.Errorf("expected 1 JWKS request, got %d", iss.JWKSRequests)
+	}
+
+	p.fetched = time.Now().Add(-jwksMinInterval)
+	if _, err := p.Verify(raw); err != nil {
+		t.Errorf("expected rotated key to be fetched: %s", err)
+	}
+}
+
+func TestClaimIneligible(t *testing.T) {
+	cases := []Identity{
+		{Provider: "test", Subject: "1", Email: "a@example.com", EmailVerified: true},
+		{Provider: "google", Subject: "1", Email: "a@example.com"},
+		{Provider: "google", Subject: "1", EmailVerified: true},
+	}
+	for _, id := range cases {
+		if _, _, err := Claim(nil, id); err != sql.ErrNoRows {
+			t.Errorf("Claim(%+v) = %v, want sql.ErrNoRows", id, err)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// How long fetched JWKS are trusted for, and how often an unknown kid may
+// trigger a refetch.
+const (
+	jwksLifetime    = time.Hour
+	jwksMinInterval = time.Minute
+)
+
+// ClaimMap names the ID token claims that hold each piece of the identity.
+type ClaimMap struct {
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+
+	// Domain is appended to the username if present, e.g. Google's hd claim.
+	Domain string `json:"domain"`
+}
+
+// Config describes a login provider.
+type Config struct {
+	Name      string   `json:"name"`
+	Issuer    string   `json:"issuer"`
+	Audiences []string `json:"audiences"`
+	Claims    ClaimMap `json:"claims"`
+}
+
+// Google returns the configuration matching how Google logins have always
+// mapped onto Fokal usernames.
+func Google(clientIDs ...string) Config {
+	return Config{
+		Name:      "google",
+		Issuer:    "https://accounts.google.com",
+		Audiences: clientIDs,
+		Claims:    ClaimMap{Username: "email", Domain: "hd"},
+	}
+}
+
+// Identity is the verified subject of an ID token.
+type Identity struct {
+	Provider      string `db:"provider" json:"provider"`
+	Subject       string `db:"subject" json:"-"`
+	Email         string `db:"email" json:"email,omitempty"`
+	EmailVerified bool   `db:"-" json:"-"`
+	Name          string `db:"-" json:"-"`
+	Username      string `db:"-" json:"-"`
+}
+
+// Provider verifies ID tokens for a single issuer, caching its signing keys.
+type Provider struct {
+	Config
+
+	client  *http.Client
+	jwksURI string
+
+	mu      sync.RWMutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+type discovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+// NewProvider discovers the issuer's configuration and fetches its keys.
+func NewProvider(cfg Config, client *http.Client) (*Provider, error) {
+	if cfg.Name == "" || cfg.Issuer == "" {
+		return nil, errors.New("oidc: providers need a name and issuer")
+	}
+	if len(cfg.Audiences) == 0 {
+		return nil, fmt.Errorf("oidc: provider %s has no audiences", cfg.Name)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+
+	defaults := ClaimMap{Subject: "sub", Email: "email", Name: "name", Username: "preferred_username"}
+	if cfg.Claims.Subject == "" {
+		cfg.Claims.Subject = defaults.Subject
+	}
+	if cfg.Claims.Email == "" {
+		cfg.Claims.Email = defaults.Email
+	}
+	if cfg.Claims.Name == "" {
+		cfg.Claims.Name = defaults.Name
+	}
+	if cfg.Claims.Username == "" {
+		cfg.Claims.Username = defaults.Username
+	}
+
+	p := &Provider{Config: cfg, client: client}
+
+	d := discovery{}
+	err := p.getJSON(strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovered issuer %s does not match %s", d.Issuer, cfg.Issuer)
+	}
+	p.jwksURI = d.JWKSURI
+
+	err = p.refresh()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Provider) getJSON(url string, v interface{}) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *Provider) refresh() error {
+	set := jwks{}
+	err := p.getJSON(p.jwksURI, &set)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.fetched = time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+// key returns the signing key for kid, refetching the JWKS when it is stale or
+// the kid is unknown.
+func (p *Provider) key(kid string) (*rsa.PublicKey, error) {
+	p.mu.RLock()
+	k, ok := p.keys[kid]
+	age := time.Since(p.fetched)
+	p.mu.RUnlock()
+
+	if (ok && age < jwksLifetime) || (!ok && age < jwksMinInterval) {
+		if !ok {
+			return nil, fmt.Errorf("oidc: unknown kid %s", kid)
+		}
+		return k, nil
+	}
+
+	err := p.refresh()
+	if err != nil {
+		log.Printf("oidc: unable to refresh keys for %s: %s", p.Name, err)
+		if ok {
+			return k, nil
+		}
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	k, ok = p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("oidc: unknown kid %s", kid)
+	}
+	return k, nil
+}
+
+// Verify checks the token's signature, issuer, audience and expiry and maps
+// its claims onto an Identity.
+func (p *Provider) Verify(raw string) (Identity, error) {
+	token, err := jwt.Parse(raw, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(kid)
+	})
+	if err != nil {
+		return Identity{}, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return Identity{}, errors.New("oidc: token is invalid")
+	}
+
+	if iss, _ := claims["iss"].(string); iss != p.Issuer {
+		return Identity{}, errors.New("oidc: unexpected issuer")
+	}
+	if !p.audienceAllowed(claims["aud"]) {
+		return Identity{}, errors.New("oidc: unexpected audience")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return Identity{}, errors.New("oidc: token has no expiry")
+	}
+
+	return p.identity(claims)
+}
+
+func (p *Provider) audienceAllowed(aud interface{}) bool {
+	var audiences []string
+	switch v := aud.(type) {
+	case string:
+		audiences = []string{v}
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+
+	for _, a := range audiences {
+		for _, allowed := range p.Audiences {
+			if a == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Provider) identity(claims jwt.MapClaims) (Identity, error) {
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return s
+	}
+
+	id := Identity{
+		Provider: p.Name,
+		Subject:  str(p.Claims.Subject),
+		Email:    str(p.Claims.Email),
+		Name:     str(p.Claims.Name),
+	}
+	if id.Subject == "" {
+		return Identity{}, errors.New("oidc: token has no subject")
+	}
+
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		id.EmailVerified = v
+	case string:
+		id.EmailVerified = v == "true"
+	}
+
+	id.Username = strings.Split(str(p.Claims.Username), "@")[0]
+	if p.Claims.Domain != "" {
+		if domain := str(p.Claims.Domain); domain != "" {
+			id.Username = id.Username + "." + domain
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,53 @@
+package oidc
+
+import (
+	"errors"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrUnknownIssuer is returned for tokens from issuers that are not configured.
+var ErrUnknownIssuer = errors.New("oidc: unknown issuer")
+
+// Registry holds the configured providers, keyed by issuer.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(providers ...*Provider) *Registry {
+	r := &Registry{providers: make(map[string]*Provider)}
+	for _, p := range providers {
+		r.providers[p.Issuer] = p
+	}
+	return r
+}
+
+// Provider returns the configured provider with the given name.
+func (r *Registry) Provider(name string) (*Provider, bool) {
+	for _, p := range r.providers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// Issuer returns the issuer claimed by the token without verifying it.
+func Issuer(raw string) string {
+	claims := jwt.MapClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(raw, claims)
+	if err != nil {
+		return ""
+	}
+	iss, _ := claims["iss"].(string)
+	return iss
+}
+
+// Verify routes the token to the provider for its issuer.
+func (r *Registry) Verify(raw string) (Identity, error) {
+	p, ok := r.providers[Issuer(raw)]
+	if !ok {
+		return Identity{}, ErrUnknownIssuer
+	}
+	return p.Verify(raw)
+}
@@ -18,7 +18,7 @@ func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 		userID = &ref.Id
 	}
 
-	image, err := Image(store, userID)
+	image, err := Image(store, retrieval.Viewer(r), userID)
 	if err != nil {
 		return handler.Response{}, err
 	}
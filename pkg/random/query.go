@@ -6,13 +6,13 @@ import (
 	"github.com/fokal/fokal-core/pkg/retrieval"
 )
 
-func Image(state *handler.State, u *int64) (model.Image, error) {
+func Image(state *handler.State, viewer int64, u *int64) (model.Image, error) {
 	var id int64
 	var err error
 	if u != nil {
-		err = state.DB.Get(&id, "SELECT random_image($1);", *u)
+		err = state.DB.Get(&id, "SELECT random_image($1, $2);", viewer, *u)
 	} else {
-		err = state.DB.Get(&id, "SELECT random_image();")
+		err = state.DB.Get(&id, "SELECT random_image($1);", viewer)
 	}
 
 	if err != nil {
@@ -1,16 +1,89 @@
 package ratelimit
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
-
+	"strconv"
 	"time"
 
-	"github.com/didip/tollbooth"
-	"github.com/didip/tollbooth/limiter"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/context"
 )
 
-func RateLimit(h http.Handler) http.Handler {
-	return tollbooth.LimitHandler(tollbooth.NewLimiter(5, &limiter.ExpirableOptions{
-		DefaultExpirationTTL: time.Second,
-	}), h)
+type Middleware struct {
+	Pool   *redis.Pool
+	Limits Limits
+	C      Class
+}
+
+// Handler limits requests in the middleware's class. Requests are counted
+// against the authenticated user, so it has to run after the authentication
+// middleware to do so, and against the client's IP otherwise. Requests are let
+// through if Redis is unavailable.
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.Allow(w, r) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// Allow counts the request and reports whether it may go ahead. Requests over
+// the limit are answered with a 429. When a request counts towards several
+// limits the RateLimit headers describe the one with the least remaining.
+func (m Middleware) Allow(w http.ResponseWriter, r *http.Request) bool {
+	rate, ok := m.Limits[m.C]
+	if !ok {
+		rate = DefaultLimits[m.C]
+	}
+
+	res, err := Allow(m.Pool, fmt.Sprintf("%s:%s", m.C, client(r)), rate)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+
+	reset := seconds(res.Reset)
+	remaining, err := strconv.Atoi(w.Header().Get("RateLimit-Remaining"))
+	if err != nil || res.Remaining < remaining {
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(reset))
+	}
+
+	if !res.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(reset))
+		w.WriteHeader(http.StatusTooManyRequests)
+		j, _ := json.Marshal(map[string]interface{}{
+			"code": http.StatusTooManyRequests,
+			"err":  fmt.Sprintf("Rate limit of %s exceeded", rate),
+		})
+		w.Write(j)
+		return false
+	}
+	return true
+}
+
+// client identifies who a request is counted against.
+func client(r *http.Request) string {
+	if user, ok := context.Get(r, "auth").(model.Ref); ok {
+		return fmt.Sprintf("user:%d", user.Id)
+	}
+	if ip, ok := context.Get(r, "ip").(string); ok {
+		return "ip:" + ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
 }
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+const prefix = "ratelimit:"
+
+// Class groups routes that share a limit.
+type Class string
+
+const (
+	Default = Class("default")
+	Upload  = Class("upload")
+	Search  = Class("search")
+	Social  = Class("social")
+)
+
+// Rate allows Limit requests in any window of length Period.
+type Rate struct {
+	Limit  int
+	Period time.Duration
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Limit, r.Period)
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": time.Hour * 24,
+}
+
+// ParseRate reads a rate such as 60/m or 20/1h. The period is either one of
+// s, m, h and d or a Go duration.
+func ParseRate(s string) (Rate, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) != 2 {
+		return Rate{}, fmt.Errorf("invalid rate %q, expected <limit>/<period>", s)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit < 1 {
+		return Rate{}, fmt.Errorf("invalid limit in rate %q", s)
+	}
+
+	period, ok := units[parts[1]]
+	if !ok {
+		period, err = time.ParseDuration(parts[1])
+		if err != nil || period < time.Second {
+			return Rate{}, fmt.Errorf("invalid period in rate %q", s)
+		}
+	}
+	return Rate{Limit: limit, Period: period}, nil
+}
+
+// Limits maps each class of routes to its rate.
+type Limits map[Class]Rate
+
+// DefaultLimits are used for classes that are not configured.
+var DefaultLimits = Limits{
+	Default: {Limit: 300, Period: time.Minute},
+	Upload:  {Limit: 30, Period: time.Hour},
+	Search:  {Limit: 120, Period: time.Minute},
+	Social:  {Limit: 60, Period: time.Minute},
+}
+
+// ParseLimits reads a comma separated list of class=rate pairs, such as
+// upload=20/h,search=60/m, on top of DefaultLimits.
+func ParseLimits(s string) (Limits, error) {
+	limits := Limits{}
+	for c, r := range DefaultLimits {
+		limits[c] = r
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return Limits{}, fmt.Errorf("invalid limit %q, expected <class>=<rate>", pair)
+		}
+
+		c := Class(strings.TrimSpace(kv[0]))
+		if _, ok := DefaultLimits[c]; !ok {
+			return Limits{}, fmt.Errorf("unknown rate limit class %s", c)
+		}
+		r, err := ParseRate(kv[1])
+		if err != nil {
+			return Limits{}, err
+		}
+		limits[c] = r
+	}
+	return limits, nil
+}
+
+// Result describes the state of a key's window after a request.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the oldest request in the window expires.
+	Reset time.Duration
+}
+
+// slidingWindow keeps the time of every request in the window in a sorted
+// set, dropping those older than the window before counting them.
+var slidingWindow = redis.NewScript(1, `
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+  redis.call('ZADD', KEYS[1], now, ARGV[4])
+  count = count + 1
+  allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+  reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+// Allow records a request for key against the rate, unless the key has
+// already used up its window.
+func Allow(pool *redis.Pool, key string, rate Rate) (Result, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	window := int64(rate.Period / time.Millisecond)
+	vals, err := redis.Int64s(slidingWindow.Do(conn, prefix+key, now, window, rate.Limit, uuid.NewV4().String()))
+	if err != nil {
+		return Result{}, err
+	}
+	if len(vals) != 3 {
+		return Result{}, fmt.Errorf("unexpected rate limit reply %v", vals)
+	}
+
+	remaining := rate.Limit - int(vals[1])
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Result{
+		Allowed:   vals[0] == 1,
+		Limit:     rate.Limit,
+		Remaining: remaining,
+		Reset:     time.Duration(vals[2]) * time.Millisecond,
+	}, nil
+}
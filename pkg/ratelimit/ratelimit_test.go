@@ -0,0 +1,54 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		raw  string
+		rate Rate
+		ok   bool
+	}{
+		{"60/m", Rate{60, time.Minute}, true},
+		{"5/s", Rate{5, time.Second}, true},
+		{"20/1h", Rate{20, time.Hour}, true},
+		{" 1000/d ", Rate{1000, time.Hour * 24}, true},
+		{"20/90s", Rate{20, time.Second * 90}, true},
+		{"60", Rate{}, false},
+		{"0/m", Rate{}, false},
+		{"ten/m", Rate{}, false},
+		{"10/fortnight", Rate{}, false},
+		{"10/500ms", Rate{}, false},
+	}
+
+	for _, test := range tests {
+		rate, err := ParseRate(test.raw)
+		if test.ok && (err != nil || rate != test.rate) {
+			t.Errorf("ParseRate(%q) = %v, %v, expected %v", test.raw, rate, err, test.rate)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("ParseRate(%q) expected an error, got %v", test.raw, rate)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("upload=10/h, search=30/m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits[Upload] != (Rate{10, time.Hour}) || limits[Search] != (Rate{30, time.Minute}) {
+		t.Errorf("unexpected limits %v", limits)
+	}
+	if limits[Social] != DefaultLimits[Social] {
+		t.Errorf("expected the default social limit, got %v", limits[Social])
+	}
+
+	for _, raw := range []string{"uploads=10/h", "upload", "upload=10"} {
+		if _, err := ParseLimits(raw); err == nil {
+			t.Errorf("ParseLimits(%q) expected an error", raw)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package reports
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/admin"
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/cache"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/notifications"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/tokens"
+	"github.com/fokal/fokal-core/pkg/trash"
+	"github.com/fokal/fokal-core/pkg/webhooks"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+type reportHandler func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// target returns the image or user in the url along with who owns it.
+func target(state *handler.State, r *http.Request, t model.ReferenceType) (model.Ref, int64, error) {
+	id := mux.Vars(r)["ID"]
+	if t == model.Users {
+		ref, err := retrieval.GetUserRef(state.DB, id)
+		return ref, ref.Id, err
+	}
+
+	ref, err := retrieval.GetImageRef(state.DB, id)
+	if err != nil {
+		return model.Ref{}, 0, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+	}
+	owner, err := permissions.Owner(state.DB, ref.Id, model.Images)
+	if err != nil {
+		return model.Ref{}, 0, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return ref, owner, nil
+}
+
+// report returns the report in the url.
+func report(state *handler.State, r *http.Request) (Report, error) {
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Report not found")}
+	id, err := strconv.ParseInt(mux.Vars(r)["report"], 10, 64)
+	if err != nil {
+		return Report{}, notFound
+	}
+
+	rep, err := Get(state.DB, id)
+	if err == sql.ErrNoRows {
+		return Report{}, notFound
+	} else if err != nil {
+		return Report{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return rep, nil
+}
+
+// CreateHandler reports the image or user in the url to moderators.
+func CreateHandler(t model.ReferenceType) reportHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		user := context.Get(r, "auth").(model.Ref)
+
+		ref, owner, err := target(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+		if owner == user.Id {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Cannot report yourself")}
+		}
+
+		req := new(request.CreateReportRequest)
+		if errs := binding.Bind(r, req); errs != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+		}
+		details, err := Details(req.Reason, req.Details)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		id, err := Create(state.DB, user.Id, ref, owner, req.Reason, details)
+		if err == ErrDuplicate {
+			return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+		} else if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create report")}
+		}
+
+		rep, err := Get(state.DB, id)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+
+		audit.Record(state.DB, r, "report.create", ref, nil, rep)
+		return handler.Response{
+			Code: http.StatusCreated,
+			Data: map[string]interface{}{"id": rep.Id, "status": rep.Status},
+		}, nil
+	}
+}
+
+// ListHandler returns the moderation queue, oldest first. The status param
+// picks reports in one state instead of every pending one.
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	params := r.URL.Query()
+
+	status := params.Get("status")
+	switch status {
+	case "", Open, Reviewing, Actioned, Dismissed:
+	default:
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid status")}
+	}
+
+	limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	reports, err := List(state.DB, status, limit, offset)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve reports")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: reports}, nil
+}
+
+func GetHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	rep, err := report(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	return handler.Response{Code: http.StatusOK, Data: rep}, nil
+}
+
+// TriageHandler marks a pending report as being reviewed, or dismisses it and
+// lets the reporter know.
+func TriageHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	moderator := context.Get(r, "auth").(model.Ref)
+
+	rep, err := report(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.TriageReportRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	err = Triage(state.DB, rep.Id, moderator.Id, req.Status, req.Note)
+	switch err {
+	case nil:
+	case ErrStatus:
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	case ErrResolved:
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: err}
+	default:
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to update report")}
+	}
+
+	updated, err := Get(state.DB, rep.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	if updated.Status == Dismissed && updated.ReporterId != nil {
+		notifications.Resolved(state, notifications.Report, *updated.ReporterId, updated.Id)
+	}
+
+	audit.Record(state.DB, r, "report.triage", rep.Ref(), rep, updated)
+	return handler.Response{Code: http.StatusOK, Data: updated}, nil
+}
+
+// ActionHandler takes the reported content down by hiding or deleting it, or
+// suspends its owner. Every pending report on the same content is resolved
+// with it, and the reporters and owner are notified.
+func ActionHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	moderator := context.Get(r, "auth").(model.Ref)
+
+	rep, err := report(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if !rep.Pending() {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: ErrResolved}
+	}
+	if rep.Target == "" || rep.OwnerId == nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusGone, Err: errors.New("Reported content no longer exists")}
+	}
+
+	req := new(request.ReportActionRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	ref := rep.Ref()
+	owner := model.Ref{Id: *rep.OwnerId, Collection: model.Users, Shortcode: rep.Owner}
+	switch {
+	case req.Action == Hide:
+		if ref.Collection == model.Users {
+			if err = outranks(state, r, owner, "hide"); err != nil {
+				return handler.Response{}, err
+			}
+		}
+		if err = SetHidden(state.DB, ref, true); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to hide content")}
+		}
+		audit.Record(state.DB, r, ref.Collection.String()+".hide", ref, map[string]bool{"hidden": false}, map[string]bool{"hidden": true})
+	case req.Action == Delete && ref.Collection == model.Images:
+		// Deleted images are hidden too, so restoring them from the trash
+		// doesn't bring them back into view.
+		before, _ := retrieval.GetImage(state, ref.Id)
+		if err = SetHidden(state.DB, ref, true); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete image")}
+		}
+		// An image its owner already trashed only needs hiding.
+		err = trash.Delete(state.DB, ref)
+		if err == nil {
+			audit.Record(state.DB, r, "image.delete", ref, before, nil)
+			webhooks.Emit(state, webhooks.ImageDeleted, owner.Id, map[string]model.Ref{"image": ref, "user": owner})
+		} else if err != sql.ErrNoRows {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete image")}
+		}
+	case req.Action == Suspend:
+		if err = suspend(state, r, owner, rep.Reason); err != nil {
+			return handler.Response{}, err
+		}
+	default:
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: ErrAction}
+	}
+
+	resolved, err := Resolve(state.DB, ref, moderator.Id, req.Action, req.Note)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to resolve reports")}
+	}
+
+	notifications.Resolved(state, notifications.Takedown, owner.Id, rep.Id)
+	for _, res := range resolved {
+		if res.ReporterId != nil {
+			notifications.Resolved(state, notifications.Report, *res.ReporterId, res.Id)
+		}
+	}
+
+	audit.Record(state.DB, r, "report.action", ref, rep, resolved)
+
+	if err := cache.Flush(state.RD); err != nil {
+		log.Println(err)
+	}
+	return handler.Response{Code: http.StatusOK, Data: resolved}, nil
+}
+
+// suspend suspends the owner of reported content for the report's reason, as
+// long as the moderator is allowed to.
+func suspend(state *handler.State, r *http.Request, owner model.Ref, reason string) error {
+	if !roles.Current(r).Can(roles.SuspendUsers) {
+		return handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Cannot suspend users")}
+	}
+
+	if err := outranks(state, r, owner, "suspend"); err != nil {
+		return err
+	}
+
+	if err := admin.Suspend(state.DB, owner.Id, reason); err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to suspend user")}
+	}
+	if err := tokens.RevokeAll(state, owner.Id); err != nil {
+		log.Println(err)
+	}
+
+	audit.Record(state.DB, r, "user.suspend", owner,
+		map[string]interface{}{"suspended": false},
+		map[string]interface{}{"suspended": true, "reason": reason})
+	return nil
+}
+
+// outranks checks that the moderator's role is above the owner's, as action
+// cannot be taken against users of an equal or higher role.
+func outranks(state *handler.State, r *http.Request, owner model.Ref, action string) error {
+	role, err := roles.Get(state.DB, owner.Id)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !roles.Current(r).Outranks(role) {
+		return handler.StatusError{Code: http.StatusForbidden, Err: fmt.Errorf("Cannot %s users of an equal or higher role", action)}
+	}
+	return nil
+}
+
+// UnhideHandler puts content taken down by a report back up.
+func UnhideHandler(t model.ReferenceType) reportHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		ref, _, err := target(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		if err = SetHidden(state.DB, ref, false); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to unhide content")}
+		}
+
+		audit.Record(state.DB, r, t.String()+".unhide", ref, map[string]bool{"hidden": true}, map[string]bool{"hidden": false})
+		if err := cache.Flush(state.RD); err != nil {
+			log.Println(err)
+		}
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
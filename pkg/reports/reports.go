@@ -0,0 +1,248 @@
+package reports
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	Open      = "open"
+	Reviewing = "reviewing"
+	Actioned  = "actioned"
+	Dismissed = "dismissed"
+)
+
+const (
+	Hide    = "hide"
+	Delete  = "delete"
+	Suspend = "suspend"
+)
+
+// Reasons lists the categories content can be reported for.
+var Reasons = []string{"spam", "nudity", "violence", "harassment", "copyright", "impersonation", "other"}
+
+// MaxDetails is the longest description a reporter can add, in characters.
+const MaxDetails = 1000
+
+var (
+	// ErrReason is returned for reasons that aren't in Reasons.
+	ErrReason = fmt.Errorf("reason must be one of %v", Reasons)
+	// ErrDetails is returned for overlong details.
+	ErrDetails = errors.New("details must be at most 1000 characters")
+	// ErrDuplicate is returned when the reporter already has a pending report
+	// on the same target.
+	ErrDuplicate = errors.New("You have already reported this")
+	// ErrResolved is returned when triaging or acting on a report that has
+	// already been actioned or dismissed.
+	ErrResolved = errors.New("Report has already been resolved")
+	// ErrStatus is returned when triaging to anything but reviewing or
+	// dismissed.
+	ErrStatus = errors.New("status must be reviewing or dismissed")
+	// ErrAction is returned for unknown actions, or deleting a user.
+	ErrAction = errors.New("action must be hide, delete or suspend, and users can't be deleted")
+)
+
+// Report flags an image or user for moderators. Target is empty once what was
+// reported has been deleted, and Owner once they no longer exist.
+type Report struct {
+	Id         int64      `db:"id" json:"id"`
+	ReporterId *int64     `db:"reporter_id" json:"-"`
+	Reporter   string     `db:"reporter" json:"reporter,omitempty"`
+	TargetType string     `db:"target_type" json:"target_type"`
+	TargetId   int64      `db:"target_id" json:"-"`
+	Target     string     `db:"target" json:"target,omitempty"`
+	OwnerId    *int64     `db:"owner_id" json:"-"`
+	Owner      string     `db:"owner" json:"owner,omitempty"`
+	Reason     string     `db:"reason" json:"reason"`
+	Details    *string    `db:"details" json:"details,omitempty"`
+	Status     string     `db:"status" json:"status"`
+	Action     *string    `db:"action" json:"action,omitempty"`
+	Note       *string    `db:"note" json:"note,omitempty"`
+	Moderator  string     `db:"moderator" json:"moderator,omitempty"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt  time.Time  `db:"updated_at" json:"updated_at"`
+	ResolvedAt *time.Time `db:"resolved_at" json:"resolved_at,omitempty"`
+}
+
+// Ref returns what was reported.
+func (r Report) Ref() model.Ref {
+	ref := model.Ref{Id: r.TargetId, Shortcode: r.Target, Collection: model.Users}
+	if r.TargetType == model.Images.String() {
+		ref.Collection = model.Images
+	}
+	return ref
+}
+
+// Pending reports whether the report is still waiting on a moderator.
+func (r Report) Pending() bool {
+	return r.Status == Open || r.Status == Reviewing
+}
+
+const selectReports = `
+	SELECT reports.id, reports.reporter_id, coalesce(reporters.username, '') AS reporter,
+		reports.target_type, reports.target_id,
+		coalesce(CASE reports.target_type WHEN 'image' THEN images.shortcode ELSE targets.username END, '') AS target,
+		reports.owner_id, coalesce(owners.username, '') AS owner,
+		reports.reason, reports.details, reports.status, reports.action, reports.note,
+		coalesce(moderators.username, '') AS moderator,
+		reports.created_at, reports.updated_at, reports.resolved_at
+	FROM content.reports AS reports
+		LEFT JOIN content.users AS reporters ON reporters.id = reports.reporter_id
+		LEFT JOIN content.images AS images ON reports.target_type = 'image' AND images.id = reports.target_id
+			AND images.deleted_at IS NULL
+		LEFT JOIN content.users AS targets ON reports.target_type = 'user' AND targets.id = reports.target_id
+			AND targets.deleted_at IS NULL
+		LEFT JOIN content.users AS owners ON owners.id = reports.owner_id
+		LEFT JOIN content.users AS moderators ON moderators.id = reports.moderator_id`
+
+// Details trims what the reporter wrote and checks the reason and length.
+func Details(reason, raw string) (string, error) {
+	found := false
+	for _, r := range Reasons {
+		found = found || r == reason
+	}
+	if !found {
+		return "", ErrReason
+	}
+
+	details := strings.TrimSpace(raw)
+	if utf8.RuneCountInString(details) > MaxDetails {
+		return "", ErrDetails
+	}
+	return details, nil
+}
+
+// Create files the reporter's report on the target, which belongs to owner.
+func Create(db *sqlx.DB, reporter int64, target model.Ref, owner int64, reason, details string) (int64, error) {
+	var d *string
+	if details != "" {
+		d = &details
+	}
+
+	var id int64
+	err := db.Get(&id, `
+	INSERT INTO content.reports (reporter_id, target_type, target_id, owner_id, reason, details)
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`, reporter, target.Collection.String(), target.Id, owner, reason, d)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return 0, ErrDuplicate
+	}
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return id, nil
+}
+
+func Get(db *sqlx.DB, id int64) (Report, error) {
+	report := Report{}
+	err := db.Get(&report, selectReports+" WHERE reports.id = $1", id)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return report, err
+}
+
+// List returns the reports with the given status, or every pending one when
+// status is empty, oldest first so the queue is worked in order.
+func List(db *sqlx.DB, status string, limit, offset int) ([]Report, error) {
+	reports := []Report{}
+	err := db.Select(&reports, selectReports+`
+	WHERE CASE WHEN $1 = '' THEN reports.status IN ('open', 'reviewing')
+		ELSE reports.status :: TEXT = $1 END
+	ORDER BY reports.created_at, reports.id
+	LIMIT $2 OFFSET $3`, status, limit, offset)
+	if err != nil {
+		log.Println(err)
+	}
+	return reports, err
+}
+
+// Triage moves a pending report to reviewing, or dismisses it.
+func Triage(db *sqlx.DB, id, moderator int64, status, note string) error {
+	if status != Reviewing && status != Dismissed {
+		return ErrStatus
+	}
+
+	res, err := db.Exec(`
+	UPDATE content.reports
+		SET status = $2, note = coalesce(nullif($3, ''), note), moderator_id = $4,
+			updated_at = timezone('UTC'::text, now()),
+			resolved_at = CASE WHEN $2 = 'dismissed' THEN timezone('UTC'::text, now()) END
+	WHERE id = $1 AND status IN ('open', 'reviewing')`, id, status, note, moderator)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return resolved(res)
+}
+
+// Resolve marks every pending report on the target as actioned with the
+// action taken, returning them.
+func Resolve(db *sqlx.DB, target model.Ref, moderator int64, action, note string) ([]Report, error) {
+	ids := []int64{}
+	err := db.Select(&ids, `
+	UPDATE content.reports
+		SET status = 'actioned', action = $3, note = nullif($4, ''), moderator_id = $5,
+			updated_at = timezone('UTC'::text, now()), resolved_at = timezone('UTC'::text, now())
+	WHERE target_type = $1 AND target_id = $2 AND status IN ('open', 'reviewing')
+	RETURNING id`, target.Collection.String(), target.Id, action, note, moderator)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	reports := []Report{}
+	err = db.Select(&reports, selectReports+" WHERE reports.id = ANY($1) ORDER BY reports.id", pq.Array(ids))
+	if err != nil {
+		log.Println(err)
+	}
+	return reports, err
+}
+
+// SetHidden takes the image or user down, or puts it back up. Hidden users'
+// images are hidden along with them.
+func SetHidden(db *sqlx.DB, target model.Ref, hidden bool) error {
+	table := "content.users"
+	if target.Collection == model.Images {
+		table = "content.images"
+	}
+
+	res, err := db.Exec(`
+	UPDATE `+table+`
+		SET hidden_at = CASE WHEN $2 THEN coalesce(hidden_at, timezone('UTC'::text, now())) END
+	WHERE id = $1`, target.Id, hidden)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func resolved(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if n == 0 {
+		return ErrResolved
+	}
+	return nil
+}
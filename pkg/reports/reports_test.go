@@ -0,0 +1,42 @@
+package reports
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fokal/fokal-core/pkg/model"
+)
+
+func TestDetails(t *testing.T) {
+	details, err := Details("spam", "  selling followers \n")
+	if err != nil || details != "selling followers" {
+		t.Errorf("expected trimmed details, got %q, %v", details, err)
+	}
+	if _, err := Details("other", ""); err != nil {
+		t.Errorf("expected details to be optional, got %v", err)
+	}
+	if _, err := Details("boring", ""); err != ErrReason {
+		t.Errorf("expected ErrReason, got %v", err)
+	}
+	if _, err := Details("spam", strings.Repeat("é", MaxDetails+1)); err != ErrDetails {
+		t.Errorf("expected ErrDetails, got %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := Report{TargetType: "image", TargetId: 4, Target: "abcdefghijkl", Status: Open}
+	if ref := r.Ref(); ref.Collection != model.Images || ref.Id != 4 || ref.Shortcode != "abcdefghijkl" {
+		t.Errorf("unexpected ref %+v", ref)
+	}
+	r.TargetType = "user"
+	if ref := r.Ref(); ref.Collection != model.Users {
+		t.Errorf("expected a user ref, got %+v", ref)
+	}
+
+	for status, pending := range map[string]bool{Open: true, Reviewing: true, Actioned: false, Dismissed: false} {
+		r.Status = status
+		if r.Pending() != pending {
+			t.Errorf("expected %s pending to be %v", status, pending)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package request
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+)
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+func (cf *RoleRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Role: binding.Field{
+			Form:     "role",
+			Required: true,
+		},
+	}
+}
+
+type SuspendRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (cf *SuspendRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Reason: binding.Field{
+			Form:     "reason",
+			Required: true,
+		},
+	}
+}
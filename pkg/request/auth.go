@@ -2,6 +2,7 @@ package request
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/mholt/binding"
 )
@@ -17,3 +18,81 @@ func (cf *LoginRequest) FieldMap(req *http.Request) binding.FieldMap {
 		&cf.Password: "password",
 	}
 }
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+func (cf *ChangePasswordRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.CurrentPassword: "current_password",
+		&cf.Password: binding.Field{
+			Form:     "password",
+			Required: true,
+		},
+	}
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (cf *CreateAPIKeyRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Name: binding.Field{
+			Form:     "name",
+			Required: true,
+		},
+		&cf.Scopes: binding.Field{
+			Form:     "scopes",
+			Required: true,
+		},
+	}
+}
+
+type IDTokenRequest struct {
+	IDToken string `json:"id_token"`
+}
+
+func (cf *IDTokenRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.IDToken: binding.Field{
+			Form:     "id_token",
+			Required: true,
+		},
+	}
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+func (cf *TOTPCodeRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Code: binding.Field{
+			Form:     "code",
+			Required: true,
+		},
+	}
+}
+
+type ChallengeRequest struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+func (cf *ChallengeRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Challenge: binding.Field{
+			Form:     "challenge",
+			Required: true,
+		},
+		&cf.Code: binding.Field{
+			Form:     "code",
+			Required: true,
+		},
+	}
+}
@@ -0,0 +1,51 @@
+package request
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+)
+
+type CreateCollectionRequest struct {
+	Title       string  `json:"title"`
+	Description *string `json:"description"`
+	Public      *bool   `json:"public"`
+}
+
+func (cf *CreateCollectionRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Title: binding.Field{
+			Form:     "title",
+			Required: true,
+		},
+		&cf.Description: "description",
+		&cf.Public:      "public",
+	}
+}
+
+type PatchCollectionRequest struct {
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Cover       *string `json:"cover"`
+}
+
+func (cf *PatchCollectionRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Title:       "title",
+		&cf.Description: "description",
+		&cf.Cover:       "cover",
+	}
+}
+
+type OrderCollectionRequest struct {
+	Images []string `json:"images"`
+}
+
+func (cf *OrderCollectionRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Images: binding.Field{
+			Form:     "images",
+			Required: true,
+		},
+	}
+}
@@ -0,0 +1,35 @@
+package request
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+)
+
+type CreateCommentRequest struct {
+	Body   string `json:"body"`
+	Parent *int64 `json:"parent"`
+}
+
+func (cf *CreateCommentRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Body: binding.Field{
+			Form:     "body",
+			Required: true,
+		},
+		&cf.Parent: "parent",
+	}
+}
+
+type EditCommentRequest struct {
+	Body string `json:"body"`
+}
+
+func (cf *EditCommentRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Body: binding.Field{
+			Form:     "body",
+			Required: true,
+		},
+	}
+}
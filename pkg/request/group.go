@@ -0,0 +1,35 @@
+package request
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+)
+
+type CreateGroupRequest struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+}
+
+func (cf *CreateGroupRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Name: binding.Field{
+			Form:     "name",
+			Required: true,
+		},
+		&cf.Description: "description",
+	}
+}
+
+type GroupMemberRequest struct {
+	Role string `json:"role"`
+}
+
+func (cf *GroupMemberRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Role: binding.Field{
+			Form:     "role",
+			Required: true,
+		},
+	}
+}
@@ -2,11 +2,19 @@ package request
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/mholt/binding"
 )
 
 type PatchImageRequest struct {
+	// These can be cleared by patching them with an empty string, so they are
+	// only left out when they are missing.
+	Title       *string `json:"title" structs:"title,omitempty"`
+	Description *string `json:"description" structs:"description,omitempty"`
+	Creator     *string `json:"creator" structs:"creator,omitempty"`
+	Copyright   *string `json:"copyright" structs:"copyright,omitempty"`
+
 	Tags []string `json:"tags" structs:"tags,omitempty"`
 
 	Aperture     float64 `json:"aperture" structs:"aperture,omitempty"`
@@ -21,6 +29,20 @@ type PatchImageRequest struct {
 
 	CaptureTime string `json:"capture_time" structs:"capture_time,omitempty"`
 
+	// Zero and false are settings of their own, so these are only left out
+	// when they are missing.
+	ExposureCompensation *float64 `json:"exposure_compensation" structs:"exposure_compensation,omitempty"`
+	Flash                *bool    `json:"flash" structs:"flash,omitempty"`
+	Altitude             *float64 `json:"altitude" structs:"altitude,omitempty"`
+
+	ExposureProgram string `json:"exposure_program" structs:"exposure_program,omitempty"`
+	ExposureMode    string `json:"exposure_mode" structs:"exposure_mode,omitempty"`
+	MeteringMode    string `json:"metering_mode" structs:"metering_mode,omitempty"`
+	WhiteBalance    string `json:"white_balance" structs:"white_balance,omitempty"`
+	FocalLength35mm int    `json:"focal_length_35mm" structs:"focal_length_35mm,omitempty"`
+	TimezoneOffset  string `json:"timezone_offset" structs:"timezone_offset,omitempty"`
+	Software        string `json:"software" structs:"software,omitempty"`
+
 	Geo *GeoPatch `json:"geo" structs:"geo,omitempty"`
 }
 
@@ -32,6 +54,8 @@ type GeoPatch struct {
 
 func (cf *PatchImageRequest) FieldMap(req *http.Request) binding.FieldMap {
 	return binding.FieldMap{
+		&cf.Title:        "title",
+		&cf.Description:  "description",
 		&cf.Tags:         "tags",
 		&cf.Aperture:     "aperture",
 		&cf.ExposureTime: "exposure_time",
@@ -42,5 +66,31 @@ func (cf *PatchImageRequest) FieldMap(req *http.Request) binding.FieldMap {
 		&cf.LensMake:     "lens_make",
 		&cf.LensModel:    "lens_model",
 		&cf.CaptureTime:  "capture_time",
+		&cf.Creator:      "creator",
+		&cf.Copyright:    "copyright",
+
+		&cf.ExposureCompensation: "exposure_compensation",
+		&cf.Flash:                "flash",
+		&cf.Altitude:             "altitude",
+
+		&cf.ExposureProgram: "exposure_program",
+		&cf.ExposureMode:    "exposure_mode",
+		&cf.MeteringMode:    "metering_mode",
+		&cf.WhiteBalance:    "white_balance",
+		&cf.FocalLength35mm: "focal_length_35mm",
+		&cf.TimezoneOffset:  "timezone_offset",
+		&cf.Software:        "software",
+	}
+}
+
+type CreateShareLinkRequest struct {
+	ExpiresAt     *time.Time `json:"expires_at"`
+	MaxViews      *int       `json:"max_views"`
+	AllowDownload bool       `json:"allow_download"`
+}
+
+func (cf *CreateShareLinkRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.AllowDownload: "allow_download",
 	}
 }
@@ -0,0 +1,52 @@
+package request
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+)
+
+type CreateReportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+func (cf *CreateReportRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Reason: binding.Field{
+			Form:     "reason",
+			Required: true,
+		},
+		&cf.Details: "details",
+	}
+}
+
+type TriageReportRequest struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+func (cf *TriageReportRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Status: binding.Field{
+			Form:     "status",
+			Required: true,
+		},
+		&cf.Note: "note",
+	}
+}
+
+type ReportActionRequest struct {
+	Action string `json:"action"`
+	Note   string `json:"note"`
+}
+
+func (cf *ReportActionRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.Action: binding.Field{
+			Form:     "action",
+			Required: true,
+		},
+		&cf.Note: "note",
+	}
+}
@@ -0,0 +1,39 @@
+package request
+
+import (
+	"net/http"
+
+	"github.com/mholt/binding"
+)
+
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+}
+
+func (cf *CreateWebhookRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.URL: binding.Field{
+			Form:     "url",
+			Required: true,
+		},
+		&cf.Events: binding.Field{
+			Form:     "events",
+			Required: true,
+		},
+	}
+}
+
+type PatchWebhookRequest struct {
+	URL    *string  `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+func (cf *PatchWebhookRequest) FieldMap(req *http.Request) binding.FieldMap {
+	return binding.FieldMap{
+		&cf.URL:    "url",
+		&cf.Events: "events",
+		&cf.Active: "active",
+	}
+}
@@ -66,3 +66,26 @@ func ExistsEmail(db *sqlx.DB, email string) (bool, error) {
 
 	return count == 1, nil
 }
+
+// ExistsGroup checks if the given group shortcode exists in the database
+func ExistsGroup(db *sqlx.DB, shortcode string) (bool, error) {
+	count := 0
+	err := db.Get(&count, "SELECT count(*) FROM content.groups WHERE shortcode = $1;", shortcode)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return count == 1, nil
+}
+
+// ExistsCollection checks if the given collection shortcode exists in the
+// database
+func ExistsCollection(db *sqlx.DB, shortcode string) (bool, error) {
+	count := 0
+	err := db.Get(&count, "SELECT count(*) FROM content.collections WHERE shortcode = $1;", shortcode)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return count == 1, nil
+}
@@ -10,11 +10,21 @@ import (
 
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/sharing"
 	"github.com/fokal/fokal-core/pkg/stats"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 )
 
+// Viewer returns the id of the user making the request, or 0 for anonymous
+// requests.
+func Viewer(r *http.Request) int64 {
+	if user, ok := context.Get(r, "auth").(model.Ref); ok {
+		return user.Id
+	}
+	return 0
+}
+
 func UserHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
 	var rsp handler.Response
 	username := mux.Vars(r)["ID"]
@@ -24,10 +34,19 @@ func UserHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (
 		return rsp, err
 	}
 
-	user, err := GetUser(store, ref.Id)
+	viewer := Viewer(r)
+	user, err := GetUser(store, viewer, ref.Id)
 	if err != nil {
 		return rsp, err
 	}
+
+	if viewer != 0 && viewer != ref.Id {
+		following, err := IsFollowing(store.DB, viewer, ref.Id)
+		if err != nil {
+			return rsp, err
+		}
+		user.IsFollowing = &following
+	}
 	return handler.Response{
 		Code: http.StatusOK,
 		Data: user,
@@ -43,7 +62,7 @@ func UserImagesHandler(store *handler.State, w http.ResponseWriter, r *http.Requ
 		return rsp, err
 	}
 
-	user, err := GetUserImages(store, ref.Id)
+	user, err := GetUserImages(store, Viewer(r), ref.Id)
 	if err != nil {
 		return handler.Response{}, err
 	}
@@ -63,7 +82,7 @@ func UserFavoritesHandler(store *handler.State, w http.ResponseWriter, r *http.R
 		return rsp, err
 	}
 
-	user, err := GetUserFavorites(store, ref.Id)
+	user, err := GetUserFavorites(store, Viewer(r), ref.Id)
 	if err != nil {
 		return handler.Response{}, err
 	}
@@ -85,7 +104,7 @@ func LoggedInUserHandler(store *handler.State, w http.ResponseWriter, r *http.Re
 	}
 
 	usrRef := val.(model.Ref)
-	user, err := GetUser(store, usrRef.Id)
+	user, err := GetUser(store, usrRef.Id, usrRef.Id)
 	if err != nil {
 		return rsp, err
 	}
@@ -107,7 +126,7 @@ func LoggedInUserImagesHandler(store *handler.State, w http.ResponseWriter, r *h
 	}
 
 	usrRef := val.(model.Ref)
-	images, err := GetUserImages(store, usrRef.Id)
+	images, err := GetUserImages(store, usrRef.Id, usrRef.Id)
 	if err != nil {
 		return rsp, err
 	}
@@ -133,6 +152,13 @@ func ImageHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 		return rsp, err
 	}
 
+	// Without downloads a link only shows the sizes up to medium, which can
+	// only be enlarged by changing their signed urls.
+	if link, ok := sharing.Shared(r); ok && !link.AllowDownload {
+		img.Source.Raw = ""
+		img.Source.Large = ""
+	}
+
 	stats.AddStat(store.DB, ref.Id, "view")
 
 	return handler.Response{
@@ -172,7 +198,7 @@ func TagHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (h
 	}
 
 	tag := model.Ref{Collection: model.Tags, Id: tid, Shortcode: id}
-	images, err := TaggedImages(store, tid, limit)
+	images, err := TaggedImages(store, Viewer(r), tid, limit)
 	if err != nil {
 		return rsp, err
 	}
@@ -206,7 +232,7 @@ func RecentImageHandler(store *handler.State, w http.ResponseWriter, r *http.Req
 		limit = 500
 	}
 
-	images, err := RecentImages(store, limit)
+	images, err := RecentImages(store, Viewer(r), limit)
 	if err != nil {
 		return rsp, err
 	}
@@ -236,7 +262,7 @@ func FeaturedImageHandler(store *handler.State, w http.ResponseWriter, r *http.R
 	if limit == 0 {
 		limit = 500
 	}
-	images, err := FeaturedImages(store, limit)
+	images, err := FeaturedImages(store, Viewer(r), limit)
 	if err != nil {
 		return rsp, err
 	}
@@ -266,7 +292,7 @@ func TrendingImagesHander(store *handler.State, w http.ResponseWriter, r *http.R
 	if limit == 0 {
 		limit = 500
 	}
-	images, err := Trending(store, limit)
+	images, err := Trending(store, Viewer(r), limit)
 	if err != nil {
 		return rsp, err
 	}
@@ -1,6 +1,8 @@
 package retrieval
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"log"
 
 	"fmt"
@@ -15,8 +17,9 @@ import (
 	"github.com/lib/pq"
 )
 
-// GetUser returns the fields of a user row into a User struct, including image references.
-func GetUser(state *handler.State, u int64) (model.User, error) {
+// GetUser returns the fields of a user row into a User struct, including
+// references to the images and favorites the viewer can see.
+func GetUser(state *handler.State, viewer, u int64) (model.User, error) {
 	user := model.User{}
 	err := state.DB.Get(&user, "SELECT * FROM content.users WHERE id = $1", u)
 	if err != nil {
@@ -25,7 +28,10 @@ func GetUser(state *handler.State, u int64) (model.User, error) {
 	}
 
 	images := []string{}
-	err = state.DB.Select(&images, `SELECT shortcode FROM content.images WHERE user_id = $1`, u)
+	err = state.DB.Select(&images, `
+	SELECT images.shortcode
+	FROM content.images AS images
+	WHERE images.user_id = $1 AND permissions.viewable($2, images.id, 'image')`, u, viewer)
 	if err != nil {
 		log.Println(err)
 		return model.User{}, err
@@ -42,7 +48,7 @@ func GetUser(state *handler.State, u int64) (model.User, error) {
 	SELECT images.shortcode
 	FROM content.images AS images
 		JOIN content.user_favorites AS favs ON favs.image_id = images.id
-	WHERE favs.user_id = $1`, u)
+	WHERE favs.user_id = $1 AND permissions.viewable($2, images.id, 'image')`, u, viewer)
 	if err != nil {
 		log.Println(err)
 		return model.User{}, err
@@ -54,21 +60,41 @@ func GetUser(state *handler.State, u int64) (model.User, error) {
 	}
 
 	user.FavoriteLinks = &favoriteLinks
+
+	err = state.DB.QueryRowx(`
+	SELECT (SELECT count(*) FROM content.user_follows WHERE followed_id = $1),
+		(SELECT count(*) FROM content.user_follows WHERE user_id = $1)`, u).Scan(&user.Followers, &user.Following)
+	if err != nil {
+		log.Println(err)
+		return model.User{}, err
+	}
+
 	if user.AvatarID != nil {
-		user.Avatars = ImageSources(*user.AvatarID, "avatar")
+		user.Avatars = ImageSources(state, *user.AvatarID, "avatar")
 	} else {
-		user.Avatars = ImageSources(user.Username, "avatar")
+		user.Avatars = ImageSources(state, user.Username, "avatar")
 	}
 	user.Permalink = model.Ref{Collection: model.Users, Shortcode: user.Username}.ToURL(state.Port, state.Local)
 
 	return user, nil
 }
 
+// IsFollowing reports whether the viewer follows the user.
+func IsFollowing(db *sqlx.DB, viewer, u int64) (bool, error) {
+	var following bool
+	err := db.Get(&following, `
+	SELECT EXISTS(SELECT 1 FROM content.user_follows WHERE user_id = $1 AND followed_id = $2)`, viewer, u)
+	if err != nil {
+		log.Println(err)
+	}
+	return following, err
+}
+
 // GetUsers TODO rewrite this to make a single call to the database.
-func GetUsers(state *handler.State, userIds []int64) ([]model.User, error) {
+func GetUsers(state *handler.State, viewer int64, userIds []int64) ([]model.User, error) {
 	users := []model.User{}
 	for _, userId := range userIds {
-		usr, err := GetUser(state, userId)
+		usr, err := GetUser(state, viewer, userId)
 		if err != nil {
 			log.Println(err)
 			return []model.User{}, err
@@ -96,13 +122,16 @@ func GetImages(state *handler.State, imageIDS []int64) ([]model.Image, error) {
 func GetImage(state *handler.State, i int64) (model.Image, error) {
 	img := model.Image{}
 	q := `
-	SELECT id, shortcode, publish_time, last_modified, user_id, featured, title, description FROM content.images AS images
+	SELECT id, shortcode, publish_time, last_modified, user_id, featured, title, description, comments_disabled
+	FROM content.images AS images
 	WHERE images.id = %[1]d;
 
 	-- metadata
 
 	SELECT aperture, exposure_time, focal_length, iso, make, model,
-	lens_make, lens_model, pixel_yd, pixel_xd, capture_time, loc, dir, description
+	lens_make, lens_model, pixel_yd, pixel_xd, capture_time, creator, copyright,
+	exposure_compensation, exposure_program, exposure_mode, metering_mode, flash,
+	white_balance, focal_length_35mm, altitude, timezone_offset, software, loc, dir, description
 	FROM content.image_metadata AS meta
 	LEFT JOIN content.image_geo AS geo ON geo.image_id = meta.image_id
 	WHERE meta.image_id = %[1]d;
@@ -142,6 +171,10 @@ func GetImage(state *handler.State, i int64) (model.Image, error) {
 	SELECT COALESCE(sum(total),0) FROM content.image_stats
 	WHERE image_id = %[1]d AND stat_type = 'download';
 
+	SELECT count(*) FROM content.comments AS comments
+		INNER JOIN content.users AS users ON users.id = comments.user_id
+	WHERE comments.image_id = %[1]d AND comments.deleted_at IS NULL AND users.deleted_at IS NULL;
+
 
 	-- favorited by
 	SELECT username FROM content.users as users
@@ -158,7 +191,7 @@ func GetImage(state *handler.State, i int64) (model.Image, error) {
 
 	for rows.Next() {
 		err = rows.Scan(&img.Id, &img.Shortcode, &img.PublishTime, &img.LastModified, &img.UserId,
-			&img.Featured, &img.Title, &img.Description)
+			&img.Featured, &img.Title, &img.Description, &img.CommentsDisabled)
 		if err != nil {
 			switch err {
 			case sql.ErrNoRows:
@@ -212,12 +245,14 @@ func GetImage(state *handler.State, i int64) (model.Image, error) {
 		return model.Image{}, err
 	}
 
-	usr, err := GetUser(state, img.UserId)
+	// Images are shown without a viewer, so their owner only links to what
+	// anyone can see.
+	usr, err := GetUser(state, 0, img.UserId)
 	if err != nil {
 		return model.Image{}, err
 	}
 	img.User = &usr
-	img.Source = ImageSources(img.Shortcode, "content")
+	img.Source = ImageSources(state, img.Shortcode, "content")
 
 	img.Permalink = model.Ref{Collection: model.Images, Shortcode: img.Shortcode}.ToURL(state.Port, state.Local)
 	return img, nil
@@ -242,18 +277,38 @@ func imageLandmarks(rows *sqlx.Rows) ([]model.Landmark, error) {
 	return landmarks, nil
 }
 
-func ImageSources(shortcode, location string) model.ImageSource {
-	var prefix = "https://images.fok.al/" + location + "/"
-	var resourceBaseURL = prefix + shortcode
+// ImageSources returns the urls of an image's original and its sizes. With an
+// image token set they are signed, so the image host can refuse urls that
+// were changed, like a size with its width taken off.
+func ImageSources(state *handler.State, shortcode, location string) model.ImageSource {
+	path := "/" + location + "/" + shortcode
 	return model.ImageSource{
-		Raw:    resourceBaseURL,
-		Large:  resourceBaseURL + "?ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy",
-		Medium: resourceBaseURL + "?ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy&w=1080&fit=max",
-		Small:  resourceBaseURL + "?ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy&w=400&fit=max",
-		Thumb:  resourceBaseURL + "?ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy&w=200&fit=max",
+		Raw:    imageURL(state.ImageToken, path, ""),
+		Large:  imageURL(state.ImageToken, path, "ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy"),
+		Medium: imageURL(state.ImageToken, path, "ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy&w=1080&fit=max"),
+		Small:  imageURL(state.ImageToken, path, "ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy&w=400&fit=max"),
+		Thumb:  imageURL(state.ImageToken, path, "ixlib=rb-0.3.5&q=80&fm=jpg&crop=entropy&w=200&fit=max"),
 	}
 }
 
+// imageURL returns the url of the path on the image host. With a token it is
+// signed as an imgix secure url, with an md5 of the token, path and query.
+func imageURL(token []byte, path, query string) string {
+	if query != "" {
+		query = "?" + query
+	}
+	url := "https://images.fok.al" + path + query
+	if len(token) == 0 {
+		return url
+	}
+
+	sum := md5.Sum([]byte(string(token) + path + query))
+	if query == "" {
+		return url + "?s=" + hex.EncodeToString(sum[:])
+	}
+	return url + "&s=" + hex.EncodeToString(sum[:])
+}
+
 func imageLabels(rows *sqlx.Rows) ([]model.Label, error) {
 	labels := []model.Label{}
 	var err error
@@ -343,6 +398,16 @@ func imageStats(rows *sqlx.Rows) (model.ImageStats, error) {
 			return stat, err
 		}
 	}
+	if !rows.NextResultSet() {
+		return stat, rows.Err()
+	}
+
+	for rows.Next() {
+		err = rows.Scan(&stat.Comments)
+		if err != nil {
+			return stat, err
+		}
+	}
 	return stat, nil
 }
 
@@ -377,8 +442,10 @@ func imageMetadata(rows *sqlx.Rows) (model.ImageMetadata, error) {
 
 	for rows.Next() {
 		err := rows.Scan(&meta.Aperture, &meta.ExposureTime, &meta.FocalLength, &meta.ISO, &meta.Make, &meta.Model,
-			&meta.LensMake, &meta.LensModel, &meta.PixelYDimension, &meta.PixelXDimension, &meta.CaptureTime, &loc.Point, &loc.ImageDirection,
-			&loc.Description)
+			&meta.LensMake, &meta.LensModel, &meta.PixelYDimension, &meta.PixelXDimension, &meta.CaptureTime, &meta.Creator, &meta.Copyright,
+			&meta.ExposureCompensation, &meta.ExposureProgram, &meta.ExposureMode, &meta.MeteringMode, &meta.Flash,
+			&meta.WhiteBalance, &meta.FocalLength35mm, &meta.Altitude, &meta.TimezoneOffset, &meta.Software,
+			&loc.Point, &loc.ImageDirection, &loc.Description)
 		if err != nil {
 			return meta, err
 		}
@@ -390,15 +457,14 @@ func imageMetadata(rows *sqlx.Rows) (model.ImageMetadata, error) {
 	return meta, nil
 }
 
-func GetUserFavorites(state *handler.State, userId int64) ([]model.Image, error) {
+func GetUserFavorites(state *handler.State, viewer, userId int64) ([]model.Image, error) {
 	images := []int64{}
 
 	err := state.DB.Select(&images, `
 			SELECT favs.image_id
 			FROM content.user_favorites AS favs
-			INNER JOIN permissions.can_view AS view ON view.o_id = favs.image_id
-				WHERE view.user_id = -1 AND favs.user_id = $1
-			ORDER BY favs.created_at DESC`, userId)
+				WHERE favs.user_id = $1 AND permissions.viewable($2, favs.image_id, 'image')
+			ORDER BY favs.created_at DESC`, userId, viewer)
 
 	if err != nil {
 		log.Println(err)
@@ -408,15 +474,14 @@ func GetUserFavorites(state *handler.State, userId int64) ([]model.Image, error)
 	return GetImages(state, images)
 }
 
-func GetUserImages(state *handler.State, userId int64) ([]model.Image, error) {
+func GetUserImages(state *handler.State, viewer, userId int64) ([]model.Image, error) {
 	images := []int64{}
 
 	err := state.DB.Select(&images, `
 			SELECT images.id
 			FROM content.images AS images
-			INNER JOIN permissions.can_view AS view ON view.o_id = images.id
-				WHERE view.user_id = -1 AND images.user_id = $1
-			ORDER BY images.publish_time DESC`, userId)
+				WHERE images.user_id = $1 AND permissions.viewable($2, images.id, 'image')
+			ORDER BY images.publish_time DESC`, userId, viewer)
 
 	if err != nil {
 		log.Println(err)
@@ -428,7 +493,7 @@ func GetUserImages(state *handler.State, userId int64) ([]model.Image, error) {
 
 func GetImageRef(db *sqlx.DB, i string) (model.Ref, error) {
 	ref := model.Ref{Collection: model.Images, Shortcode: i}
-	err := db.Get(&ref.Id, "SELECT id FROM content.images WHERE shortcode = $1", i)
+	err := db.Get(&ref.Id, "SELECT id FROM content.images WHERE shortcode = $1 AND deleted_at IS NULL", i)
 	if err != nil {
 		log.Printf("Error Retrieving: %v %v\n", ref, err)
 		return model.Ref{}, err
@@ -438,7 +503,7 @@ func GetImageRef(db *sqlx.DB, i string) (model.Ref, error) {
 
 func GetUserRef(db *sqlx.DB, u string) (model.Ref, error) {
 	ref := model.Ref{Collection: model.Users, Shortcode: u}
-	err := db.Get(&ref.Id, "SELECT id FROM content.users WHERE username = $1", u)
+	err := db.Get(&ref.Id, "SELECT id FROM content.users WHERE username = $1 AND deleted_at IS NULL", u)
 	if err != nil {
 		log.Printf("Error Retrieving: %v %v %v\n", u, ref, err)
 		if err == sql.ErrNoRows {
@@ -449,6 +514,19 @@ func GetUserRef(db *sqlx.DB, u string) (model.Ref, error) {
 	return ref, nil
 }
 
+func GetCollectionRef(db *sqlx.DB, c string) (model.Ref, error) {
+	ref := model.Ref{Collection: model.Collections, Shortcode: c}
+	err := db.Get(&ref.Id, "SELECT id FROM content.collections WHERE shortcode = $1", c)
+	if err != nil {
+		log.Printf("Error Retrieving: %v %v\n", ref, err)
+		if err == sql.ErrNoRows {
+			return model.Ref{}, handler.StatusError{Code: 404, Err: errors.New("No collection found")}
+		}
+		return model.Ref{}, err
+	}
+	return ref, nil
+}
+
 func GetTagRef(db *sqlx.DB, tid int64) (model.Ref, error) {
 	var desc string
 	err := db.Get(&desc, "SELECT description FROM content.image_tags WHERE id = $1", tid)
@@ -468,7 +546,7 @@ func GetUserRefByEmail(db *sqlx.DB, email string) (model.Ref, error) {
 	return ref, nil
 }
 
-func TaggedImages(state *handler.State, tID int64, limit int) (model.Tag, error) {
+func TaggedImages(state *handler.State, viewer, tID int64, limit int) (model.Tag, error) {
 	ids := []int64{}
 	tag := model.Tag{}
 
@@ -477,10 +555,10 @@ func TaggedImages(state *handler.State, tID int64, limit int) (model.Tag, error)
 		FROM content.image_tag_bridge AS bridge
 		  JOIN content.image_tags AS tags ON bridge.tag_id = tags.id
 		  JOIN content.images AS images ON bridge.image_id = images.id
-		WHERE tags.id = $1
+		WHERE tags.id = $1 AND permissions.viewable($3, images.id, 'image')
 		ORDER BY ranking(1, views + favorites, featured :: INT + 3) DESC
 		LIMIT $2;
-	`, tID, limit)
+	`, tID, limit, viewer)
 	if err != nil {
 		log.Println(err)
 		return model.Tag{}, err
@@ -497,7 +575,9 @@ func TaggedImages(state *handler.State, tID int64, limit int) (model.Tag, error)
 		return tag, err
 	}
 
-	err = state.DB.Get(&tag.Count, "SELECT count(*) FROM content.image_tag_bridge WHERE tag_id = $1", tID)
+	err = state.DB.Get(&tag.Count, `
+		SELECT count(*) FROM content.image_tag_bridge
+		WHERE tag_id = $1 AND permissions.viewable($2, image_id, 'image')`, tID, viewer)
 	if err != nil {
 		log.Println(err)
 		return tag, err
@@ -506,14 +586,15 @@ func TaggedImages(state *handler.State, tID int64, limit int) (model.Tag, error)
 	return tag, nil
 }
 
-func Trending(state *handler.State, limit int) ([]model.Image, error) {
+func Trending(state *handler.State, viewer int64, limit int) ([]model.Image, error) {
 	ids := []int64{}
 
 	err := state.DB.Select(&ids, `
 	SELECT id FROM content.images
+	WHERE permissions.viewable($2, id, 'image')
 	ORDER BY ranking(publish_time, views + favorites , featured::int + 3) DESC
 	LIMIT $1
-	`, limit)
+	`, limit, viewer)
 	if err != nil {
 		if err, ok := err.(*pq.Error); ok {
 			log.Printf("%+v", err)
@@ -524,15 +605,14 @@ func Trending(state *handler.State, limit int) ([]model.Image, error) {
 
 }
 
-func FeaturedImages(state *handler.State, limit int) ([]model.Image, error) {
+func FeaturedImages(state *handler.State, viewer int64, limit int) ([]model.Image, error) {
 	imgs := []int64{}
 	var stmt *sqlx.Stmt
 	var err error
 	stmt, err = state.DB.Preparex(`
 		SELECT images.id
 		FROM content.images AS images
-		INNER JOIN permissions.can_view AS view ON view.o_id = images.id
-		WHERE view.user_id = -1 AND images.featured = TRUE
+		WHERE images.featured = TRUE AND permissions.viewable($2, images.id, 'image')
 		ORDER BY publish_time DESC
 		LIMIT $1
 		`)
@@ -544,7 +624,7 @@ func FeaturedImages(state *handler.State, limit int) ([]model.Image, error) {
 		return []model.Image{}, err
 	}
 	err = stmt.Select(&imgs,
-		limit)
+		limit, viewer)
 	if err != nil {
 		if err, ok := err.(*pq.Error); ok {
 			log.Printf("%+v", err)
@@ -554,16 +634,15 @@ func FeaturedImages(state *handler.State, limit int) ([]model.Image, error) {
 	return GetImages(state, imgs)
 }
 
-func RecentImages(state *handler.State, limit int) ([]model.Image, error) {
+func RecentImages(state *handler.State, viewer int64, limit int) ([]model.Image, error) {
 	imageIds := []int64{}
 	err := state.DB.Select(&imageIds, `
 		SELECT images.id
 		FROM content.images AS images
-		INNER JOIN permissions.can_view AS view ON view.o_id = images.id
-		WHERE view.user_id = -1
+		WHERE permissions.viewable($2, images.id, 'image')
 		ORDER BY publish_time DESC
 		LIMIT $1
-		`, limit)
+		`, limit, viewer)
 
 	if err != nil {
 		if err, ok := err.(*pq.Error); ok {
@@ -0,0 +1,19 @@
+package retrieval
+
+import "testing"
+
+func TestImageURL(t *testing.T) {
+	cases := []struct {
+		token, query, want string
+	}{
+		{"", "w=200", "https://images.fok.al/content/abc?w=200"},
+		{"", "", "https://images.fok.al/content/abc"},
+		{"secret", "w=200", "https://images.fok.al/content/abc?w=200&s=eea5b24384cad5aeb554cf85a4c0d9a0"},
+		{"secret", "", "https://images.fok.al/content/abc?s=452b8f3337c59421a6ff5c880eca8562"},
+	}
+	for _, c := range cases {
+		if got := imageURL([]byte(c.token), "/content/abc", c.query); got != c.want {
+			t.Errorf("imageURL(%q, %q) = %q, want %q", c.token, c.query, got, c.want)
+		}
+	}
+}
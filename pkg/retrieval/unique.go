@@ -19,6 +19,10 @@ func GenerateSC(db *sqlx.DB, collection model.ReferenceType) (string, error) {
 	switch collection {
 	case model.Images:
 		f = ExistsImage
+	case model.Groups:
+		f = ExistsGroup
+	case model.Collections:
+		f = ExistsCollection
 	default:
 		return "", errors.New("Invalid Collection Type.")
 	}
@@ -0,0 +1,48 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/admin"
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/fokal/fokal-core/pkg/trash"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterAdminRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	put := api.Methods("PUT").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	capable := func(s scopes.Scope, c roles.Capability) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler,
+			roles.Middleware{State: state, C: c, M: roles.CapabilityMiddle}.Handler)
+	}
+
+	put.Handle("/admin/users/{ID}/role", capable(scopes.Edit, roles.ManageRoles).Then(handler.Handler{State: state, H: admin.RoleHandler}))
+	opts.Handle("/admin/users/{ID}/role", chain.Then(handler.Options("PUT")))
+
+	put.Handle("/admin/users/{ID}/featured", capable(scopes.Edit, roles.FeatureContent).Then(handler.Handler{State: state, H: admin.FeatureHandler(true)}))
+	del.Handle("/admin/users/{ID}/featured", capable(scopes.Edit, roles.FeatureContent).Then(handler.Handler{State: state, H: admin.FeatureHandler(false)}))
+	opts.Handle("/admin/users/{ID}/featured", chain.Then(handler.Options("PUT", "DELETE")))
+
+	put.Handle("/admin/users/{ID}/suspension", capable(scopes.Edit, roles.SuspendUsers).Then(handler.Handler{State: state, H: admin.SuspendHandler}))
+	del.Handle("/admin/users/{ID}/suspension", capable(scopes.Edit, roles.SuspendUsers).Then(handler.Handler{State: state, H: admin.UnsuspendHandler}))
+	opts.Handle("/admin/users/{ID}/suspension", chain.Then(handler.Options("PUT", "DELETE")))
+
+	post.Handle("/admin/users/{ID}/restore", capable(scopes.Edit, roles.RestoreUsers).Then(handler.Handler{State: state, H: trash.RestoreUserHandler}))
+	opts.Handle("/admin/users/{ID}/restore", chain.Then(handler.Options("POST")))
+
+	get.Handle("/admin/audit", capable(scopes.Read, roles.ViewAudit).Then(handler.Handler{State: state, H: audit.QueryHandler}))
+	opts.Handle("/admin/audit", chain.Then(handler.Options("GET")))
+
+	get.Handle("/admin/stats", capable(scopes.Read, roles.ViewStats).Then(handler.Handler{State: state, H: admin.StatsHandler}))
+	opts.Handle("/admin/stats", chain.Then(handler.Options("GET")))
+}
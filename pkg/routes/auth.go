@@ -3,6 +3,7 @@ package routes
 import (
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/apikeys"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 )
@@ -17,4 +18,96 @@ func RegisterAuthRoutes(state *handler.State, api *mux.Router, chain alice.Chain
 	get.Handle("/auth/refresh", chain.Then(handler.Handler{State: state, H: security.RefreshHandler}))
 	opts.Handle("/auth/refresh", chain.Then(handler.Options("GET")))
 
+	post := api.Methods("POST").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+
+	post.Handle("/auth/oidc", chain.Then(handler.Handler{State: state, H: security.LoginHandler}))
+	opts.Handle("/auth/oidc", chain.Then(handler.Options("POST")))
+
+	post.Handle("/auth/challenge", chain.Then(handler.Handler{State: state, H: security.ChallengeHandler}))
+	opts.Handle("/auth/challenge", chain.Then(handler.Options("POST")))
+
+	// API Keys
+
+	get.Handle("/auth/keys", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: apikeys.ListHandler}))
+	post.Handle("/auth/keys", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: apikeys.CreateHandler}))
+	opts.Handle("/auth/keys", chain.Then(handler.Options("GET", "POST")))
+
+	del.Handle("/auth/keys/{ID}", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: apikeys.RevokeHandler}))
+	opts.Handle("/auth/keys/{ID}", chain.Then(handler.Options("DELETE")))
+
+	// Sessions
+	post.Handle("/auth/logout", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.LogoutHandler}))
+	opts.Handle("/auth/logout", chain.Then(handler.Options("POST")))
+
+	get.Handle("/auth/sessions", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.SessionsHandler}))
+	del.Handle("/auth/sessions", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.RevokeSessionsHandler}))
+	opts.Handle("/auth/sessions", chain.Then(handler.Options("GET", "DELETE")))
+
+	del.Handle("/auth/sessions/{ID}", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.RevokeSessionHandler}))
+	opts.Handle("/auth/sessions/{ID}", chain.Then(handler.Options("DELETE")))
+
+	put := api.Methods("PUT").Subrouter()
+	put.Handle("/users/me/password", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.PasswordHandler}))
+	opts.Handle("/users/me/password", chain.Then(handler.Options("PUT")))
+
+	// Identities
+	get.Handle("/users/me/identities", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.IdentitiesHandler}))
+	post.Handle("/users/me/identities", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.LinkIdentityHandler}))
+	opts.Handle("/users/me/identities", chain.Then(handler.Options("GET", "POST")))
+
+	del.Handle("/users/me/identities/{provider}", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.UnlinkIdentityHandler}))
+	opts.Handle("/users/me/identities/{provider}", chain.Then(handler.Options("DELETE")))
+
+	// Two-factor authentication
+	post.Handle("/users/me/totp", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.EnrollTOTPHandler}))
+	del.Handle("/users/me/totp", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.DisableTOTPHandler}))
+	opts.Handle("/users/me/totp", chain.Then(handler.Options("POST", "DELETE")))
+
+	post.Handle("/users/me/totp/verify", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.VerifyTOTPHandler}))
+	opts.Handle("/users/me/totp/verify", chain.Then(handler.Options("POST")))
+
+	post.Handle("/users/me/totp/recovery-codes", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler).
+		Then(handler.Handler{State: state, H: security.RecoveryCodesHandler}))
+	opts.Handle("/users/me/totp/recovery-codes", chain.Then(handler.Options("POST")))
+
+}
+
+// RegisterWellKnownRoutes registers the unversioned discovery routes at the
+// root of the router.
+func RegisterWellKnownRoutes(state *handler.State, router *mux.Router, chain alice.Chain) {
+	get := router.Methods("GET").Subrouter()
+	opts := router.Methods("OPTIONS").Subrouter()
+
+	get.Handle("/.well-known/jwks.json", chain.Then(handler.Handler{State: state, H: security.JWKSHandler}))
+	opts.Handle("/.well-known/jwks.json", chain.Then(handler.Options("GET")))
 }
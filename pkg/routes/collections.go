@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/collections"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterCollectionRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	put := api.Methods("PUT").Subrouter()
+	patch := api.Methods("PATCH").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	auth := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler)
+	}
+	view := chain.Append(
+		handler.Middleware{State: state, M: security.SetAuthenticatedUser}.Handler,
+		permissions.Middleware{State: state,
+			T:          permissions.CanView,
+			TargetType: model.Collections,
+			M:          permissions.PermissionMiddle}.Handler)
+	collection := func(p permissions.Permission, s scopes.Scope) alice.Chain {
+		return auth(s).Append(
+			permissions.Middleware{State: state,
+				T:          p,
+				TargetType: model.Collections,
+				M:          permissions.PermissionMiddle}.Handler)
+	}
+
+	post.Handle("/collections", auth(scopes.Edit).Then(handler.Handler{State: state, H: collections.CreateHandler}))
+	opts.Handle("/collections", chain.Then(handler.Options("POST")))
+
+	get.Handle("/collections/{ID:[a-zA-Z]{12}}", view.Then(handler.Handler{State: state, H: collections.CollectionHandler}))
+	patch.Handle("/collections/{ID:[a-zA-Z]{12}}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: collections.PatchHandler}))
+	del.Handle("/collections/{ID:[a-zA-Z]{12}}",
+		collection(permissions.CanDelete, scopes.Delete).Then(handler.Handler{State: state, H: collections.DeleteHandler}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}", chain.Then(handler.Options("GET", "PATCH", "DELETE")))
+
+	get.Handle("/collections/{ID:[a-zA-Z]{12}}/images", view.Then(handler.Handler{State: state, H: collections.ImagesHandler}))
+	put.Handle("/collections/{ID:[a-zA-Z]{12}}/images",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: collections.OrderHandler}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}/images", chain.Then(handler.Options("GET", "PUT")))
+
+	put.Handle("/collections/{ID:[a-zA-Z]{12}}/images/{image:[a-zA-Z]{12}}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: collections.AddImageHandler}))
+	del.Handle("/collections/{ID:[a-zA-Z]{12}}/images/{image:[a-zA-Z]{12}}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: collections.RemoveImageHandler}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}/images/{image:[a-zA-Z]{12}}", chain.Then(handler.Options("PUT", "DELETE")))
+
+	get.Handle("/users/me/collections", auth(scopes.Read).Then(handler.Handler{State: state, H: collections.LoggedInCollectionsHandler}))
+	opts.Handle("/users/me/collections", chain.Then(handler.Options("GET")))
+
+	get.Handle("/users/{ID}/collections", chain.Append(
+		handler.Middleware{State: state, M: security.SetAuthenticatedUser}.Handler,
+		permissions.Middleware{State: state,
+			T:          permissions.CanView,
+			TargetType: model.Users,
+			M:          permissions.PermissionMiddle}.Handler).
+		Then(handler.Handler{State: state, H: collections.UserCollectionsHandler}))
+	opts.Handle("/users/{ID}/collections", chain.Then(handler.Options("GET")))
+
+	// Visibility and collaborators
+	put.Handle("/collections/{ID:[a-zA-Z]{12}}/public",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: permissions.SetPublicHandler(model.Collections, true)}))
+	del.Handle("/collections/{ID:[a-zA-Z]{12}}/public",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: permissions.SetPublicHandler(model.Collections, false)}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}/public", chain.Then(handler.Options("PUT", "DELETE")))
+
+	get.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions",
+		collection(permissions.CanEdit, scopes.Read).Then(handler.Handler{State: state, H: permissions.ListHandler(model.Collections)}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions", chain.Then(handler.Options("GET")))
+
+	put.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions/{permission}/{user}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: permissions.GrantHandler(model.Collections)}))
+	del.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions/{permission}/{user}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: permissions.RevokeHandler(model.Collections)}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions/{permission}/{user}", chain.Then(handler.Options("PUT", "DELETE")))
+
+	put.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions/{permission}/groups/{group}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: permissions.GrantGroupHandler(model.Collections)}))
+	del.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions/{permission}/groups/{group}",
+		collection(permissions.CanEdit, scopes.Edit).Then(handler.Handler{State: state, H: permissions.RevokeGroupHandler(model.Collections)}))
+	opts.Handle("/collections/{ID:[a-zA-Z]{12}}/permissions/{permission}/groups/{group}", chain.Then(handler.Options("PUT", "DELETE")))
+}
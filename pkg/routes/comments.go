@@ -0,0 +1,59 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/comments"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterCommentRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	put := api.Methods("PUT").Subrouter()
+	patch := api.Methods("PATCH").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	canView := permissions.Middleware{State: state,
+		T:          permissions.CanView,
+		TargetType: model.Images,
+		M:          permissions.PermissionMiddle}.Handler
+	comment := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler,
+			ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler,
+			canView)
+	}
+	edit := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Edit, M: scopes.ScopeMiddle}.Handler,
+		permissions.Middleware{State: state,
+			T:          permissions.CanEdit,
+			TargetType: model.Images,
+			M:          permissions.PermissionMiddle}.Handler)
+
+	get.Handle("/images/{ID:[a-zA-Z]{12}}/comments", chain.Append(
+		handler.Middleware{State: state, M: security.SetAuthenticatedUser}.Handler,
+		canView).
+		Then(handler.Handler{State: state, H: comments.ListHandler}))
+	post.Handle("/images/{ID:[a-zA-Z]{12}}/comments",
+		comment(scopes.Social).Then(handler.Handler{State: state, H: comments.CreateHandler}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/comments", chain.Then(handler.Options("GET", "POST")))
+
+	put.Handle("/images/{ID:[a-zA-Z]{12}}/comments/disabled", edit.Then(handler.Handler{State: state, H: comments.DisableHandler(true)}))
+	del.Handle("/images/{ID:[a-zA-Z]{12}}/comments/disabled", edit.Then(handler.Handler{State: state, H: comments.DisableHandler(false)}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/comments/disabled", chain.Then(handler.Options("PUT", "DELETE")))
+
+	patch.Handle("/images/{ID:[a-zA-Z]{12}}/comments/{comment:[0-9]+}",
+		comment(scopes.Social).Then(handler.Handler{State: state, H: comments.EditHandler}))
+	del.Handle("/images/{ID:[a-zA-Z]{12}}/comments/{comment:[0-9]+}",
+		comment(scopes.Social).Then(handler.Handler{State: state, H: comments.DeleteHandler}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/comments/{comment:[0-9]+}", chain.Then(handler.Options("PATCH", "DELETE")))
+}
@@ -3,7 +3,9 @@ package routes
 import (
 	"github.com/fokal/fokal-core/pkg/create"
 	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
 	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 )
@@ -14,7 +16,10 @@ func RegisterCreateRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 
 	post.Handle("/images", chain.Append(handler.Middleware{
 		State: state,
-		M:     security.Authenticate}.Handler).Then(handler.Handler{State: state, H: create.ImageHandler}))
+		M:     security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Upload, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Upload}.Handler).
+		Then(handler.Handler{State: state, H: create.ImageHandler}))
 	opts.Handle("/images", chain.Then(handler.Options("POST")))
 
 	post.Handle("/users", chain.Then(handler.Handler{
@@ -29,7 +34,9 @@ func RegisterCreateRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 		handler.Middleware{
 			State: state,
 			M:     security.Authenticate,
-		}.Handler).Then(handler.Handler{
+		}.Handler,
+		scopes.Middleware{State: state, S: scopes.Upload, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Upload}.Handler).Then(handler.Handler{
 		State: state,
 		H:     create.AvatarHandler,
 	}))
@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterEventRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	get.Handle("/events", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Default}.Handler).
+		Then(state.Events))
+	opts.Handle("/events", chain.Then(handler.Options("GET")))
+}
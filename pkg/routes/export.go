@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/export"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterExportRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	read := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler)
+
+	post.Handle("/users/me/export", read.Then(handler.Handler{State: state, H: export.CreateHandler}))
+	opts.Handle("/users/me/export", chain.Then(handler.Options("POST")))
+
+	get.Handle("/users/me/exports", read.Then(handler.Handler{State: state, H: export.ListHandler}))
+	opts.Handle("/users/me/exports", chain.Then(handler.Options("GET")))
+
+	get.Handle("/users/me/exports/{export:[0-9]+}", read.Then(handler.Handler{State: state, H: export.GetHandler}))
+	opts.Handle("/users/me/exports/{export:[0-9]+}", chain.Then(handler.Options("GET")))
+}
@@ -0,0 +1,39 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/groups"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterGroupRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	put := api.Methods("PUT").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	auth := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler)
+	}
+
+	get.Handle("/groups", auth(scopes.Read).Then(handler.Handler{State: state, H: groups.ListHandler}))
+	post.Handle("/groups", auth(scopes.Edit).Then(handler.Handler{State: state, H: groups.CreateHandler}))
+	opts.Handle("/groups", chain.Then(handler.Options("GET", "POST")))
+
+	get.Handle("/groups/{ID:[a-zA-Z]{12}}", auth(scopes.Read).Then(handler.Handler{State: state, H: groups.GroupHandler}))
+	del.Handle("/groups/{ID:[a-zA-Z]{12}}", auth(scopes.Delete).Then(handler.Handler{State: state, H: groups.DeleteHandler}))
+	opts.Handle("/groups/{ID:[a-zA-Z]{12}}", chain.Then(handler.Options("GET", "DELETE")))
+
+	get.Handle("/groups/{ID:[a-zA-Z]{12}}/images", auth(scopes.Read).Then(handler.Handler{State: state, H: groups.ImagesHandler}))
+	opts.Handle("/groups/{ID:[a-zA-Z]{12}}/images", chain.Then(handler.Options("GET")))
+
+	put.Handle("/groups/{ID:[a-zA-Z]{12}}/members/{user}", auth(scopes.Edit).Then(handler.Handler{State: state, H: groups.SetMemberHandler}))
+	del.Handle("/groups/{ID:[a-zA-Z]{12}}/members/{user}", auth(scopes.Edit).Then(handler.Handler{State: state, H: groups.RemoveMemberHandler}))
+	opts.Handle("/groups/{ID:[a-zA-Z]{12}}/members/{user}", chain.Then(handler.Options("PUT", "DELETE")))
+}
@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/importer"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+// RegisterImportRoutes adds the import routes. Archives are uploaded through
+// uploads, which has no timeout.
+func RegisterImportRoutes(state *handler.State, api *mux.Router, chain, uploads alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	read := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler)
+
+	post.Handle("/users/me/imports", uploads.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Upload, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Upload}.Handler).
+		Then(handler.Handler{State: state, H: importer.CreateHandler}))
+	get.Handle("/users/me/imports", read.Then(handler.Handler{State: state, H: importer.ListHandler}))
+	opts.Handle("/users/me/imports", chain.Then(handler.Options("GET", "POST")))
+
+	get.Handle("/users/me/imports/{import:[0-9]+}", read.Then(handler.Handler{State: state, H: importer.GetHandler}))
+	opts.Handle("/users/me/imports/{import:[0-9]+}", chain.Then(handler.Options("GET")))
+}
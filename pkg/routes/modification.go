@@ -6,6 +6,8 @@ import (
 	"github.com/fokal/fokal-core/pkg/modification"
 	"github.com/fokal/fokal-core/pkg/security"
 	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 )
@@ -20,18 +22,14 @@ func RegisterModificationRoutes(state *handler.State, api *mux.Router, chain ali
 	put.Handle("/images/{ID:[a-zA-Z]{12}}/featured",
 		chain.Append(
 			handler.Middleware{State: state, M: security.Authenticate}.Handler,
-			permissions.Middleware{State: state,
-				T:          permissions.CanEdit,
-				TargetType: model.Images,
-				M:          permissions.PermissionMiddle}.Handler).
+			scopes.Middleware{State: state, S: scopes.Edit, M: scopes.ScopeMiddle}.Handler,
+			roles.Middleware{State: state, C: roles.FeatureContent, M: roles.CapabilityMiddle}.Handler).
 			Then(handler.Handler{State: state, H: modification.FeatureImage}))
 	del.Handle("/images/{ID:[a-zA-Z]{12}}/featured",
 		chain.Append(
 			handler.Middleware{State: state, M: security.Authenticate}.Handler,
-			permissions.Middleware{State: state,
-				T:          permissions.CanEdit,
-				TargetType: model.Images,
-				M:          permissions.PermissionMiddle}.Handler).
+			scopes.Middleware{State: state, S: scopes.Edit, M: scopes.ScopeMiddle}.Handler,
+			roles.Middleware{State: state, C: roles.FeatureContent, M: roles.CapabilityMiddle}.Handler).
 			Then(handler.Handler{State: state, H: modification.UnFeatureImage}))
 
 	opts.Handle("/images/{ID:[a-zA-Z]{12}}/featured", chain.Then(handler.Options("DELETE", "PUT")))
@@ -39,6 +37,7 @@ func RegisterModificationRoutes(state *handler.State, api *mux.Router, chain ali
 	del.Handle("/images/{ID:[a-zA-Z]{12}}",
 		chain.Append(
 			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Delete, M: scopes.ScopeMiddle}.Handler,
 			permissions.Middleware{State: state,
 				T:          permissions.CanDelete,
 				TargetType: model.Images,
@@ -48,6 +47,7 @@ func RegisterModificationRoutes(state *handler.State, api *mux.Router, chain ali
 	patch.Handle("/images/{ID:[a-zA-Z]{12}}",
 		chain.Append(
 			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Edit, M: scopes.ScopeMiddle}.Handler,
 			permissions.Middleware{State: state,
 				T:          permissions.CanEdit,
 				TargetType: model.Images,
@@ -55,7 +55,12 @@ func RegisterModificationRoutes(state *handler.State, api *mux.Router, chain ali
 			Then(handler.Handler{State: state, H: modification.PatchImage}))
 
 	put.Handle("/images/{ID:[a-zA-Z]{12}}/download",
-		chain.
+		chain.Append(
+			handler.Middleware{State: state, M: security.SetAuthenticatedUser}.Handler,
+			permissions.Middleware{State: state,
+				T:          permissions.CanView,
+				TargetType: model.Images,
+				M:          permissions.PermissionMiddle}.Handler).
 			Then(handler.Handler{State: state, H: modification.DownloadHandler}))
 
 	opts.Handle("/images/{ID:[a-zA-Z]{12}}", chain.Then(handler.Options("PATCH", "DELETE")))
@@ -64,11 +69,13 @@ func RegisterModificationRoutes(state *handler.State, api *mux.Router, chain ali
 	// User Routes
 	del.Handle("/users/me",
 		chain.Append(
-			handler.Middleware{State: state, M: security.Authenticate}.Handler).
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Delete, M: scopes.ScopeMiddle}.Handler).
 			Then(handler.Handler{State: state, H: modification.DeleteUser}))
 	patch.Handle("/users/me",
 		chain.Append(
-			handler.Middleware{State: state, M: security.Authenticate}.Handler).
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Edit, M: scopes.ScopeMiddle}.Handler).
 			Then(handler.Handler{State: state, H: modification.PatchUser}))
 	opts.Handle("/users/me", chain.Then(handler.Options("PATCH", "DELETE")))
 
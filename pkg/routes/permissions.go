@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/fokal/fokal-core/pkg/sharing"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterPermissionRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	put := api.Methods("PUT").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	image := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler,
+			permissions.Middleware{State: state,
+				T:          permissions.CanEdit,
+				TargetType: model.Images,
+				M:          permissions.PermissionMiddle}.Handler)
+	}
+	user := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler)
+	}
+
+	// Image Routes
+	put.Handle("/images/{ID:[a-zA-Z]{12}}/public",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: permissions.SetPublicHandler(model.Images, true)}))
+	del.Handle("/images/{ID:[a-zA-Z]{12}}/public",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: permissions.SetPublicHandler(model.Images, false)}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/public", chain.Then(handler.Options("PUT", "DELETE")))
+
+	get.Handle("/images/{ID:[a-zA-Z]{12}}/permissions",
+		image(scopes.Read).Then(handler.Handler{State: state, H: permissions.ListHandler(model.Images)}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/permissions", chain.Then(handler.Options("GET")))
+
+	put.Handle("/images/{ID:[a-zA-Z]{12}}/permissions/{permission}/{user}",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: permissions.GrantHandler(model.Images)}))
+	del.Handle("/images/{ID:[a-zA-Z]{12}}/permissions/{permission}/{user}",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: permissions.RevokeHandler(model.Images)}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/permissions/{permission}/{user}", chain.Then(handler.Options("PUT", "DELETE")))
+
+	put.Handle("/images/{ID:[a-zA-Z]{12}}/permissions/{permission}/groups/{group}",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: permissions.GrantGroupHandler(model.Images)}))
+	del.Handle("/images/{ID:[a-zA-Z]{12}}/permissions/{permission}/groups/{group}",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: permissions.RevokeGroupHandler(model.Images)}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/permissions/{permission}/groups/{group}", chain.Then(handler.Options("PUT", "DELETE")))
+
+	// Share Links
+	get.Handle("/images/{ID:[a-zA-Z]{12}}/share-links",
+		image(scopes.Read).Then(handler.Handler{State: state, H: sharing.ListHandler}))
+	post.Handle("/images/{ID:[a-zA-Z]{12}}/share-links",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: sharing.CreateHandler}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/share-links", chain.Then(handler.Options("GET", "POST")))
+
+	del.Handle("/images/{ID:[a-zA-Z]{12}}/share-links/{link:[0-9]+}",
+		image(scopes.Edit).Then(handler.Handler{State: state, H: sharing.RevokeHandler}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/share-links/{link:[0-9]+}", chain.Then(handler.Options("DELETE")))
+
+	get.Handle("/users/me/share-links",
+		user(scopes.Read).Then(handler.Handler{State: state, H: sharing.UserLinksHandler}))
+	opts.Handle("/users/me/share-links", chain.Then(handler.Options("GET")))
+
+	// User Routes
+	put.Handle("/users/me/public",
+		user(scopes.Edit).Then(handler.Handler{State: state, H: permissions.SetPublicHandler(model.Users, true)}))
+	del.Handle("/users/me/public",
+		user(scopes.Edit).Then(handler.Handler{State: state, H: permissions.SetPublicHandler(model.Users, false)}))
+	opts.Handle("/users/me/public", chain.Then(handler.Options("PUT", "DELETE")))
+
+	get.Handle("/users/me/permissions",
+		user(scopes.Read).Then(handler.Handler{State: state, H: permissions.ListHandler(model.Users)}))
+	opts.Handle("/users/me/permissions", chain.Then(handler.Options("GET")))
+
+	put.Handle("/users/me/permissions/{permission}/{user}",
+		user(scopes.Edit).Then(handler.Handler{State: state, H: permissions.GrantHandler(model.Users)}))
+	del.Handle("/users/me/permissions/{permission}/{user}",
+		user(scopes.Edit).Then(handler.Handler{State: state, H: permissions.RevokeHandler(model.Users)}))
+	opts.Handle("/users/me/permissions/{permission}/{user}", chain.Then(handler.Options("PUT", "DELETE")))
+
+	put.Handle("/users/me/permissions/{permission}/groups/{group}",
+		user(scopes.Edit).Then(handler.Handler{State: state, H: permissions.GrantGroupHandler(model.Users)}))
+	del.Handle("/users/me/permissions/{permission}/groups/{group}",
+		user(scopes.Edit).Then(handler.Handler{State: state, H: permissions.RevokeGroupHandler(model.Users)}))
+	opts.Handle("/users/me/permissions/{permission}/groups/{group}", chain.Then(handler.Options("PUT", "DELETE")))
+}
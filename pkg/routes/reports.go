@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
+	"github.com/fokal/fokal-core/pkg/reports"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterReportRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	patch := api.Methods("PATCH").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	report := func(t model.ReferenceType) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler,
+			ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler,
+			permissions.Middleware{State: state,
+				T:          permissions.CanView,
+				TargetType: t,
+				M:          permissions.PermissionMiddle}.Handler)
+	}
+	review := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler,
+			roles.Middleware{State: state, C: roles.ReviewReports, M: roles.CapabilityMiddle}.Handler)
+	}
+
+	post.Handle("/images/{ID:[a-zA-Z]{12}}/reports", report(model.Images).Then(handler.Handler{State: state, H: reports.CreateHandler(model.Images)}))
+	opts.Handle("/images/{ID:[a-zA-Z]{12}}/reports", chain.Then(handler.Options("POST")))
+
+	post.Handle("/users/{ID}/reports", report(model.Users).Then(handler.Handler{State: state, H: reports.CreateHandler(model.Users)}))
+	opts.Handle("/users/{ID}/reports", chain.Then(handler.Options("POST")))
+
+	get.Handle("/admin/reports", review(scopes.Read).Then(handler.Handler{State: state, H: reports.ListHandler}))
+	opts.Handle("/admin/reports", chain.Then(handler.Options("GET")))
+
+	get.Handle("/admin/reports/{report:[0-9]+}", review(scopes.Read).Then(handler.Handler{State: state, H: reports.GetHandler}))
+	patch.Handle("/admin/reports/{report:[0-9]+}", review(scopes.Edit).Then(handler.Handler{State: state, H: reports.TriageHandler}))
+	opts.Handle("/admin/reports/{report:[0-9]+}", chain.Then(handler.Options("GET", "PATCH")))
+
+	post.Handle("/admin/reports/{report:[0-9]+}/action", review(scopes.Edit).Then(handler.Handler{State: state, H: reports.ActionHandler}))
+	opts.Handle("/admin/reports/{report:[0-9]+}/action", chain.Then(handler.Options("POST")))
+
+	del.Handle("/admin/images/{ID:[a-zA-Z]{12}}/hidden", review(scopes.Edit).Then(handler.Handler{State: state, H: reports.UnhideHandler(model.Images)}))
+	opts.Handle("/admin/images/{ID:[a-zA-Z]{12}}/hidden", chain.Then(handler.Options("DELETE")))
+
+	del.Handle("/admin/users/{ID}/hidden", review(scopes.Edit).Then(handler.Handler{State: state, H: reports.UnhideHandler(model.Users)}))
+	opts.Handle("/admin/users/{ID}/hidden", chain.Then(handler.Options("DELETE")))
+}
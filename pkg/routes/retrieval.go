@@ -7,6 +7,7 @@ import (
 	"github.com/fokal/fokal-core/pkg/retrieval"
 	"github.com/fokal/fokal-core/pkg/security"
 	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 )
@@ -57,25 +58,44 @@ func RegisterRetrievalRoutes(state *handler.State, api *mux.Router, chain alice.
 		handler.Middleware{
 			State: state,
 			M:     security.Authenticate,
-		}.Handler).Then(handler.Handler{State: state, H: retrieval.LoggedInUserHandler}))
+		}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler).
+		Then(handler.Handler{State: state, H: retrieval.LoggedInUserHandler}))
 	opts.Handle("/users/me", chain.Then(handler.Options("GET")))
 
 	get.Handle("/users/me/images", chain.Append(
 		handler.Middleware{
 			State: state,
 			M:     security.Authenticate,
-		}.Handler).Then(handler.Handler{State: state, H: retrieval.LoggedInUserImagesHandler}))
+		}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler).
+		Then(handler.Handler{State: state, H: retrieval.LoggedInUserImagesHandler}))
 	opts.Handle("/users/me/images", chain.Then(handler.Options("GET")))
 
-	get.Handle("/users/{ID}", chain.Then(handler.Handler{State: state, H: retrieval.UserHandler}))
+	viewUser := []alice.Constructor{
+		handler.Middleware{
+			State: state,
+			M:     security.SetAuthenticatedUser,
+		}.Handler,
+		permissions.Middleware{State: state,
+			T:          permissions.CanView,
+			TargetType: model.Users,
+			M:          permissions.PermissionMiddle,
+		}.Handler}
+
+	get.Handle("/users/{ID}", chain.Append(viewUser...).Then(handler.Handler{State: state, H: retrieval.UserHandler}))
 	opts.Handle("/users/{ID}", chain.Then(handler.Options("GET")))
 
-	get.Handle("/users/{ID}/images", c.Then(handler.Handler{State: state, H: retrieval.UserImagesHandler}))
+	get.Handle("/users/{ID}/images", c.Append(viewUser...).Then(handler.Handler{State: state, H: retrieval.UserImagesHandler}))
 	opts.Handle("/users/{ID}/images", chain.Then(handler.Options("GET")))
 
-	get.Handle("/users/{ID}/favorites", c.Then(handler.Handler{State: state, H: retrieval.UserFavoritesHandler}))
+	get.Handle("/users/{ID}/favorites", c.Append(viewUser...).Then(handler.Handler{State: state, H: retrieval.UserFavoritesHandler}))
 	opts.Handle("/users/{ID}/favorites", chain.Then(handler.Options("GET")))
 
-	get.Handle("/tags/{ID}", c.Then(handler.Handler{State: state, H: retrieval.TagHandler}))
+	get.Handle("/tags/{ID}", c.Append(
+		handler.Middleware{
+			State: state,
+			M:     security.SetAuthenticatedUser,
+		}.Handler).Then(handler.Handler{State: state, H: retrieval.TagHandler}))
 	opts.Handle("/tags/{ID}", chain.Then(handler.Options("GET")))
 }
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
 	"github.com/fokal/fokal-core/pkg/search"
 	"github.com/fokal/fokal-core/pkg/security"
 	"github.com/gorilla/mux"
@@ -17,7 +18,8 @@ func RegisterSearchRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 			handler.Middleware{
 				State: state,
 				M:     security.SetAuthenticatedUser,
-			}.Handler).Then(handler.Handler{State: state, H: search.SearchHandler}))
+			}.Handler,
+			ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Search}.Handler).Then(handler.Handler{State: state, H: search.SearchHandler}))
 	opts.Handle("/search", chain.Then(handler.Options("POST")))
 
 }
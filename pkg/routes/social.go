@@ -1,16 +1,21 @@
 package routes
 
 import (
+	"github.com/fokal/fokal-core/pkg/feed"
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/notifications"
+	"github.com/fokal/fokal-core/pkg/ratelimit"
 	"github.com/fokal/fokal-core/pkg/security"
 	"github.com/fokal/fokal-core/pkg/security/permissions"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
 	"github.com/fokal/fokal-core/pkg/social"
 	"github.com/gorilla/mux"
 	"github.com/justinas/alice"
 )
 
 func RegisterSocialRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
 	put := api.Methods("PUT").Subrouter()
 	del := api.Methods("DELETE").Subrouter()
 	opts := api.Methods("OPTIONS").Subrouter()
@@ -18,6 +23,8 @@ func RegisterSocialRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 	put.Handle("/images/{ID:[a-zA-Z]{12}}/favorite",
 		chain.Append(
 			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler,
+			ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler,
 			permissions.Middleware{State: state,
 				T:          permissions.CanView,
 				TargetType: model.Images,
@@ -26,6 +33,8 @@ func RegisterSocialRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 	del.Handle("/images/{ID:[a-zA-Z]{12}}/favorite",
 		chain.Append(
 			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler,
+			ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler,
 			permissions.Middleware{State: state,
 				T:          permissions.CanView,
 				TargetType: model.Images,
@@ -35,6 +44,8 @@ func RegisterSocialRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 
 	put.Handle("/users/{ID}/follow", chain.Append(
 		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler,
 		permissions.Middleware{State: state,
 			T:          permissions.CanView,
 			TargetType: model.Users,
@@ -45,6 +56,8 @@ func RegisterSocialRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 		}))
 	del.Handle("/users/{ID}/follow", chain.Append(
 		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler,
 		permissions.Middleware{State: state,
 			T:          permissions.CanView,
 			TargetType: model.Users,
@@ -55,4 +68,65 @@ func RegisterSocialRoutes(state *handler.State, api *mux.Router, chain alice.Cha
 		}))
 	opts.Handle("/users/{ID}/follow", chain.Then(handler.Options("PUT", "DELETE")))
 
+	relation := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler,
+		ratelimit.Middleware{Pool: state.RD, Limits: state.RateLimits, C: ratelimit.Social}.Handler)
+
+	put.Handle("/users/{ID}/block", relation.Then(handler.Handler{State: state, H: social.RelationHandler("user.block", social.Block)}))
+	del.Handle("/users/{ID}/block", relation.Then(handler.Handler{State: state, H: social.RelationHandler("user.unblock", social.UnBlock)}))
+	opts.Handle("/users/{ID}/block", chain.Then(handler.Options("PUT", "DELETE")))
+
+	put.Handle("/users/{ID}/mute", relation.Then(handler.Handler{State: state, H: social.RelationHandler("user.mute", social.Mute)}))
+	del.Handle("/users/{ID}/mute", relation.Then(handler.Handler{State: state, H: social.RelationHandler("user.unmute", social.UnMute)}))
+	opts.Handle("/users/{ID}/mute", chain.Then(handler.Options("PUT", "DELETE")))
+
+	own := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler)
+
+	get.Handle("/users/me/blocks", own.Then(handler.Handler{State: state, H: social.OwnListHandler(social.Blocks)}))
+	opts.Handle("/users/me/blocks", chain.Then(handler.Options("GET")))
+
+	get.Handle("/users/me/mutes", own.Then(handler.Handler{State: state, H: social.OwnListHandler(social.Mutes)}))
+	opts.Handle("/users/me/mutes", chain.Then(handler.Options("GET")))
+
+	viewUser := chain.Append(
+		handler.Middleware{State: state, M: security.SetAuthenticatedUser}.Handler,
+		permissions.Middleware{State: state,
+			T:          permissions.CanView,
+			TargetType: model.Users,
+			M:          permissions.PermissionMiddle}.Handler)
+
+	get.Handle("/users/{ID}/followers", viewUser.Then(handler.Handler{State: state, H: social.FollowsHandler(social.Followers)}))
+	opts.Handle("/users/{ID}/followers", chain.Then(handler.Options("GET")))
+
+	get.Handle("/users/{ID}/following", viewUser.Then(handler.Handler{State: state, H: social.FollowsHandler(social.Following)}))
+	opts.Handle("/users/{ID}/following", chain.Then(handler.Options("GET")))
+
+	get.Handle("/users/me/feed", chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler).
+		Then(handler.Handler{State: state, H: feed.FeedHandler}))
+	opts.Handle("/users/me/feed", chain.Then(handler.Options("GET")))
+
+	read := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Read, M: scopes.ScopeMiddle}.Handler)
+	mark := chain.Append(
+		handler.Middleware{State: state, M: security.Authenticate}.Handler,
+		scopes.Middleware{State: state, S: scopes.Social, M: scopes.ScopeMiddle}.Handler)
+
+	get.Handle("/notifications", read.Then(handler.Handler{State: state, H: notifications.ListHandler}))
+	opts.Handle("/notifications", chain.Then(handler.Options("GET")))
+
+	get.Handle("/notifications/unread-count", read.Then(handler.Handler{State: state, H: notifications.UnreadCountHandler}))
+	opts.Handle("/notifications/unread-count", chain.Then(handler.Options("GET")))
+
+	put.Handle("/notifications/read", mark.Then(handler.Handler{State: state, H: notifications.MarkReadHandler}))
+	opts.Handle("/notifications/read", chain.Then(handler.Options("PUT")))
+
+	put.Handle("/notifications/{ID:[0-9]+}/read", mark.Then(handler.Handler{State: state, H: notifications.MarkReadHandler}))
+	opts.Handle("/notifications/{ID:[0-9]+}/read", chain.Then(handler.Options("PUT")))
+
 }
@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/fokal/fokal-core/pkg/trash"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterTrashRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	auth := func(s scopes.Scope) alice.Chain {
+		return chain.Append(
+			handler.Middleware{State: state, M: security.Authenticate}.Handler,
+			scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler)
+	}
+
+	get.Handle("/users/me/trash", auth(scopes.Read).Then(handler.Handler{State: state, H: trash.ListHandler}))
+	opts.Handle("/users/me/trash", chain.Then(handler.Options("GET")))
+
+	del.Handle("/users/me/trash/{ID:[a-zA-Z]{12}}", auth(scopes.Delete).Then(handler.Handler{State: state, H: trash.PurgeHandler}))
+	opts.Handle("/users/me/trash/{ID:[a-zA-Z]{12}}", chain.Then(handler.Options("DELETE")))
+
+	post.Handle("/users/me/trash/{ID:[a-zA-Z]{12}}/restore", auth(scopes.Edit).Then(handler.Handler{State: state, H: trash.RestoreHandler}))
+	opts.Handle("/users/me/trash/{ID:[a-zA-Z]{12}}/restore", chain.Then(handler.Options("POST")))
+}
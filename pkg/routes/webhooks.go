@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/security"
+	"github.com/fokal/fokal-core/pkg/security/roles"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/fokal/fokal-core/pkg/webhooks"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+func RegisterWebhookRoutes(state *handler.State, api *mux.Router, chain alice.Chain) {
+	get := api.Methods("GET").Subrouter()
+	post := api.Methods("POST").Subrouter()
+	patch := api.Methods("PATCH").Subrouter()
+	del := api.Methods("DELETE").Subrouter()
+	opts := api.Methods("OPTIONS").Subrouter()
+
+	for _, base := range []struct {
+		path  string
+		admin bool
+		auth  func(s scopes.Scope) alice.Chain
+	}{
+		{"/users/me/webhooks", false, func(s scopes.Scope) alice.Chain {
+			return chain.Append(
+				handler.Middleware{State: state, M: security.Authenticate}.Handler,
+				scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler)
+		}},
+		{"/admin/webhooks", true, func(s scopes.Scope) alice.Chain {
+			return chain.Append(
+				handler.Middleware{State: state, M: security.Authenticate}.Handler,
+				scopes.Middleware{State: state, S: s, M: scopes.ScopeMiddle}.Handler,
+				roles.Middleware{State: state, C: roles.ManageWebhooks, M: roles.CapabilityMiddle}.Handler)
+		}},
+	} {
+		get.Handle(base.path, base.auth(scopes.Read).Then(handler.Handler{State: state, H: webhooks.ListHandler(base.admin)}))
+		post.Handle(base.path, base.auth(scopes.Edit).Then(handler.Handler{State: state, H: webhooks.CreateHandler(base.admin)}))
+		opts.Handle(base.path, chain.Then(handler.Options("GET", "POST")))
+
+		hook := base.path + "/{ID:[0-9]+}"
+		get.Handle(hook, base.auth(scopes.Read).Then(handler.Handler{State: state, H: webhooks.GetHandler(base.admin)}))
+		patch.Handle(hook, base.auth(scopes.Edit).Then(handler.Handler{State: state, H: webhooks.PatchHandler(base.admin)}))
+		del.Handle(hook, base.auth(scopes.Edit).Then(handler.Handler{State: state, H: webhooks.DeleteHandler(base.admin)}))
+		opts.Handle(hook, chain.Then(handler.Options("GET", "PATCH", "DELETE")))
+
+		get.Handle(hook+"/deliveries", base.auth(scopes.Read).Then(handler.Handler{State: state, H: webhooks.DeliveriesHandler(base.admin)}))
+		opts.Handle(hook+"/deliveries", chain.Then(handler.Options("GET")))
+
+		post.Handle(hook+"/deliveries/{delivery:[0-9]+}/redeliver",
+			base.auth(scopes.Edit).Then(handler.Handler{State: state, H: webhooks.RedeliverHandler(base.admin)}))
+		opts.Handle(hook+"/deliveries/{delivery:[0-9]+}/redeliver", chain.Then(handler.Options("POST")))
+	}
+}
@@ -17,6 +17,7 @@ import (
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/devinmcgloin/clr/clr"
+	"github.com/fokal/fokal-core/pkg/collections"
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/model"
 	"github.com/fokal/fokal-core/pkg/retrieval"
@@ -59,6 +60,7 @@ func SearchHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 	}
 
 	var ids []Rank
+	viewer := retrieval.Viewer(r)
 
 	tsQuery := formatQueryString(searchReq.RequiredTerms, searchReq.OptionalTerms, searchReq.ExcludedTerms)
 	psql := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
@@ -66,7 +68,13 @@ func SearchHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 		LeftJoin("content.image_geo AS geo ON searches.searchable_id = geo.image_id").
 		LeftJoin("content.image_color_bridge AS bridge ON searches.searchable_id = bridge.image_id").
 		LeftJoin("content.colors AS colors ON bridge.color_id = colors.id").Where(sq.Eq{"searches.searchable_type": searchReq.Types}).
-		Options("DISTINCT ON (ID, type)")
+		Options("DISTINCT ON (ID, type)").
+		Where(`CASE WHEN searches.searchable_type = 'tag' THEN TRUE
+			ELSE permissions.viewable(?, searches.searchable_id, searches.searchable_type :: CONTENT_TYPE) END`, viewer).
+		Where(`NOT permissions.blocked(?, CASE searches.searchable_type
+			WHEN 'user' THEN searches.searchable_id
+			WHEN 'image' THEN (SELECT user_id FROM content.images WHERE id = searches.searchable_id)
+			WHEN 'collection' THEN (SELECT user_id FROM content.collections WHERE id = searches.searchable_id) END)`, viewer)
 
 	if tsQuery == "" {
 		q = q.Column("0 AS rank")
@@ -107,9 +115,10 @@ func SearchHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 	sort.Sort(ByRankColor(ids))
 
 	resp := Response{
-		Images: []model.Image{},
-		Users:  []model.User{},
-		Tags:   []TagResponse{}}
+		Images:      []model.Image{},
+		Users:       []model.User{},
+		Tags:        []TagResponse{},
+		Collections: []model.Collection{}}
 
 	for _, v := range ids {
 		switch v.Type {
@@ -121,14 +130,21 @@ func SearchHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 			}
 			resp.Images = append(resp.Images, img)
 		case User:
-			user, err := retrieval.GetUser(store, v.ID)
+			user, err := retrieval.GetUser(store, viewer, v.ID)
 			if err != nil {
 				log.Println(err)
 				return handler.Response{}, handler.StatusError{Err: err, Code: http.StatusInternalServerError}
 			}
 			resp.Users = append(resp.Users, user)
+		case Collection:
+			collection, err := collections.Get(store, viewer, v.ID)
+			if err != nil {
+				log.Println(err)
+				return handler.Response{}, handler.StatusError{Err: err, Code: http.StatusInternalServerError}
+			}
+			resp.Collections = append(resp.Collections, collection)
 		case Tag:
-			tag, err := retrieval.TaggedImages(store, v.ID, 1)
+			tag, err := retrieval.TaggedImages(store, viewer, v.ID, 1)
 			if err != nil {
 				log.Println(err)
 				return handler.Response{}, handler.StatusError{Err: err, Code: http.StatusInternalServerError}
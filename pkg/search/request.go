@@ -8,7 +8,7 @@ const (
 	User       = "user"
 	Image      = "image"
 	Tag        = "tag"
-	Collection = "collections"
+	Collection = "collection"
 )
 
 type Request struct {
@@ -47,7 +47,8 @@ type TagResponse struct {
 }
 
 type Response struct {
-	Images []model.Image `json:"images"`
-	Users  []model.User  `json:"users"`
-	Tags   []TagResponse `json:"tags"`
+	Images      []model.Image      `json:"images"`
+	Users       []model.User       `json:"users"`
+	Tags        []TagResponse      `json:"tags"`
+	Collections []model.Collection `json:"collections"`
 }
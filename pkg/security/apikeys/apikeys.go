@@ -0,0 +1,162 @@
+package apikeys
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/generator"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Prefix marks a bearer token as an API key rather than a JWT.
+const Prefix = "fk_"
+
+type Key struct {
+	Id        int64          `db:"id" json:"-"`
+	UserId    int64          `db:"user_id" json:"-"`
+	Name      string         `db:"name" json:"name"`
+	Prefix    string         `db:"prefix" json:"id"`
+	Hash      string         `db:"hash" json:"-"`
+	Scopes    pq.StringArray `db:"scopes" json:"scopes"`
+	ExpiresAt *time.Time     `db:"expires_at" json:"expires_at,omitempty"`
+	LastUsed  *time.Time     `db:"last_used" json:"last_used,omitempty"`
+	Revoked   bool           `db:"revoked" json:"-"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+}
+
+// IsAPIKey reports whether the raw bearer token looks like an API key.
+func IsAPIKey(raw string) bool {
+	return strings.HasPrefix(raw, Prefix)
+}
+
+func hash(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create generates a new key for the user. The raw key is only returned here,
+// the database keeps its prefix and hash.
+func Create(db *sqlx.DB, userID int64, name string, s []scopes.Scope, expires *time.Time) (string, Key, error) {
+	prefix, err := generator.GenerateSecureString(4)
+	if err != nil {
+		log.Println(err)
+		return "", Key{}, err
+	}
+	secret, err := generator.GenerateSecureString(32)
+	if err != nil {
+		log.Println(err)
+		return "", Key{}, err
+	}
+	raw := Prefix + prefix + "_" + secret
+
+	names := make(pq.StringArray, len(s))
+	for i, v := range s {
+		names[i] = string(v)
+	}
+
+	key := Key{}
+	err = db.Get(&key, `
+	INSERT INTO permissions.api_keys(user_id, name, prefix, hash, scopes, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING *;`, userID, name, prefix, hash(raw), names, expires)
+	if err != nil {
+		log.Println(err)
+		return "", Key{}, err
+	}
+	return raw, key, nil
+}
+
+// List returns all keys belonging to the user that have not been revoked.
+func List(db *sqlx.DB, userID int64) ([]Key, error) {
+	keys := []Key{}
+	err := db.Select(&keys, `
+	SELECT * FROM permissions.api_keys
+	WHERE user_id = $1 AND revoked = FALSE
+	ORDER BY created_at DESC`, userID)
+	if err != nil {
+		log.Println(err)
+		return []Key{}, err
+	}
+	return keys, nil
+}
+
+// Revoke disables the key with the given prefix if it belongs to the user.
+func Revoke(db *sqlx.DB, userID int64, prefix string) error {
+	res, err := db.Exec(`
+	UPDATE permissions.api_keys
+		SET revoked = TRUE
+	WHERE user_id = $1 AND prefix = $2 AND revoked = FALSE`, userID, prefix)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if n == 0 {
+		return handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No API key found")}
+	}
+	return nil
+}
+
+// Verify checks a raw API key and returns the owning user along with the
+// scopes the key was granted.
+func Verify(db *sqlx.DB, raw string) (model.Ref, []scopes.Scope, error) {
+	invalid := handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("API key is invalid")}
+
+	parts := strings.SplitN(strings.TrimPrefix(raw, Prefix), "_", 2)
+	if len(parts) != 2 {
+		return model.Ref{}, []scopes.Scope{}, invalid
+	}
+
+	key := Key{}
+	err := db.Get(&key, "SELECT * FROM permissions.api_keys WHERE prefix = $1", parts[0])
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+			return model.Ref{}, []scopes.Scope{}, err
+		}
+		return model.Ref{}, []scopes.Scope{}, invalid
+	}
+
+	if subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash(raw))) != 1 || key.Revoked {
+		return model.Ref{}, []scopes.Scope{}, invalid
+	}
+
+	if key.ExpiresAt != nil && key.ExpiresAt.Before(time.Now()) {
+		return model.Ref{}, []scopes.Scope{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("API key has expired")}
+	}
+
+	granted, err := scopes.Parse(key.Scopes)
+	if err != nil {
+		log.Println(err)
+		return model.Ref{}, []scopes.Scope{}, invalid
+	}
+
+	ref := model.Ref{Collection: model.Users, Id: key.UserId}
+	err = db.Get(&ref.Shortcode, "SELECT username FROM content.users WHERE id = $1", key.UserId)
+	if err != nil {
+		log.Println(err)
+		return model.Ref{}, []scopes.Scope{}, invalid
+	}
+
+	_, err = db.Exec("UPDATE permissions.api_keys SET last_used = now() WHERE id = $1", key.Id)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return ref, granted, nil
+}
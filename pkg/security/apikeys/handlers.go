@@ -0,0 +1,91 @@
+package apikeys
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+// sessionUser returns the authenticated user, refusing requests that were
+// themselves made with an API key so keys cannot mint or revoke other keys.
+func sessionUser(r *http.Request) (model.Ref, error) {
+	val, ok := context.GetOk(r, "auth")
+	if !ok {
+		return model.Ref{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+	if _, ok := context.GetOk(r, "apikey"); ok {
+		return model.Ref{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("API keys cannot manage API keys")}
+	}
+	return val.(model.Ref), nil
+}
+
+func CreateHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := sessionUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.CreateAPIKeyRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	granted, err := scopes.Parse(req.Scopes)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+	if len(granted) == 0 {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("At least one scope is required")}
+	}
+
+	raw, key, err := Create(store.DB, user.Id, req.Name, granted, req.ExpiresAt)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create API key")}
+	}
+
+	audit.Record(store.DB, r, "api_key.create", user, nil, key)
+
+	return handler.Response{
+		Code: http.StatusCreated,
+		Data: map[string]interface{}{"key": raw, "api_key": key},
+	}, nil
+}
+
+func ListHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := sessionUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	keys, err := List(store.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	return handler.Response{Code: http.StatusOK, Data: keys}, nil
+}
+
+func RevokeHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, err := sessionUser(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	prefix := mux.Vars(r)["ID"]
+	err = Revoke(store.DB, user.Id, prefix)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	audit.Record(store.DB, r, "api_key.revoke", user, map[string]string{"id": prefix}, nil)
+
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
@@ -2,6 +2,8 @@ package security
 
 import (
 	"crypto/sha512"
+	"crypto/subtle"
+	"database/sql"
 	"encoding/hex"
 	"log"
 	"net/http"
@@ -59,10 +61,41 @@ func GenerateSaltPass(password string) (string, string, error) {
 	return saltedPass, salt, nil
 }
 
+// ErrWrongPassword is returned when changing a password without the right
+// current one.
+var ErrWrongPassword = errors.New("Current password is incorrect")
+
+// SetPassword replaces the user's password. Users who already have one must
+// give it as current.
+func SetPassword(db *sqlx.DB, uid int64, current, password string) error {
+	var creds struct {
+		Password sql.NullString `db:"password"`
+		Salt     sql.NullString `db:"salt"`
+	}
+	err := db.Get(&creds, "SELECT password, salt FROM content.users WHERE id = $1 AND deleted_at IS NULL", uid)
+	if err != nil {
+		return err
+	}
+
+	if creds.Password.Valid {
+		sha := sha512.New().Sum(append([]byte(current), []byte(creds.Salt.String)...))
+		if subtle.ConstantTimeCompare([]byte(creds.Password.String), []byte(hex.EncodeToString(sha))) != 1 {
+			return ErrWrongPassword
+		}
+	}
+
+	saltedPass, salt, err := GenerateSaltPass(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE content.users SET password = $1, salt = $2, last_modified = now() WHERE id = $3", saltedPass, salt, uid)
+	return err
+}
+
 // GetLogin returns the salt, password, email and username for a given user.
 func GetLogin(db *sqlx.DB, username string) (*Credentials, error) {
 	userInfo := new(Credentials)
-	err := db.Get(userInfo, "SELECT id, username, salt, password, email FROM content.users WHERE username = $1 LIMIT 1;", username)
+	err := db.Get(userInfo, "SELECT id, username, salt, password, email FROM content.users WHERE username = $1 AND deleted_at IS NULL LIMIT 1;", username)
 	if err != nil {
 		log.Print(err)
 		return userInfo, err
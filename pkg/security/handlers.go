@@ -1,6 +1,7 @@
 package security
 
 import (
+	"errors"
 	"net/http"
 
 	"crypto/x509"
@@ -8,39 +9,209 @@ import (
 	"encoding/pem"
 
 	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fokal/fokal-core/pkg/audit"
 	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/oidc"
+	"github.com/fokal/fokal-core/pkg/request"
 	"github.com/fokal/fokal-core/pkg/tokens"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
 )
 
+// PublicKeyHandler returns the PEM encoded public key for every published
+// signing key, keyed by kid.
 func PublicKeyHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
 	key := make(map[string]string)
-	keyBytes, err := x509.MarshalPKIXPublicKey(state.PublicKeys[state.KeyHash])
-	if err != nil {
-		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
-	}
+	for _, k := range state.Keys.Published() {
+		keyBytes, err := x509.MarshalPKIXPublicKey(k.Public)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
 
-	pemBytes := pem.EncodeToMemory(&pem.Block{
-		Type:  "RSA PUBLIC KEY",
-		Bytes: keyBytes,
-	})
+		pemBytes := pem.EncodeToMemory(&pem.Block{
+			Type:  "RSA PUBLIC KEY",
+			Bytes: keyBytes,
+		})
 
-	key[state.KeyHash] = string(pemBytes)
+		key[k.ID] = string(pemBytes)
+	}
 	return handler.Response{Code: http.StatusOK, Data: key}, nil
 }
 
+// JWKSHandler publishes the signing keys as a JSON Web Key Set.
+func JWKSHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	return handler.Response{Code: http.StatusOK, Data: state.Keys.JWKS()}, nil
+}
+
+// RefreshHandler renews a fokal token. ID tokens from a configured provider
+// are exchanged for a new session, as clients have always signed in here.
 func RefreshHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	raw, err := tokens.Bearer(r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if oidc.Issuer(raw) != "fokal" {
+		return providerLogin(state, r, raw)
+	}
+
 	user, err := tokens.Verify(state, r)
 	if err != nil {
 		return handler.Response{}, err
 
 	}
-	oldJWT, _ := tokens.Parse(state, r)
-	claims := oldJWT.Claims.(jwt.MapClaims)
-	email := claims["email"].(string)
+	if err := active(state, user); err != nil {
+		return handler.Response{}, err
+	}
+	claims, err := tokens.Claims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	email, ok := claims["email"].(string)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token is malformed")}
+	}
+
+	token, err := tokens.Renew(state, r, user, email, claims)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	return handler.Response{Code: http.StatusOK, Data: map[string]string{"token": token}}, nil
+}
+
+// sessionClaims returns the authenticated user and the claims of their token.
+// Requests made with API keys have no session and are refused.
+func sessionClaims(state *handler.State, r *http.Request) (model.Ref, jwt.MapClaims, error) {
+	if _, ok := context.GetOk(r, "apikey"); ok {
+		return model.Ref{}, jwt.MapClaims{}, handler.StatusError{Code: http.StatusForbidden, Err: errors.New("API keys do not have sessions")}
+	}
+
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return model.Ref{}, jwt.MapClaims{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+	}
+
+	claims, err := tokens.Claims(state, r)
+	if err != nil {
+		return model.Ref{}, jwt.MapClaims{}, err
+	}
+	return user, claims, nil
+}
+
+func LogoutHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, claims, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	err = tokens.RevokeToken(state, user.Id, claims)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to revoke token")}
+	}
+
+	audit.Record(state.DB, r, "auth.logout", user, map[string]interface{}{"session": claims["sid"]}, nil)
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+func SessionsHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, claims, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	sessions, err := tokens.Sessions(state, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve sessions")}
+	}
 
-	jwt, err := tokens.Create(state, user, email)
+	sid, _ := claims["sid"].(string)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == sid
+	}
+	return handler.Response{Code: http.StatusOK, Data: sessions}, nil
+}
+
+// RevokeSessionsHandler logs out every session other than the current one.
+func RevokeSessionsHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, claims, err := sessionClaims(state, r)
 	if err != nil {
 		return handler.Response{}, err
 	}
-	return handler.Response{Code: http.StatusOK, Data: map[string]string{"token": jwt}}, nil
+
+	sessions, err := tokens.Sessions(state, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve sessions")}
+	}
+
+	sid, _ := claims["sid"].(string)
+	for _, s := range sessions {
+		if s.ID == sid {
+			continue
+		}
+		err = tokens.RevokeSession(state, user.Id, s.ID)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to revoke session")}
+		}
+		audit.Record(state.DB, r, "auth.revoke_session", user, map[string]string{"session": s.ID}, nil)
+	}
+	return handler.Response{Code: http.StatusAccepted}, nil
+}
+
+// PasswordHandler changes the logged in user's password and logs out every
+// session, returning a new token for the current one.
+func PasswordHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, claims, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	email, ok := claims["email"].(string)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token is malformed")}
+	}
+
+	req := new(request.ChangePasswordRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	if len(req.Password) < 8 {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Password must be at least 8 characters")}
+	}
+
+	err = SetPassword(state.DB, user.Id, req.CurrentPassword, req.Password)
+	if err == ErrWrongPassword {
+		return handler.Response{}, handler.StatusError{Code: http.StatusForbidden, Err: err}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to change password")}
+	}
+
+	err = tokens.RevokeAll(state, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to revoke sessions")}
+	}
+
+	token, err := tokens.Create(state, r, user, email)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	audit.Record(state.DB, r, "auth.password", user, nil, nil)
+	return handler.Response{Code: http.StatusOK, Data: map[string]string{"token": token}}, nil
+}
+
+func RevokeSessionHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	sid := mux.Vars(r)["ID"]
+	err = tokens.RevokeSession(state, user.Id, sid)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	audit.Record(state.DB, r, "auth.revoke_session", user, map[string]string{"session": sid}, nil)
+	return handler.Response{Code: http.StatusAccepted}, nil
 }
@@ -0,0 +1,127 @@
+package security
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/oidc"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/mholt/binding"
+)
+
+// providerLogin exchanges an ID token from a configured provider for a fokal
+// token, or a challenge for users with two-factor authentication.
+func providerLogin(state *handler.State, r *http.Request, raw string) (handler.Response, error) {
+	id, err := state.Providers.Verify(raw)
+	if err != nil {
+		log.Println(err)
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Token is invalid")}
+	}
+
+	user, email, err := oidc.Lookup(state.DB, id)
+	if err == sql.ErrNoRows {
+		user, email, err = oidc.Claim(state.DB, id)
+		if err == nil {
+			audit.RecordAs(state.DB, r, user, "identity.link", user, nil, map[string]string{"provider": id.Provider})
+		}
+	}
+	if err == sql.ErrNoRows {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No user is linked to this identity")}
+	} else if err != nil {
+		log.Println(err)
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	if err := active(state, user); err != nil {
+		return handler.Response{}, err
+	}
+
+	return login(state, r, user, email, map[string]string{"provider": id.Provider})
+}
+
+// LoginHandler signs in with an ID token from any configured provider.
+func LoginHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	req := new(request.IDTokenRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+	return providerLogin(state, r, req.IDToken)
+}
+
+func IdentitiesHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	links, err := oidc.Links(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve identities")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: links}, nil
+}
+
+// LinkIdentityHandler lets the logged in user sign in through another
+// provider.
+func LinkIdentityHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.IDTokenRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	id, err := state.Providers.Verify(req.IDToken)
+	if err != nil {
+		log.Println(err)
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Token is invalid")}
+	}
+
+	err = oidc.Add(state.DB, user.Id, id)
+	if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("Identity is already linked")}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to link identity")}
+	}
+
+	audit.Record(state.DB, r, "identity.link", user, nil, map[string]string{"provider": id.Provider})
+	return handler.Response{Code: http.StatusCreated}, nil
+}
+
+// UnlinkIdentityHandler removes a provider from the logged in user. The last
+// identity cannot be removed as the user would be unable to sign in.
+func UnlinkIdentityHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	links, err := oidc.Links(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve identities")}
+	}
+	if len(links) <= 1 {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("Cannot remove the last identity")}
+	}
+
+	provider := mux.Vars(r)["provider"]
+	removed, err := oidc.Remove(state.DB, user.Id, provider)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to unlink identity")}
+	}
+	if !removed {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Identity not found")}
+	}
+
+	audit.Record(state.DB, r, "identity.unlink", user, map[string]string{"provider": provider}, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
@@ -1,20 +1,77 @@
 package security
 
 import (
+	"errors"
 	"net/http"
 
 	"log"
 
 	"encoding/json"
 
+	"strings"
+
+	"github.com/fokal/fokal-core/pkg/admin"
 	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/security/apikeys"
+	"github.com/fokal/fokal-core/pkg/security/scopes"
 	"github.com/fokal/fokal-core/pkg/tokens"
+	"github.com/fokal/fokal-core/pkg/trash"
 	"github.com/gorilla/context"
 )
 
+// verify authenticates the request with either an API key or a JWT, returning
+// the user along with the scopes the credentials were granted. Browser
+// sessions are granted every scope.
+func verify(state *handler.State, r *http.Request) (model.Ref, []scopes.Scope, bool, error) {
+	raw := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if apikeys.IsAPIKey(raw) {
+		user, granted, err := apikeys.Verify(state.DB, raw)
+		if err == nil {
+			err = active(state, user)
+		}
+		return user, granted, true, err
+	}
+
+	user, err := tokens.Verify(state, r)
+	if err == nil {
+		err = active(state, user)
+	}
+	return user, scopes.All, false, err
+}
+
+// Recheck verifies the request's credentials again, for requests such as event
+// streams that stay open past a logout, suspension or deletion.
+func Recheck(state *handler.State) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		_, _, _, err := verify(state, r)
+		return err
+	}
+}
+
+// active refuses users whose account has been suspended or deleted.
+func active(state *handler.State, user model.Ref) error {
+	suspended, err := admin.Suspended(state.DB, user.Id)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to verify user")}
+	}
+	if suspended {
+		return handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Account has been suspended")}
+	}
+
+	deleted, err := trash.Deleted(state.DB, user.Id)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to verify user")}
+	}
+	if deleted {
+		return handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Account has been deleted")}
+	}
+	return nil
+}
+
 func Authenticate(state *handler.State, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, err := tokens.Verify(state, r)
+		user, granted, isKey, err := verify(state, r)
 		if err != nil {
 			switch e := err.(type) {
 			case handler.Error:
@@ -36,17 +93,27 @@ func Authenticate(state *handler.State, next http.Handler) http.Handler {
 		} else {
 			log.Printf("Setting user auth: %v\n", user)
 			context.Set(r, "auth", user)
+			context.Set(r, "scopes", granted)
+			if isKey {
+				context.Set(r, "apikey", true)
+			}
 			next.ServeHTTP(w, r)
 		}
 
 	})
 }
 
+// SetAuthenticatedUser sets the user on the request if valid credentials are
+// present. API keys without the read scope are treated as anonymous.
 func SetAuthenticatedUser(state *handler.State, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		user, err := tokens.Verify(state, r)
-		if err == nil {
+		user, granted, isKey, err := verify(state, r)
+		if err == nil && scopes.Contains(granted, scopes.Read) {
 			context.Set(r, "auth", user)
+			context.Set(r, "scopes", granted)
+			if isKey {
+				context.Set(r, "apikey", true)
+			}
 		}
 		next.ServeHTTP(w, r)
 	})
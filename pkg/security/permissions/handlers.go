@@ -0,0 +1,301 @@
+package permissions
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/cache"
+	"github.com/fokal/fokal-core/pkg/groups"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
+)
+
+type resourceHandler func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error)
+
+// target returns the item a permission request refers to. Images and
+// collections are named by the ID in the url, users can only manage
+// themselves.
+func target(state *handler.State, r *http.Request, t model.ReferenceType) (model.Ref, error) {
+	switch t {
+	case model.Images:
+		ref, err := retrieval.GetImageRef(state.DB, mux.Vars(r)["ID"])
+		if err != nil {
+			return model.Ref{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+		}
+		return ref, nil
+	case model.Collections:
+		return retrieval.GetCollectionRef(state.DB, mux.Vars(r)["ID"])
+	case model.Users:
+		ref, ok := context.Get(r, "auth").(model.Ref)
+		if !ok {
+			return model.Ref{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+		}
+		return ref, nil
+	}
+	return model.Ref{}, handler.StatusError{Code: http.StatusInternalServerError}
+}
+
+// record audits a change to who can access the target.
+func record(state *handler.State, r *http.Request, action string, ref model.Ref, t model.ReferenceType, before Access) {
+	after, err := List(state.DB, ref.Id, t)
+	if err != nil {
+		return
+	}
+	audit.Record(state.DB, r, action, ref, before, after)
+}
+
+// SetPublicHandler makes the target public or private.
+func SetPublicHandler(t model.ReferenceType, public bool) resourceHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		ref, err := target(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		before, _ := List(state.DB, ref.Id, t)
+		err = SetPublic(state.DB, ref.Id, t, public)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to change visibility")}
+		}
+
+		record(state, r, "permissions.public", ref, t, before)
+
+		// Listings are cached for anonymous viewers.
+		if err := cache.Flush(state.RD); err != nil {
+			log.Println(err)
+		}
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
+
+// ListHandler returns who has access to the target.
+func ListHandler(t model.ReferenceType) resourceHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		ref, err := target(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		access, err := List(state.DB, ref.Id, t)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve permissions")}
+		}
+		return handler.Response{Code: http.StatusOK, Data: access}, nil
+	}
+}
+
+// grantee returns the target, permission and user named in the url.
+func grantee(state *handler.State, r *http.Request, t model.ReferenceType) (model.Ref, Permission, model.Ref, error) {
+	ref, err := target(state, r, t)
+	if err != nil {
+		return model.Ref{}, "", model.Ref{}, err
+	}
+
+	vars := mux.Vars(r)
+	p, err := ParsePermission(vars["permission"])
+	if err != nil {
+		return model.Ref{}, "", model.Ref{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	user, err := retrieval.GetUserRef(state.DB, vars["user"])
+	if err != nil {
+		return model.Ref{}, "", model.Ref{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No user found")}
+	}
+	return ref, p, user, nil
+}
+
+// holds checks that the caller has the permission they are granting or
+// revoking, so collaborators cannot hand out or take away more than they were
+// given.
+func holds(db *sqlx.DB, caller model.Ref, p Permission, item int64, t model.ReferenceType) error {
+	valid, err := Valid(db, caller.Id, p, item, t)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !valid {
+		return handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Can only grant or revoke permissions you hold")}
+	}
+	return nil
+}
+
+// grant gives the user a permission on the item for the caller.
+func grant(db *sqlx.DB, caller, user model.Ref, p Permission, item int64, t model.ReferenceType) error {
+	if err := holds(db, caller, p, item, t); err != nil {
+		return err
+	}
+
+	err := Add(db, user.Id, p, item, t)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to grant permission")}
+	}
+	return nil
+}
+
+// GrantHandler gives a user a permission on the target.
+func GrantHandler(t model.ReferenceType) resourceHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		caller, ok := context.Get(r, "auth").(model.Ref)
+		if !ok {
+			return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+		}
+
+		ref, p, user, err := grantee(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		before, _ := List(state.DB, ref.Id, t)
+		err = grant(state.DB, caller, user, p, ref.Id, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		record(state, r, "permissions.grant", ref, t, before)
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
+
+// RevokeHandler takes a permission on the target away from a user. The
+// owner's permissions cannot be revoked.
+func RevokeHandler(t model.ReferenceType) resourceHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		caller, ok := context.Get(r, "auth").(model.Ref)
+		if !ok {
+			return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+		}
+
+		ref, p, user, err := grantee(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		owner, err := Owner(state.DB, ref.Id, t)
+		if err == sql.ErrNoRows {
+			return handler.Response{}, handler.StatusError{Code: http.StatusNotFound}
+		} else if err != nil {
+			log.Println(err)
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+		if owner == user.Id {
+			return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("Cannot revoke the owner's permissions")}
+		}
+		if err := holds(state.DB, caller, p, ref.Id, t); err != nil {
+			return handler.Response{}, err
+		}
+
+		before, _ := List(state.DB, ref.Id, t)
+		removed, err := Remove(state.DB, user.Id, p, ref.Id, t)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to revoke permission")}
+		}
+		if !removed {
+			return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Permission was not granted")}
+		}
+
+		record(state, r, "permissions.revoke", ref, t, before)
+		return handler.Response{Code: http.StatusNoContent}, nil
+	}
+}
+
+// groupGrantee returns the target, permission and group named in the url.
+func groupGrantee(state *handler.State, r *http.Request, t model.ReferenceType) (model.Ref, Permission, model.Ref, error) {
+	ref, err := target(state, r, t)
+	if err != nil {
+		return model.Ref{}, "", model.Ref{}, err
+	}
+
+	vars := mux.Vars(r)
+	p, err := ParsePermission(vars["permission"])
+	if err != nil {
+		return model.Ref{}, "", model.Ref{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+	}
+
+	group, err := groups.GetRef(state.DB, vars["group"])
+	if err != nil {
+		return model.Ref{}, "", model.Ref{}, err
+	}
+	return ref, p, group, nil
+}
+
+// grantGroup gives the group a permission on the item for the caller, who can
+// only share with groups they belong to.
+func grantGroup(db *sqlx.DB, caller, group model.Ref, p Permission, item int64, t model.ReferenceType) error {
+	if err := holds(db, caller, p, item, t); err != nil {
+		return err
+	}
+
+	_, member, err := groups.MemberRole(db, group.Id, caller.Id)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if !member {
+		return handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Must be a member of the group to share with it")}
+	}
+
+	err = AddGroup(db, group.Id, p, item, t)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to grant permission")}
+	}
+	return nil
+}
+
+// GrantGroupHandler gives every member of a group a permission on the target.
+func GrantGroupHandler(t model.ReferenceType) resourceHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		caller, ok := context.Get(r, "auth").(model.Ref)
+		if !ok {
+			return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+		}
+
+		ref, p, group, err := groupGrantee(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		before, _ := List(state.DB, ref.Id, t)
+		err = grantGroup(state.DB, caller, group, p, ref.Id, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		record(state, r, "permissions.grant_group", ref, t, before)
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
+
+// RevokeGroupHandler takes a permission on the target away from a group.
+func RevokeGroupHandler(t model.ReferenceType) resourceHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		caller, ok := context.Get(r, "auth").(model.Ref)
+		if !ok {
+			return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Must be logged in to use this endpoint")}
+		}
+
+		ref, p, group, err := groupGrantee(state, r, t)
+		if err != nil {
+			return handler.Response{}, err
+		}
+		if err := holds(state.DB, caller, p, ref.Id, t); err != nil {
+			return handler.Response{}, err
+		}
+
+		before, _ := List(state.DB, ref.Id, t)
+		removed, err := RemoveGroup(state.DB, group.Id, p, ref.Id, t)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to revoke permission")}
+		}
+		if !removed {
+			return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Permission was not granted")}
+		}
+
+		record(state, r, "permissions.revoke_group", ref, t, before)
+		return handler.Response{Code: http.StatusNoContent}, nil
+	}
+}
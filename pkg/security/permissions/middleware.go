@@ -8,6 +8,7 @@ import (
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/model"
 	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/sharing"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 )
@@ -59,6 +60,8 @@ func PermissionMiddle(state *handler.State, p Permission, TargetType model.Refer
 			tarRef, err = retrieval.GetImageRef(state.DB, id)
 		case model.Users:
 			tarRef, err = retrieval.GetUserRef(state.DB, id)
+		case model.Collections:
+			tarRef, err = retrieval.GetCollectionRef(state.DB, id)
 		}
 
 		if err != nil {
@@ -66,11 +69,20 @@ func PermissionMiddle(state *handler.State, p Permission, TargetType model.Refer
 			return
 		}
 
-		valid, err := Valid(state.DB, user.Id, p, tarRef.Id)
+		valid, err := Valid(state.DB, user.Id, p, tarRef.Id, TargetType)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+		// Share links stand in for a can_view grant on their image.
+		if raw := r.URL.Query().Get("share"); !valid && p == CanView && TargetType == model.Images && raw != "" {
+			link, err := sharing.Verify(state, raw, tarRef.Id, r.Method == http.MethodGet)
+			if err == nil {
+				context.Set(r, "share", link)
+				valid = true
+			}
+		}
+
 		if !valid && p != CanView {
 			w.WriteHeader(http.StatusNotFound)
 			return
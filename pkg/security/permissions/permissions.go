@@ -1,8 +1,11 @@
 package permissions
 
 import (
+	"errors"
+	"fmt"
 	"log"
 
+	"github.com/fokal/fokal-core/pkg/model"
 	"github.com/jmoiron/sqlx"
 )
 
@@ -14,72 +17,239 @@ const (
 	CanView   = Permission("can_view")
 )
 
-func Valid(db *sqlx.DB, userRef int64, permission Permission, item int64) (bool, error) {
-	var valid int
-	var err error
-	var stmt *sqlx.Stmt
+// Public is the user id that grants a permission to everyone.
+const Public = -1
+
+var Permissions = []Permission{CanView, CanEdit, CanDelete}
+
+// ParsePermission returns the permission with the given name.
+func ParsePermission(name string) (Permission, error) {
+	for _, p := range Permissions {
+		if string(p) == name {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown permission %s", name)
+}
+
+// ContentType returns the content_type used to store permissions for t.
+func ContentType(t model.ReferenceType) (string, error) {
+	switch t {
+	case model.Images:
+		return "image", nil
+	case model.Users:
+		return "user", nil
+	case model.Collections:
+		return "collection", nil
+	}
+	return "", errors.New("permissions are only stored for images, users and collections")
+}
+
+// Valid reports whether the user holds the permission on the item, whether it
+// was granted to them, to a group they belong to or to everyone. Admins hold
+// every permission.
+func Valid(db *sqlx.DB, userRef int64, permission Permission, item int64, t model.ReferenceType) (bool, error) {
+	var valid bool
+
+	contentType, err := ContentType(t)
+	if err != nil {
+		return false, err
+	}
 
 	switch permission {
 	case CanEdit:
-		stmt, err = db.Preparex("SELECT count(*) FROM permissions.can_edit WHERE user_id = $1 AND o_id = $2;")
+		err = db.Get(&valid, "SELECT permissions.editable($1, $2, $3);", userRef, item, contentType)
 	case CanView:
-		stmt, err = db.Preparex("SELECT count(*) FROM permissions.can_view WHERE (user_id = $1 OR user_id = -1) AND o_id = $2;")
+		err = db.Get(&valid, "SELECT permissions.viewable($1, $2, $3);", userRef, item, contentType)
 	case CanDelete:
-		stmt, err = db.Preparex("SELECT count(*) FROM permissions.can_delete WHERE user_id = $1 AND o_id = $2;")
+		err = db.Get(&valid, "SELECT permissions.deletable($1, $2, $3);", userRef, item, contentType)
+	default:
+		err = fmt.Errorf("unknown permission %s", permission)
 	}
 	if err != nil {
 		log.Println(err)
 		return false, err
 	}
-	log.Printf("usr: %d, permission: %v, item: %d ", userRef, permission, item)
-	err = stmt.Get(&valid, userRef, item)
+	log.Printf("usr: %d, permission: %v, item: %d %s, valid: %t", userRef, permission, item, contentType, valid)
+	return valid, nil
+}
+
+func Add(db *sqlx.DB, userRef int64, permission Permission, item int64, t model.ReferenceType) error {
+	contentType, err := ContentType(t)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf(`
+	INSERT INTO permissions.%s(user_id, o_id, type) VALUES($1, $2, $3)
+	ON CONFLICT DO NOTHING;`, table(permission)), userRef, item, contentType)
 	if err != nil {
 		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// Remove revokes the permission, returning false if it was not granted.
+func Remove(db *sqlx.DB, userRef int64, permission Permission, item int64, t model.ReferenceType) (bool, error) {
+	contentType, err := ContentType(t)
+	if err != nil {
 		return false, err
 	}
 
-	if valid == 0 {
-		admin, err := IsAdmin(db, userRef)
-		if err != nil {
-			log.Println(err)
-			return false, err
-		}
-		if admin {
-			return admin, nil
-		}
+	res, err := db.Exec(fmt.Sprintf(`
+	DELETE FROM permissions.%s WHERE user_id = $1 AND o_id = $2 AND type = $3;`, table(permission)),
+		userRef, item, contentType)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// AddGroup grants the permission to every member of the group.
+func AddGroup(db *sqlx.DB, groupID int64, permission Permission, item int64, t model.ReferenceType) error {
+	contentType, err := ContentType(t)
+	if err != nil {
+		return err
 	}
-	log.Printf("valid: %d\n", valid)
-	return valid == 1, nil
 
+	_, err = db.Exec(fmt.Sprintf(`
+	INSERT INTO permissions.group_%s(group_id, o_id, type) VALUES($1, $2, $3)
+	ON CONFLICT DO NOTHING;`, table(permission)), groupID, item, contentType)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
 }
 
-func Add(db *sqlx.DB, userRef int64, permission Permission, item int64) error {
-	var err error
-	var stmt *sqlx.Stmt
+// RemoveGroup revokes the group's permission, returning false if it was not
+// granted.
+func RemoveGroup(db *sqlx.DB, groupID int64, permission Permission, item int64, t model.ReferenceType) (bool, error) {
+	contentType, err := ContentType(t)
+	if err != nil {
+		return false, err
+	}
 
-	switch permission {
-	case CanEdit:
-		stmt, err = db.Preparex("INSERT INTO permissions.can_edit(user_id, o_id) VALUES($1, $2);")
-	case CanView:
-		stmt, err = db.Preparex("INSERT INTO permissions.can_view(user_id, o_id) VALUES($1, $2);")
-	case CanDelete:
-		stmt, err = db.Preparex("INSERT INTO permissions.can_delete(user_id, o_id) VALUES($1, $2);")
+	res, err := db.Exec(fmt.Sprintf(`
+	DELETE FROM permissions.group_%s WHERE group_id = $1 AND o_id = $2 AND type = $3;`, table(permission)),
+		groupID, item, contentType)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetPublic makes the item viewable by everyone or only by those it has been
+// shared with.
+func SetPublic(db *sqlx.DB, item int64, t model.ReferenceType, public bool) error {
+	if public {
+		return Add(db, Public, CanView, item, t)
+	}
+	_, err := Remove(db, Public, CanView, item, t)
+	return err
+}
+
+// Grant is a user holding a permission on an item.
+type Grant struct {
+	Permission Permission `db:"permission" json:"permission"`
+	Username   string     `db:"username" json:"username"`
+}
+
+// GroupGrant is a group holding a permission on an item.
+type GroupGrant struct {
+	Permission Permission `db:"permission" json:"permission"`
+	Group      string     `db:"shortcode" json:"group"`
+	Name       string     `db:"name" json:"name"`
+}
+
+// Access describes who can see and change an item.
+type Access struct {
+	Public bool         `json:"public"`
+	Grants []Grant      `json:"grants"`
+	Groups []GroupGrant `json:"groups"`
+}
+
+// List returns every grant on the item.
+func List(db *sqlx.DB, item int64, t model.ReferenceType) (Access, error) {
+	contentType, err := ContentType(t)
+	if err != nil {
+		return Access{}, err
 	}
+
+	access := Access{Grants: []Grant{}, Groups: []GroupGrant{}}
+	err = db.Get(&access.Public, `
+	SELECT count(*) = 1 FROM permissions.can_view WHERE user_id = -1 AND o_id = $1 AND type = $2;`,
+		item, contentType)
 	if err != nil {
 		log.Println(err)
-		return err
+		return Access{}, err
 	}
-	_, err = stmt.Exec(userRef, item)
+
+	err = db.Select(&access.Grants, `
+	SELECT grants.permission, users.username
+	FROM (SELECT 'can_view' AS permission, user_id FROM permissions.can_view WHERE o_id = $1 AND type = $2
+		UNION ALL
+		SELECT 'can_edit', user_id FROM permissions.can_edit WHERE o_id = $1 AND type = $2
+		UNION ALL
+		SELECT 'can_delete', user_id FROM permissions.can_delete WHERE o_id = $1 AND type = $2) AS grants
+		INNER JOIN content.users AS users ON users.id = grants.user_id
+	ORDER BY users.username, grants.permission`, item, contentType)
 	if err != nil {
 		log.Println(err)
-		return err
+		return Access{}, err
 	}
-	return nil
+
+	err = db.Select(&access.Groups, `
+	SELECT grants.permission, groups.shortcode, groups.name
+	FROM (SELECT 'can_view' AS permission, group_id FROM permissions.group_can_view WHERE o_id = $1 AND type = $2
+		UNION ALL
+		SELECT 'can_edit', group_id FROM permissions.group_can_edit WHERE o_id = $1 AND type = $2
+		UNION ALL
+		SELECT 'can_delete', group_id FROM permissions.group_can_delete WHERE o_id = $1 AND type = $2) AS grants
+		INNER JOIN content.groups AS groups ON groups.id = grants.group_id
+	ORDER BY groups.name, grants.permission`, item, contentType)
+	if err != nil {
+		log.Println(err)
+		return Access{}, err
+	}
+	return access, nil
+}
+
+// Owner returns the id of the user who owns the item.
+func Owner(db *sqlx.DB, item int64, t model.ReferenceType) (int64, error) {
+	switch t {
+	case model.Images:
+		var owner int64
+		err := db.Get(&owner, "SELECT user_id FROM content.images WHERE id = $1", item)
+		return owner, err
+	case model.Users:
+		return item, nil
+	case model.Collections:
+		var owner int64
+		err := db.Get(&owner, "SELECT user_id FROM content.collections WHERE id = $1", item)
+		return owner, err
+	}
+	return 0, errors.New("permissions are only stored for images, users and collections")
+}
+
+func table(p Permission) string {
+	switch p {
+	case CanEdit:
+		return "can_edit"
+	case CanDelete:
+		return "can_delete"
+	}
+	return "can_view"
 }
 
-// IsAdmin checks if the given user has admin privileges
+// IsAdmin checks if the given user has the admin role
 func IsAdmin(db *sqlx.DB, id int64) (bool, error) {
-	rows, err := db.Query("SELECT count(*) FROM content.users WHERE id = $1 AND admin = TRUE", id)
+	rows, err := db.Query("SELECT count(*) FROM content.users WHERE id = $1 AND role = 'admin'", id)
 	if err != nil {
 		log.Print(err)
 		return false, err
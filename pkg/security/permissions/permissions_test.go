@@ -0,0 +1,216 @@
+package permissions
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// fake is a database driver that records statements and answers queries for
+// group membership from members, keyed by group and user id, and for
+// permission checks from holds.
+type fake struct {
+	members map[[2]int64]string
+	holds   map[holding]bool
+	execs   []exec
+}
+
+// holding is a user and the permission function that is true for them.
+type holding struct {
+	user int64
+	fn   string
+}
+
+type exec struct {
+	query string
+	args  []driver.Value
+}
+
+func (d *fake) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *fake) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{d, query}, nil
+}
+func (d *fake) Close() error { return nil }
+func (d *fake) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeStmt struct {
+	d     *fake
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.execs = append(s.d.execs, exec{s.query, args})
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	for _, fn := range []string{"permissions.viewable", "permissions.editable", "permissions.deletable"} {
+		if strings.Contains(s.query, fn) {
+			held := s.d.holds[holding{args[0].(int64), fn}]
+			return &rows{values: [][]driver.Value{{held}}}, nil
+		}
+	}
+	if !strings.Contains(s.query, "content.group_members") {
+		return nil, errors.New("unexpected query")
+	}
+	role, ok := s.d.members[[2]int64{args[0].(int64), args[1].(int64)}]
+	if !ok {
+		return &rows{}, nil
+	}
+	return &rows{values: [][]driver.Value{{role}}}, nil
+}
+
+type rows struct {
+	values [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return []string{"role"} }
+func (r *rows) Close() error      { return nil }
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+var db = &fake{}
+
+func init() {
+	sql.Register("permissions-fake", db)
+}
+
+func TestGrantGroup(t *testing.T) {
+	conn, err := sqlx.Open("permissions-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	group := model.Ref{Id: 7, Collection: model.Groups}
+	db.members = map[[2]int64]string{{7, 1}: "member", {7, 2}: "owner", {7, 4}: "member"}
+	db.holds = map[holding]bool{
+		{1, "permissions.viewable"}: true,
+		{2, "permissions.viewable"}: true,
+		{3, "permissions.viewable"}: true,
+	}
+
+	tests := []struct {
+		caller int64
+		code   int
+	}{
+		{1, 0},
+		{2, 0},
+		{3, http.StatusForbidden},
+		{4, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		db.execs = nil
+		caller := model.Ref{Id: test.caller, Collection: model.Users}
+		err := grantGroup(conn, caller, group, CanView, 42, model.Images)
+
+		if test.code == 0 {
+			if err != nil {
+				t.Errorf("user %d: expected the grant, got %s", test.caller, err)
+				continue
+			}
+			if len(db.execs) != 1 || !strings.Contains(db.execs[0].query, "permissions.group_can_view") {
+				t.Fatalf("user %d: expected a grant to group_can_view, got %v", test.caller, db.execs)
+			}
+			args := db.execs[0].args
+			if args[0] != int64(7) || args[1] != int64(42) || args[2] != "image" {
+				t.Errorf("user %d: expected the group, image and type to be bound, got %v", test.caller, args)
+			}
+			continue
+		}
+
+		e, ok := err.(handler.StatusError)
+		if !ok || e.Code != test.code {
+			t.Errorf("user %d: expected status %d, got %v", test.caller, test.code, err)
+		}
+		if len(db.execs) != 0 {
+			t.Errorf("user %d: expected nothing to be granted, got %v", test.caller, db.execs)
+		}
+	}
+}
+
+func TestGrant(t *testing.T) {
+	conn, err := sqlx.Open("permissions-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A collaborator who can edit a collection but not delete it.
+	caller := model.Ref{Id: 1, Collection: model.Users}
+	user := model.Ref{Id: 9, Collection: model.Users}
+	db.holds = map[holding]bool{
+		{1, "permissions.viewable"}: true,
+		{1, "permissions.editable"}: true,
+	}
+
+	tests := []struct {
+		p    Permission
+		code int
+	}{
+		{CanView, 0},
+		{CanEdit, 0},
+		{CanDelete, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		db.execs = nil
+		err := grant(conn, caller, user, test.p, 42, model.Collections)
+
+		if test.code == 0 {
+			if err != nil {
+				t.Errorf("%s: expected the grant, got %s", test.p, err)
+				continue
+			}
+			if len(db.execs) != 1 || !strings.Contains(db.execs[0].query, table(test.p)) {
+				t.Fatalf("%s: expected a grant to %s, got %v", test.p, table(test.p), db.execs)
+			}
+			args := db.execs[0].args
+			if args[0] != int64(9) || args[1] != int64(42) || args[2] != "collection" {
+				t.Errorf("%s: expected the user, collection and type to be bound, got %v", test.p, args)
+			}
+			continue
+		}
+
+		e, ok := err.(handler.StatusError)
+		if !ok || e.Code != test.code {
+			t.Errorf("%s: expected status %d, got %v", test.p, test.code, err)
+		}
+		if len(db.execs) != 0 {
+			t.Errorf("%s: expected nothing to be granted, got %v", test.p, db.execs)
+		}
+	}
+}
+
+func TestRemoveGroup(t *testing.T) {
+	conn, err := sqlx.Open("permissions-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	db.execs = nil
+	removed, err := RemoveGroup(conn, 7, CanEdit, 42, model.Images)
+	if err != nil || !removed {
+		t.Fatalf("Expected the permission to be removed, got %t %v", removed, err)
+	}
+	if len(db.execs) != 1 || !strings.Contains(db.execs[0].query, "DELETE FROM permissions.group_can_edit") {
+		t.Errorf("Expected a delete from group_can_edit, got %v", db.execs)
+	}
+}
@@ -0,0 +1,61 @@
+package roles
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/gorilla/context"
+)
+
+type Middleware struct {
+	*handler.State
+	C Capability
+	M func(state *handler.State, c Capability, next http.Handler) http.Handler
+}
+
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return m.M(m.State, m.C, next)
+}
+
+// CapabilityMiddle rejects requests from users whose role lacks the given
+// capability. It has to run after security.Authenticate and sets the user's
+// role on the request.
+func CapabilityMiddle(state *handler.State, c Capability, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := context.Get(r, "auth").(model.Ref)
+		if !ok {
+			log.Println("User not set")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		role, err := Get(state.DB, user.Id)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		if !role.Can(c) {
+			w.WriteHeader(http.StatusForbidden)
+			j, _ := json.Marshal(map[string]interface{}{
+				"code": http.StatusForbidden,
+				"err":  fmt.Sprintf("The %s role does not allow %s", role, c),
+			})
+			w.Write(j)
+			return
+		}
+
+		context.Set(r, "role", role)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Current returns the role set by CapabilityMiddle.
+func Current(r *http.Request) Role {
+	role, _ := context.Get(r, "role").(Role)
+	return role
+}
@@ -0,0 +1,132 @@
+package roles
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Role string
+
+const (
+	User      = Role("user")
+	Curator   = Role("curator")
+	Moderator = Role("moderator")
+	Admin     = Role("admin")
+)
+
+type Capability string
+
+const (
+	// FeatureContent allows featuring images and users.
+	FeatureContent = Capability("feature_content")
+	// SuspendUsers allows suspending accounts ranked below your own.
+	SuspendUsers = Capability("suspend_users")
+	// ViewStats allows reading the system stats.
+	ViewStats = Capability("view_stats")
+	// ViewAudit allows reading the audit trail.
+	ViewAudit = Capability("view_audit")
+	// ManageRoles allows promoting and demoting users.
+	ManageRoles = Capability("manage_roles")
+	// ModerateComments allows deleting anyone's comments.
+	ModerateComments = Capability("moderate_comments")
+	// ReviewReports allows working the report queue and taking reported
+	// content down.
+	ReviewReports = Capability("review_reports")
+	// ManageWebhooks allows registering webhooks that receive every event.
+	ManageWebhooks = Capability("manage_webhooks")
+	// RestoreUsers allows taking deleted accounts back out of the trash.
+	RestoreUsers = Capability("restore_users")
+)
+
+var rank = map[Role]int{User: 0, Curator: 1, Moderator: 2, Admin: 3}
+
+var capabilities = map[Role][]Capability{
+	Curator:   {FeatureContent},
+	Moderator: {FeatureContent, SuspendUsers, ViewStats, ViewAudit, ModerateComments, ReviewReports},
+	Admin:     {FeatureContent, SuspendUsers, ViewStats, ViewAudit, ModerateComments, ReviewReports, ManageRoles, ManageWebhooks, RestoreUsers},
+}
+
+// ErrLastAdmin is returned when a change would leave no admins.
+var ErrLastAdmin = errors.New("there must be at least one admin")
+
+// Parse returns the role with the given name.
+func Parse(name string) (Role, error) {
+	r := Role(name)
+	if _, ok := rank[r]; !ok {
+		return "", fmt.Errorf("unknown role %s", name)
+	}
+	return r, nil
+}
+
+// Can reports whether the role carries the capability.
+func (r Role) Can(c Capability) bool {
+	for _, v := range capabilities[r] {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Outranks reports whether r is ranked strictly above o.
+func (r Role) Outranks(o Role) bool {
+	return rank[r] > rank[o]
+}
+
+// Get returns the role of the given user.
+func Get(db *sqlx.DB, id int64) (Role, error) {
+	var role Role
+	err := db.Get(&role, "SELECT role FROM content.users WHERE id = $1", id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+// Set changes the role of the given user, refusing to demote the last admin.
+// The admins are locked first, so two admins demoting each other at once
+// can't both see the other as the admin that remains.
+func Set(db *sqlx.DB, id int64, role Role) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = tx.Exec("SELECT id FROM content.users WHERE role = 'admin' FOR UPDATE")
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+
+	res, err := tx.Exec(`
+	UPDATE content.users SET role = $2, last_modified = CURRENT_TIMESTAMP
+	WHERE id = $1 AND ($2 = 'admin' OR EXISTS(SELECT 1
+	                                         FROM content.users
+	                                         WHERE role = 'admin' AND id <> $1))`, id, string(role))
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return ErrLastAdmin
+	}
+	return tx.Commit()
+}
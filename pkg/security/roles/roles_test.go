@@ -0,0 +1,127 @@
+package roles
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func TestOutranks(t *testing.T) {
+	order := []Role{User, Curator, Moderator, Admin}
+	for i, r := range order {
+		for j, o := range order {
+			if expected := i > j; r.Outranks(o) != expected {
+				t.Errorf("%s outranks %s: expected %t", r, o, expected)
+			}
+		}
+	}
+	if User.Outranks(Role("unknown")) {
+		t.Error("Expected unknown roles to rank with users")
+	}
+}
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		capability Capability
+		roles      []Role
+	}{
+		{FeatureContent, []Role{Curator, Moderator, Admin}},
+		{SuspendUsers, []Role{Moderator, Admin}},
+		{ViewStats, []Role{Moderator, Admin}},
+		{ViewAudit, []Role{Moderator, Admin}},
+		{ModerateComments, []Role{Moderator, Admin}},
+		{ReviewReports, []Role{Moderator, Admin}},
+		{ManageRoles, []Role{Admin}},
+		{ManageWebhooks, []Role{Admin}},
+		{RestoreUsers, []Role{Admin}},
+	}
+
+	for _, test := range tests {
+		allowed := map[Role]bool{}
+		for _, r := range test.roles {
+			allowed[r] = true
+		}
+		for _, r := range []Role{User, Curator, Moderator, Admin} {
+			if r.Can(test.capability) != allowed[r] {
+				t.Errorf("%s can %s: expected %t", r, test.capability, allowed[r])
+			}
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, name := range []string{"user", "curator", "moderator", "admin"} {
+		if r, err := Parse(name); err != nil || string(r) != name {
+			t.Errorf("Expected %s to parse, got %v %v", name, r, err)
+		}
+	}
+	if _, err := Parse("owner"); err == nil {
+		t.Error("Expected unknown roles to be refused")
+	}
+}
+
+// updates is a database driver that records updates and reports the given
+// number of rows as changed, standing in for the last admin guard.
+type updates struct {
+	affected int64
+	queries  []string
+	query    string
+	args     []driver.Value
+}
+
+func (d *updates) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *updates) Prepare(query string) (driver.Stmt, error) {
+	d.query = query
+	d.queries = append(d.queries, query)
+	return d, nil
+}
+func (d *updates) Close() error              { return nil }
+func (d *updates) NumInput() int             { return -1 }
+func (d *updates) Begin() (driver.Tx, error) { return d, nil }
+func (d *updates) Commit() error             { return nil }
+func (d *updates) Rollback() error           { return nil }
+func (d *updates) Exec(args []driver.Value) (driver.Result, error) {
+	d.args = args
+	return driver.RowsAffected(d.affected), nil
+}
+func (d *updates) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+var fake = &updates{}
+
+func init() {
+	sql.Register("roles-updates", fake)
+}
+
+func TestSetLastAdmin(t *testing.T) {
+	db, err := sqlx.Open("roles-updates", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fake.affected = 0
+	fake.queries = nil
+	if err := Set(db, 1, Moderator); err != ErrLastAdmin {
+		t.Errorf("Expected demoting the last admin to fail, got %v", err)
+	}
+	if len(fake.queries) != 2 || !strings.Contains(fake.queries[0], "role = 'admin' FOR UPDATE") {
+		t.Errorf("Expected the admins to be locked before the update, got %q", fake.queries)
+	}
+	if !strings.Contains(fake.query, "role = 'admin' AND id <> $1") {
+		t.Errorf("Expected the update to require another admin, got %q", fake.query)
+	}
+	if len(fake.args) != 2 || fake.args[0] != int64(1) || fake.args[1] != "moderator" {
+		t.Errorf("Expected the user and role to be bound, got %v", fake.args)
+	}
+
+	fake.affected = 1
+	if err := Set(db, 1, Moderator); err != nil {
+		t.Errorf("Expected demoting an admin to succeed when another remains, got %v", err)
+	}
+}
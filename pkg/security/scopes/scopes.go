@@ -0,0 +1,85 @@
+package scopes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"encoding/json"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/gorilla/context"
+)
+
+type Scope string
+
+const (
+	Read   = Scope("read")
+	Upload = Scope("upload")
+	Edit   = Scope("edit")
+	Delete = Scope("delete")
+	Social = Scope("social")
+)
+
+// All is the set of scopes granted to browser sessions.
+var All = []Scope{Read, Upload, Edit, Delete, Social}
+
+// Parse validates the given scope names and returns them as Scopes.
+func Parse(names []string) ([]Scope, error) {
+	parsed := []Scope{}
+	for _, name := range names {
+		s := Scope(name)
+		if !Contains(All, s) {
+			return []Scope{}, fmt.Errorf("Invalid scope: %s", name)
+		}
+		if !Contains(parsed, s) {
+			parsed = append(parsed, s)
+		}
+	}
+	return parsed, nil
+}
+
+// Contains checks if s is in the given set of scopes.
+func Contains(set []Scope, s Scope) bool {
+	for _, v := range set {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type Middleware struct {
+	*handler.State
+	S Scope
+	M func(state *handler.State, s Scope, next http.Handler) http.Handler
+}
+
+func (m Middleware) Handler(next http.Handler) http.Handler {
+	return m.M(m.State, m.S, next)
+}
+
+// ScopeMiddle rejects requests whose credentials were not granted the given
+// scope. It has to run after security.Authenticate has set the request scopes.
+func ScopeMiddle(state *handler.State, s Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		val, ok := context.GetOk(r, "scopes")
+		if !ok {
+			log.Println("Scopes not set")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		granted, ok := val.([]Scope)
+		if !ok || !Contains(granted, s) {
+			w.WriteHeader(http.StatusForbidden)
+			j, _ := json.Marshal(map[string]interface{}{
+				"code": http.StatusForbidden,
+				"err":  fmt.Sprintf("Credentials are missing the %s scope", s),
+			})
+			w.Write(j)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
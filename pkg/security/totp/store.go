@@ -0,0 +1,229 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// RecoveryCodes is how many recovery codes are issued at a time.
+const RecoveryCodes = 10
+
+var (
+	ErrEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrNotEnrolled = errors.New("two-factor authentication is not enabled")
+	ErrInvalidCode = errors.New("invalid code")
+)
+
+// Enabled reports whether the user has confirmed two-factor authentication.
+func Enabled(db *sqlx.DB, userID int64) (bool, error) {
+	var enabled bool
+	err := db.Get(&enabled, "SELECT enabled FROM permissions.totp WHERE user_id = $1", userID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return enabled, nil
+}
+
+// Enroll stores a new secret for the user. It has no effect on login until it
+// is confirmed with Enable.
+func Enroll(db *sqlx.DB, userID int64) (string, error) {
+	enabled, err := Enabled(db, userID)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return "", ErrEnabled
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+
+	_, err = db.Exec(`
+	INSERT INTO permissions.totp(user_id, secret) VALUES ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at`,
+		userID, secret)
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	return secret, nil
+}
+
+// Enable turns on two-factor authentication once the user proves they have
+// enrolled the secret, returning their recovery codes.
+func Enable(db *sqlx.DB, userID int64, code string) ([]string, error) {
+	var secret string
+	err := db.Get(&secret, "SELECT secret FROM permissions.totp WHERE user_id = $1 AND NOT enabled", userID)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotEnrolled
+	} else if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	step, ok := Validate(secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE permissions.totp SET enabled = TRUE, last_step = $2, enabled_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1`, userID, step)
+	if err != nil {
+		log.Println(err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Disable removes the user's secret and recovery codes.
+func Disable(db *sqlx.DB, userID int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, q := range []string{
+		"DELETE FROM permissions.totp_recovery_codes WHERE user_id = $1",
+		"DELETE FROM permissions.totp WHERE user_id = $1",
+	} {
+		if _, err := tx.Exec(q, userID); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Check verifies a code from the user's authenticator or one of their
+// recovery codes, which can only be used once. Each authenticator code is
+// also only accepted once. It reports whether a recovery code was used.
+func Check(db *sqlx.DB, userID int64, code string) (bool, error) {
+	var secret string
+	err := db.Get(&secret, "SELECT secret FROM permissions.totp WHERE user_id = $1 AND enabled", userID)
+	if err == sql.ErrNoRows {
+		return false, ErrNotEnrolled
+	} else if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	if step, ok := Validate(secret, code, time.Now()); ok {
+		res, err := db.Exec(`
+		UPDATE permissions.totp SET last_step = $2
+		WHERE user_id = $1 AND last_step < $2`, userID, step)
+		if err != nil {
+			log.Println(err)
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return false, ErrInvalidCode
+		}
+		return false, nil
+	}
+
+	res, err := db.Exec(`
+	UPDATE permissions.totp_recovery_codes SET used_at = CURRENT_TIMESTAMP
+	WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`, userID, hashRecoveryCode(code))
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, ErrInvalidCode
+	}
+	return true, nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes.
+func RegenerateRecoveryCodes(db *sqlx.DB, userID int64) ([]string, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+func replaceRecoveryCodes(tx *sqlx.Tx, userID int64) ([]string, error) {
+	_, err := tx.Exec("DELETE FROM permissions.totp_recovery_codes WHERE user_id = $1", userID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	codes := make([]string, RecoveryCodes)
+	for i := range codes {
+		codes[i], err = newRecoveryCode()
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		_, err = tx.Exec(`
+		INSERT INTO permissions.totp_recovery_codes(user_id, hash) VALUES ($1, $2)`,
+			userID, hashRecoveryCode(codes[i]))
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns a code such as 7hq2k-mc4xa.
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(encoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes so codes can be typed in
+// loosely.
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
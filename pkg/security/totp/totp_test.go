@@ -0,0 +1,69 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 appendix B, for SHA1.
+func TestHOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, test := range tests {
+		step := Step(time.Unix(test.unix, 0))
+		if code := hotp(key, uint64(step), 8); code != test.code {
+			t.Errorf("at %d expected %s, got %s", test.unix, test.code, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1500000000, 0)
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step, ok := Validate(secret, code, now.Add(Period)); !ok || step != Step(now) {
+		t.Errorf("expected the code to be accepted one period later at step %d, got %d %v", Step(now), step, ok)
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Error("expected the code to expire")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Error("expected short codes to be refused")
+	}
+	if _, ok := Validate(strings.ToLower(secret), code, now); !ok {
+		t.Error("expected lower case secrets to be accepted")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := newRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != 11 || code[5] != '-' {
+		t.Errorf("unexpected recovery code %s", code)
+	}
+	loose := strings.ToUpper(strings.Replace(code, "-", " ", 1))
+	if hashRecoveryCode(loose) != hashRecoveryCode(code) {
+		t.Error("expected recovery codes to ignore case and separators")
+	}
+}
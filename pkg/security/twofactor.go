@@ -0,0 +1,222 @@
+package security
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/fokal/fokal-core/pkg/security/totp"
+	"github.com/fokal/fokal-core/pkg/tokens"
+	"github.com/mholt/binding"
+)
+
+// login starts a session for a user who has signed in, or issues a challenge
+// if they have two-factor authentication enabled.
+func login(state *handler.State, r *http.Request, user model.Ref, email string, detail map[string]string) (handler.Response, error) {
+	enabled, err := totp.Enabled(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to log in")}
+	}
+
+	if enabled {
+		challenge, err := tokens.Challenge(state, user, email)
+		if err != nil {
+			return handler.Response{}, err
+		}
+		return handler.Response{Code: http.StatusOK, Data: map[string]interface{}{
+			"challenge":  challenge,
+			"expires_in": int(tokens.ChallengeLifetime.Seconds()),
+		}}, nil
+	}
+
+	token, err := tokens.Create(state, r, user, email)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	audit.RecordAs(state.DB, r, user, "auth.login", user, nil, detail)
+	return handler.Response{Code: http.StatusOK, Data: map[string]string{"token": token}}, nil
+}
+
+// ChallengeHandler exchanges a login challenge and a code from the user's
+// authenticator, or one of their recovery codes, for a token.
+func ChallengeHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	req := new(request.ChallengeRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	challenge, err := tokens.VerifyChallenge(state, req.Challenge)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if err := active(state, challenge.User); err != nil {
+		return handler.Response{}, err
+	}
+
+	recovery, err := check(state, w, challenge.User, req.Code)
+	if err == totp.ErrInvalidCode || err == totp.ErrNotEnrolled {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Invalid code")}
+	} else if _, ok := err.(handler.StatusError); ok {
+		return handler.Response{}, err
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to verify code")}
+	}
+
+	token, err := tokens.CompleteChallenge(state, r, challenge)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	method := "totp"
+	if recovery {
+		method = "recovery_code"
+	}
+	audit.RecordAs(state.DB, r, challenge.User, "auth.login", challenge.User, nil, map[string]string{"mfa": method})
+	return handler.Response{Code: http.StatusOK, Data: map[string]string{"token": token}}, nil
+}
+
+// EnrollTOTPHandler creates a new secret for the logged in user. Two-factor
+// authentication is only enabled once a code from it is verified.
+func EnrollTOTPHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	secret, err := totp.Enroll(state.DB, user.Id)
+	if err == totp.ErrEnabled {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("Two-factor authentication is already enabled")}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to enroll")}
+	}
+
+	return handler.Response{Code: http.StatusCreated, Data: map[string]string{
+		"secret": secret,
+		"uri":    totp.URI(secret, user.Shortcode),
+	}}, nil
+}
+
+// VerifyTOTPHandler enables two-factor authentication for the logged in user
+// and returns their recovery codes, which are not shown again.
+func VerifyTOTPHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.TOTPCodeRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	codes, err := totp.Enable(state.DB, user.Id, req.Code)
+	switch err {
+	case nil:
+	case totp.ErrNotEnrolled:
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No pending enrollment")}
+	case totp.ErrInvalidCode:
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid code")}
+	default:
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to enable two-factor authentication")}
+	}
+
+	audit.Record(state.DB, r, "totp.enable", user, nil, nil)
+	return handler.Response{Code: http.StatusOK, Data: map[string][]string{"recovery_codes": codes}}, nil
+}
+
+// check verifies a second factor code, refusing to once the user has entered
+// too many wrong ones.
+func check(state *handler.State, w http.ResponseWriter, user model.Ref, code string) (bool, error) {
+	locked, err := tokens.SecondFactorLocked(state.RD, user.Id)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	if locked > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.Seconds()))))
+		return false, handler.StatusError{Code: http.StatusTooManyRequests, Err: errors.New("Too many wrong codes, please try again later")}
+	}
+
+	recovery, err := totp.Check(state.DB, user.Id, code)
+	switch err {
+	case nil:
+		err = tokens.SecondFactorPassed(state.RD, user.Id)
+		if err != nil {
+			log.Println(err)
+		}
+		return recovery, nil
+	case totp.ErrInvalidCode:
+		if err := tokens.SecondFactorFailed(state.RD, user.Id); err != nil {
+			log.Println(err)
+		}
+	}
+	return recovery, err
+}
+
+// confirm checks a code from the logged in user before changing their second
+// factor.
+func confirm(state *handler.State, w http.ResponseWriter, r *http.Request, user model.Ref) error {
+	req := new(request.TOTPCodeRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	_, err := check(state, w, user, req.Code)
+	if _, ok := err.(handler.StatusError); ok {
+		return err
+	}
+	switch err {
+	case nil:
+		return nil
+	case totp.ErrNotEnrolled:
+		return handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Two-factor authentication is not enabled")}
+	case totp.ErrInvalidCode:
+		return handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Invalid code")}
+	default:
+		return handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to verify code")}
+	}
+}
+
+func DisableTOTPHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if err := confirm(state, w, r, user); err != nil {
+		return handler.Response{}, err
+	}
+
+	err = totp.Disable(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to disable two-factor authentication")}
+	}
+
+	audit.Record(state.DB, r, "totp.disable", user, nil, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
+
+// RecoveryCodesHandler replaces the logged in user's recovery codes.
+func RecoveryCodesHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, _, err := sessionClaims(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+	if err := confirm(state, w, r, user); err != nil {
+		return handler.Response{}, err
+	}
+
+	codes, err := totp.RegenerateRecoveryCodes(state.DB, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create recovery codes")}
+	}
+
+	audit.Record(state.DB, r, "totp.recovery_codes", user, nil, nil)
+	return handler.Response{Code: http.StatusOK, Data: map[string][]string{"recovery_codes": codes}}, nil
+}
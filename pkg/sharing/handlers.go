@@ -0,0 +1,124 @@
+package sharing
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+func image(state *handler.State, r *http.Request) (model.Ref, error) {
+	ref := model.Ref{Collection: model.Images, Shortcode: mux.Vars(r)["ID"]}
+	err := state.DB.Get(&ref.Id, "SELECT id FROM content.images WHERE shortcode = $1 AND deleted_at IS NULL", ref.Shortcode)
+	if err != nil {
+		return model.Ref{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found")}
+	}
+	return ref, nil
+}
+
+func CreateHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized}
+	}
+
+	ref, err := image(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	req := new(request.CreateShareLinkRequest)
+	if errs := binding.Bind(r, req); errs != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+	}
+
+	expiresAt := time.Now().Add(DefaultLifetime)
+	if req.ExpiresAt != nil {
+		expiresAt = *req.ExpiresAt
+	}
+	if !expiresAt.After(time.Now()) {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("expires_at must be in the future")}
+	}
+	if req.MaxViews != nil && *req.MaxViews < 1 {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("max_views must be positive")}
+	}
+
+	link, err := Create(state, user.Id, ref, expiresAt, req.MaxViews, req.AllowDownload)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create share link")}
+	}
+
+	// The url carries the token and stays out of the audit trail.
+	audit.Record(state.DB, r, "share_link.create", ref, nil, map[string]interface{}{
+		"link":           link.Id,
+		"allow_download": link.AllowDownload,
+		"max_views":      link.MaxViews,
+		"expires_at":     link.ExpiresAt,
+	})
+	return handler.Response{Code: http.StatusCreated, Data: link}, nil
+}
+
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	ref, err := image(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	links, err := List(state, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve share links")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: links}, nil
+}
+
+func UserLinksHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ok := context.Get(r, "auth").(model.Ref)
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusUnauthorized}
+	}
+
+	links, err := ForUser(state, user.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve share links")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: links}, nil
+}
+
+func RevokeHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	ref, err := image(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	linkID, err := strconv.ParseInt(mux.Vars(r)["link"], 10, 64)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Share link not found")}
+	}
+
+	revoked, err := Revoke(state.DB, ref.Id, linkID)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to revoke share link")}
+	}
+	if !revoked {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Share link not found")}
+	}
+
+	audit.Record(state.DB, r, "share_link.revoke", ref,
+		map[string]interface{}{"link": linkID, "revoked": false},
+		map[string]interface{}{"link": linkID, "revoked": true})
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
+
+// Shared returns the link the request was authorized with, if any.
+func Shared(r *http.Request) (Link, bool) {
+	link, ok := context.Get(r, "share").(Link)
+	return link, ok
+}
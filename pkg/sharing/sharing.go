@@ -0,0 +1,190 @@
+package sharing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultLifetime is how long links last when no expiry is given.
+const DefaultLifetime = time.Hour * 24 * 7
+
+var ErrInvalid = errors.New("Share link is invalid or has expired")
+
+// Link grants view access to a single image without an account.
+type Link struct {
+	Id            int64     `db:"id" json:"id"`
+	ImageId       int64     `db:"image_id" json:"-"`
+	Image         string    `db:"shortcode" json:"image"`
+	UserId        int64     `db:"user_id" json:"-"`
+	AllowDownload bool      `db:"allow_download" json:"allow_download"`
+	MaxViews      *int      `db:"max_views" json:"max_views,omitempty"`
+	Views         int       `db:"views" json:"views"`
+	ExpiresAt     time.Time `db:"expires_at" json:"expires_at"`
+	Revoked       bool      `db:"revoked" json:"revoked"`
+	CreatedAt     time.Time `db:"created_at" json:"created_at"`
+
+	URL string `db:"-" json:"url,omitempty"`
+}
+
+const selectLinks = `
+	SELECT links.id, links.image_id, images.shortcode, links.user_id, links.allow_download,
+		links.max_views, links.views, links.expires_at, links.revoked, links.created_at
+	FROM permissions.share_links AS links
+		INNER JOIN content.images AS images ON images.id = links.image_id`
+
+// Create stores a new link for the image and returns it with its url.
+func Create(state *handler.State, userID int64, image model.Ref, expiresAt time.Time, maxViews *int, allowDownload bool) (Link, error) {
+	var id int64
+	err := state.DB.Get(&id, `
+	INSERT INTO permissions.share_links(image_id, user_id, allow_download, max_views, expires_at)
+	VALUES ($1, $2, $3, $4, $5) RETURNING id;`, image.Id, userID, allowDownload, maxViews, expiresAt)
+	if err != nil {
+		log.Println(err)
+		return Link{}, err
+	}
+
+	link := Link{}
+	err = state.DB.Get(&link, selectLinks+" WHERE links.id = $1", id)
+	if err != nil {
+		log.Println(err)
+		return Link{}, err
+	}
+	return link, sign(state, &link)
+}
+
+// sign sets the link's url. Its token is an HMAC of the link and image ids
+// under the share secret, which unlike the signing keys is never rotated, so
+// links keep working for as long as they are active. Expiry, views and
+// revocation are checked against the stored link.
+func sign(state *handler.State, link *Link) error {
+	if len(state.ShareSecret) == 0 {
+		return errors.New("Share secret is not set")
+	}
+
+	ref := model.Ref{Id: link.ImageId, Collection: model.Images, Shortcode: link.Image}
+	link.URL = fmt.Sprintf("%s?share=%s", ref.ToURL(state.Port, state.Local), token(state.ShareSecret, link.ImageId, link.Id))
+	return nil
+}
+
+func token(secret []byte, imageID, linkID int64) string {
+	return fmt.Sprintf("%d.%s", linkID, base64.RawURLEncoding.EncodeToString(mac(secret, imageID, linkID)))
+}
+
+func mac(secret []byte, imageID, linkID int64) []byte {
+	h := hmac.New(sha256.New, secret)
+	fmt.Fprintf(h, "share:%d:%d", imageID, linkID)
+	return h.Sum(nil)
+}
+
+// parse returns the link id in a share token for the image if its HMAC is
+// valid.
+func parse(secret []byte, raw string, imageID int64) (int64, bool) {
+	parts := strings.SplitN(raw, ".", 2)
+	if len(parts) != 2 || len(secret) == 0 {
+		return 0, false
+	}
+	lid, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sum, mac(secret, imageID, lid)) {
+		return 0, false
+	}
+	return lid, true
+}
+
+// List returns the links for the image, with urls for those still active.
+func List(state *handler.State, imageID int64) ([]Link, error) {
+	return list(state, " WHERE links.image_id = $1 ORDER BY links.created_at DESC", imageID)
+}
+
+// ForUser returns every link the user has created.
+func ForUser(state *handler.State, userID int64) ([]Link, error) {
+	return list(state, " WHERE links.user_id = $1 ORDER BY links.created_at DESC", userID)
+}
+
+func list(state *handler.State, where string, arg int64) ([]Link, error) {
+	links := []Link{}
+	err := state.DB.Select(&links, selectLinks+where, arg)
+	if err != nil {
+		log.Println(err)
+		return []Link{}, err
+	}
+
+	for i := range links {
+		if links[i].active() {
+			if err := sign(state, &links[i]); err != nil {
+				return []Link{}, err
+			}
+		}
+	}
+	return links, nil
+}
+
+func (l Link) active() bool {
+	return !l.Revoked && l.ExpiresAt.After(time.Now()) && (l.MaxViews == nil || l.Views < *l.MaxViews)
+}
+
+// Revoke disables the link, returning false if the image has no such link.
+func Revoke(db *sqlx.DB, imageID, linkID int64) (bool, error) {
+	res, err := db.Exec(`
+	UPDATE permissions.share_links SET revoked = TRUE
+	WHERE id = $1 AND image_id = $2`, linkID, imageID)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Verify checks a share token grants access to the image. Views are counted
+// against the link's limit when countView is set.
+func Verify(state *handler.State, raw string, imageID int64, countView bool) (Link, error) {
+	lid, ok := parse(state.ShareSecret, raw, imageID)
+	if !ok {
+		return Link{}, ErrInvalid
+	}
+
+	active := `
+	links.id = $1 AND links.image_id = $2 AND NOT links.revoked AND links.expires_at > now()
+	AND (links.max_views IS NULL OR links.views < links.max_views)
+	AND NOT permissions.deleted(links.image_id, 'image')
+	AND NOT permissions.taken_down(links.image_id, 'image')`
+
+	var err error
+	link := Link{}
+	if countView {
+		err = state.DB.Get(&link, `
+		WITH links AS (
+			UPDATE permissions.share_links AS links SET views = views + 1
+			WHERE `+active+`
+			RETURNING links.*)
+		SELECT links.id, links.image_id, images.shortcode, links.user_id, links.allow_download,
+			links.max_views, links.views, links.expires_at, links.revoked, links.created_at
+		FROM links INNER JOIN content.images AS images ON images.id = links.image_id`, lid, imageID)
+	} else {
+		err = state.DB.Get(&link, selectLinks+" WHERE "+active, lid, imageID)
+	}
+	if err == sql.ErrNoRows {
+		return Link{}, ErrInvalid
+	} else if err != nil {
+		log.Println(err)
+		return Link{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return link, nil
+}
@@ -0,0 +1,29 @@
+package sharing
+
+import "testing"
+
+func TestToken(t *testing.T) {
+	secret := []byte("secret")
+	raw := token(secret, 42, 7)
+
+	if lid, ok := parse(secret, raw, 42); !ok || lid != 7 {
+		t.Errorf("Expected link 7, got %d %t", lid, ok)
+	}
+
+	for name, test := range map[string]struct {
+		secret []byte
+		raw    string
+		image  int64
+	}{
+		"other image":  {secret, raw, 43},
+		"other secret": {[]byte("other"), raw, 42},
+		"no secret":    {nil, raw, 42},
+		"other link":   {secret, "8" + raw[1:], 42},
+		"no mac":       {secret, "7", 42},
+		"malformed":    {secret, "7.%%%", 42},
+	} {
+		if _, ok := parse(test.secret, test.raw, test.image); ok {
+			t.Errorf("%s: expected the token to be refused", name)
+		}
+	}
+}
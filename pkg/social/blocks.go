@@ -0,0 +1,105 @@
+package social
+
+import (
+	"log"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+)
+
+// Block stops a and b from following each other, favoriting each other's
+// images or commenting on them. Existing follows and favorites between them
+// are removed.
+func Block(db *sqlx.DB, a, b int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, q := range []string{`
+	INSERT INTO content.user_blocks (user_id, blocked_id) VALUES ($1, $2)
+	ON CONFLICT (user_id, blocked_id) DO NOTHING`, `
+	DELETE FROM content.user_follows
+	WHERE (user_id = $1 AND followed_id = $2) OR (user_id = $2 AND followed_id = $1)`, `
+	DELETE FROM content.user_favorites AS favs
+	USING content.images AS images
+	WHERE images.id = favs.image_id
+		AND ((favs.user_id = $1 AND images.user_id = $2) OR (favs.user_id = $2 AND images.user_id = $1))`,
+	} {
+		if _, err = tx.Exec(q, a, b); err != nil {
+			log.Println(err)
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func UnBlock(db *sqlx.DB, a, b int64) error {
+	stmt, err := db.Preparex("DELETE FROM content.user_blocks WHERE user_id = $1 AND blocked_id = $2")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return modify(db, stmt, a, b)
+}
+
+// Mute hides b from a's feed and notifications.
+func Mute(db *sqlx.DB, a, b int64) error {
+	stmt, err := db.Preparex(`
+	INSERT INTO content.user_mutes (user_id, muted_id) VALUES ($1, $2)
+	ON CONFLICT (user_id, muted_id) DO NOTHING`)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return modify(db, stmt, a, b)
+}
+
+func UnMute(db *sqlx.DB, a, b int64) error {
+	stmt, err := db.Preparex("DELETE FROM content.user_mutes WHERE user_id = $1 AND muted_id = $2")
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	return modify(db, stmt, a, b)
+}
+
+// Blocked reports whether the user and the target, or the target's owner for
+// images, have blocked each other.
+func Blocked(db *sqlx.DB, user int64, target model.Ref) (bool, error) {
+	var blocked bool
+	var err error
+	switch target.Collection {
+	case model.Images:
+		err = db.Get(&blocked, "SELECT permissions.blocked($1, user_id) FROM content.images WHERE id = $2", user, target.Id)
+	default:
+		err = db.Get(&blocked, "SELECT permissions.blocked($1, $2)", user, target.Id)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	return blocked, err
+}
+
+// Blocks returns the users u has blocked, most recent first. Only u can see
+// them.
+func Blocks(state *handler.State, viewer, u int64, limit, offset int) ([]model.User, error) {
+	return follows(state, `
+	SELECT blocked_id FROM content.user_blocks
+	WHERE user_id = $1 AND $2 = $1
+	ORDER BY created_at DESC, blocked_id
+	LIMIT $3 OFFSET $4`, viewer, u, limit, offset)
+}
+
+// Mutes returns the users u has muted, most recent first. Only u can see
+// them.
+func Mutes(state *handler.State, viewer, u int64, limit, offset int) ([]model.User, error) {
+	return follows(state, `
+	SELECT muted_id FROM content.user_mutes
+	WHERE user_id = $1 AND $2 = $1
+	ORDER BY created_at DESC, muted_id
+	LIMIT $3 OFFSET $4`, viewer, u, limit, offset)
+}
@@ -0,0 +1,39 @@
+package social
+
+import (
+	"log"
+
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+)
+
+// Followers returns the users following u that the viewer can see, most recent
+// first.
+func Followers(state *handler.State, viewer, u int64, limit, offset int) ([]model.User, error) {
+	return follows(state, `
+	SELECT user_id FROM content.user_follows
+	WHERE followed_id = $1 AND permissions.viewable($2, user_id, 'user')
+	ORDER BY created_at DESC, user_id
+	LIMIT $3 OFFSET $4`, viewer, u, limit, offset)
+}
+
+// Following returns the users u follows that the viewer can see, most recent
+// first.
+func Following(state *handler.State, viewer, u int64, limit, offset int) ([]model.User, error) {
+	return follows(state, `
+	SELECT followed_id FROM content.user_follows
+	WHERE user_id = $1 AND permissions.viewable($2, followed_id, 'user')
+	ORDER BY created_at DESC, followed_id
+	LIMIT $3 OFFSET $4`, viewer, u, limit, offset)
+}
+
+func follows(state *handler.State, query string, viewer, u int64, limit, offset int) ([]model.User, error) {
+	ids := []int64{}
+	err := state.DB.Select(&ids, query, u, viewer, limit, offset)
+	if err != nil {
+		log.Println(err)
+		return []model.User{}, err
+	}
+	return retrieval.GetUsers(state, viewer, ids)
+}
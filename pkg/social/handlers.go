@@ -1,13 +1,19 @@
 package social
 
 import (
+	"errors"
 	"net/http"
 
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/feed"
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/notifications"
 	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/webhooks"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
+	"github.com/jmoiron/sqlx"
 )
 
 func FavoriteHandler(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
@@ -24,11 +30,22 @@ func FavoriteHandler(store *handler.State, w http.ResponseWriter, r *http.Reques
 		return handler.Response{}, err
 	}
 
+	if err = refuseBlocked(store, usrRef.Id, imageRef); err != nil {
+		return handler.Response{}, err
+	}
+
 	err = Favorite(store.DB, usrRef.Id, imageRef.Id)
 	if err != nil {
 		return handler.Response{}, err
 	}
 
+	notifications.Favorited(store, usrRef.Id, imageRef.Id)
+	feed.Announce(store, usrRef, feed.Favorites, imageRef)
+	audit.Record(store.DB, r, "image.favorite", imageRef, map[string]bool{"favorited": false}, map[string]bool{"favorited": true})
+	if owner, err := webhooks.ImageOwner(store.DB, imageRef.Id); err == nil {
+		webhooks.Emit(store, webhooks.FavoriteCreated, owner.Id, map[string]model.Ref{"image": imageRef, "user": usrRef})
+	}
+
 	return handler.Response{Code: http.StatusAccepted}, nil
 }
 
@@ -51,6 +68,9 @@ func UnFavoriteHandler(store *handler.State, w http.ResponseWriter, r *http.Requ
 		return handler.Response{}, err
 	}
 
+	notifications.Retract(store.DB, notifications.Favorite, usrRef.Id, imageRef)
+	audit.Record(store.DB, r, "image.unfavorite", imageRef, map[string]bool{"favorited": true}, map[string]bool{"favorited": false})
+
 	return handler.Response{Code: http.StatusAccepted}, nil
 }
 
@@ -67,12 +87,24 @@ func FollowHandler(store *handler.State, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return handler.Response{}, err
 	}
+	if followedRef.Id == usrRef.Id {
+		return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Cannot follow yourself")}
+	}
+
+	if err = refuseBlocked(store, usrRef.Id, followedRef); err != nil {
+		return handler.Response{}, err
+	}
 
 	err = Follow(store.DB, usrRef.Id, followedRef.Id)
 	if err != nil {
 		return handler.Response{}, err
 	}
 
+	notifications.Followed(store, usrRef.Id, followedRef.Id)
+	feed.Announce(store, usrRef, feed.Follows, followedRef)
+	audit.Record(store.DB, r, "user.follow", followedRef, map[string]bool{"followed": false}, map[string]bool{"followed": true})
+	webhooks.Emit(store, webhooks.UserFollowed, followedRef.Id, map[string]model.Ref{"user": usrRef, "followed": followedRef})
+
 	return handler.Response{Code: http.StatusAccepted}, nil
 }
 
@@ -94,5 +126,92 @@ func UnFollowHandler(store *handler.State, w http.ResponseWriter, r *http.Reques
 		return handler.Response{}, err
 	}
 
+	notifications.Retract(store.DB, notifications.Follow, usrRef.Id, followedRef)
+	audit.Record(store.DB, r, "user.unfollow", followedRef, map[string]bool{"followed": true}, map[string]bool{"followed": false})
+
 	return handler.Response{Code: http.StatusAccepted}, nil
 }
+
+// refuseBlocked returns a 403 when the user and the target, or the owner of
+// the target image, have blocked each other.
+func refuseBlocked(store *handler.State, user int64, target model.Ref) error {
+	blocked, err := Blocked(store.DB, user, target)
+	if err != nil {
+		return handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	if blocked {
+		return handler.StatusError{Code: http.StatusForbidden, Err: errors.New("Cannot interact with a blocked user")}
+	}
+	return nil
+}
+
+type relationFunc func(db *sqlx.DB, a, b int64) error
+
+// RelationHandler blocks, mutes or undoes either for the user in the url with
+// f, recording action in the audit trail.
+func RelationHandler(action string, f relationFunc) func(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	return func(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		usrRef := context.Get(r, "auth").(model.Ref)
+
+		targetRef, err := retrieval.GetUserRef(store.DB, mux.Vars(r)["ID"])
+		if err != nil {
+			return handler.Response{}, err
+		}
+		if targetRef.Id == usrRef.Id {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errors.New("Cannot block or mute yourself")}
+		}
+
+		if err = f(store.DB, usrRef.Id, targetRef.Id); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+
+		audit.Record(store.DB, r, action, targetRef, nil, nil)
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+type listFunc func(state *handler.State, viewer, u int64, limit, offset int) ([]model.User, error)
+
+// FollowsHandler lists the followers or followed users of the user in the url
+// with list.
+func FollowsHandler(list listFunc) func(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	return func(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		userRef, err := retrieval.GetUserRef(store.DB, mux.Vars(r)["ID"])
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		users, err := list(store, retrieval.Viewer(r), userRef.Id, limit, offset)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve users")}
+		}
+		return handler.Response{Code: http.StatusOK, Data: users}, nil
+	}
+}
+
+// OwnListHandler lists the logged in user's blocked or muted users with list.
+func OwnListHandler(list listFunc) func(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	return func(store *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		usrRef := context.Get(r, "auth").(model.Ref)
+		users, err := list(store, usrRef.Id, usrRef.Id, limit, offset)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve users")}
+		}
+		return handler.Response{Code: http.StatusOK, Data: users}, nil
+	}
+}
@@ -25,7 +25,9 @@ func UnFavorite(db *sqlx.DB, uID, iID int64) error {
 }
 
 func Follow(db *sqlx.DB, idA, idB int64) error {
-	stmt, err := db.Preparex("INSERT INTO content.user_follows (user_id, followed_id) VALUES ($1, $2)")
+	stmt, err := db.Preparex(`
+	INSERT INTO content.user_follows (user_id, followed_id) VALUES ($1, $2)
+	ON CONFLICT (user_id, followed_id) DO NOTHING`)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -34,7 +36,7 @@ func Follow(db *sqlx.DB, idA, idB int64) error {
 }
 
 func UnFollow(db *sqlx.DB, idA, idB int64) error {
-	stmt, err := db.Preparex("DELETE FROM content.user_follows WHERE user_id = $1 AND followed_id = $2)")
+	stmt, err := db.Preparex("DELETE FROM content.user_follows WHERE user_id = $1 AND followed_id = $2")
 	if err != nil {
 		log.Println(err)
 		return err
@@ -0,0 +1,96 @@
+package social
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// recorder is a database driver that accepts every statement and keeps what
+// was executed so it can be checked without a database.
+type recorder struct {
+	execs []exec
+}
+
+type exec struct {
+	query string
+	args  []driver.Value
+}
+
+func (d *recorder) Open(name string) (driver.Conn, error) { return d, nil }
+func (d *recorder) Prepare(query string) (driver.Stmt, error) {
+	return stmt{d, query}, nil
+}
+func (d *recorder) Close() error { return nil }
+func (d *recorder) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type stmt struct {
+	d     *recorder
+	query string
+}
+
+func (s stmt) Close() error  { return nil }
+func (s stmt) NumInput() int { return -1 }
+func (s stmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.execs = append(s.d.execs, exec{s.query, args})
+	return driver.RowsAffected(1), nil
+}
+func (s stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries are not supported")
+}
+
+var rec = &recorder{}
+
+func init() {
+	sql.Register("social-recorder", rec)
+}
+
+// TestStatements checks the exact statement each change runs and what is
+// bound to it, which catches malformed SQL such as the stray parenthesis that
+// once broke unfollowing.
+func TestStatements(t *testing.T) {
+	db, err := sqlx.Open("social-recorder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name  string
+		f     func(*sqlx.DB, int64, int64) error
+		query string
+	}{
+		{"Favorite", Favorite, "INSERT INTO content.user_favorites (user_id, image_id) VALUES ($1, $2)"},
+		{"UnFavorite", UnFavorite, "DELETE FROM content.user_favorites WHERE user_id = $1 AND image_id = $2"},
+		{"Follow", Follow, "INSERT INTO content.user_follows (user_id, followed_id) VALUES ($1, $2) ON CONFLICT (user_id, followed_id) DO NOTHING"},
+		{"UnFollow", UnFollow, "DELETE FROM content.user_follows WHERE user_id = $1 AND followed_id = $2"},
+		{"AddTag", AddTag, "INSERT INTO content.image_tag_bridge (image_id, tag_id) VALUES ($1, $2)"},
+		{"RemoveTag", RemoveTag, "DELETE FROM content.image_tag_bridge WHERE image_id = $1 AND tag_id = $2"},
+		{"UnBlock", UnBlock, "DELETE FROM content.user_blocks WHERE user_id = $1 AND blocked_id = $2"},
+		{"Mute", Mute, "INSERT INTO content.user_mutes (user_id, muted_id) VALUES ($1, $2) ON CONFLICT (user_id, muted_id) DO NOTHING"},
+		{"UnMute", UnMute, "DELETE FROM content.user_mutes WHERE user_id = $1 AND muted_id = $2"},
+	}
+	for _, test := range tests {
+		rec.execs = nil
+		if err := test.f(db, 1, 2); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		}
+		if len(rec.execs) != 1 {
+			t.Fatalf("%s: expected one statement, got %d", test.name, len(rec.execs))
+		}
+
+		e := rec.execs[0]
+		if q := strings.Join(strings.Fields(e.query), " "); q != test.query {
+			t.Errorf("%s: expected %q, got %q", test.name, test.query, q)
+		}
+		if len(e.args) != 2 || e.args[0] != int64(1) || e.args[1] != int64(2) {
+			t.Errorf("%s: expected 1 and 2 to be bound in order, got %v", test.name, e.args)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package tokens
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/garyburd/redigo/redis"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// ChallengeLifetime is how long a user has to enter their second factor
+	// after logging in.
+	ChallengeLifetime = 5 * time.Minute
+	// MaxSecondFactorFailures is how many wrong codes a user can enter before
+	// their second factor is locked.
+	MaxSecondFactorFailures = 5
+	// SecondFactorLockout is how long after the last wrong code failures are
+	// counted for, and so how long a locked second factor stays locked.
+	SecondFactorLockout = 15 * time.Minute
+
+	challengeType  = "mfa"
+	failuresPrefix = "auth:mfa-failures:"
+)
+
+// PendingChallenge is a login waiting on a second factor.
+type PendingChallenge struct {
+	User   model.Ref
+	Email  string
+	ID     string
+	claims jwt.MapClaims
+}
+
+// Challenge issues a short lived token for a user who has passed the first
+// factor. It cannot be used to authenticate and has to be exchanged with
+// CompleteChallenge for a session.
+func Challenge(state *handler.State, u model.Ref, email string) (string, error) {
+	now := time.Now()
+	claims := &jwt.MapClaims{
+		"iat":   now.Unix(),
+		"exp":   now.Add(ChallengeLifetime).Unix(),
+		"iss":   "fokal",
+		"typ":   challengeType,
+		"sub":   u.Shortcode,
+		"email": email,
+		"jti":   uuid.NewV4().String(),
+	}
+
+	key, err := state.Keys.Current()
+	if err != nil {
+		log.Println(err)
+		return "", handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create challenge.")}
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	ss, err := token.SignedString(key.Private)
+	if err != nil {
+		log.Println(err)
+		return "", handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create challenge.")}
+	}
+	return ss, nil
+}
+
+// VerifyChallenge checks a challenge token.
+func VerifyChallenge(state *handler.State, raw string) (PendingChallenge, error) {
+	invalid := handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Challenge is invalid or has expired")}
+
+	token, err := jwt.Parse(raw, keyfunc(state))
+	if err != nil || !token.Valid {
+		return PendingChallenge{}, invalid
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != challengeType {
+		return PendingChallenge{}, invalid
+	}
+	email, hasEmail := claims["email"].(string)
+	jti, hasID := claims["jti"].(string)
+	if !hasEmail || !hasID {
+		return PendingChallenge{}, invalid
+	}
+
+	user, err := retrieval.GetUserRefByEmail(state.DB, email)
+	if err != nil {
+		return PendingChallenge{}, invalid
+	}
+
+	isRevoked, err := revoked(state.RD, user.Id, claims)
+	if err != nil {
+		log.Println(err)
+		return PendingChallenge{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to verify challenge")}
+	}
+	if isRevoked {
+		return PendingChallenge{}, invalid
+	}
+
+	return PendingChallenge{User: user, Email: email, ID: jti, claims: claims}, nil
+}
+
+// CompleteChallenge revokes the challenge so it cannot be reused and starts a
+// session for its user.
+func CompleteChallenge(state *handler.State, r *http.Request, c PendingChallenge) (string, error) {
+	err := revoke(state.RD, c.ID, remaining(c.claims))
+	if err != nil {
+		log.Println(err)
+		return "", handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to complete challenge")}
+	}
+	return Create(state, r, c.User, c.Email)
+}
+
+// SecondFactorLocked returns how long the user's second factor stays locked
+// for, or zero if codes can be tried. Failures are counted per user rather
+// than per challenge, as anyone with the first factor can start a new
+// challenge whenever they like.
+func SecondFactorLocked(pool *redis.Pool, uid int64) (time.Duration, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("%s%d", failuresPrefix, uid)
+	conn.Send("MULTI")
+	conn.Send("GET", key)
+	conn.Send("PTTL", key)
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return 0, err
+	}
+
+	failures, err := redis.Int(values[0], nil)
+	if err == redis.ErrNil {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if failures < MaxSecondFactorFailures {
+		return 0, nil
+	}
+	ttl, err := redis.Int64(values[1], nil)
+	if err != nil || ttl < 0 {
+		return SecondFactorLockout, err
+	}
+	return time.Duration(ttl) * time.Millisecond, nil
+}
+
+// SecondFactorFailed counts a wrong code against the user. Every failure
+// pushes back when the count is forgotten.
+func SecondFactorFailed(pool *redis.Pool, uid int64) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("%s%d", failuresPrefix, uid)
+	conn.Send("MULTI")
+	conn.Send("INCR", key)
+	conn.Send("EXPIRE", key, int64(SecondFactorLockout.Seconds()))
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// SecondFactorPassed forgets the user's failures once they enter a valid
+// code.
+func SecondFactorPassed(pool *redis.Pool, uid int64) error {
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", fmt.Sprintf("%s%d", failuresPrefix, uid))
+	return err
+}
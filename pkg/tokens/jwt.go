@@ -14,38 +14,119 @@ import (
 	"github.com/fokal/fokal-core/pkg/handler"
 	"github.com/fokal/fokal-core/pkg/model"
 	"github.com/fokal/fokal-core/pkg/retrieval"
+	uuid "github.com/satori/go.uuid"
 )
 
-func Create(state *handler.State, u model.Ref, email string) (string, error) {
+// Create issues a token for a new session.
+func Create(state *handler.State, r *http.Request, u model.Ref, email string) (string, error) {
+	now := time.Now()
+	return sign(state, r, u, email, uuid.NewV4().String(), now, now)
+}
+
+// Renew issues a replacement token for the session described by claims,
+// keeping its session id and original authentication time. Sessions cannot be
+// renewed past MaxSessionAge. Tokens without a session start a new one.
+func Renew(state *handler.State, r *http.Request, u model.Ref, email string, claims jwt.MapClaims) (string, error) {
+	sid, ok := claims["sid"].(string)
+	authTime, hasAuthTime := claims["auth_time"].(float64)
+	if !ok || !hasAuthTime {
+		return Create(state, r, u, email)
+	}
+
+	started := time.Unix(int64(authTime), 0)
+	if time.Since(started) > state.MaxSessionAge {
+		return "", handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Session has expired, please log in again.")}
+	}
+
+	ss, err := sign(state, r, u, email, sid, started, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	if jti, ok := claims["jti"].(string); ok {
+		err = revoke(state.RD, jti, remaining(claims))
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return ss, nil
+}
+
+func sign(state *handler.State, r *http.Request, u model.Ref, email, sid string, authTime, now time.Time) (string, error) {
+	exp := now.Add(state.SessionLifetime)
+	if limit := authTime.Add(state.MaxSessionAge); exp.After(limit) {
+		exp = limit
+	}
 
 	claims := &jwt.MapClaims{
-		"iat":   time.Now().Unix(),
-		"exp":   time.Now().Add(state.SessionLifetime).Unix(),
-		"iss":   "fokal",
-		"sub":   u.Shortcode,
-		"email": email,
+		"iat":       numericDate(now),
+		"exp":       exp.Unix(),
+		"iss":       "fokal",
+		"sub":       u.Shortcode,
+		"email":     email,
+		"jti":       uuid.NewV4().String(),
+		"sid":       sid,
+		"auth_time": authTime.Unix(),
+	}
+
+	key, err := state.Keys.Current()
+	if err != nil {
+		log.Println(err)
+		return "", handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create token.")}
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	token.Header["kid"] = state.KeyHash
-	ss, err := token.SignedString(state.PrivateKey)
+	token.Header["kid"] = key.ID
+	ss, err := token.SignedString(key.Private)
 	if err != nil {
 		log.Println(err)
 		return "", handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create token.")}
 	}
 
+	err = track(state, u.Id, Session{
+		ID:          sid,
+		IP:          requestIP(r),
+		UserAgent:   r.UserAgent(),
+		CreatedAt:   authTime,
+		RefreshedAt: now,
+		ExpiresAt:   exp,
+	})
+	if err != nil {
+		log.Println(err)
+		return "", handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create session.")}
+	}
+
 	return ss, nil
 }
 
-func Parse(state *handler.State, r *http.Request) (*jwt.Token, error) {
+// numericDate returns t in seconds to the millisecond, so tokens issued just
+// after a revocation can be told apart from those issued just before it.
+func numericDate(t time.Time) float64 {
+	return float64(t.UnixNano()/int64(time.Millisecond)) / 1000
+}
+
+// Bearer returns the raw bearer token on the request.
+func Bearer(r *http.Request) (string, error) {
 	tokenStrings, err := jwtreq.HeaderExtractor{"Authorization"}.ExtractToken(r)
 
 	if err != nil {
-		return nil, handler.StatusError{Err: errors.New("Bearer Header not present"), Code: http.StatusUnauthorized}
+		return "", handler.StatusError{Err: errors.New("Bearer Header not present"), Code: http.StatusUnauthorized}
 	}
+	return strings.Replace(tokenStrings, "Bearer ", "", 1), nil
+}
 
-	tokenStr := strings.Replace(tokenStrings, "Bearer ", "", 1)
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+func Parse(state *handler.State, r *http.Request) (*jwt.Token, error) {
+	tokenStr, err := Bearer(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return jwt.Parse(tokenStr, keyfunc(state))
+}
+
+// keyfunc finds the ring key a token was signed with.
+func keyfunc(state *handler.State) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
 			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 		}
@@ -60,21 +141,12 @@ func Parse(state *handler.State, r *http.Request) (*jwt.Token, error) {
 			return nil, fmt.Errorf("Invalid kid type.\n")
 		}
 
-		valid := false
-		for k := range state.PublicKeys {
-			if k == kid {
-				valid = true
-				break
-			}
-		}
-		if !valid {
+		publicKey, ok := state.Keys.Public(kid)
+		if !ok {
 			return nil, fmt.Errorf("Invalid kid type.\n")
-
 		}
-
-		return state.PublicKeys[kid], nil
-	})
-	return token, err
+		return publicKey, nil
+	}
 }
 
 func Verify(state *handler.State, r *http.Request) (model.Ref, error) {
@@ -87,14 +159,28 @@ func Verify(state *handler.State, r *http.Request) (model.Ref, error) {
 
 	if token.Valid {
 		claims, ok := token.Claims.(jwt.MapClaims)
+		// Challenges and other special purpose tokens carry a typ and
+		// cannot be used to log in.
+		email, hasEmail := claims["email"].(string)
+		if _, typed := claims["typ"]; ok && (typed || !hasEmail) {
+			return model.Ref{}, handler.StatusError{Err: errors.New("Token is invalid"), Code: http.StatusBadRequest}
+		}
 		if token.Valid && ok {
-			email := claims["email"].(string)
 			id, err := retrieval.GetUserRefByEmail(state.DB, email)
 			if err != nil {
 				return model.Ref{}, handler.StatusError{
 					Code: http.StatusBadRequest,
 					Err:  errors.New("Token is malformed")}
 			}
+
+			isRevoked, err := revoked(state.RD, id.Id, claims)
+			if err != nil {
+				log.Println(err)
+				return model.Ref{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to verify token")}
+			}
+			if isRevoked {
+				return model.Ref{}, handler.StatusError{Code: http.StatusUnauthorized, Err: errors.New("Token has been revoked")}
+			}
 			return id, nil
 		}
 	} else if err, ok := err.(*jwt.ValidationError); ok {
@@ -109,3 +195,17 @@ func Verify(state *handler.State, r *http.Request) (model.Ref, error) {
 
 	return model.Ref{}, handler.StatusError{Err: errors.New("Token is invalid"), Code: http.StatusBadRequest}
 }
+
+// Claims returns the claims of the bearer token on the request.
+func Claims(state *handler.State, r *http.Request) (jwt.MapClaims, error) {
+	token, err := Parse(state, r)
+	if err != nil || !token.Valid {
+		return jwt.MapClaims{}, handler.StatusError{Err: errors.New("Token is invalid"), Code: http.StatusBadRequest}
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return jwt.MapClaims{}, handler.StatusError{Err: errors.New("Token is malformed"), Code: http.StatusBadRequest}
+	}
+	return claims, nil
+}
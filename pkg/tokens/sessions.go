@@ -0,0 +1,192 @@
+package tokens
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/garyburd/redigo/redis"
+	"github.com/gorilla/context"
+)
+
+// Redis keys used to track sessions and revoked tokens.
+const (
+	revokedPrefix       = "auth:revoked:"
+	revokedBeforePrefix = "auth:revoked-before:"
+	sessionsPrefix      = "auth:sessions:"
+)
+
+// Session describes a login. Refreshing a token keeps its session.
+type Session struct {
+	ID          string    `json:"id"`
+	Current     bool      `json:"current"`
+	IP          string    `json:"ip,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"last_refreshed"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+func requestIP(r *http.Request) string {
+	if ip, ok := context.GetOk(r, "ip"); ok {
+		return fmt.Sprintf("%v", ip)
+	}
+	return ""
+}
+
+// remaining returns how long the token described by claims is valid for.
+func remaining(claims jwt.MapClaims) time.Duration {
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return 0
+	}
+	return time.Until(time.Unix(int64(exp), 0))
+}
+
+func track(state *handler.State, uid int64, s Session) error {
+	conn := state.RD.Get()
+	defer conn.Close()
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%s%d", sessionsPrefix, uid)
+	conn.Send("MULTI")
+	conn.Send("HSET", key, s.ID, b)
+	conn.Send("EXPIRE", key, int64(state.MaxSessionAge.Seconds()))
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// revoke adds id, either a token or session id, to the revocation list for the
+// given duration.
+func revoke(pool *redis.Pool, id string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	conn := pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SETEX", revokedPrefix+id, int64(ttl.Seconds())+1, 1)
+	return err
+}
+
+// revoked checks the token and session ids in claims against the revocation
+// list, along with any blanket revocation for the user.
+func revoked(pool *redis.Pool, uid int64, claims jwt.MapClaims) (bool, error) {
+	conn := pool.Get()
+	defer conn.Close()
+
+	keys := []interface{}{fmt.Sprintf("%s%d", revokedBeforePrefix, uid)}
+	for _, c := range []string{"jti", "sid"} {
+		if id, ok := claims[c].(string); ok {
+			keys = append(keys, revokedPrefix+id)
+		}
+	}
+
+	values, err := redis.Values(conn.Do("MGET", keys...))
+	if err != nil {
+		return false, err
+	}
+
+	before, err := redis.Float64(values[0], nil)
+	if err == nil {
+		iat, _ := claims["iat"].(float64)
+		if iat < before {
+			return true, nil
+		}
+	} else if err != redis.ErrNil {
+		return false, err
+	}
+
+	for _, v := range values[1:] {
+		if v != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Sessions returns the active sessions for the user, newest first.
+func Sessions(state *handler.State, uid int64) ([]Session, error) {
+	conn := state.RD.Get()
+	defer conn.Close()
+
+	key := fmt.Sprintf("%s%d", sessionsPrefix, uid)
+	values, err := redis.StringMap(conn.Do("HGETALL", key))
+	if err != nil {
+		return []Session{}, err
+	}
+
+	sessions := []Session{}
+	for id, v := range values {
+		s := Session{}
+		err = json.Unmarshal([]byte(v), &s)
+		if err != nil || s.ExpiresAt.Before(time.Now()) {
+			conn.Do("HDEL", key, id)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].RefreshedAt.After(sessions[j].RefreshedAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession logs out the given session for the user.
+func RevokeSession(state *handler.State, uid int64, sid string) error {
+	conn := state.RD.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(conn.Do("HDEL", fmt.Sprintf("%s%d", sessionsPrefix, uid), sid))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No session found")}
+	}
+	return revoke(state.RD, sid, state.MaxSessionAge)
+}
+
+// RevokeToken revokes the token described by claims and, if it has one, its
+// session.
+func RevokeToken(state *handler.State, uid int64, claims jwt.MapClaims) error {
+	if jti, ok := claims["jti"].(string); ok {
+		err := revoke(state.RD, jti, remaining(claims))
+		if err != nil {
+			return err
+		}
+	}
+	if sid, ok := claims["sid"].(string); ok {
+		err := RevokeSession(state, uid, sid)
+		if err != nil {
+			if e, ok := err.(handler.StatusError); !ok || e.Code != http.StatusNotFound {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RevokeAll invalidates every token issued to the user up to now.
+func RevokeAll(state *handler.State, uid int64) error {
+	conn := state.RD.Get()
+	defer conn.Close()
+
+	before := strconv.FormatFloat(numericDate(time.Now()), 'f', 3, 64)
+	conn.Send("MULTI")
+	conn.Send("SETEX", fmt.Sprintf("%s%d", revokedBeforePrefix, uid), int64(state.MaxSessionAge.Seconds()), before)
+	conn.Send("DEL", fmt.Sprintf("%s%d", sessionsPrefix, uid))
+	_, err := conn.Do("EXEC")
+	return err
+}
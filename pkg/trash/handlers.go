@@ -0,0 +1,126 @@
+package trash
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/cache"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// trashed returns the logged in user's image in the url, if it is in their
+// trash.
+func trashed(state *handler.State, r *http.Request) (model.Ref, model.Ref, error) {
+	user := context.Get(r, "auth").(model.Ref)
+	ref := model.Ref{Collection: model.Images, Shortcode: mux.Vars(r)["ID"]}
+
+	err := state.DB.Get(&ref.Id, `
+	SELECT id FROM content.images
+	WHERE shortcode = $1 AND user_id = $2 AND deleted_at IS NOT NULL`, ref.Shortcode, user.Id)
+	if err == sql.ErrNoRows {
+		return user, model.Ref{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found in trash")}
+	} else if err != nil {
+		log.Println(err)
+		return user, model.Ref{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return user, ref, nil
+}
+
+// ListHandler returns the images in the logged in user's trash, most recently
+// deleted first, with when each will be purged.
+func ListHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user := context.Get(r, "auth").(model.Ref)
+
+	limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	items, err := List(state, user.Id, limit, offset)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve trash")}
+	}
+	return handler.Response{Code: http.StatusOK, Data: items}, nil
+}
+
+// RestoreHandler takes an image back out of the logged in user's trash.
+func RestoreHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	user, ref, err := trashed(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	ref, err = Restore(state.DB, user.Id, ref.Shortcode)
+	if err == sql.ErrNoRows {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Image not found in trash")}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to restore image")}
+	}
+
+	img, err := retrieval.GetImage(state, ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	audit.Record(state.DB, r, "image.restore", ref, map[string]bool{"deleted": true}, map[string]bool{"deleted": false})
+	if err := cache.Flush(state.RD); err != nil {
+		log.Println(err)
+	}
+	return handler.Response{Code: http.StatusOK, Data: img}, nil
+}
+
+// PurgeHandler removes an image in the logged in user's trash for good,
+// without waiting for it to expire.
+func PurgeHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	_, ref, err := trashed(state, r)
+	if err != nil {
+		return handler.Response{}, err
+	}
+
+	before, _ := retrieval.GetImage(state, ref.Id)
+	ok, err := PurgeImage(state.DB, ref.Id, time.Now())
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to purge image")}
+	}
+	if !ok {
+		return handler.Response{}, handler.StatusError{Code: http.StatusConflict, Err: errors.New("Image is being restored or purged")}
+	}
+
+	audit.Record(state.DB, r, "image.purge", ref, before, nil)
+	return handler.Response{Code: http.StatusNoContent}, nil
+}
+
+// RestoreUserHandler takes the deleted account in the url back out of the
+// trash before it is purged, so its owner can log in again.
+func RestoreUserHandler(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+	ref, err := RestoreUser(state.DB, mux.Vars(r)["ID"])
+	if err == sql.ErrNoRows {
+		return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("No deleted user found")}
+	} else if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to restore user")}
+	}
+
+	user, err := retrieval.GetUser(state, retrieval.Viewer(r), ref.Id)
+	if err != nil {
+		return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+
+	audit.Record(state.DB, r, "user.restore", ref, map[string]bool{"deleted": true}, map[string]bool{"deleted": false})
+	if err := cache.Flush(state.RD); err != nil {
+		log.Println(err)
+	}
+	return handler.Response{Code: http.StatusOK, Data: user}, nil
+}
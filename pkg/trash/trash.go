@@ -0,0 +1,325 @@
+package trash
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/cache"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/retrieval"
+	"github.com/fokal/fokal-core/pkg/upload"
+	"github.com/jmoiron/sqlx"
+)
+
+// Retention is how long deleted images and users are kept in the trash before
+// they are purged.
+const Retention = 30 * 24 * time.Hour
+
+// batch is how many images or users a single purge removes.
+const batch = 100
+
+// ErrImagesRemain is returned when purging a user whose images couldn't all be
+// purged first.
+var ErrImagesRemain = errors.New("user still has images")
+
+// imageStatements remove everything kept for an image. Comments, collection
+// entries, notifications and share links cascade from the image itself.
+var imageStatements = []string{
+	"DELETE FROM content.image_metadata WHERE image_id = $1",
+	"DELETE FROM content.image_label_bridge WHERE image_id = $1",
+	"DELETE FROM content.image_tag_bridge WHERE image_id = $1",
+	"DELETE FROM content.user_favorites WHERE image_id = $1",
+	"DELETE FROM content.image_color_bridge WHERE image_id = $1",
+	"DELETE FROM content.image_landmark_bridge WHERE image_id = $1",
+	"DELETE FROM content.image_geo WHERE image_id = $1",
+	"DELETE FROM content.image_stats WHERE image_id = $1",
+	"DELETE FROM permissions.can_view WHERE o_id = $1 AND type = 'image'",
+	"DELETE FROM permissions.can_edit WHERE o_id = $1 AND type = 'image'",
+	"DELETE FROM permissions.can_delete WHERE o_id = $1 AND type = 'image'",
+	"DELETE FROM permissions.group_can_view WHERE o_id = $1 AND type = 'image'",
+	"DELETE FROM permissions.group_can_edit WHERE o_id = $1 AND type = 'image'",
+	"DELETE FROM permissions.group_can_delete WHERE o_id = $1 AND type = 'image'",
+	"DELETE FROM content.images WHERE id = $1",
+}
+
+// userStatements remove everything kept for a user once their images are gone.
+// Follows, blocks, mutes, identities, memberships, collections, comments,
+// notifications, two factor secrets and webhooks cascade from the user.
+var userStatements = []string{
+	"DELETE FROM content.user_favorites WHERE user_id = $1",
+	"DELETE FROM permissions.can_view WHERE user_id = $1 OR (o_id = $1 AND type = 'user')",
+	"DELETE FROM permissions.can_edit WHERE user_id = $1 OR (o_id = $1 AND type = 'user')",
+	"DELETE FROM permissions.can_delete WHERE user_id = $1 OR (o_id = $1 AND type = 'user')",
+	"DELETE FROM permissions.group_can_view WHERE o_id = $1 AND type = 'user'",
+	"DELETE FROM permissions.group_can_edit WHERE o_id = $1 AND type = 'user'",
+	"DELETE FROM permissions.group_can_delete WHERE o_id = $1 AND type = 'user'",
+	"DELETE FROM permissions.api_keys WHERE user_id = $1",
+	"DELETE FROM content.users WHERE id = $1",
+}
+
+// Item is an image in its owner's trash.
+type Item struct {
+	Image     model.Image `json:"image"`
+	DeletedAt time.Time   `json:"deleted_at"`
+	PurgeAt   time.Time   `json:"purge_at"`
+}
+
+func newItem(img model.Image, deletedAt time.Time) Item {
+	return Item{Image: img, DeletedAt: deletedAt, PurgeAt: deletedAt.Add(Retention)}
+}
+
+// Delete moves the image or user to the trash, hiding it everywhere until it is
+// restored or purged. A deleted user's images go with them.
+func Delete(db *sqlx.DB, ref model.Ref) error {
+	table := "content.users"
+	if ref.Collection == model.Images {
+		table = "content.images"
+	}
+
+	res, err := db.Exec(`
+	UPDATE `+table+`
+		SET deleted_at = timezone('UTC'::text, now())
+	WHERE id = $1 AND deleted_at IS NULL`, ref.Id)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Restore takes the user's image with the given shortcode back out of the
+// trash.
+func Restore(db *sqlx.DB, user int64, shortcode string) (model.Ref, error) {
+	ref := model.Ref{Collection: model.Images, Shortcode: shortcode}
+	err := db.Get(&ref.Id, `
+	UPDATE content.images
+		SET deleted_at = NULL
+	WHERE shortcode = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+	RETURNING id`, shortcode, user)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return ref, err
+}
+
+// RestoreUser takes the user with the given username back out of the trash,
+// along with the images that were deleted with them.
+func RestoreUser(db *sqlx.DB, username string) (model.Ref, error) {
+	ref := model.Ref{Collection: model.Users, Shortcode: username}
+	err := db.Get(&ref.Id, `
+	UPDATE content.users
+		SET deleted_at = NULL
+	WHERE username = $1 AND deleted_at IS NOT NULL
+	RETURNING id`, username)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return ref, err
+}
+
+// Deleted reports whether the user deleted their account.
+func Deleted(db *sqlx.DB, user int64) (bool, error) {
+	var deleted bool
+	err := db.Get(&deleted, "SELECT deleted_at IS NOT NULL FROM content.users WHERE id = $1", user)
+	if err != nil {
+		log.Println(err)
+	}
+	return deleted, err
+}
+
+// List returns the images in the user's trash, most recently deleted first.
+func List(state *handler.State, user int64, limit, offset int) ([]Item, error) {
+	rows := []struct {
+		Id        int64     `db:"id"`
+		DeletedAt time.Time `db:"deleted_at"`
+	}{}
+	err := state.DB.Select(&rows, `
+	SELECT id, deleted_at FROM content.images
+	WHERE user_id = $1 AND deleted_at IS NOT NULL
+	ORDER BY deleted_at DESC, id DESC
+	LIMIT $2 OFFSET $3`, user, limit, offset)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	items := []Item{}
+	for _, row := range rows {
+		img, err := retrieval.GetImage(state, row.Id)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, newItem(img, row.DeletedAt))
+	}
+	return items, nil
+}
+
+// Purge removes the images and users that have been in the trash longer than
+// Retention, along with their stored files, and clears the response cache if
+// anything went. Servers purging at the same time skip each other's rows.
+func Purge(state *handler.State) error {
+	cutoff := time.Now().Add(-Retention)
+
+	images := []int64{}
+	err := state.DB.Select(&images, `
+	SELECT images.id
+	FROM content.images AS images
+		INNER JOIN content.users AS users ON users.id = images.user_id
+	WHERE images.deleted_at < $1 OR users.deleted_at < $1
+	ORDER BY images.id
+	LIMIT $2`, cutoff, batch)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	purged := 0
+	for _, id := range images {
+		ok, err := PurgeImage(state.DB, id, cutoff)
+		if err != nil {
+			log.Printf("Unable to purge image_id = %d %s", id, err)
+			continue
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	users := []int64{}
+	err = state.DB.Select(&users, `
+	SELECT id FROM content.users
+	WHERE deleted_at < $1
+	ORDER BY id
+	LIMIT $2`, cutoff, batch)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	for _, id := range users {
+		ok, err := purgeUser(state.DB, id, cutoff)
+		if err != nil {
+			log.Printf("Unable to purge user_id = %d %s", id, err)
+			continue
+		}
+		if ok {
+			purged++
+		}
+	}
+
+	if purged > 0 {
+		log.Printf("Purged %d deleted images and users", purged)
+		if err := cache.Flush(state.RD); err != nil {
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// PurgeImage removes the image and its original if it, or its owner, was
+// deleted before cutoff. It reports false when the image was restored or is
+// being purged elsewhere. Rows are only removed once the stored files are, so
+// a failed purge is retried from the start.
+func PurgeImage(db *sqlx.DB, id int64, cutoff time.Time) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var shortcode string
+	err = tx.Get(&shortcode, `
+	SELECT images.shortcode
+	FROM content.images AS images
+		INNER JOIN content.users AS users ON users.id = images.user_id
+	WHERE images.id = $1 AND (images.deleted_at < $2 OR users.deleted_at < $2)
+	FOR UPDATE OF images SKIP LOCKED`, id, cutoff)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	log.Printf("Purging image_id = %d\n", id)
+	for _, stmt := range imageStatements {
+		if _, err = tx.Exec(stmt, id); err != nil {
+			log.Println(err)
+			return false, err
+		}
+	}
+
+	if err = upload.Remove(shortcode, "content"); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return true, nil
+}
+
+// purgeUser removes the user and their avatar if they were deleted before
+// cutoff. Their images must already have been purged.
+func purgeUser(db *sqlx.DB, id int64, cutoff time.Time) (bool, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var avatar *string
+	err = tx.Get(&avatar, `
+	SELECT avatar_id FROM content.users
+	WHERE id = $1 AND deleted_at < $2
+	FOR UPDATE SKIP LOCKED`, id, cutoff)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	var remaining int
+	if err = tx.Get(&remaining, "SELECT count(*) FROM content.images WHERE user_id = $1", id); err != nil {
+		log.Println(err)
+		return false, err
+	}
+	if remaining > 0 {
+		return false, ErrImagesRemain
+	}
+
+	log.Printf("Purging user_id = %d\n", id)
+	for _, stmt := range userStatements {
+		if _, err = tx.Exec(stmt, id); err != nil {
+			log.Println(err)
+			return false, err
+		}
+	}
+
+	if avatar != nil {
+		if err = upload.Remove(*avatar, "avatar"); err != nil {
+			return false, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println(err)
+		return false, err
+	}
+	return true, nil
+}
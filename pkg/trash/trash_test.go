@@ -0,0 +1,39 @@
+package trash
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/model"
+)
+
+func TestItem(t *testing.T) {
+	deleted := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	item := newItem(model.Image{Shortcode: "abcdefghijkl"}, deleted)
+	if !item.PurgeAt.Equal(deleted.Add(Retention)) {
+		t.Errorf("expected purge %s after deletion, got %s", Retention, item.PurgeAt.Sub(deleted))
+	}
+	if item.Image.Shortcode != "abcdefghijkl" {
+		t.Errorf("unexpected image %+v", item.Image)
+	}
+}
+
+// The row the purge locks has to go last, so a failure partway through leaves
+// it in place to be retried.
+func TestStatements(t *testing.T) {
+	for name, statements := range map[string][]string{
+		"content.images": imageStatements,
+		"content.users":  userStatements,
+	} {
+		last := statements[len(statements)-1]
+		if last != "DELETE FROM "+name+" WHERE id = $1" {
+			t.Errorf("expected %s to be deleted last, got %q", name, last)
+		}
+		for _, stmt := range statements {
+			if !strings.HasPrefix(stmt, "DELETE FROM ") || !strings.Contains(stmt, "$1") {
+				t.Errorf("expected a delete scoped to the %s row, got %q", name, stmt)
+			}
+		}
+	}
+}
@@ -2,11 +2,15 @@ package upload
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 func ImageAWS(img *bytes.Buffer, format string, filename string, bucketURI string, region string) error {
@@ -50,3 +54,126 @@ func formatParams(buffer *bytes.Buffer, size int64, filetype string, bucketName
 
 	return params, nil
 }
+
+// RemoveAWS deletes every object in the bucket whose key starts with prefix.
+func RemoveAWS(prefix string, bucketURI string, region string) error {
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Printf("error while constructing new aws session %s", err)
+		return err
+	}
+	svc := s3.New(sess)
+
+	objects := []*s3.ObjectIdentifier{}
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketURI),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, &s3.ObjectIdentifier{Key: obj.Key})
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Error while listing %s in aws %s", prefix, err)
+		return err
+	}
+
+	// DeleteObjects takes at most 1000 keys at a time.
+	for len(objects) > 0 {
+		n := len(objects)
+		if n > 1000 {
+			n = 1000
+		}
+
+		log.Printf("Deleting %d objects under %s from %s", n, prefix, bucketURI)
+		out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucketURI),
+			Delete: &s3.Delete{Objects: objects[:n], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			log.Printf("Error while deleting from aws %s", err)
+			return err
+		}
+		if len(out.Errors) > 0 {
+			log.Printf("Error while deleting %s from aws %s", aws.StringValue(out.Errors[0].Key), aws.StringValue(out.Errors[0].Message))
+			return errors.New("Unable to delete " + aws.StringValue(out.Errors[0].Key))
+		}
+		objects = objects[n:]
+	}
+
+	return nil
+}
+
+// StreamAWS uploads everything read from body in parts, so only a few parts are
+// held in memory however large it is.
+func StreamAWS(body io.Reader, contentType string, filename string, bucketURI string, region string) error {
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Printf("error while constructing new aws session %s", err)
+		return err
+	}
+	uploader := s3manager.NewUploader(sess)
+
+	log.Printf("Streaming %s to %s with type %s", filename, bucketURI, contentType)
+	_, err = uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(bucketURI),
+		Key:         aws.String(filename),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		log.Printf("Error while streaming to aws %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// OpenAWS returns the object's body for reading. Callers must close it.
+func OpenAWS(filename string, bucketURI string, region string) (io.ReadCloser, error) {
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Printf("error while constructing new aws session %s", err)
+		return nil, err
+	}
+	svc := s3.New(sess)
+
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketURI),
+		Key:    aws.String(filename),
+	})
+	if err != nil {
+		log.Printf("Error while downloading %s from aws %s", filename, err)
+		return nil, err
+	}
+
+	return out.Body, nil
+}
+
+// PresignAWS returns a url anyone can download the object from until expires
+// has passed.
+func PresignAWS(filename string, bucketURI string, region string, expires time.Duration) (string, error) {
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		log.Printf("error while constructing new aws session %s", err)
+		return "", err
+	}
+	svc := s3.New(sess)
+
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucketURI),
+		Key:    aws.String(filename),
+	})
+	url, err := req.Presign(expires)
+	if err != nil {
+		log.Printf("Error while presigning %s %s", filename, err)
+		return "", err
+	}
+
+	return url, nil
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"image"
 	"image/jpeg"
+	"io"
 	"log"
 	"strings"
 )
@@ -39,6 +40,17 @@ func ProccessImage(errChan chan error, img image.Image, format string, shortcode
 	errChan <- nil
 }
 
+// Original opens the original stored for the shortcode. Callers must close it.
+func Original(shortcode string, kind string) (io.ReadCloser, error) {
+	return OpenAWS(strings.Join([]string{kind, shortcode}, "/"), "images-fokal", "us-west-1")
+}
+
+// Remove deletes the original stored for the shortcode, along with any
+// derivatives stored beside it.
+func Remove(shortcode string, kind string) error {
+	return RemoveAWS(strings.Join([]string{kind, shortcode}, "/"), "images-fokal", "us-west-1")
+}
+
 func in(contentType string, opts []string) bool {
 	for _, opt := range opts {
 		if strings.Compare(contentType, opt) == 0 {
@@ -0,0 +1,206 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+const (
+	// MaxAttempts is how many times a delivery is tried before it fails.
+	MaxAttempts = 8
+	// batch is how many deliveries are claimed at a time.
+	batch = 20
+	// lease is how long a claimed delivery is left to its server before
+	// another may try it.
+	lease = time.Minute * 5
+)
+
+// Backoff returns how long to wait before the next attempt after the given
+// number of failed ones: 30s, doubling each time, up to 6h.
+func Backoff(attempts int) time.Duration {
+	wait := 30 * time.Second
+	for i := 1; i < attempts && wait < 6*time.Hour; i++ {
+		wait *= 2
+	}
+	if wait > 6*time.Hour {
+		wait = 6 * time.Hour
+	}
+	return wait
+}
+
+// Sign returns the signature sent in X-Fokal-Signature: the hex HMAC-SHA256,
+// keyed with the hook's secret, of the timestamp sent in X-Fokal-Timestamp, a
+// period and the body.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var errPrivate = errors.New("webhooks cannot be delivered to private addresses")
+
+// sharedAddressSpace is the range carriers use for NAT (RFC 6598), which
+// IsPrivate leaves out but is as internal as any private range.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// refusePrivate stops deliveries from reaching the network they are sent from.
+func refusePrivate(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || sharedAddressSpace.Contains(ip) {
+		return errPrivate
+	}
+	return nil
+}
+
+// Client returns the client deliveries are sent with. Private addresses are
+// only reachable when local is set.
+func Client(local bool) *http.Client {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	if !local {
+		dialer.Control = refusePrivate
+	}
+	return &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+type claimed struct {
+	Id        int64          `db:"id"`
+	Event     string         `db:"event"`
+	Payload   types.JSONText `db:"payload"`
+	Attempts  int            `db:"attempts"`
+	CreatedAt time.Time      `db:"created_at"`
+	URL       string         `db:"url"`
+	Secret    string         `db:"secret"`
+}
+
+// Deliver sends every delivery that is due, returning once none are left.
+// Deliveries are claimed for a while before they are sent so servers running
+// it at the same time don't send them twice.
+func Deliver(db *sqlx.DB, client *http.Client) error {
+	for {
+		due := []claimed{}
+		err := db.Select(&due, `
+		UPDATE webhooks.deliveries AS d
+			SET next_attempt_at = timezone('UTC'::text, now()) + $2 * INTERVAL '1 second'
+		FROM webhooks.hooks AS h
+		WHERE h.id = d.hook_id AND d.id IN (
+			SELECT deliveries.id FROM webhooks.deliveries AS deliveries
+				INNER JOIN webhooks.hooks AS hooks ON hooks.id = deliveries.hook_id
+			WHERE deliveries.status = 'pending' AND hooks.active
+				AND deliveries.next_attempt_at <= timezone('UTC'::text, now())
+			ORDER BY deliveries.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF deliveries SKIP LOCKED)
+		RETURNING d.id, d.event, d.payload, d.attempts, d.created_at, h.url, h.secret`, batch, lease.Seconds())
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		for _, d := range due {
+			code, err := send(client, d)
+			record(db, d, code, err)
+		}
+	}
+}
+
+// send posts the delivery to its hook, returning the response's status code.
+func send(client *http.Client, d claimed) (int, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"id":         d.Id,
+		"event":      d.Event,
+		"created_at": d.CreatedAt,
+		"data":       d.Payload,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Fokal-Webhooks")
+	req.Header.Set("X-Fokal-Event", d.Event)
+	req.Header.Set("X-Fokal-Delivery", strconv.FormatInt(d.Id, 10))
+	req.Header.Set("X-Fokal-Timestamp", strconv.FormatInt(now, 10))
+	req.Header.Set("X-Fokal-Signature", Sign(d.Secret, now, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("hook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record stores the outcome of an attempt, scheduling the next one or failing
+// the delivery once it has been tried MaxAttempts times.
+func record(db *sqlx.DB, d claimed, code int, sendErr error) {
+	var responseCode *int
+	if code != 0 {
+		responseCode = &code
+	}
+	attempts := d.Attempts + 1
+
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = db.Exec(`
+		UPDATE webhooks.deliveries
+			SET status = 'succeeded', attempts = $2, response_code = $3, error = NULL,
+				delivered_at = timezone('UTC'::text, now())
+		WHERE id = $1`, d.Id, attempts, responseCode)
+	default:
+		status := "pending"
+		if attempts >= MaxAttempts {
+			status = "failed"
+		}
+		_, err = db.Exec(`
+		UPDATE webhooks.deliveries
+			SET status = $2, attempts = $3, response_code = $4, error = $5,
+				next_attempt_at = timezone('UTC'::text, now()) + $6 * INTERVAL '1 second'
+		WHERE id = $1`, d.Id, status, attempts, responseCode, sendErr.Error(), Backoff(attempts).Seconds())
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
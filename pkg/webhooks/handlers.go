@@ -0,0 +1,211 @@
+package webhooks
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/fokal/fokal-core/pkg/audit"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/fokal/fokal-core/pkg/request"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+	"github.com/mholt/binding"
+)
+
+type hookHandler func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error)
+
+const (
+	defaultLimit = 25
+	maxLimit     = 100
+)
+
+// owner returns who the hooks being managed belong to: the logged in user, or
+// nobody for admin hooks.
+func owner(r *http.Request, admin bool) (model.Ref, *int64) {
+	user := context.Get(r, "auth").(model.Ref)
+	if admin {
+		return user, nil
+	}
+	return user, &user.Id
+}
+
+// hook returns the hook in the url if it belongs to the owner.
+func hook(state *handler.State, r *http.Request, owner *int64) (Hook, error) {
+	notFound := handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Webhook not found")}
+	id, err := strconv.ParseInt(mux.Vars(r)["ID"], 10, 64)
+	if err != nil {
+		return Hook{}, notFound
+	}
+
+	h, err := Get(state.DB, owner, id)
+	if err == sql.ErrNoRows {
+		return Hook{}, notFound
+	} else if err != nil {
+		return Hook{}, handler.StatusError{Code: http.StatusInternalServerError}
+	}
+	return h, nil
+}
+
+// CreateHandler registers a hook. The signing secret is only returned here.
+func CreateHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		user, owner := owner(r, admin)
+
+		req := new(request.CreateWebhookRequest)
+		if errs := binding.Bind(r, req); errs != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+		}
+		if err := ValidURL(req.URL, state.Local); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+		if err := ValidEvents(req.Events); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+		}
+
+		h, err := Create(state.DB, owner, req.URL, req.Events)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to create webhook")}
+		}
+
+		audit.Record(state.DB, r, "webhook.create", user, nil, h)
+		return handler.Response{
+			Code: http.StatusCreated,
+			Data: map[string]interface{}{"secret": h.Secret, "webhook": h},
+		}, nil
+	}
+}
+
+func ListHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		_, owner := owner(r, admin)
+
+		hooks, err := List(state.DB, owner)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve webhooks")}
+		}
+		return handler.Response{Code: http.StatusOK, Data: hooks}, nil
+	}
+}
+
+func GetHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		_, owner := owner(r, admin)
+
+		h, err := hook(state, r, owner)
+		if err != nil {
+			return handler.Response{}, err
+		}
+		return handler.Response{Code: http.StatusOK, Data: h}, nil
+	}
+}
+
+// PatchHandler changes a hook's url or events, or pauses it with active.
+// Deliveries queued while a hook is paused are sent once it is active again.
+func PatchHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		user, owner := owner(r, admin)
+
+		h, err := hook(state, r, owner)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		req := new(request.PatchWebhookRequest)
+		if errs := binding.Bind(r, req); errs != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: errs}
+		}
+		if req.URL != nil {
+			if err := ValidURL(*req.URL, state.Local); err != nil {
+				return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+			}
+		}
+		if req.Events != nil {
+			if err := ValidEvents(req.Events); err != nil {
+				return handler.Response{}, handler.StatusError{Code: http.StatusBadRequest, Err: err}
+			}
+		}
+
+		if err = Update(state.DB, h.Id, req.URL, req.Events, req.Active); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to update webhook")}
+		}
+
+		updated, err := Get(state.DB, owner, h.Id)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError}
+		}
+
+		audit.Record(state.DB, r, "webhook.patch", user, h, updated)
+		return handler.Response{Code: http.StatusOK, Data: updated}, nil
+	}
+}
+
+func DeleteHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		user, owner := owner(r, admin)
+
+		h, err := hook(state, r, owner)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		if err = Delete(state.DB, h.Id); err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to delete webhook")}
+		}
+
+		audit.Record(state.DB, r, "webhook.delete", user, h, nil)
+		return handler.Response{Code: http.StatusAccepted}, nil
+	}
+}
+
+// DeliveriesHandler returns the hook's delivery log, newest first.
+func DeliveriesHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		_, owner := owner(r, admin)
+
+		h, err := hook(state, r, owner)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		limit, offset, err := handler.Page(r, defaultLimit, maxLimit)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		deliveries, err := Deliveries(state.DB, h.Id, limit, offset)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to retrieve deliveries")}
+		}
+		return handler.Response{Code: http.StatusOK, Data: deliveries}, nil
+	}
+}
+
+// RedeliverHandler queues the delivery in the url to be sent again.
+func RedeliverHandler(admin bool) hookHandler {
+	return func(state *handler.State, w http.ResponseWriter, r *http.Request) (handler.Response, error) {
+		user, owner := owner(r, admin)
+
+		h, err := hook(state, r, owner)
+		if err != nil {
+			return handler.Response{}, err
+		}
+
+		id, err := strconv.ParseInt(mux.Vars(r)["delivery"], 10, 64)
+		if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Delivery not found")}
+		}
+
+		d, err := Redeliver(state.DB, h.Id, id)
+		if err == sql.ErrNoRows {
+			return handler.Response{}, handler.StatusError{Code: http.StatusNotFound, Err: errors.New("Delivery not found")}
+		} else if err != nil {
+			return handler.Response{}, handler.StatusError{Code: http.StatusInternalServerError, Err: errors.New("Unable to redeliver")}
+		}
+
+		audit.Record(state.DB, r, "webhook.redeliver", user, nil, map[string]int64{"webhook": h.Id, "delivery": id})
+		return handler.Response{Code: http.StatusAccepted, Data: d}, nil
+	}
+}
@@ -0,0 +1,245 @@
+package webhooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/fokal/fokal-core/pkg/generator"
+	"github.com/fokal/fokal-core/pkg/handler"
+	"github.com/fokal/fokal-core/pkg/model"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+const (
+	ImagePublished  = "image.published"
+	ImageDeleted    = "image.deleted"
+	ImageFeatured   = "image.featured"
+	FavoriteCreated = "favorite.created"
+	UserFollowed    = "user.followed"
+)
+
+// Events lists every event hooks can subscribe to.
+var Events = []string{ImagePublished, ImageDeleted, ImageFeatured, FavoriteCreated, UserFollowed}
+
+// SecretPrefix marks a hook's signing secret.
+const SecretPrefix = "whsec_"
+
+var (
+	// ErrEvents is returned when a hook subscribes to no events or to one that
+	// doesn't exist.
+	ErrEvents = fmt.Errorf("events must be one or more of %v", Events)
+	// ErrURL is returned for hook urls that aren't absolute http(s) urls.
+	ErrURL = errors.New("url must be an absolute https url")
+)
+
+// Hook receives the events it subscribes to. Hooks without a user are
+// registered by admins and receive events for everyone, others only those
+// concerning their user.
+type Hook struct {
+	Id        int64          `db:"id" json:"id"`
+	UserId    *int64         `db:"user_id" json:"-"`
+	URL       string         `db:"url" json:"url"`
+	Secret    string         `db:"secret" json:"-"`
+	Events    pq.StringArray `db:"events" json:"events"`
+	Active    bool           `db:"active" json:"active"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+}
+
+// Delivery is an event sent, or waiting to be sent, to a hook.
+type Delivery struct {
+	Id            int64          `db:"id" json:"id"`
+	HookId        int64          `db:"hook_id" json:"-"`
+	Event         string         `db:"event" json:"event"`
+	Payload       types.JSONText `db:"payload" json:"payload"`
+	Status        string         `db:"status" json:"status"`
+	Attempts      int            `db:"attempts" json:"attempts"`
+	NextAttemptAt time.Time      `db:"next_attempt_at" json:"next_attempt_at"`
+	ResponseCode  *int           `db:"response_code" json:"response_code,omitempty"`
+	Error         *string        `db:"error" json:"error,omitempty"`
+	RedeliveryOf  *int64         `db:"redelivery_of" json:"redelivery_of,omitempty"`
+	CreatedAt     time.Time      `db:"created_at" json:"created_at"`
+	DeliveredAt   *time.Time     `db:"delivered_at" json:"delivered_at,omitempty"`
+}
+
+// ValidEvents checks that every event exists and there is at least one.
+func ValidEvents(events []string) error {
+	if len(events) == 0 {
+		return ErrEvents
+	}
+	for _, e := range events {
+		found := false
+		for _, v := range Events {
+			found = found || e == v
+		}
+		if !found {
+			return ErrEvents
+		}
+	}
+	return nil
+}
+
+// ValidURL checks a hook url. Plain http is only allowed when local is set.
+func ValidURL(raw string, local bool) error {
+	u, err := url.Parse(raw)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return ErrURL
+	}
+	if u.Scheme != "https" && !(local && u.Scheme == "http") {
+		return ErrURL
+	}
+	return nil
+}
+
+const selectHooks = "SELECT id, user_id, url, secret, events, active, created_at FROM webhooks.hooks"
+
+// Create registers a hook for the user, or an admin hook when user is nil. The
+// signing secret is returned with the hook.
+func Create(db *sqlx.DB, user *int64, rawURL string, events []string) (Hook, error) {
+	secret, err := generator.GenerateSecureString(32)
+	if err != nil {
+		log.Println(err)
+		return Hook{}, err
+	}
+
+	hook := Hook{}
+	err = db.Get(&hook, `
+	INSERT INTO webhooks.hooks (user_id, url, secret, events)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, user_id, url, secret, events, active, created_at`, user, rawURL, SecretPrefix+secret, pq.StringArray(events))
+	if err != nil {
+		log.Println(err)
+		return Hook{}, err
+	}
+	return hook, nil
+}
+
+// List returns the user's hooks, or the admin hooks when user is nil.
+func List(db *sqlx.DB, user *int64) ([]Hook, error) {
+	hooks := []Hook{}
+	err := db.Select(&hooks, selectHooks+`
+	WHERE user_id IS NOT DISTINCT FROM $1
+	ORDER BY created_at`, user)
+	if err != nil {
+		log.Println(err)
+	}
+	return hooks, err
+}
+
+// Get returns the hook if it belongs to the user, or is an admin hook when user
+// is nil.
+func Get(db *sqlx.DB, user *int64, id int64) (Hook, error) {
+	hook := Hook{}
+	err := db.Get(&hook, selectHooks+" WHERE id = $1 AND user_id IS NOT DISTINCT FROM $2", id, user)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return hook, err
+}
+
+// Update changes whichever of the hook's url, events and active flag are set.
+func Update(db *sqlx.DB, id int64, rawURL *string, events []string, active *bool) error {
+	var e interface{}
+	if events != nil {
+		e = pq.StringArray(events)
+	}
+	_, err := db.Exec(`
+	UPDATE webhooks.hooks
+		SET url = coalesce($2, url), events = coalesce($3, events), active = coalesce($4, active)
+	WHERE id = $1`, id, rawURL, e, active)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Delete removes the hook along with its deliveries.
+func Delete(db *sqlx.DB, id int64) error {
+	_, err := db.Exec("DELETE FROM webhooks.hooks WHERE id = $1", id)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// Subject is something an event is about.
+type Subject struct {
+	Id        string `json:"id"`
+	Permalink string `json:"permalink"`
+}
+
+// Emit queues the event for every active hook subscribed to it that belongs to
+// owner, the user the event concerns, or to an admin. subjects name what the
+// event is about, such as the image and the user who favorited it.
+func Emit(state *handler.State, event string, owner int64, subjects map[string]model.Ref) error {
+	payload := make(map[string]Subject, len(subjects))
+	for name, ref := range subjects {
+		payload[name] = Subject{Id: ref.Shortcode, Permalink: ref.ToURL(state.Port, state.Local)}
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	_, err = state.DB.Exec(`
+	INSERT INTO webhooks.deliveries (hook_id, event, payload)
+	SELECT id, $1, $2 FROM webhooks.hooks
+	WHERE active AND $1 = ANY(events) AND (user_id IS NULL OR user_id = $3)`, event, types.JSONText(raw), owner)
+	if err != nil {
+		log.Println(err)
+	}
+	return err
+}
+
+// ImageOwner returns the user who owns the image, for events about it.
+func ImageOwner(db *sqlx.DB, image int64) (model.Ref, error) {
+	owner := model.Ref{Collection: model.Users}
+	err := db.QueryRowx(`
+	SELECT users.id, users.username
+	FROM content.images AS images
+		INNER JOIN content.users AS users ON users.id = images.user_id
+	WHERE images.id = $1`, image).Scan(&owner.Id, &owner.Shortcode)
+	if err != nil {
+		log.Println(err)
+	}
+	return owner, err
+}
+
+// Deliveries returns the hook's delivery log, newest first.
+func Deliveries(db *sqlx.DB, hook int64, limit, offset int) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := db.Select(&deliveries, `
+	SELECT id, hook_id, event, payload, status, attempts, next_attempt_at, response_code,
+		error, redelivery_of, created_at, delivered_at
+	FROM webhooks.deliveries
+	WHERE hook_id = $1
+	ORDER BY created_at DESC, id DESC
+	LIMIT $2 OFFSET $3`, hook, limit, offset)
+	if err != nil {
+		log.Println(err)
+	}
+	return deliveries, err
+}
+
+// Redeliver queues a new delivery of the same event to the hook. The original
+// is kept in the log.
+func Redeliver(db *sqlx.DB, hook, id int64) (Delivery, error) {
+	d := Delivery{}
+	err := db.Get(&d, `
+	INSERT INTO webhooks.deliveries (hook_id, event, payload, redelivery_of)
+	SELECT hook_id, event, payload, id FROM webhooks.deliveries
+	WHERE id = $1 AND hook_id = $2
+	RETURNING id, hook_id, event, payload, status, attempts, next_attempt_at, response_code,
+		error, redelivery_of, created_at, delivered_at`, id, hook)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+	}
+	return d, err
+}
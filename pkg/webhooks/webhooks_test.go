@@ -0,0 +1,111 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	got := Sign("whsec_test", 1500000000, []byte(`{"event":"image.published"}`))
+	want := "sha256=7f6de872543e2845d06dfea663648fb8b49e90c1e96448bd89a74c9992622c17"
+	if got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		5:  8 * time.Minute,
+		20: 6 * time.Hour,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("after %d attempts expected %s, got %s", attempts, want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := ValidEvents([]string{ImagePublished, UserFollowed}); err != nil {
+		t.Error(err)
+	}
+	for _, events := range [][]string{nil, {}, {"image.viewed"}} {
+		if ValidEvents(events) == nil {
+			t.Errorf("expected %v to be refused", events)
+		}
+	}
+
+	if err := ValidURL("https://cms.example.com/hooks/fokal", false); err != nil {
+		t.Error(err)
+	}
+	for _, raw := range []string{"", "/hooks", "ftp://example.com", "http://example.com"} {
+		if ValidURL(raw, false) == nil {
+			t.Errorf("expected %q to be refused", raw)
+		}
+	}
+	if err := ValidURL("http://localhost:3000/hooks", true); err != nil {
+		t.Errorf("expected plain http to be allowed locally: %s", err)
+	}
+}
+
+func TestRefusePrivate(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1:80", "10.0.0.8:443", "192.168.1.1:443", "169.254.169.254:80", "100.64.0.1:443", "100.127.255.254:80", "[::1]:443"} {
+		if refusePrivate("tcp", addr, nil) == nil {
+			t.Errorf("expected %s to be refused", addr)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34:443", "100.128.0.1:443"} {
+		if err := refusePrivate("tcp", addr, nil); err != nil {
+			t.Errorf("expected %s to be allowed: %s", addr, err)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	var header http.Header
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := claimed{Id: 7, Event: FavoriteCreated, Payload: []byte(`{"image":{"id":"abc"}}`), URL: server.URL, Secret: "whsec_test"}
+	code, err := send(Client(true), d)
+	if err != nil || code != http.StatusNoContent {
+		t.Fatalf("expected a 204, got %d, %v", code, err)
+	}
+
+	timestamp, err := strconv.ParseInt(header.Get("X-Fokal-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("X-Fokal-Signature") != Sign(d.Secret, timestamp, body) {
+		t.Error("signature doesn't match the body")
+	}
+	if header.Get("X-Fokal-Event") != FavoriteCreated || header.Get("X-Fokal-Delivery") != "7" {
+		t.Errorf("unexpected headers %v", header)
+	}
+
+	var envelope struct {
+		Id   int64                        `json:"id"`
+		Data map[string]map[string]string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Id != 7 || envelope.Data["image"]["id"] != "abc" {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	if _, err := send(Client(false), d); err == nil {
+		t.Error("expected delivery to a loopback address to be refused")
+	}
+}